@@ -0,0 +1,82 @@
+// Package asciicast writes terminal sessions in asciicast v2 format,
+// see https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+package asciicast
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Writer writes header and output events, event time is counted from
+// writer creation. Output is split on utf-8 character boundaries, so
+// multibyte characters cut by reads are not broken.
+type Writer struct {
+	w       io.Writer
+	started time.Time
+	pending []byte
+	written int64
+}
+
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Version = 2
+	writer := &Writer{w: w, started: time.Now()}
+	if header.Timestamp == 0 {
+		header.Timestamp = writer.started.Unix()
+	}
+	if err := writer.writeLine(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *Writer) writeLine(value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	n, err := writer.w.Write(append(line, '\n'))
+	writer.written += int64(n)
+	return err
+}
+
+// Written returns number of bytes written so far.
+func (writer *Writer) Written() int64 {
+	return writer.written
+}
+
+// Marker writes marker event with label, e.g. to note who was typing.
+func (writer *Writer) Marker(label string) error {
+	elapsed := time.Since(writer.started).Seconds()
+	return writer.writeLine([]interface{}{elapsed, "m", label})
+}
+
+// Output writes output event with data printed to terminal.
+func (writer *Writer) Output(data []byte) error {
+	data = append(writer.pending, data...)
+	complete := len(data)
+	for cut := 1; cut < utf8.UTFMax && cut <= len(data); cut++ {
+		if utf8.RuneStart(data[len(data)-cut]) {
+			if !utf8.FullRune(data[len(data)-cut:]) {
+				complete = len(data) - cut
+			}
+			break
+		}
+	}
+	writer.pending = append([]byte{}, data[complete:]...)
+	if complete == 0 {
+		return nil
+	}
+	elapsed := time.Since(writer.started).Seconds()
+	return writer.writeLine([]interface{}{elapsed, "o", string(data[:complete])})
+}
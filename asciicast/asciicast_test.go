@@ -0,0 +1,100 @@
+package asciicast
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterOutput(t *testing.T) {
+	euro := []byte("€") // e2 82 ac
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   []string
+	}{
+		{
+			name:   "ascii",
+			chunks: [][]byte{[]byte("login: "), []byte("root\r\n")},
+			want:   []string{"login: ", "root\r\n"},
+		},
+		{
+			name:   "character split between reads",
+			chunks: [][]byte{append([]byte("price "), euro[:2]...), append(euro[2:], '5')},
+			want:   []string{"price ", "€5"},
+		},
+		{
+			name:   "only part of character",
+			chunks: [][]byte{euro[:1], euro[1:]},
+			want:   []string{"€"},
+		},
+		{
+			name:   "invalid byte is not held back",
+			chunks: [][]byte{{'a', 0xff}},
+			want:   []string{"a�"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, Header{Width: 80, Height: 24})
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := writer.Output(chunk); err != nil {
+					t.Fatalf("Output() error = %v", err)
+				}
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if int64(buf.Len()) != writer.Written() {
+				t.Errorf("Written() = %d, want %d", writer.Written(), buf.Len())
+			}
+			header := Header{}
+			if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+				t.Fatalf("cannot parse header %s: %v", lines[0], err)
+			}
+			if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Timestamp == 0 {
+				t.Errorf("header = %+v", header)
+			}
+			got := []string{}
+			for _, line := range lines[1:] {
+				event := []interface{}{}
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("cannot parse event %s: %v", line, err)
+				}
+				if len(event) != 3 || event[1] != "o" {
+					t.Fatalf("event = %v, want output event", event)
+				}
+				got = append(got, event[2].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Output() events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterMarker(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, Header{Width: 80, Height: 24, Timestamp: 1700000000, Title: "web1"})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := writer.Marker("alice joined"); err != nil {
+		t.Fatalf("Marker() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if want := `{"version":2,"width":80,"height":24,"timestamp":1700000000,"title":"web1"}`; lines[0] != want {
+		t.Errorf("header = %s, want %s", lines[0], want)
+	}
+	event := []interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("cannot parse event %s: %v", lines[1], err)
+	}
+	if len(event) != 3 || event[1] != "m" || event[2] != "alice joined" {
+		t.Errorf("Marker() event = %v", event)
+	}
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"time"
+)
+
+const ResultSuccess = "success"
+const ResultFailure = "failure"
+
+// ResultQueued is result of request which submitted background task,
+// task outcome is appended as another entry with the same TaskId.
+const ResultQueued = "queued"
+
+type Entry struct {
+	Time       time.Time              `json:"time"`
+	User       string                 `json:"user"`
+	ApiToken   string                 `json:"api_token,omitempty"`
+	RemoteAddr string                 `json:"remote_addr"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Action     string                 `json:"action"`
+	NodeId     string                 `json:"node,omitempty"`
+	TargetId   string                 `json:"target,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	TaskId     string                 `json:"task,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Result     string                 `json:"result"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+type EntryListOptions struct {
+	User     string
+	Action   string
+	NodeId   string
+	TargetId string
+	Result   string
+	TaskId   string
+	Limit    int
+}
+
+func (options EntryListOptions) Match(entry *Entry) bool {
+	if options.User != "" && entry.User != options.User {
+		return false
+	}
+	if options.Action != "" && entry.Action != options.Action {
+		return false
+	}
+	if options.NodeId != "" && entry.NodeId != options.NodeId {
+		return false
+	}
+	if options.TargetId != "" && entry.TargetId != options.TargetId {
+		return false
+	}
+	if options.Result != "" && entry.Result != options.Result {
+		return false
+	}
+	if options.TaskId != "" && entry.TaskId != options.TaskId {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+package audit
+
+type Repository interface {
+	Append(entry *Entry) error
+	// List returns matching entries, newest first
+	List(options EntryListOptions) ([]*Entry, error)
+}
+
+type Log struct {
+	Repository
+}
+
+func NewLog(repo Repository) *Log {
+	return &Log{repo}
+}
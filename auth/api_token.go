@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const ApiTokenBytes = 32
+
+type ApiToken struct {
+	Id          string
+	UserId      string
+	Name        string
+	HashedToken string
+	Created     time.Time
+	Static      bool
+}
+
+func GenerateApiToken() (string, error) {
+	raw := make([]byte, ApiTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
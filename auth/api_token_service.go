@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrApiTokenNotFound = errors.New("api token not found")
+var ErrApiTokenInvalid = errors.New("invalid api token")
+var ErrApiTokenStatic = errors.New("api token is declared in configuration file and cannot be revoked")
+
+type ApiTokenRepository interface {
+	List() ([]*ApiToken, error)
+	Save(token *ApiToken) error
+	Delete(id string) error
+}
+
+type ApiTokenService struct {
+	repo   ApiTokenRepository
+	static []*ApiToken
+}
+
+func NewApiTokenService(repo ApiTokenRepository, static []*ApiToken) *ApiTokenService {
+	for _, token := range static {
+		token.Static = true
+	}
+	return &ApiTokenService{repo: repo, static: static}
+}
+
+func (service *ApiTokenService) all() ([]*ApiToken, error) {
+	tokens, err := service.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]*ApiToken{}, service.static...), tokens...), nil
+}
+
+func (service *ApiTokenService) List(userId string) ([]*ApiToken, error) {
+	tokens, err := service.all()
+	if err != nil {
+		return nil, err
+	}
+	result := []*ApiToken{}
+	for _, token := range tokens {
+		if token.UserId == userId {
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+func (service *ApiTokenService) Issue(userId, name string) (*ApiToken, string, error) {
+	plain, err := GenerateApiToken()
+	if err != nil {
+		return nil, "", util.NewError(err, "cannot generate token")
+	}
+	token := &ApiToken{
+		Id:          uuid.New().String(),
+		UserId:      userId,
+		Name:        name,
+		HashedToken: HashApiToken(plain),
+		Created:     time.Now(),
+	}
+	if err := service.repo.Save(token); err != nil {
+		return nil, "", util.NewError(err, "cannot save token")
+	}
+	return token, plain, nil
+}
+
+func (service *ApiTokenService) Authenticate(plain string) (*ApiToken, error) {
+	if plain == "" {
+		return nil, ErrApiTokenInvalid
+	}
+	tokens, err := service.all()
+	if err != nil {
+		return nil, util.NewError(err, "cannot load tokens")
+	}
+	hashed := []byte(HashApiToken(plain))
+	var found *ApiToken
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(hashed, []byte(token.HashedToken)) == 1 {
+			found = token
+		}
+	}
+	if found == nil {
+		return nil, ErrApiTokenInvalid
+	}
+	return found, nil
+}
+
+func (service *ApiTokenService) Revoke(id, userId string) error {
+	tokens, err := service.List(userId)
+	if err != nil {
+		return util.NewError(err, "cannot load tokens")
+	}
+	for _, token := range tokens {
+		if token.Id != id {
+			continue
+		}
+		if token.Static {
+			return ErrApiTokenStatic
+		}
+		return service.repo.Delete(id)
+	}
+	return ErrApiTokenNotFound
+}
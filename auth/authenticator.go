@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"errors"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+var ErrPrincipalNotFound = errors.New("principal not found")
+
+// Principal is an authenticated identity with permissions it is given.
+type Principal struct {
+	Id       string
+	FullName string
+	Email    string
+	Grants   Grants
+	Projects []string
+}
+
+type Authenticator interface {
+	Authenticate(username, password string) (*Principal, error)
+	Lookup(username string) (*Principal, error)
+}
+
+// Authenticators tries each authenticator in order, first success wins.
+type Authenticators []Authenticator
+
+func (authenticators Authenticators) Authenticate(username, password string) (*Principal, error) {
+	var lastErr error = ErrInvalidCredentials
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.Authenticate(username, password)
+		if err == nil {
+			return principal, nil
+		}
+		if err != ErrInvalidCredentials {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+func (authenticators Authenticators) Lookup(username string) (*Principal, error) {
+	var lastErr error = ErrPrincipalNotFound
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.Lookup(username)
+		if err == nil {
+			return principal, nil
+		}
+		if err != ErrPrincipalNotFound {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Grant gives role on machines with id starting with one of VmPrefixes
+// located on one of Nodes. Empty list means no restriction.
+type Grant struct {
+	Role       Role
+	Nodes      []string
+	VmPrefixes []string
+}
+
+func (grant Grant) matchNode(nodeId string) bool {
+	if len(grant.Nodes) == 0 {
+		return true
+	}
+	for _, node := range grant.Nodes {
+		if node == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+func (grant Grant) matchVm(vmId string) bool {
+	if len(grant.VmPrefixes) == 0 {
+		return true
+	}
+	if vmId == "" {
+		return false
+	}
+	for _, prefix := range grant.VmPrefixes {
+		if strings.HasPrefix(vmId, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type Grants []Grant
+
+// Can reports whether role is granted for a machine. Empty nodeId or vmId
+// means resource is not bound to a node or a machine, so only grants
+// without corresponding restriction apply.
+func (grants Grants) Can(role Role, nodeId, vmId string) bool {
+	for _, grant := range grants {
+		if grant.Role < role {
+			continue
+		}
+		if nodeId == "" && len(grant.Nodes) > 0 {
+			continue
+		}
+		if grant.matchNode(nodeId) && grant.matchVm(vmId) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanNode reports whether role is granted for anything on the node,
+// machine prefixes are not taken into account.
+func (grants Grants) CanNode(role Role, nodeId string) bool {
+	for _, grant := range grants {
+		if grant.Role >= role && grant.matchNode(nodeId) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestGrantsCan(t *testing.T) {
+	grants := Grants{
+		{Role: RoleViewer},
+		{Role: RoleOperator, Nodes: []string{"n1"}},
+		{Role: RoleAdmin, Nodes: []string{"n1"}, VmPrefixes: []string{"web-"}},
+	}
+	tests := []struct {
+		name   string
+		grants Grants
+		role   Role
+		nodeId string
+		vmId   string
+		want   bool
+	}{
+		{"viewer everywhere", grants, RoleViewer, "n2", "db-1", true},
+		{"operator on node", grants, RoleOperator, "n1", "db-1", true},
+		{"operator on other node", grants, RoleOperator, "n2", "db-1", false},
+		{"admin of prefixed machine", grants, RoleAdmin, "n1", "web-1", true},
+		{"admin of other machine", grants, RoleAdmin, "n1", "db-1", false},
+		{"admin of prefixed machine on other node", grants, RoleAdmin, "n2", "web-1", false},
+		{"prefix grant doesn't apply without machine", grants, RoleAdmin, "n1", "", false},
+		{"node grant doesn't apply without node", grants, RoleOperator, "", "", false},
+		{"higher role implies lower", Grants{{Role: RoleAdmin}}, RoleViewer, "", "", true},
+		{"no grants", nil, RoleViewer, "n1", "web-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grants.Can(tt.role, tt.nodeId, tt.vmId); got != tt.want {
+				t.Errorf("Can() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantsCanNode(t *testing.T) {
+	grants := Grants{
+		{Role: RoleViewer},
+		{Role: RoleAdmin, Nodes: []string{"n1"}, VmPrefixes: []string{"web-"}},
+	}
+	tests := []struct {
+		name   string
+		role   Role
+		nodeId string
+		want   bool
+	}{
+		{"viewer on any node", RoleViewer, "n2", true},
+		{"machine prefixes are ignored", RoleAdmin, "n1", true},
+		{"admin on other node", RoleAdmin, "n2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grants.CanNode(tt.role, tt.nodeId); got != tt.want {
+				t.Errorf("CanNode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+// GroupGrant gives role and projects to members of Group. Nodes and
+// VmPrefixes limit the role same way as for Grant.
+type GroupGrant struct {
+	Group      string
+	Role       Role
+	Nodes      []string
+	VmPrefixes []string
+	Projects   []string
+}
+
+type GroupGrants []GroupGrant
+
+// Apply adds grants and projects of groups principal is member of,
+// group names are compared with equal.
+func (groupGrants GroupGrants) Apply(principal *Principal, memberOf []string, equal func(a, b string) bool) {
+	for _, group := range memberOf {
+		for _, groupGrant := range groupGrants {
+			if !equal(group, groupGrant.Group) {
+				continue
+			}
+			principal.Grants = append(principal.Grants, Grant{Role: groupGrant.Role, Nodes: groupGrant.Nodes, VmPrefixes: groupGrant.VmPrefixes})
+			principal.Projects = append(principal.Projects, groupGrant.Projects...)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"subuk/vmango/util"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type LdapSettings struct {
+	Url                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDn             string
+	BindPassword       string
+	BaseDn             string
+	UserFilter         string
+	IdAttribute        string
+	FullNameAttribute  string
+	EmailAttribute     string
+	GroupAttribute     string
+	Groups             GroupGrants
+}
+
+type LdapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type LdapAuthenticator struct {
+	settings LdapSettings
+	dial     func() (LdapConn, error)
+}
+
+func NewLdapAuthenticator(settings LdapSettings) *LdapAuthenticator {
+	authenticator := &LdapAuthenticator{settings: settings}
+	authenticator.dial = authenticator.dialUrl
+	return authenticator
+}
+
+func (authenticator *LdapAuthenticator) dialUrl() (LdapConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: authenticator.settings.InsecureSkipVerify}
+	conn, err := ldap.DialURL(authenticator.settings.Url, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if authenticator.settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, util.NewError(err, "starttls failed")
+		}
+	}
+	return conn, nil
+}
+
+func (authenticator *LdapAuthenticator) connect() (LdapConn, error) {
+	conn, err := authenticator.dial()
+	if err != nil {
+		return nil, util.NewError(err, "cannot connect to ldap server")
+	}
+	if authenticator.settings.BindDn != "" {
+		if err := conn.Bind(authenticator.settings.BindDn, authenticator.settings.BindPassword); err != nil {
+			conn.Close()
+			return nil, util.NewError(err, "ldap service account bind failed")
+		}
+	}
+	return conn, nil
+}
+
+func (authenticator *LdapAuthenticator) find(conn LdapConn, username string) (*ldap.Entry, error) {
+	settings := authenticator.settings
+	request := ldap.NewSearchRequest(
+		settings.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(settings.UserFilter, ldap.EscapeFilter(username)),
+		[]string{settings.IdAttribute, settings.FullNameAttribute, settings.EmailAttribute, settings.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, util.NewError(err, "ldap user search failed")
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrPrincipalNotFound
+	}
+	return result.Entries[0], nil
+}
+
+// principal maps entry groups to grants, entries without any mapped group
+// get no principal at all.
+func (authenticator *LdapAuthenticator) principal(entry *ldap.Entry) (*Principal, error) {
+	settings := authenticator.settings
+	principal := &Principal{
+		Id:       entry.GetAttributeValue(settings.IdAttribute),
+		FullName: entry.GetAttributeValue(settings.FullNameAttribute),
+		Email:    entry.GetAttributeValue(settings.EmailAttribute),
+	}
+	settings.Groups.Apply(principal, entry.GetAttributeValues(settings.GroupAttribute), strings.EqualFold)
+	if principal.Id == "" || len(principal.Grants) == 0 {
+		return nil, ErrPrincipalNotFound
+	}
+	return principal, nil
+}
+
+func (authenticator *LdapAuthenticator) Authenticate(username, password string) (*Principal, error) {
+	// Bind with empty password is an anonymous bind and always succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := authenticator.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entry, err := authenticator.find(conn, username)
+	if err == ErrPrincipalNotFound {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, util.NewError(err, "ldap user bind failed")
+	}
+	principal, err := authenticator.principal(entry)
+	if err == ErrPrincipalNotFound {
+		return nil, ErrInvalidCredentials
+	}
+	return principal, err
+}
+
+func (authenticator *LdapAuthenticator) Lookup(username string) (*Principal, error) {
+	conn, err := authenticator.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entry, err := authenticator.find(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	return authenticator.principal(entry)
+}
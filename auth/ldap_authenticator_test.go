@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type fakeLdapEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLdapDirectory is an in-process ldap server stand-in, entries are keyed by dn
+type fakeLdapDirectory struct {
+	entries map[string]fakeLdapEntry
+	userKey string
+}
+
+type fakeLdapConn struct {
+	directory *fakeLdapDirectory
+	closed    bool
+}
+
+func (conn *fakeLdapConn) Bind(username, password string) error {
+	entry, exists := conn.directory.entries[username]
+	if !exists || entry.password != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+	}
+	return nil
+}
+
+func (conn *fakeLdapConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	for dn, entry := range conn.directory.entries {
+		for _, value := range entry.attributes[conn.directory.userKey] {
+			if request.Filter != fmt.Sprintf("(%s=%s)", conn.directory.userKey, ldap.EscapeFilter(value)) {
+				continue
+			}
+			result.Entries = append(result.Entries, ldap.NewEntry(dn, entry.attributes))
+		}
+	}
+	return result, nil
+}
+
+func (conn *fakeLdapConn) Close() {
+	conn.closed = true
+}
+
+func newTestLdapAuthenticator() *LdapAuthenticator {
+	directory := &fakeLdapDirectory{
+		userKey: "uid",
+		entries: map[string]fakeLdapEntry{
+			"cn=service,dc=example,dc=com": {password: "service-secret"},
+			"uid=alice,ou=people,dc=example,dc=com": {
+				password: "alice-secret",
+				attributes: map[string][]string{
+					"uid":      {"alice"},
+					"cn":       {"Alice Liddell"},
+					"mail":     {"alice@example.com"},
+					"memberOf": {"CN=Admins,OU=Groups,DC=example,DC=com", "cn=web,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				password: "bob-secret",
+				attributes: map[string][]string{
+					"uid":      {"bob"},
+					"memberOf": {"cn=unrelated,ou=groups,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+	authenticator := NewLdapAuthenticator(LdapSettings{
+		BindDn:            "cn=service,dc=example,dc=com",
+		BindPassword:      "service-secret",
+		BaseDn:            "dc=example,dc=com",
+		UserFilter:        "(uid=%s)",
+		IdAttribute:       "uid",
+		FullNameAttribute: "cn",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		Groups: GroupGrants{
+			{Group: "cn=admins,ou=groups,dc=example,dc=com", Role: RoleAdmin, Nodes: []string{"local"}},
+			{Group: "cn=web,ou=groups,dc=example,dc=com", Role: RoleViewer, Projects: []string{"web"}},
+		},
+	})
+	authenticator.dial = func() (LdapConn, error) {
+		return &fakeLdapConn{directory: directory}, nil
+	}
+	return authenticator
+}
+
+func TestLdapAuthenticatorAuthenticate(t *testing.T) {
+	alice := &Principal{
+		Id:       "alice",
+		FullName: "Alice Liddell",
+		Email:    "alice@example.com",
+		Grants: Grants{
+			{Role: RoleAdmin, Nodes: []string{"local"}},
+			{Role: RoleViewer},
+		},
+		Projects: []string{"web"},
+	}
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     *Principal
+		wantErr  error
+	}{
+		{"Ok", "alice", "alice-secret", alice, nil},
+		{"WrongPassword", "alice", "wrong", nil, ErrInvalidCredentials},
+		{"EmptyPassword", "alice", "", nil, ErrInvalidCredentials},
+		{"UnknownUser", "carol", "carol-secret", nil, ErrInvalidCredentials},
+		{"NoMappedGroups", "bob", "bob-secret", nil, ErrInvalidCredentials},
+		{"FilterInjection", "*", "alice-secret", nil, ErrInvalidCredentials},
+	}
+	authenticator := newTestLdapAuthenticator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authenticator.Authenticate(tt.username, tt.password)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Authenticate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLdapAuthenticatorLookup(t *testing.T) {
+	authenticator := newTestLdapAuthenticator()
+	principal, err := authenticator.Lookup("alice")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if principal.Id != "alice" || len(principal.Grants) != 2 {
+		t.Errorf("Lookup() = %+v", principal)
+	}
+	if _, err := authenticator.Lookup("bob"); err != ErrPrincipalNotFound {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrPrincipalNotFound)
+	}
+}
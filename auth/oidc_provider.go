@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"subuk/vmango/util"
+	"sync"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrOidcNonceMismatch = errors.New("id token nonce mismatch")
+
+type OidcSettings struct {
+	Issuer        string
+	ClientId      string
+	ClientSecret  string
+	RedirectUrl   string
+	Scopes        []string
+	IdClaim       string
+	FullNameClaim string
+	EmailClaim    string
+	GroupsClaim   string
+	Groups        GroupGrants
+}
+
+// OidcProvider implements authorization code flow against OpenID Connect
+// provider. Provider metadata is discovered on first use, so vmango may start
+// while the provider is unavailable.
+type OidcProvider struct {
+	settings      OidcSettings
+	mu            sync.Mutex
+	provider      *oidc.Provider
+	endSessionUrl string
+}
+
+func NewOidcProvider(settings OidcSettings) *OidcProvider {
+	return &OidcProvider{settings: settings}
+}
+
+// Provider keeps discovery context for fetching signing keys later,
+// so it must not be bound to a request.
+func (p *OidcProvider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	provider, err := oidc.NewProvider(context.Background(), p.settings.Issuer)
+	if err != nil {
+		return nil, util.NewError(err, "oidc provider discovery failed")
+	}
+	metadata := struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}{}
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, util.NewError(err, "cannot parse oidc provider metadata")
+	}
+	p.provider = provider
+	p.endSessionUrl = metadata.EndSessionEndpoint
+	return provider, nil
+}
+
+func (p *OidcProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.settings.ClientId,
+		ClientSecret: p.settings.ClientSecret,
+		RedirectURL:  p.settings.RedirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.settings.Scopes...),
+	}
+}
+
+func (p *OidcProvider) AuthCodeURL(state, nonce string) (string, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange trades authorization code for id token and maps its claims to
+// principal. Principal has no grants if none of its groups is mapped.
+func (p *OidcProvider) Exchange(ctx context.Context, code, nonce string) (*Principal, string, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := p.oauth2Config(provider).Exchange(ctx, code)
+	if err != nil {
+		return nil, "", util.NewError(err, "cannot exchange authorization code")
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("no id_token in token response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.settings.ClientId}).Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, "", util.NewError(err, "id token verification failed")
+	}
+	if idToken.Nonce != nonce {
+		return nil, "", ErrOidcNonceMismatch
+	}
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", util.NewError(err, "cannot parse id token claims")
+	}
+	principal := &Principal{
+		Id:       stringClaim(claims, p.settings.IdClaim),
+		FullName: stringClaim(claims, p.settings.FullNameClaim),
+		Email:    stringClaim(claims, p.settings.EmailClaim),
+	}
+	if principal.Id == "" {
+		return nil, "", fmt.Errorf("no '%s' claim in id token", p.settings.IdClaim)
+	}
+	p.settings.Groups.Apply(principal, stringsClaim(claims, p.settings.GroupsClaim), func(a, b string) bool { return a == b })
+	return principal, rawIdToken, nil
+}
+
+// EndSessionURL returns provider logout url, empty string means provider
+// doesn't support RP-initiated logout.
+func (p *OidcProvider) EndSessionURL(rawIdToken, postLogoutRedirectUrl string) (string, error) {
+	if _, err := p.discover(); err != nil {
+		return "", err
+	}
+	if p.endSessionUrl == "" {
+		return "", nil
+	}
+	endSessionUrl, err := url.Parse(p.endSessionUrl)
+	if err != nil {
+		return "", util.NewError(err, "invalid end session endpoint")
+	}
+	query := endSessionUrl.Query()
+	query.Set("client_id", p.settings.ClientId)
+	if rawIdToken != "" {
+		query.Set("id_token_hint", rawIdToken)
+	}
+	if postLogoutRedirectUrl != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirectUrl)
+	}
+	endSessionUrl.RawQuery = query.Encode()
+	return endSessionUrl.String(), nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := []string{}
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+type Role int
+
+const (
+	RoleUnknown Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+func (role Role) String() string {
+	switch role {
+	default:
+		return "unknown"
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+}
+
+func NewRole(input string) Role {
+	switch input {
+	default:
+		return RoleUnknown
+	case "viewer":
+		return RoleViewer
+	case "operator":
+		return RoleOperator
+	case "admin":
+		return RoleAdmin
+	}
+}
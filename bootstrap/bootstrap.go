@@ -2,16 +2,25 @@ package bootstrap
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	os_user "os/user"
+	"strings"
+	"subuk/vmango/audit"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
 	libcompute "subuk/vmango/compute"
 	"subuk/vmango/config"
 	"subuk/vmango/configdrive"
 	"subuk/vmango/filesystem"
 	"subuk/vmango/libvirt"
+	"subuk/vmango/sshtunnel"
 	"subuk/vmango/util"
 	"subuk/vmango/web"
+	"subuk/vmango/webhook"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -59,15 +68,71 @@ func Web(configFilename string) {
 		os.Exit(1)
 	}
 
-	epub := filesystem.NewScriptedComputeEventBroker(logger.With().Str("component", "compute-event-broker").Logger())
+	tokenRepo, err := filesystem.NewApiTokenRepository(util.ExpandHomeDir(cfg.ApiTokenFile))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize api token storage")
+		os.Exit(1)
+	}
+	volumeOwnerRepo, err := filesystem.NewVolumeOwnershipRepository(util.ExpandHomeDir(cfg.VolumeOwnerFile))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize volume owner storage")
+		os.Exit(1)
+	}
+	auditRepo, err := filesystem.NewAuditRepository(util.ExpandHomeDir(cfg.AuditFile))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize audit log")
+		os.Exit(1)
+	}
+	auditLog := audit.NewLog(auditRepo)
+
+	staticTokens := []*auth.ApiToken{}
+	for _, user := range cfg.Web.Users {
+		for _, token := range user.ApiTokens {
+			staticTokens = append(staticTokens, &auth.ApiToken{
+				Id:          "config-" + user.Id + "-" + token.Name,
+				UserId:      user.Id,
+				Name:        token.Name,
+				HashedToken: token.HashedToken,
+			})
+		}
+	}
+	tokens := auth.NewApiTokenService(tokenRepo, staticTokens)
+
+	deliveryRepo, err := filesystem.NewEventDeliveryRepository(util.ExpandHomeDir(cfg.EventOutboxDir))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize event outbox")
+		os.Exit(1)
+	}
+	outbox := libcompute.NewEventOutbox(deliveryRepo)
 	for _, sub := range cfg.Subscribes {
-		epub.Subscribe(sub.Event, sub.Script, sub.Mandatory)
+		if sub.Url != "" {
+			outbox.Subscribe(webhook.NewSubscriber(webhook.Subscription{
+				Event:     sub.Event,
+				Url:       sub.Url,
+				Secret:    sub.Secret,
+				Mandatory: sub.Mandatory,
+				Attempts:  sub.Attempts,
+				Timeout:   time.Duration(sub.Timeout) * time.Second,
+			}, logger.With().Str("component", "webhook-event-subscriber").Logger()))
+			logger.Info().
+				Str("event", sub.Event).
+				Str("url", sub.Url).
+				Bool("mandatory", sub.Mandatory).
+				Msg("new webhook subscription created")
+			continue
+		}
+		outbox.Subscribe(filesystem.NewScriptEventSubscriber(sub.Event, sub.Script, sub.Mandatory, sub.Attempts, logger.With().Str("component", "script-event-subscriber").Logger()))
 		logger.Info().
 			Str("event", sub.Event).
 			Str("script", sub.Script).
 			Bool("mandatory", sub.Mandatory).
 			Msg("new script subscription created")
 	}
+	if err := outbox.Start(); err != nil {
+		logger.Error().Err(err).Msg("cannot start event outbox")
+		os.Exit(1)
+	}
+	epub := outbox
 
 	nodeUri := map[string]string{}
 	nodeOrder := []string{}
@@ -84,9 +149,14 @@ func Web(configFilename string) {
 				Msg("unknown libvirt configdrive write format")
 			os.Exit(1)
 		}
+		tunnel, err := sshTunnelFromConfig(c, logger.With().Str("component", "ssh-tunnel").Str("node", c.Name).Logger())
+		if err != nil {
+			logger.Warn().Err(err).Str("node", c.Name).Msg("cannot configure ssh tunnel, graphic console will not be available")
+		}
 		vmRepSettings[c.Name] = libvirt.NodeSettings{
 			CdSuffix: c.ConfigDriveSuffix,
 			Cache:    c.Cache,
+			Tunnel:   tunnel,
 		}
 		vmManSettings[c.Name] = compute.VirtualMachineManagerNodeSettings{
 			CdPool:   c.ConfigDrivePool,
@@ -103,16 +173,71 @@ func Web(configFilename string) {
 	nodeRepo := libvirt.NewNodeRepository(connectionPool, logger.With().Str("component", "node-repository").Logger())
 	netRepo := libvirt.NewNetworkRepository(connectionPool, logger.With().Str("component", "net-repository").Logger())
 
+	events := libcompute.NewEventBroadcaster()
+	if err := vmRepo.WatchEvents(compute.EventPublishers{events, epub}); err != nil {
+		logger.Warn().Err(err).Msg("cannot watch domain events, live state updates are disabled")
+	}
+
 	network := libcompute.NewNetworkService(netRepo)
 	keys := libcompute.NewKeyService(keyRepo)
 	volpools := libcompute.NewVolumePoolService(volpoolRepo)
 	nodes := libcompute.NewNodeService(nodeRepo)
-	volumes := libcompute.NewVolumeService(volumeRepo)
-	vms := libcompute.NewVirtualMachineService(vmRepo)
+	volumes := libcompute.NewVolumeService(volumeRepo, volumeOwnerRepo, epub)
+	vms := libcompute.NewVirtualMachineService(vmRepo, epub, time.Duration(cfg.ShutdownTimeout)*time.Second)
+
+	quotaLimits := []*libcompute.Quota{}
+	for _, c := range cfg.UserQuotas {
+		quotaLimits = append(quotaLimits, quotaFromConfig(libcompute.QuotaSubjectUser, c))
+	}
+	for _, c := range cfg.ProjectQuotas {
+		quotaLimits = append(quotaLimits, quotaFromConfig(libcompute.QuotaSubjectProject, c))
+	}
+	quotas := libcompute.NewQuotaService(vms, volumes, quotaLimits)
 
-	vmanager := libcompute.NewVirtualMachineManager(vms, volumes, epub, vmManSettings)
+	tasks := libcompute.NewTaskService(cfg.TaskWorkers, cfg.TaskHistory)
+	vmanager := libcompute.NewVirtualMachineManager(vms, volumes, nodes, network, quotas, epub, vmManSettings)
 
-	webenv := web.New(cfg, logger, network, keys, volpools, nodes, volumes, vms, vmanager)
+	recordingRepo, err := filesystem.NewConsoleRecordingRepository(util.ExpandHomeDir(cfg.ConsoleRecording.Dir))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize console recordings storage")
+		os.Exit(1)
+	}
+	recordings := libcompute.NewConsoleRecordingService(
+		recordingRepo,
+		!cfg.ConsoleRecording.Disabled,
+		time.Duration(cfg.ConsoleRecording.MaxAge)*24*time.Hour,
+		uint64(cfg.ConsoleRecording.MaxSizeMib)*1024*1024,
+	)
+	if err := recordings.Prune(); err != nil {
+		logger.Warn().Err(err).Msg("cannot remove old console recordings")
+	}
+	consoles := libcompute.NewConsoleMultiplexer(vms, recordings)
+
+	authenticators := auth.Authenticators{web.NewConfigAuthenticator(cfg.Web.Users)}
+	for _, c := range cfg.Auths {
+		switch c.Type {
+		case "ldap":
+			authenticators = append(authenticators, auth.NewLdapAuthenticator(ldapSettingsFromConfig(c)))
+		}
+	}
+
+	var oidcProvider *auth.OidcProvider
+	if cfg.Web.Oidc.Enabled() {
+		oidcProvider = auth.NewOidcProvider(auth.OidcSettings{
+			Issuer:        cfg.Web.Oidc.Issuer,
+			ClientId:      cfg.Web.Oidc.ClientId,
+			ClientSecret:  cfg.Web.Oidc.ClientSecret,
+			RedirectUrl:   cfg.Web.Oidc.RedirectUrl,
+			Scopes:        cfg.Web.Oidc.Scopes,
+			IdClaim:       cfg.Web.Oidc.IdClaim,
+			FullNameClaim: cfg.Web.Oidc.FullNameClaim,
+			EmailClaim:    cfg.Web.Oidc.EmailClaim,
+			GroupsClaim:   cfg.Web.Oidc.GroupsClaim,
+			Groups:        groupGrantsFromConfig(cfg.Web.Oidc.Groups),
+		})
+	}
+
+	webenv := web.New(cfg, logger, network, keys, volpools, nodes, volumes, vms, vmanager, tasks, tokens, quotas, authenticators, oidcProvider, auditLog, events, outbox, recordings, consoles)
 	server := http.Server{
 		Addr:    cfg.Web.Listen,
 		Handler: webenv,
@@ -123,3 +248,91 @@ func Web(configFilename string) {
 		os.Exit(1)
 	}
 }
+
+// sshTunnelFromConfig returns nil tunnel for nodes not connected over ssh.
+// Default ssh keys from home directory are used unless ssh_keys are set.
+func sshTunnelFromConfig(c config.LibvirtConfig, logger zerolog.Logger) (*sshtunnel.Tunnel, error) {
+	uri, err := url.Parse(c.Uri)
+	if err != nil {
+		return nil, util.NewError(err, "cannot parse libvirt uri")
+	}
+	if !strings.Contains(uri.Scheme, "ssh") {
+		return nil, nil
+	}
+	username := uri.User.Username()
+	if username == "" {
+		current, err := os_user.Current()
+		if err != nil {
+			return nil, util.NewError(err, "cannot get current user")
+		}
+		username = current.Username
+	}
+	port := uri.Port()
+	if port == "" {
+		port = "22"
+	}
+	keys := []string{}
+	for _, filename := range c.SshKeys {
+		keys = append(keys, util.ExpandHomeDir(filename))
+	}
+	if len(keys) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			filename := util.ExpandHomeDir("~/.ssh/" + name)
+			if _, err := os.Stat(filename); err == nil {
+				keys = append(keys, filename)
+			}
+		}
+	}
+	return sshtunnel.New(sshtunnel.Config{
+		Addr:       net.JoinHostPort(uri.Hostname(), port),
+		User:       username,
+		KeyFiles:   keys,
+		KnownHosts: []string{util.ExpandHomeDir(c.SshKnownHosts)},
+		Timeout:    10 * time.Second,
+	}, logger)
+}
+
+func quotaFromConfig(subject compute.QuotaSubject, c config.QuotaConfig) *compute.Quota {
+	return &compute.Quota{
+		Subject: subject,
+		Name:    c.Name,
+		Limit: compute.QuotaResources{
+			VCpus:  c.Vcpus,
+			Memory: compute.NewSize(c.MemoryMb, compute.SizeUnitM),
+			Disk:   compute.NewSize(c.DiskGb, compute.SizeUnitG),
+			Vms:    c.Vms,
+		},
+	}
+}
+
+func ldapSettingsFromConfig(c config.AuthConfig) auth.LdapSettings {
+	settings := auth.LdapSettings{
+		Url:                c.Url,
+		StartTLS:           c.StartTLS,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		BindDn:             c.BindDn,
+		BindPassword:       c.BindPassword,
+		BaseDn:             c.BaseDn,
+		UserFilter:         c.UserFilter,
+		IdAttribute:        c.IdAttribute,
+		FullNameAttribute:  c.FullNameAttribute,
+		EmailAttribute:     c.EmailAttribute,
+		GroupAttribute:     c.GroupAttribute,
+	}
+	settings.Groups = groupGrantsFromConfig(c.Groups)
+	return settings
+}
+
+func groupGrantsFromConfig(groups []config.AuthGroupConfig) auth.GroupGrants {
+	result := auth.GroupGrants{}
+	for _, group := range groups {
+		result = append(result, auth.GroupGrant{
+			Group:      group.Name,
+			Role:       auth.NewRole(group.Role),
+			Nodes:      group.Nodes,
+			VmPrefixes: group.VmPrefixes,
+			Projects:   group.Projects,
+		})
+	}
+	return result
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"subuk/vmango/auth"
 
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh/terminal"
@@ -20,3 +21,12 @@ func GenPassword() {
 	fmt.Printf(string(hashedPassword))
 	fmt.Fprintf(os.Stderr, "\n")
 }
+
+func GenApiToken() {
+	token, err := auth.GenerateApiToken()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stderr, "Token (send as 'Authorization: Bearer <token>' header): %s\n", token)
+	fmt.Printf("hashed_token = \"%s\"\n", auth.HashApiToken(token))
+}
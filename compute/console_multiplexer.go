@@ -0,0 +1,364 @@
+package compute
+
+import (
+	"errors"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+)
+
+var ErrConsoleReadOnly = errors.New("console is read-only for viewer")
+var ErrConsoleControlHeld = errors.New("console input is held by another viewer")
+
+const (
+	consoleViewerBuffer = 256
+	consoleBacklogSize  = 16 * 1024
+	consoleReadSize     = 4096
+)
+
+// Input control may be taken over from holder which hasn't typed anything
+// for this long, so forgotten browser tab doesn't lock everyone out.
+var consoleControlIdle = 2 * time.Minute
+
+// ConsoleStatus is sent to viewer when viewers or input holder change.
+type ConsoleStatus struct {
+	Holder    string
+	Control   bool
+	Viewers   []string
+	Recording bool
+	Error     string
+}
+
+// ConsoleMultiplexer shares one console stream of a machine between
+// viewers. Output goes to every viewer, input is accepted only from
+// the viewer holding control. The stream is opened by the first viewer
+// and closed when the last one leaves. Session is recorded once per
+// stream, viewers joining and control changes are recorded as markers.
+// Stream and recording are opened and closed without holding the lock,
+// so slow hypervisor or recordings pruning doesn't stall other consoles.
+type ConsoleMultiplexer struct {
+	vms        *VirtualMachineService
+	recordings *ConsoleRecordingService
+	consoles   map[string]*sharedConsole
+	mu         *sync.Mutex
+}
+
+// sharedConsole is registered before its stream is opened, ready is
+// closed when opening is finished, successfully or with openErr.
+type sharedConsole struct {
+	key       string
+	ready     chan struct{}
+	openErr   error
+	stream    VirtualMachineConsoleStream
+	recorder  *ConsoleRecorder
+	recordErr error
+	viewers   []*ConsoleViewer
+	holder    *ConsoleViewer
+	lastInput time.Time
+	backlog   []byte
+	closed    bool
+}
+
+func NewConsoleMultiplexer(vms *VirtualMachineService, recordings *ConsoleRecordingService) *ConsoleMultiplexer {
+	return &ConsoleMultiplexer{
+		vms:        vms,
+		recordings: recordings,
+		consoles:   map[string]*sharedConsole{},
+		mu:         &sync.Mutex{},
+	}
+}
+
+// Attach joins user to machine console. Width and height are used
+// for recording if the console is not open yet. Recent output is sent to
+// new viewer first, so it sees current screen contents.
+func (m *ConsoleMultiplexer) Attach(id, node, user string, width, height int) (*ConsoleViewer, error) {
+	key := node + "/" + id
+	for {
+		m.mu.Lock()
+		console := m.consoles[key]
+		opener := console == nil
+		if opener {
+			console = &sharedConsole{key: key, ready: make(chan struct{})}
+			m.consoles[key] = console
+		}
+		m.mu.Unlock()
+		if opener {
+			m.open(console, id, node, user, width, height)
+		}
+		<-console.ready
+
+		m.mu.Lock()
+		if console.openErr != nil {
+			m.mu.Unlock()
+			return nil, console.openErr
+		}
+		if console.closed {
+			m.mu.Unlock()
+			if opener {
+				return nil, errors.New("console closed right after opening")
+			}
+			continue
+		}
+		viewer := m.join(console, user)
+		m.mu.Unlock()
+		return viewer, nil
+	}
+}
+
+// open opens stream and recording of registered console, it must be
+// called without lock held.
+func (m *ConsoleMultiplexer) open(console *sharedConsole, id, node, user string, width, height int) {
+	stream, err := m.vms.GetConsoleStream(id, node)
+	var recorder *ConsoleRecorder
+	var recordErr error
+	if err == nil {
+		recorder, recordErr = m.recordings.Record(id, node, user, width, height)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer close(console.ready)
+	if err != nil {
+		console.openErr = util.NewError(err, "cannot open console")
+		console.closed = true
+		delete(m.consoles, console.key)
+		return
+	}
+	console.stream = stream
+	console.recorder = recorder
+	console.recordErr = recordErr
+	go m.pump(console)
+}
+
+// join must be called with lock held.
+func (m *ConsoleMultiplexer) join(console *sharedConsole, user string) *ConsoleViewer {
+	viewer := &ConsoleViewer{
+		User:    user,
+		m:       m,
+		console: console,
+		output:  make(chan []byte, consoleViewerBuffer),
+		status:  make(chan ConsoleStatus, 1),
+	}
+	if len(console.backlog) > 0 {
+		viewer.output <- append([]byte{}, console.backlog...)
+	}
+	console.viewers = append(console.viewers, viewer)
+	console.recorder.Marker(user + " joined")
+	if console.holder == nil {
+		m.grant(console, viewer)
+	}
+	m.notify(console)
+	return viewer
+}
+
+func (m *ConsoleMultiplexer) pump(console *sharedConsole) {
+	buf := make([]byte, consoleReadSize)
+	for {
+		n, err := console.stream.Read(buf)
+		m.mu.Lock()
+		if err != nil || console.closed {
+			finish := m.close(console)
+			m.mu.Unlock()
+			finish()
+			return
+		}
+		data := append([]byte{}, buf[:n]...)
+		console.backlog = append(console.backlog, data...)
+		if len(console.backlog) > consoleBacklogSize {
+			console.backlog = append([]byte{}, console.backlog[len(console.backlog)-consoleBacklogSize:]...)
+		}
+		console.recorder.Output(data)
+		finishes := []func(){}
+		for _, viewer := range append([]*ConsoleViewer{}, console.viewers...) {
+			select {
+			case viewer.output <- data:
+			default:
+				// viewer doesn't keep up, drop it instead of stalling others
+				finishes = append(finishes, m.detach(viewer))
+			}
+		}
+		m.mu.Unlock()
+		for _, finish := range finishes {
+			finish()
+		}
+	}
+}
+
+func noFinish() {}
+
+// close must be called with lock held. Returned function closes the
+// stream and recording, it must be called after the lock is released,
+// as closing recording prunes old ones.
+func (m *ConsoleMultiplexer) close(console *sharedConsole) func() {
+	if console.closed {
+		return noFinish
+	}
+	console.closed = true
+	if m.consoles[console.key] == console {
+		delete(m.consoles, console.key)
+	}
+	for _, viewer := range console.viewers {
+		viewer.closed = true
+		close(viewer.output)
+		close(viewer.status)
+	}
+	console.viewers = nil
+	console.holder = nil
+	return func() {
+		console.stream.Close()
+		console.recorder.Close()
+	}
+}
+
+// detach must be called with lock held, returned function must be called
+// after the lock is released, see close.
+func (m *ConsoleMultiplexer) detach(viewer *ConsoleViewer) func() {
+	if viewer.closed {
+		return noFinish
+	}
+	viewer.closed = true
+	close(viewer.output)
+	close(viewer.status)
+	console := viewer.console
+	for idx, other := range console.viewers {
+		if other == viewer {
+			console.viewers = append(console.viewers[:idx], console.viewers[idx+1:]...)
+			break
+		}
+	}
+	console.recorder.Marker(viewer.User + " left")
+	if len(console.viewers) == 0 {
+		return m.close(console)
+	}
+	if console.holder == viewer {
+		console.holder = nil
+		console.recorder.Marker("input released")
+	}
+	m.notify(console)
+	return noFinish
+}
+
+// grant must be called with lock held.
+func (m *ConsoleMultiplexer) grant(console *sharedConsole, viewer *ConsoleViewer) {
+	console.holder = viewer
+	console.lastInput = time.Now()
+	console.recorder.Marker("input by " + viewer.User)
+}
+
+func (m *ConsoleMultiplexer) status(console *sharedConsole, viewer *ConsoleViewer) ConsoleStatus {
+	status := ConsoleStatus{
+		Control:   console.holder == viewer,
+		Recording: console.recorder != nil,
+		Viewers:   []string{},
+	}
+	if console.holder != nil {
+		status.Holder = console.holder.User
+	}
+	for _, other := range console.viewers {
+		status.Viewers = append(status.Viewers, other.User)
+	}
+	return status
+}
+
+// send replaces status not yet read by viewer, only the latest one matters.
+func (m *ConsoleMultiplexer) send(viewer *ConsoleViewer, status ConsoleStatus) {
+	select {
+	case <-viewer.status:
+	default:
+	}
+	viewer.status <- status
+}
+
+// notify must be called with lock held.
+func (m *ConsoleMultiplexer) notify(console *sharedConsole) {
+	for _, viewer := range console.viewers {
+		m.send(viewer, m.status(console, viewer))
+	}
+}
+
+// ConsoleViewer is one user attached to shared console.
+type ConsoleViewer struct {
+	User    string
+	m       *ConsoleMultiplexer
+	console *sharedConsole
+	output  chan []byte
+	status  chan ConsoleStatus
+	closed  bool
+}
+
+// Output returns console output, channel is closed when viewer
+// is detached or console is closed.
+func (viewer *ConsoleViewer) Output() <-chan []byte {
+	return viewer.output
+}
+
+// Status returns status changes, channel is closed together with Output.
+func (viewer *ConsoleViewer) Status() <-chan ConsoleStatus {
+	return viewer.status
+}
+
+// RecordingError returns error which prevented session recording.
+func (viewer *ConsoleViewer) RecordingError() error {
+	viewer.m.mu.Lock()
+	defer viewer.m.mu.Unlock()
+	return viewer.console.recordErr
+}
+
+// Write sends input to console if viewer holds control.
+func (viewer *ConsoleViewer) Write(data []byte) (int, error) {
+	viewer.m.mu.Lock()
+	if viewer.closed {
+		viewer.m.mu.Unlock()
+		return 0, util.NewError(ErrConsoleReadOnly, "viewer is detached")
+	}
+	if viewer.console.holder != viewer {
+		viewer.m.mu.Unlock()
+		return 0, ErrConsoleReadOnly
+	}
+	viewer.console.lastInput = time.Now()
+	stream := viewer.console.stream
+	viewer.m.mu.Unlock()
+	return stream.Write(data)
+}
+
+// TakeControl makes viewer the input holder. Control held by another
+// viewer may only be taken when it is idle.
+func (viewer *ConsoleViewer) TakeControl() error {
+	viewer.m.mu.Lock()
+	defer viewer.m.mu.Unlock()
+	console := viewer.console
+	if viewer.closed || console.holder == viewer {
+		return nil
+	}
+	if console.holder != nil && time.Since(console.lastInput) < consoleControlIdle {
+		err := util.NewError(ErrConsoleControlHeld, "input is held by %s", console.holder.User)
+		status := viewer.m.status(console, viewer)
+		status.Error = err.Error()
+		viewer.m.send(viewer, status)
+		return err
+	}
+	viewer.m.grant(console, viewer)
+	viewer.m.notify(console)
+	return nil
+}
+
+// ReleaseControl gives up input control, console stays without holder
+// until somebody takes it.
+func (viewer *ConsoleViewer) ReleaseControl() {
+	viewer.m.mu.Lock()
+	defer viewer.m.mu.Unlock()
+	if viewer.closed || viewer.console.holder != viewer {
+		return
+	}
+	viewer.console.holder = nil
+	viewer.console.recorder.Marker("input released")
+	viewer.m.notify(viewer.console)
+}
+
+// Close detaches viewer, the last viewer closes the console.
+func (viewer *ConsoleViewer) Close() {
+	viewer.m.mu.Lock()
+	finish := viewer.m.detach(viewer)
+	viewer.m.mu.Unlock()
+	finish()
+}
@@ -0,0 +1,203 @@
+package compute
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConsoleStream struct {
+	*io.PipeReader
+	output *io.PipeWriter
+	mu     sync.Mutex
+	input  []byte
+	closed bool
+}
+
+func newFakeConsoleStream() *fakeConsoleStream {
+	reader, writer := io.Pipe()
+	return &fakeConsoleStream{PipeReader: reader, output: writer}
+}
+
+func (stream *fakeConsoleStream) Write(data []byte) (int, error) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.input = append(stream.input, data...)
+	return len(data), nil
+}
+
+func (stream *fakeConsoleStream) Close() error {
+	stream.mu.Lock()
+	stream.closed = true
+	stream.mu.Unlock()
+	return stream.output.Close()
+}
+
+func (stream *fakeConsoleStream) isClosed() bool {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return stream.closed
+}
+
+// fakeConsoleRepository opens fake console streams, opening of machines
+// listed in blocked waits until their channel is closed.
+type fakeConsoleRepository struct {
+	VirtualMachineRepository
+	mu      sync.Mutex
+	err     error
+	blocked map[string]chan struct{}
+	streams []*fakeConsoleStream
+}
+
+func (repo *fakeConsoleRepository) GetConsoleStream(id, node string) (VirtualMachineConsoleStream, error) {
+	if block := repo.blocked[id]; block != nil {
+		<-block
+	}
+	if repo.err != nil {
+		return nil, repo.err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stream := newFakeConsoleStream()
+	repo.streams = append(repo.streams, stream)
+	return stream, nil
+}
+
+func (repo *fakeConsoleRepository) opened() []*fakeConsoleStream {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return append([]*fakeConsoleStream{}, repo.streams...)
+}
+
+func newTestConsoleMultiplexer(repo *fakeConsoleRepository) *ConsoleMultiplexer {
+	vms := NewVirtualMachineService(repo, &fakeEventPublisher{}, time.Second)
+	return NewConsoleMultiplexer(vms, NewConsoleRecordingService(nil, false, 0, 0))
+}
+
+func TestConsoleMultiplexerAttach(t *testing.T) {
+	tests := []struct {
+		name        string
+		users       []string
+		openErr     error
+		wantErr     bool
+		wantStreams int
+		wantHolder  string
+	}{
+		{
+			name:        "first viewer opens stream and holds input",
+			users:       []string{"alice"},
+			wantStreams: 1,
+			wantHolder:  "alice",
+		},
+		{
+			name:        "viewers share stream",
+			users:       []string{"alice", "bob", "carol"},
+			wantStreams: 1,
+			wantHolder:  "alice",
+		},
+		{
+			name:    "stream cannot be opened",
+			users:   []string{"alice"},
+			openErr: errors.New("domain is not running"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeConsoleRepository{err: tt.openErr}
+			m := newTestConsoleMultiplexer(repo)
+			viewers := []*ConsoleViewer{}
+			for _, user := range tt.users {
+				viewer, err := m.Attach("web1", "n1", user, 80, 24)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Attach() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil {
+					viewers = append(viewers, viewer)
+				}
+			}
+			streams := repo.opened()
+			if len(streams) != tt.wantStreams {
+				t.Fatalf("Attach() opened %d streams, want %d", len(streams), tt.wantStreams)
+			}
+			if tt.wantErr {
+				if len(m.consoles) != 0 {
+					t.Errorf("Attach() kept %d consoles after failure", len(m.consoles))
+				}
+				return
+			}
+			status := <-viewers[len(viewers)-1].Status()
+			if status.Holder != tt.wantHolder || len(status.Viewers) != len(tt.users) {
+				t.Errorf("Status() = %+v, want holder %s and %d viewers", status, tt.wantHolder, len(tt.users))
+			}
+
+			streams[0].output.Write([]byte("login: "))
+			for _, viewer := range viewers {
+				if got := string(<-viewer.Output()); got != "login: " {
+					t.Errorf("Output() of %s = %q, want %q", viewer.User, got, "login: ")
+				}
+			}
+			for _, viewer := range viewers {
+				viewer.Write([]byte(viewer.User))
+			}
+			if got := string(streams[0].input); got != tt.wantHolder {
+				t.Errorf("stream input = %q, want only input of holder %q", got, tt.wantHolder)
+			}
+
+			for idx, viewer := range viewers {
+				viewer.Close()
+				if last := idx == len(viewers)-1; streams[0].isClosed() != last {
+					t.Errorf("stream closed = %v after %d of %d viewers left", streams[0].isClosed(), idx+1, len(viewers))
+				}
+			}
+		})
+	}
+}
+
+func TestConsoleMultiplexerAttachSlowOpen(t *testing.T) {
+	block := make(chan struct{})
+	repo := &fakeConsoleRepository{blocked: map[string]chan struct{}{"web1": block}}
+	m := newTestConsoleMultiplexer(repo)
+
+	attached := make(chan *ConsoleViewer, 2)
+	for _, user := range []string{"alice", "bob"} {
+		go func(user string) {
+			viewer, err := m.Attach("web1", "n1", user, 80, 24)
+			if err != nil {
+				t.Errorf("Attach() error = %v", err)
+			}
+			attached <- viewer
+		}(user)
+	}
+
+	other := make(chan error, 1)
+	go func() {
+		viewer, err := m.Attach("db1", "n1", "carol", 80, 24)
+		if err == nil {
+			viewer.Close()
+		}
+		other <- err
+	}()
+	select {
+	case err := <-other:
+		if err != nil {
+			t.Fatalf("Attach() to other machine error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Attach() to other machine is blocked by console being opened")
+	}
+
+	close(block)
+	first, second := <-attached, <-attached
+	streams := repo.opened()
+	if len(streams) != 2 {
+		t.Errorf("opened %d streams, want one per machine", len(streams))
+	}
+	if first.console != second.console {
+		t.Errorf("viewers waiting for opening console got different consoles")
+	}
+	first.Close()
+	second.Close()
+}
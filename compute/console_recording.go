@@ -0,0 +1,198 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"subuk/vmango/asciicast"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrConsoleRecordingNotFound = errors.New("console recording not found")
+
+// ConsoleRecording describes recorded serial console session, the session
+// itself is stored in asciicast v2 format.
+type ConsoleRecording struct {
+	Id        string
+	VmId      string
+	NodeId    string
+	User      string
+	Width     int
+	Height    int
+	Started   time.Time
+	Finished  time.Time
+	Size      uint64
+	Truncated bool
+}
+
+func (recording *ConsoleRecording) Duration() time.Duration {
+	if recording.Finished.IsZero() {
+		return 0
+	}
+	return recording.Finished.Sub(recording.Started).Round(time.Second)
+}
+
+type ConsoleRecordingListOptions struct {
+	VmId   string
+	NodeId string
+}
+
+func (options ConsoleRecordingListOptions) Match(recording *ConsoleRecording) bool {
+	if options.VmId != "" && recording.VmId != options.VmId {
+		return false
+	}
+	if options.NodeId != "" && recording.NodeId != options.NodeId {
+		return false
+	}
+	return true
+}
+
+// ConsoleRecordingRepository stores recording metadata and asciicast
+// content. List returns newest recordings first.
+type ConsoleRecordingRepository interface {
+	List(options ConsoleRecordingListOptions) ([]*ConsoleRecording, error)
+	Get(id string) (*ConsoleRecording, error)
+	Create(recording *ConsoleRecording) (io.WriteCloser, error)
+	Save(recording *ConsoleRecording) error
+	Open(id string) (io.ReadCloser, error)
+	Delete(id string) error
+}
+
+// ConsoleRecordingService records console sessions and removes recordings
+// older than maxAge or above maxSize bytes in total, oldest first. Sessions
+// in progress are only removed by age.
+type ConsoleRecordingService struct {
+	ConsoleRecordingRepository
+	enabled bool
+	maxAge  time.Duration
+	maxSize uint64
+}
+
+func NewConsoleRecordingService(repo ConsoleRecordingRepository, enabled bool, maxAge time.Duration, maxSize uint64) *ConsoleRecordingService {
+	return &ConsoleRecordingService{repo, enabled, maxAge, maxSize}
+}
+
+// Record starts new recording, it returns nil recorder if recording
+// is disabled.
+func (service *ConsoleRecordingService) Record(vmId, nodeId, user string, width, height int) (*ConsoleRecorder, error) {
+	if !service.enabled {
+		return nil, nil
+	}
+	recording := &ConsoleRecording{
+		Id:      uuid.New().String(),
+		VmId:    vmId,
+		NodeId:  nodeId,
+		User:    user,
+		Width:   width,
+		Height:  height,
+		Started: time.Now(),
+	}
+	file, err := service.ConsoleRecordingRepository.Create(recording)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create recording")
+	}
+	writer, err := asciicast.NewWriter(file, asciicast.Header{
+		Width:     width,
+		Height:    height,
+		Timestamp: recording.Started.Unix(),
+		Title:     fmt.Sprintf("%s on %s by %s", vmId, nodeId, user),
+		Env:       map[string]string{"TERM": "xterm"},
+	})
+	if err != nil {
+		file.Close()
+		service.ConsoleRecordingRepository.Delete(recording.Id)
+		return nil, util.NewError(err, "cannot write recording header")
+	}
+	return &ConsoleRecorder{service: service, recording: recording, file: file, writer: writer, mu: &sync.Mutex{}}, nil
+}
+
+// Prune removes recordings exceeding retention limits.
+func (service *ConsoleRecordingService) Prune() error {
+	recordings, err := service.ConsoleRecordingRepository.List(ConsoleRecordingListOptions{})
+	if err != nil {
+		return util.NewError(err, "cannot list recordings")
+	}
+	total := uint64(0)
+	for _, recording := range recordings {
+		total += recording.Size
+		expired := service.maxAge > 0 && time.Since(recording.Started) > service.maxAge
+		oversized := service.maxSize > 0 && total > service.maxSize
+		if expired || (oversized && !recording.Finished.IsZero()) {
+			if err := service.ConsoleRecordingRepository.Delete(recording.Id); err != nil {
+				return util.NewError(err, "cannot delete recording %s", recording.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// ConsoleRecorder writes console output to recording. All methods are
+// no-op for nil recorder. Recording stops silently when it reaches
+// service size limit or cannot be written, console session goes on.
+type ConsoleRecorder struct {
+	service   *ConsoleRecordingService
+	recording *ConsoleRecording
+	file      io.WriteCloser
+	writer    *asciicast.Writer
+	stopped   bool
+	mu        *sync.Mutex
+}
+
+func (recorder *ConsoleRecorder) Output(data []byte) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.stopped {
+		return
+	}
+	if recorder.service.maxSize > 0 && uint64(recorder.writer.Written()+int64(len(data))) > recorder.service.maxSize {
+		recorder.recording.Truncated = true
+		recorder.stopped = true
+		return
+	}
+	if err := recorder.writer.Output(data); err != nil {
+		recorder.recording.Truncated = true
+		recorder.stopped = true
+	}
+}
+
+// Marker notes session event like viewer joining or taking input control.
+func (recorder *ConsoleRecorder) Marker(label string) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.stopped {
+		return
+	}
+	if err := recorder.writer.Marker(label); err != nil {
+		recorder.recording.Truncated = true
+		recorder.stopped = true
+	}
+}
+
+// Close finishes recording and applies retention limits.
+func (recorder *ConsoleRecorder) Close() error {
+	if recorder == nil {
+		return nil
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.stopped = true
+	if err := recorder.file.Close(); err != nil {
+		return util.NewError(err, "cannot close recording")
+	}
+	recorder.recording.Finished = time.Now()
+	recorder.recording.Size = uint64(recorder.writer.Written())
+	if err := recorder.service.ConsoleRecordingRepository.Save(recorder.recording); err != nil {
+		return util.NewError(err, "cannot save recording")
+	}
+	return recorder.service.Prune()
+}
@@ -1,9 +1,14 @@
 package compute
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 )
 
+// ErrActionRejected is the cause of RejectedError.
+var ErrActionRejected = errors.New("action rejected")
+
 type Event interface {
 	Name() string
 	Plain() map[string]string
@@ -13,6 +18,109 @@ type EventPublisher interface {
 	Publish(event Event) error
 }
 
+// EventPublishers publishes event with every publisher in order,
+// the first error is returned without calling the rest.
+type EventPublishers []EventPublisher
+
+func (epubs EventPublishers) Publish(event Event) error {
+	for _, epub := range epubs {
+		if err := epub.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectedError is returned when mandatory subscriber of before_* event
+// fails, Err describes the failure including subscriber output.
+type RejectedError struct {
+	Event string
+	Err   error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by %s subscriber: %s", e.Event, e.Err)
+}
+
+func (e *RejectedError) Cause() error {
+	return ErrActionRejected
+}
+
+// EventBefore is published synchronously before an action and has the
+// same values as the event published after it, except for the name.
+type EventBefore struct {
+	name  string
+	event Event
+}
+
+func NewEventBefore(name string, event Event) *EventBefore {
+	return &EventBefore{name: name, event: event}
+}
+
+func (e *EventBefore) Name() string {
+	return e.name
+}
+
+func (e *EventBefore) Plain() map[string]string {
+	data := e.event.Plain()
+	data["event"] = e.name
+	return data
+}
+
+// publishBefore publishes before_* event, action must not be done if
+// it returns an error.
+func publishBefore(epub EventPublisher, name string, event Event) error {
+	if err := epub.Publish(NewEventBefore(name, event)); err != nil {
+		return &RejectedError{Event: name, Err: err}
+	}
+	return nil
+}
+
+// virtualMachinePlain returns common machine values used by events:
+// vm_id, vm_node, vm_owner, vm_project, vm_state, vm_state_reason, vm_cpus,
+// vm_memory_mib, vm_volume_count, vm_interface_count and for each volume
+// and interface with index N vm_volume_N_path, vm_volume_N_device,
+// vm_interface_N_mac, vm_interface_N_network, vm_interface_N_ip_addresses.
+func virtualMachinePlain(name string, vm *VirtualMachine) map[string]string {
+	data := map[string]string{
+		"event":              name,
+		"vm_id":              vm.Id,
+		"vm_node":            vm.NodeId,
+		"vm_owner":           vm.Owner,
+		"vm_project":         vm.Project,
+		"vm_state":           vm.State.String(),
+		"vm_state_reason":    vm.StateReason,
+		"vm_cpus":            fmt.Sprintf("%d", vm.VCpus),
+		"vm_memory_mib":      fmt.Sprintf("%d", vm.Memory.M()),
+		"vm_volume_count":    fmt.Sprintf("%d", len(vm.Volumes)),
+		"vm_interface_count": fmt.Sprintf("%d", len(vm.Interfaces)),
+	}
+	for idx, volume := range vm.Volumes {
+		data[fmt.Sprintf("vm_volume_%d_path", idx)] = volume.Path
+		data[fmt.Sprintf("vm_volume_%d_device", idx)] = volume.DeviceType.String()
+	}
+	for idx, iface := range vm.Interfaces {
+		data[fmt.Sprintf("vm_interface_%d_mac", idx)] = iface.Mac
+		data[fmt.Sprintf("vm_interface_%d_network", idx)] = iface.NetworkName
+		data[fmt.Sprintf("vm_interface_%d_type", idx)] = "libvirt" // BC
+		data[fmt.Sprintf("vm_interface_%d_ip_addresses", idx)] = strings.Join(iface.IpAddressList, " ")
+	}
+	return data
+}
+
+// virtualMachineActionPlain returns vm_id, vm_node and vm_action values
+// for events about actions on existing machine.
+func virtualMachineActionPlain(name, id, node, action string) map[string]string {
+	return map[string]string{
+		"event":     name,
+		"vm_id":     id,
+		"vm_node":   node,
+		"vm_action": action,
+	}
+}
+
+// EventVirtualMachineCreated is published when machine is created or
+// imported, before it is started. Plain has common machine values.
 type EventVirtualMachineCreated struct {
 	vm *VirtualMachine
 }
@@ -26,22 +134,328 @@ func (e *EventVirtualMachineCreated) Name() string {
 }
 
 func (e *EventVirtualMachineCreated) Plain() map[string]string {
-	data := map[string]string{
-		"event":              e.Name(),
-		"vm_id":              e.vm.Id,
-		"vm_cpus":            fmt.Sprintf("%d", e.vm.VCpus),
-		"vm_memory_mib":      fmt.Sprintf("%d", e.vm.Memory.M()),
-		"vm_volume_count":    fmt.Sprintf("%d", len(e.vm.Volumes)),
-		"vm_interface_count": fmt.Sprintf("%d", len(e.vm.Interfaces)),
-	}
-	for idx, volume := range e.vm.Volumes {
-		data[fmt.Sprintf("vm_volume_%d_path", idx)] = volume.Path
-		data[fmt.Sprintf("vm_volume_%d_device", idx)] = volume.DeviceType.String()
+	return virtualMachinePlain(e.Name(), e.vm)
+}
+
+// EventVirtualMachineUpdated is published when machine settings are saved.
+// Plain has common values of the updated machine.
+type EventVirtualMachineUpdated struct {
+	vm *VirtualMachine
+}
+
+func NewEventVirtualMachineUpdated(vm *VirtualMachine) *EventVirtualMachineUpdated {
+	return &EventVirtualMachineUpdated{vm: vm}
+}
+
+func (e *EventVirtualMachineUpdated) Name() string {
+	return "vm_updated"
+}
+
+func (e *EventVirtualMachineUpdated) Plain() map[string]string {
+	return virtualMachinePlain(e.Name(), e.vm)
+}
+
+// EventVirtualMachineDeleted is published after machine is deleted.
+// Plain has common values of the machine as it was before deletion and
+// vm_volumes_deleted ("true" or "false").
+type EventVirtualMachineDeleted struct {
+	vm             *VirtualMachine
+	volumesDeleted bool
+}
+
+func NewEventVirtualMachineDeleted(vm *VirtualMachine, volumesDeleted bool) *EventVirtualMachineDeleted {
+	return &EventVirtualMachineDeleted{vm: vm, volumesDeleted: volumesDeleted}
+}
+
+func (e *EventVirtualMachineDeleted) Name() string {
+	return "vm_deleted"
+}
+
+func (e *EventVirtualMachineDeleted) Plain() map[string]string {
+	data := virtualMachinePlain(e.Name(), e.vm)
+	data["vm_volumes_deleted"] = fmt.Sprintf("%t", e.volumesDeleted)
+	return data
+}
+
+// EventVirtualMachineStarted is published when machine is powered on or
+// restored from saved state. Plain has vm_id, vm_node and vm_action (start).
+type EventVirtualMachineStarted struct {
+	id, node, action string
+}
+
+func NewEventVirtualMachineStarted(id, node, action string) *EventVirtualMachineStarted {
+	return &EventVirtualMachineStarted{id: id, node: node, action: action}
+}
+
+func (e *EventVirtualMachineStarted) Name() string {
+	return "vm_started"
+}
+
+func (e *EventVirtualMachineStarted) Plain() map[string]string {
+	return virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+}
+
+// EventVirtualMachineStopped is published when machine is stopped. Plain
+// has vm_id, vm_node and vm_action (shutdown, poweroff or save).
+type EventVirtualMachineStopped struct {
+	id, node, action string
+}
+
+func NewEventVirtualMachineStopped(id, node, action string) *EventVirtualMachineStopped {
+	return &EventVirtualMachineStopped{id: id, node: node, action: action}
+}
+
+func (e *EventVirtualMachineStopped) Name() string {
+	return "vm_stopped"
+}
+
+func (e *EventVirtualMachineStopped) Plain() map[string]string {
+	return virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+}
+
+// EventVirtualMachineRebooted is published when machine is rebooted.
+// Plain has vm_id, vm_node and vm_action (reboot or reset).
+type EventVirtualMachineRebooted struct {
+	id, node, action string
+}
+
+func NewEventVirtualMachineRebooted(id, node, action string) *EventVirtualMachineRebooted {
+	return &EventVirtualMachineRebooted{id: id, node: node, action: action}
+}
+
+func (e *EventVirtualMachineRebooted) Name() string {
+	return "vm_rebooted"
+}
+
+func (e *EventVirtualMachineRebooted) Plain() map[string]string {
+	return virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+}
+
+// EventVirtualMachinePaused is published when machine is paused. Plain
+// has vm_id, vm_node and vm_action (pause).
+type EventVirtualMachinePaused struct {
+	id, node, action string
+}
+
+func NewEventVirtualMachinePaused(id, node, action string) *EventVirtualMachinePaused {
+	return &EventVirtualMachinePaused{id: id, node: node, action: action}
+}
+
+func (e *EventVirtualMachinePaused) Name() string {
+	return "vm_paused"
+}
+
+func (e *EventVirtualMachinePaused) Plain() map[string]string {
+	return virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+}
+
+// EventVirtualMachineResumed is published when paused machine is resumed.
+// Plain has vm_id, vm_node and vm_action (resume).
+type EventVirtualMachineResumed struct {
+	id, node, action string
+}
+
+func NewEventVirtualMachineResumed(id, node, action string) *EventVirtualMachineResumed {
+	return &EventVirtualMachineResumed{id: id, node: node, action: action}
+}
+
+func (e *EventVirtualMachineResumed) Name() string {
+	return "vm_resumed"
+}
+
+func (e *EventVirtualMachineResumed) Plain() map[string]string {
+	return virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+}
+
+// EventVirtualMachineMigrated is published when running machine is
+// migrated to another node. Plain has vm_id, vm_node (source node),
+// vm_target_node and vm_copy_storage ("true" or "false").
+type EventVirtualMachineMigrated struct {
+	id, node string
+	params   VirtualMachineMigrateParams
+}
+
+func NewEventVirtualMachineMigrated(id, node string, params VirtualMachineMigrateParams) *EventVirtualMachineMigrated {
+	return &EventVirtualMachineMigrated{id: id, node: node, params: params}
+}
+
+func (e *EventVirtualMachineMigrated) Name() string {
+	return "vm_migrated"
+}
+
+func (e *EventVirtualMachineMigrated) Plain() map[string]string {
+	return map[string]string{
+		"event":           e.Name(),
+		"vm_id":           e.id,
+		"vm_node":         e.node,
+		"vm_target_node":  e.params.NodeId,
+		"vm_copy_storage": fmt.Sprintf("%t", e.params.CopyStorage),
 	}
-	for idx, iface := range e.vm.Interfaces {
-		data[fmt.Sprintf("vm_interface_%d_mac", idx)] = iface.Mac
-		data[fmt.Sprintf("vm_interface_%d_network", idx)] = iface.NetworkName
-		data[fmt.Sprintf("vm_interface_%d_type", idx)] = "libvirt" // BC
+}
+
+// EventVirtualMachineSnapshotted is published when snapshot of machine is
+// created, reverted or deleted. Plain has vm_id, vm_node, vm_action
+// (create, revert or delete) and snapshot_name.
+type EventVirtualMachineSnapshotted struct {
+	id, node, action, name string
+}
+
+func NewEventVirtualMachineSnapshotted(id, node, action, name string) *EventVirtualMachineSnapshotted {
+	return &EventVirtualMachineSnapshotted{id: id, node: node, action: action, name: name}
+}
+
+func (e *EventVirtualMachineSnapshotted) Name() string {
+	return "vm_snapshotted"
+}
+
+func (e *EventVirtualMachineSnapshotted) Plain() map[string]string {
+	data := virtualMachineActionPlain(e.Name(), e.id, e.node, e.action)
+	data["snapshot_name"] = e.name
+	return data
+}
+
+// EventVirtualMachineVolumeAttached is published when volume is attached
+// to machine. Plain has vm_id, vm_node, volume_path, volume_device and
+// volume_bus.
+type EventVirtualMachineVolumeAttached struct {
+	id, node string
+	volume   *VirtualMachineAttachedVolume
+}
+
+func NewEventVirtualMachineVolumeAttached(id, node string, volume *VirtualMachineAttachedVolume) *EventVirtualMachineVolumeAttached {
+	return &EventVirtualMachineVolumeAttached{id: id, node: node, volume: volume}
+}
+
+func (e *EventVirtualMachineVolumeAttached) Name() string {
+	return "vm_volume_attached"
+}
+
+func (e *EventVirtualMachineVolumeAttached) Plain() map[string]string {
+	return map[string]string{
+		"event":         e.Name(),
+		"vm_id":         e.id,
+		"vm_node":       e.node,
+		"volume_path":   e.volume.Path,
+		"volume_device": e.volume.DeviceType.String(),
+		"volume_bus":    e.volume.DeviceBus.String(),
+	}
+}
+
+// EventVirtualMachineVolumeDetached is published when volume is detached
+// from machine. Plain has vm_id, vm_node and volume_path.
+type EventVirtualMachineVolumeDetached struct {
+	id, node, path string
+}
+
+func NewEventVirtualMachineVolumeDetached(id, node, path string) *EventVirtualMachineVolumeDetached {
+	return &EventVirtualMachineVolumeDetached{id: id, node: node, path: path}
+}
+
+func (e *EventVirtualMachineVolumeDetached) Name() string {
+	return "vm_volume_detached"
+}
+
+func (e *EventVirtualMachineVolumeDetached) Plain() map[string]string {
+	return map[string]string{
+		"event":       e.Name(),
+		"vm_id":       e.id,
+		"vm_node":     e.node,
+		"volume_path": e.path,
+	}
+}
+
+// EventVirtualMachineInterfaceAttached is published when network interface
+// is attached to machine. Plain has vm_id, vm_node, interface_mac,
+// interface_network, interface_model and interface_access_vlan.
+type EventVirtualMachineInterfaceAttached struct {
+	id, node string
+	iface    *VirtualMachineAttachedInterface
+}
+
+func NewEventVirtualMachineInterfaceAttached(id, node string, iface *VirtualMachineAttachedInterface) *EventVirtualMachineInterfaceAttached {
+	return &EventVirtualMachineInterfaceAttached{id: id, node: node, iface: iface}
+}
+
+func (e *EventVirtualMachineInterfaceAttached) Name() string {
+	return "vm_interface_attached"
+}
+
+func (e *EventVirtualMachineInterfaceAttached) Plain() map[string]string {
+	return map[string]string{
+		"event":                 e.Name(),
+		"vm_id":                 e.id,
+		"vm_node":               e.node,
+		"interface_mac":         e.iface.Mac,
+		"interface_network":     e.iface.NetworkName,
+		"interface_model":       e.iface.Model,
+		"interface_access_vlan": fmt.Sprintf("%d", e.iface.AccessVlan),
+	}
+}
+
+// EventVirtualMachineInterfaceDetached is published when network interface
+// is detached from machine. Plain has vm_id, vm_node and interface_mac.
+type EventVirtualMachineInterfaceDetached struct {
+	id, node, mac string
+}
+
+func NewEventVirtualMachineInterfaceDetached(id, node, mac string) *EventVirtualMachineInterfaceDetached {
+	return &EventVirtualMachineInterfaceDetached{id: id, node: node, mac: mac}
+}
+
+func (e *EventVirtualMachineInterfaceDetached) Name() string {
+	return "vm_interface_detached"
+}
+
+func (e *EventVirtualMachineInterfaceDetached) Plain() map[string]string {
+	return map[string]string{
+		"event":         e.Name(),
+		"vm_id":         e.id,
+		"vm_node":       e.node,
+		"interface_mac": e.mac,
+	}
+}
+
+// EventVirtualMachineStateChanged is reported by hypervisor for every
+// domain lifecycle change, including ones made outside of vmango.
+// Machine is nil if it has been undefined. Plain has vm_action (libvirt
+// event, e.g. started or undefined) and common machine values, only
+// vm_id and vm_node for undefined machine.
+type EventVirtualMachineStateChanged struct {
+	nodeId string
+	vmId   string
+	action string
+	vm     *VirtualMachine
+}
+
+func NewEventVirtualMachineStateChanged(nodeId, vmId, action string, vm *VirtualMachine) *EventVirtualMachineStateChanged {
+	return &EventVirtualMachineStateChanged{nodeId: nodeId, vmId: vmId, action: action, vm: vm}
+}
+
+func (e *EventVirtualMachineStateChanged) NodeId() string {
+	return e.nodeId
+}
+
+func (e *EventVirtualMachineStateChanged) VmId() string {
+	return e.vmId
+}
+
+func (e *EventVirtualMachineStateChanged) Action() string {
+	return e.action
+}
+
+func (e *EventVirtualMachineStateChanged) Vm() *VirtualMachine {
+	return e.vm
+}
+
+func (e *EventVirtualMachineStateChanged) Name() string {
+	return "vm_state_changed"
+}
+
+func (e *EventVirtualMachineStateChanged) Plain() map[string]string {
+	if e.vm == nil {
+		return virtualMachineActionPlain(e.Name(), e.vmId, e.nodeId, e.action)
 	}
+	data := virtualMachinePlain(e.Name(), e.vm)
+	data["vm_action"] = e.action
 	return data
 }
@@ -0,0 +1,53 @@
+package compute
+
+import (
+	"sync"
+)
+
+const eventSubscriberBuffer = 64
+
+// EventBroadcaster delivers published events to all current subscribers.
+// Publish never blocks, events are dropped for subscribers which
+// don't keep up.
+type EventBroadcaster struct {
+	subs map[<-chan Event]chan Event
+	mu   *sync.Mutex
+}
+
+func NewEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{
+		subs: map[<-chan Event]chan Event{},
+		mu:   &sync.Mutex{},
+	}
+}
+
+func (b *EventBroadcaster) Publish(event Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns channel receiving all events published from now on,
+// it must be released with Unsubscribe.
+func (b *EventBroadcaster) Subscribe() <-chan Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Event, eventSubscriberBuffer)
+	b.subs[ch] = ch
+	return ch
+}
+
+func (b *EventBroadcaster) Unsubscribe(sub <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(ch)
+	}
+}
@@ -0,0 +1,317 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrEventDeliveryNotFound = errors.New("event delivery not found")
+var ErrEventDeliveryNotFailed = errors.New("event delivery is not failed")
+
+// ErrEventRejected is the cause of subscriber errors which cannot be
+// fixed by retrying, like webhook 4xx response.
+var ErrEventRejected = errors.New("event rejected by subscriber")
+
+const (
+	outboxPollInterval = time.Minute
+	maxRetryBackoff    = 10 * time.Minute
+)
+
+// Initial delays between attempts of synchronous (mandatory) and
+// background deliveries, doubled after each failure.
+var (
+	syncRetryBackoff  = time.Second
+	asyncRetryBackoff = 10 * time.Second
+)
+
+// EventSubscriber delivers events with name Event() to one destination.
+// Name describes the destination and identifies subscriber deliveries
+// in the outbox, so it must be stable across restarts.
+type EventSubscriber interface {
+	Name() string
+	Event() string
+	Mandatory() bool
+	Attempts() int
+	Deliver(delivery *EventDelivery) error
+}
+
+type EventDeliveryState int
+
+const (
+	EventDeliveryStateUnknown EventDeliveryState = iota
+	EventDeliveryStatePending
+	EventDeliveryStateFailed
+)
+
+func (state EventDeliveryState) String() string {
+	switch state {
+	default:
+		return "unknown"
+	case EventDeliveryStatePending:
+		return "pending"
+	case EventDeliveryStateFailed:
+		return "failed"
+	}
+}
+
+func NewEventDeliveryState(input string) EventDeliveryState {
+	switch input {
+	default:
+		return EventDeliveryStateUnknown
+	case "pending":
+		return EventDeliveryStatePending
+	case "failed":
+		return EventDeliveryStateFailed
+	}
+}
+
+// EventDelivery is an event waiting for delivery to one subscriber.
+// Delivered events are removed, failed ones are kept until replayed
+// or discarded.
+type EventDelivery struct {
+	Id          string
+	Event       string
+	Subscriber  string
+	Data        map[string]string
+	State       EventDeliveryState
+	Attempts    int
+	LastError   string
+	Created     time.Time
+	LastAttempt time.Time
+	NextAttempt time.Time
+}
+
+type EventDeliveryListOptions struct {
+	States []EventDeliveryState
+}
+
+func (options EventDeliveryListOptions) Match(delivery *EventDelivery) bool {
+	if len(options.States) == 0 {
+		return true
+	}
+	for _, state := range options.States {
+		if delivery.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+type EventDeliveryRepository interface {
+	List(options EventDeliveryListOptions) ([]*EventDelivery, error)
+	Get(id string) (*EventDelivery, error)
+	Save(delivery *EventDelivery) error
+	Delete(id string) error
+}
+
+func retryBackoff(initial time.Duration, attempt int) time.Duration {
+	backoff := initial
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// EventOutbox publishes events to subscribers. Mandatory subscribers are
+// called synchronously and their failure is returned to publisher. Events
+// for other subscribers are saved to the outbox and delivered in background,
+// each subscriber has its own worker. Failed deliveries are retried with
+// backoff and kept as failed when subscriber attempts are exhausted.
+type EventOutbox struct {
+	EventDeliveryRepository
+	subs   []EventSubscriber
+	wakeup []chan struct{}
+	mu     *sync.Mutex
+}
+
+func NewEventOutbox(repo EventDeliveryRepository) *EventOutbox {
+	return &EventOutbox{
+		EventDeliveryRepository: repo,
+		subs:                    []EventSubscriber{},
+		wakeup:                  []chan struct{}{},
+		mu:                      &sync.Mutex{},
+	}
+}
+
+func (outbox *EventOutbox) Subscribe(sub EventSubscriber) {
+	outbox.subs = append(outbox.subs, sub)
+	outbox.wakeup = append(outbox.wakeup, make(chan struct{}, 1))
+}
+
+func (outbox *EventOutbox) subscriber(delivery *EventDelivery) int {
+	for idx, sub := range outbox.subs {
+		if sub.Name() == delivery.Subscriber && sub.Event() == delivery.Event {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (outbox *EventOutbox) wake(idx int) {
+	select {
+	case outbox.wakeup[idx] <- struct{}{}:
+	default:
+	}
+}
+
+// Start fails pending deliveries of subscribers which are no longer
+// configured and starts subscriber workers.
+func (outbox *EventOutbox) Start() error {
+	pending, err := outbox.EventDeliveryRepository.List(EventDeliveryListOptions{States: []EventDeliveryState{EventDeliveryStatePending}})
+	if err != nil {
+		return util.NewError(err, "cannot list pending deliveries")
+	}
+	for _, delivery := range pending {
+		if outbox.subscriber(delivery) >= 0 {
+			continue
+		}
+		delivery.State = EventDeliveryStateFailed
+		delivery.LastError = "subscriber is not configured"
+		if err := outbox.EventDeliveryRepository.Save(delivery); err != nil {
+			return util.NewError(err, "cannot save delivery %s", delivery.Id)
+		}
+	}
+	for idx := range outbox.subs {
+		go outbox.work(idx)
+	}
+	return nil
+}
+
+func (outbox *EventOutbox) Publish(event Event) error {
+	for idx, sub := range outbox.subs {
+		if sub.Event() != event.Name() {
+			continue
+		}
+		delivery := &EventDelivery{
+			Id:          uuid.New().String(),
+			Event:       event.Name(),
+			Subscriber:  sub.Name(),
+			Data:        event.Plain(),
+			State:       EventDeliveryStatePending,
+			Created:     time.Now(),
+			NextAttempt: time.Now(),
+		}
+		if sub.Mandatory() {
+			if err := outbox.deliverNow(sub, delivery); err != nil {
+				return util.NewError(err, "mandatory subscriber %s failed", sub.Name())
+			}
+			continue
+		}
+		if err := outbox.EventDeliveryRepository.Save(delivery); err != nil {
+			return util.NewError(err, "cannot save event to outbox")
+		}
+		outbox.wake(idx)
+	}
+	return nil
+}
+
+func (outbox *EventOutbox) deliverNow(sub EventSubscriber, delivery *EventDelivery) error {
+	for {
+		delivery.Attempts++
+		err := sub.Deliver(delivery)
+		if err == nil {
+			return nil
+		}
+		if util.ErrorCause(err) == ErrEventRejected || delivery.Attempts >= sub.Attempts() {
+			return err
+		}
+		time.Sleep(retryBackoff(syncRetryBackoff, delivery.Attempts))
+	}
+}
+
+func (outbox *EventOutbox) work(idx int) {
+	sub := outbox.subs[idx]
+	for {
+		next := time.Now().Add(outboxPollInterval)
+		pending, err := outbox.EventDeliveryRepository.List(EventDeliveryListOptions{States: []EventDeliveryState{EventDeliveryStatePending}})
+		if err == nil {
+			for _, delivery := range pending {
+				if delivery.Subscriber != sub.Name() || delivery.Event != sub.Event() {
+					continue
+				}
+				if delivery.NextAttempt.After(time.Now()) {
+					if delivery.NextAttempt.Before(next) {
+						next = delivery.NextAttempt
+					}
+					continue
+				}
+				outbox.attempt(sub, delivery)
+				if delivery.State == EventDeliveryStatePending && delivery.NextAttempt.Before(next) {
+					next = delivery.NextAttempt
+				}
+			}
+		}
+		select {
+		case <-outbox.wakeup[idx]:
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+func (outbox *EventOutbox) attempt(sub EventSubscriber, delivery *EventDelivery) {
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+	err := sub.Deliver(delivery)
+
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	if err == nil {
+		outbox.EventDeliveryRepository.Delete(delivery.Id)
+		return
+	}
+	delivery.LastError = err.Error()
+	if util.ErrorCause(err) == ErrEventRejected || delivery.Attempts >= sub.Attempts() {
+		delivery.State = EventDeliveryStateFailed
+	} else {
+		delivery.NextAttempt = time.Now().Add(retryBackoff(asyncRetryBackoff, delivery.Attempts))
+	}
+	outbox.EventDeliveryRepository.Save(delivery)
+}
+
+// Replay schedules failed delivery for immediate delivery with full
+// number of attempts.
+func (outbox *EventOutbox) Replay(id string) error {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	delivery, err := outbox.EventDeliveryRepository.Get(id)
+	if err != nil {
+		return err
+	}
+	if delivery.State != EventDeliveryStateFailed {
+		return util.NewError(ErrEventDeliveryNotFailed, "only failed deliveries can be replayed")
+	}
+	idx := outbox.subscriber(delivery)
+	if idx < 0 {
+		return fmt.Errorf("subscriber %s for event %s is not configured", delivery.Subscriber, delivery.Event)
+	}
+	delivery.State = EventDeliveryStatePending
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now()
+	if err := outbox.EventDeliveryRepository.Save(delivery); err != nil {
+		return util.NewError(err, "cannot save delivery")
+	}
+	outbox.wake(idx)
+	return nil
+}
+
+// Discard removes failed delivery.
+func (outbox *EventOutbox) Discard(id string) error {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	delivery, err := outbox.EventDeliveryRepository.Get(id)
+	if err != nil {
+		return err
+	}
+	if delivery.State != EventDeliveryStateFailed {
+		return util.NewError(ErrEventDeliveryNotFailed, "only failed deliveries can be discarded")
+	}
+	return outbox.EventDeliveryRepository.Delete(id)
+}
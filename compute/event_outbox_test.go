@@ -0,0 +1,199 @@
+package compute
+
+import (
+	"errors"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+type fakeEventDeliveryRepository struct {
+	deliveries map[string]*EventDelivery
+}
+
+func (repo *fakeEventDeliveryRepository) List(options EventDeliveryListOptions) ([]*EventDelivery, error) {
+	result := []*EventDelivery{}
+	for _, delivery := range repo.deliveries {
+		if options.Match(delivery) {
+			result = append(result, delivery)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeEventDeliveryRepository) Get(id string) (*EventDelivery, error) {
+	delivery, ok := repo.deliveries[id]
+	if !ok {
+		return nil, ErrEventDeliveryNotFound
+	}
+	return delivery, nil
+}
+
+func (repo *fakeEventDeliveryRepository) Save(delivery *EventDelivery) error {
+	copied := *delivery
+	repo.deliveries[delivery.Id] = &copied
+	return nil
+}
+
+func (repo *fakeEventDeliveryRepository) Delete(id string) error {
+	delete(repo.deliveries, id)
+	return nil
+}
+
+// fakeEventSubscriber returns errors from failures one per delivery
+// attempt and succeeds when they are over.
+type fakeEventSubscriber struct {
+	mandatory bool
+	attempts  int
+	failures  []error
+	calls     int
+}
+
+func (sub *fakeEventSubscriber) Name() string    { return "fake" }
+func (sub *fakeEventSubscriber) Event() string   { return "vm_created" }
+func (sub *fakeEventSubscriber) Mandatory() bool { return sub.mandatory }
+func (sub *fakeEventSubscriber) Attempts() int   { return sub.attempts }
+
+func (sub *fakeEventSubscriber) Deliver(delivery *EventDelivery) error {
+	sub.calls++
+	if sub.calls <= len(sub.failures) {
+		return sub.failures[sub.calls-1]
+	}
+	return nil
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", time.Second, 1, time.Second},
+		{"doubled", time.Second, 3, 4 * time.Second},
+		{"background", 10 * time.Second, 4, 80 * time.Second},
+		{"capped", 10 * time.Second, 8, maxRetryBackoff},
+		{"large attempt", time.Second, 1000, maxRetryBackoff},
+		{"initial above cap", time.Hour, 1, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(tt.initial, tt.attempt); got != tt.want {
+				t.Errorf("retryBackoff() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventOutboxPublishMandatory(t *testing.T) {
+	defer func(backoff time.Duration) { syncRetryBackoff = backoff }(syncRetryBackoff)
+	syncRetryBackoff = time.Millisecond
+	unavailable := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"delivered", 3, nil, 1, nil},
+		{"delivered after retry", 3, []error{unavailable, unavailable}, 3, nil},
+		{"attempts exhausted", 2, []error{unavailable, unavailable, unavailable}, 2, unavailable},
+		{"rejected is not retried", 3, []error{util.NewError(ErrEventRejected, "exit status 1")}, 1, ErrEventRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeEventDeliveryRepository{deliveries: map[string]*EventDelivery{}}
+			sub := &fakeEventSubscriber{mandatory: true, attempts: tt.attempts, failures: tt.failures}
+			outbox := NewEventOutbox(repo)
+			outbox.Subscribe(sub)
+			err := outbox.Publish(NewEventVirtualMachineCreated(&VirtualMachine{Id: "web1", Memory: NewSize(1, SizeUnitG)}))
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("Publish() error = %v, want %v", err, tt.wantErr)
+			}
+			if sub.calls != tt.wantCalls {
+				t.Errorf("Publish() delivered %d times, want %d", sub.calls, tt.wantCalls)
+			}
+			if len(repo.deliveries) != 0 {
+				t.Errorf("Publish() saved %d mandatory deliveries to outbox", len(repo.deliveries))
+			}
+		})
+	}
+}
+
+func TestEventOutboxAttempt(t *testing.T) {
+	unavailable := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		attempts      int
+		previous      int
+		failure       error
+		wantState     EventDeliveryState
+		wantDeleted   bool
+		wantNextAfter time.Duration
+	}{
+		{
+			name:        "delivered",
+			attempts:    4,
+			wantDeleted: true,
+		},
+		{
+			name:          "retried with backoff",
+			attempts:      4,
+			previous:      1,
+			failure:       unavailable,
+			wantState:     EventDeliveryStatePending,
+			wantNextAfter: 2 * asyncRetryBackoff,
+		},
+		{
+			name:      "attempts exhausted",
+			attempts:  4,
+			previous:  3,
+			failure:   unavailable,
+			wantState: EventDeliveryStateFailed,
+		},
+		{
+			name:      "rejected",
+			attempts:  4,
+			failure:   util.NewError(ErrEventRejected, "webhook responded 400"),
+			wantState: EventDeliveryStateFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeEventDeliveryRepository{deliveries: map[string]*EventDelivery{}}
+			sub := &fakeEventSubscriber{attempts: tt.attempts}
+			if tt.failure != nil {
+				sub.failures = []error{tt.failure}
+			}
+			outbox := NewEventOutbox(repo)
+			outbox.Subscribe(sub)
+			delivery := &EventDelivery{Id: "d1", Event: sub.Event(), Subscriber: sub.Name(), State: EventDeliveryStatePending, Attempts: tt.previous}
+			repo.Save(delivery)
+			started := time.Now()
+			outbox.attempt(sub, delivery)
+			saved, err := repo.Get("d1")
+			if tt.wantDeleted {
+				if err == nil {
+					t.Errorf("attempt() kept delivered event in outbox")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("attempt() removed failed delivery: %v", err)
+			}
+			if saved.State != tt.wantState {
+				t.Errorf("attempt() state = %s, want %s", saved.State, tt.wantState)
+			}
+			if saved.Attempts != tt.previous+1 {
+				t.Errorf("attempt() attempts = %d, want %d", saved.Attempts, tt.previous+1)
+			}
+			if saved.LastError != tt.failure.Error() {
+				t.Errorf("attempt() last error = %q, want %q", saved.LastError, tt.failure.Error())
+			}
+			if tt.wantNextAfter > 0 && saved.NextAttempt.Before(started.Add(tt.wantNextAfter)) {
+				t.Errorf("attempt() next attempt in %s, want %s", saved.NextAttempt.Sub(started), tt.wantNextAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package compute
+
+import (
+	"fmt"
+)
+
+// volumePlain returns common volume values used by events: volume_path,
+// volume_node, volume_name, volume_pool, volume_format and volume_size_mib
+// (empty if size is not known yet).
+func volumePlain(name string, volume *Volume) map[string]string {
+	size := ""
+	if volume.Size.Unit != SizeUnitUnknown {
+		size = fmt.Sprintf("%d", volume.Size.M())
+	}
+	return map[string]string{
+		"event":           name,
+		"volume_path":     volume.Path,
+		"volume_node":     volume.NodeId,
+		"volume_name":     volume.Name,
+		"volume_pool":     volume.Pool,
+		"volume_format":   volume.Format.String(),
+		"volume_size_mib": size,
+	}
+}
+
+// EventVolumeCreated is published when new empty volume is created.
+// Plain has common volume values.
+type EventVolumeCreated struct {
+	volume *Volume
+}
+
+func NewEventVolumeCreated(volume *Volume) *EventVolumeCreated {
+	return &EventVolumeCreated{volume: volume}
+}
+
+func (e *EventVolumeCreated) Name() string {
+	return "volume_created"
+}
+
+func (e *EventVolumeCreated) Plain() map[string]string {
+	return volumePlain(e.Name(), e.volume)
+}
+
+// EventVolumeCloned is published when volume is cloned from another one.
+// Plain has common values of the new volume and volume_original_path.
+type EventVolumeCloned struct {
+	originalPath string
+	volume       *Volume
+}
+
+func NewEventVolumeCloned(originalPath string, volume *Volume) *EventVolumeCloned {
+	return &EventVolumeCloned{originalPath: originalPath, volume: volume}
+}
+
+func (e *EventVolumeCloned) Name() string {
+	return "volume_cloned"
+}
+
+func (e *EventVolumeCloned) Plain() map[string]string {
+	data := volumePlain(e.Name(), e.volume)
+	data["volume_original_path"] = e.originalPath
+	return data
+}
+
+// EventVolumeResized is published when volume is resized. Plain has
+// volume_path, volume_node and volume_size_mib with the new size.
+type EventVolumeResized struct {
+	path, node string
+	size       Size
+}
+
+func NewEventVolumeResized(path, node string, size Size) *EventVolumeResized {
+	return &EventVolumeResized{path: path, node: node, size: size}
+}
+
+func (e *EventVolumeResized) Name() string {
+	return "volume_resized"
+}
+
+func (e *EventVolumeResized) Plain() map[string]string {
+	return map[string]string{
+		"event":           e.Name(),
+		"volume_path":     e.path,
+		"volume_node":     e.node,
+		"volume_size_mib": fmt.Sprintf("%d", e.size.M()),
+	}
+}
+
+// EventVolumeDeleted is published after volume is deleted. Plain has
+// common values of the volume as it was before deletion.
+type EventVolumeDeleted struct {
+	volume *Volume
+}
+
+func NewEventVolumeDeleted(volume *Volume) *EventVolumeDeleted {
+	return &EventVolumeDeleted{volume: volume}
+}
+
+func (e *EventVolumeDeleted) Name() string {
+	return "volume_deleted"
+}
+
+func (e *EventVolumeDeleted) Plain() map[string]string {
+	return volumePlain(e.Name(), e.volume)
+}
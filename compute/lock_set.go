@@ -0,0 +1,44 @@
+package compute
+
+import (
+	"sync"
+)
+
+// lockSet keeps mutex per name, e.g. per machine or per quota subject.
+// Mutexes are never forgotten, number of names is bounded by number of
+// machines and subjects.
+type lockSet struct {
+	mu    *sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newLockSet() *lockSet {
+	return &lockSet{mu: &sync.Mutex{}, locks: map[string]*sync.Mutex{}}
+}
+
+func (set *lockSet) get(name string) *sync.Mutex {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	lock, exists := set.locks[name]
+	if !exists {
+		lock = &sync.Mutex{}
+		set.locks[name] = lock
+	}
+	return lock
+}
+
+// lock locks mutexes of names in given order, callers must use the same
+// order to avoid deadlock. Returned function unlocks them.
+func (set *lockSet) lock(names ...string) func() {
+	locks := []*sync.Mutex{}
+	for _, name := range names {
+		lock := set.get(name)
+		lock.Lock()
+		locks = append(locks, lock)
+	}
+	return func() {
+		for idx := len(locks) - 1; idx >= 0; idx-- {
+			locks[idx].Unlock()
+		}
+	}
+}
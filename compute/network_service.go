@@ -1,5 +1,9 @@
 package compute
 
+import "errors"
+
+var ErrNetworkNotFound = errors.New("network not found")
+
 type NetworkListOptions struct {
 	NodeIds []string
 }
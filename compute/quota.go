@@ -0,0 +1,88 @@
+package compute
+
+type QuotaSubject int
+
+const (
+	QuotaSubjectUnknown QuotaSubject = iota
+	QuotaSubjectUser
+	QuotaSubjectProject
+)
+
+func (subject QuotaSubject) String() string {
+	switch subject {
+	default:
+		return "unknown"
+	case QuotaSubjectUser:
+		return "user"
+	case QuotaSubjectProject:
+		return "project"
+	}
+}
+
+func NewQuotaSubject(input string) QuotaSubject {
+	switch input {
+	default:
+		return QuotaSubjectUnknown
+	case "user":
+		return QuotaSubjectUser
+	case "project":
+		return QuotaSubjectProject
+	}
+}
+
+// QuotaResources is an amount of resources, zero limit value means no limit.
+type QuotaResources struct {
+	VCpus  int
+	Memory Size
+	Disk   Size
+	Vms    int
+}
+
+func quotaBytes(size Size) uint64 {
+	if size.Unit == SizeUnitUnknown {
+		return 0
+	}
+	return size.Bytes()
+}
+
+func (resources *QuotaResources) Add(other QuotaResources) {
+	resources.VCpus += other.VCpus
+	resources.Memory = NewSize(quotaBytes(resources.Memory)+quotaBytes(other.Memory), SizeUnitB)
+	resources.Disk = NewSize(quotaBytes(resources.Disk)+quotaBytes(other.Disk), SizeUnitB)
+	resources.Vms += other.Vms
+}
+
+// Exceeds returns name of the first resource of requested which doesn't fit
+// into the limit together with resources. Empty string means it fits.
+func (resources QuotaResources) Exceeds(requested, limit QuotaResources) string {
+	if limit.VCpus > 0 && resources.VCpus+requested.VCpus > limit.VCpus {
+		return "vcpus"
+	}
+	if quotaBytes(limit.Memory) > 0 && quotaBytes(resources.Memory)+quotaBytes(requested.Memory) > quotaBytes(limit.Memory) {
+		return "memory"
+	}
+	if quotaBytes(limit.Disk) > 0 && quotaBytes(resources.Disk)+quotaBytes(requested.Disk) > quotaBytes(limit.Disk) {
+		return "disk"
+	}
+	if limit.Vms > 0 && resources.Vms+requested.Vms > limit.Vms {
+		return "vms"
+	}
+	return ""
+}
+
+type Quota struct {
+	Subject QuotaSubject
+	Name    string
+	Limit   QuotaResources
+	Usage   QuotaResources
+}
+
+func (quota *Quota) Applies(owner, project string) bool {
+	switch quota.Subject {
+	case QuotaSubjectUser:
+		return owner != "" && owner == quota.Name
+	case QuotaSubjectProject:
+		return project != "" && project == quota.Name
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package compute
+
+import (
+	"errors"
+	"subuk/vmango/util"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type QuotaService struct {
+	vms     *VirtualMachineService
+	volumes *VolumeService
+	quotas  []*Quota
+	locks   *lockSet
+}
+
+func NewQuotaService(vms *VirtualMachineService, volumes *VolumeService, quotas []*Quota) *QuotaService {
+	return &QuotaService{vms: vms, volumes: volumes, quotas: quotas, locks: newLockSet()}
+}
+
+// Lock serializes quota check and creation of resources for owner and
+// project, so concurrent creations cannot both pass the check. Returned
+// function releases the lock. Owner is always locked before project.
+func (service *QuotaService) Lock(owner, project string) func() {
+	names := []string{}
+	if owner != "" {
+		names = append(names, QuotaSubjectUser.String()+":"+owner)
+	}
+	if project != "" {
+		names = append(names, QuotaSubjectProject.String()+":"+project)
+	}
+	return service.locks.lock(names...)
+}
+
+// List returns quotas applied to owner or project with current usage.
+// Empty owner and project lists mean all quotas.
+func (service *QuotaService) List(owners, projects []string) ([]*Quota, error) {
+	quotas := []*Quota{}
+	for _, quota := range service.quotas {
+		if len(owners) == 0 && len(projects) == 0 {
+			quotas = append(quotas, &Quota{Subject: quota.Subject, Name: quota.Name, Limit: quota.Limit})
+			continue
+		}
+		for _, owner := range owners {
+			if quota.Applies(owner, "") {
+				quotas = append(quotas, &Quota{Subject: quota.Subject, Name: quota.Name, Limit: quota.Limit})
+			}
+		}
+		for _, project := range projects {
+			if quota.Applies("", project) {
+				quotas = append(quotas, &Quota{Subject: quota.Subject, Name: quota.Name, Limit: quota.Limit})
+			}
+		}
+	}
+	if len(quotas) == 0 {
+		return quotas, nil
+	}
+	if err := service.fillUsage(quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+func (service *QuotaService) fillUsage(quotas []*Quota) error {
+	for _, quota := range quotas {
+		quota.Usage = QuotaResources{Memory: NewSize(0, SizeUnitB), Disk: NewSize(0, SizeUnitB)}
+	}
+	vms, err := service.vms.List(VirtualMachineListOptions{})
+	if err != nil {
+		return util.NewError(err, "cannot list vms")
+	}
+	for _, vm := range vms {
+		for _, quota := range quotas {
+			if quota.Applies(vm.Owner, vm.Project) {
+				quota.Usage.Add(QuotaResources{VCpus: vm.VCpus, Memory: vm.Memory, Vms: 1})
+			}
+		}
+	}
+	volumes, err := service.volumes.List(VolumeListOptions{})
+	if err != nil {
+		return util.NewError(err, "cannot list volumes")
+	}
+	for _, volume := range volumes {
+		for _, quota := range quotas {
+			if quota.Applies(volume.Owner, volume.Project) {
+				quota.Usage.Add(QuotaResources{Disk: volume.Size})
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns ErrQuotaExceeded if creation of vm with disk volumes of
+// total size would exceed quota of the vm owner or the vm project.
+func (service *QuotaService) Check(vm *VirtualMachine, disk Size) error {
+	return service.check(vm.Owner, vm.Project, QuotaResources{VCpus: vm.VCpus, Memory: vm.Memory, Disk: disk, Vms: 1})
+}
+
+// CheckDisk returns ErrQuotaExceeded if creation of standalone volume of
+// given size would exceed quota of owner or project.
+func (service *QuotaService) CheckDisk(owner, project string, disk Size) error {
+	return service.check(owner, project, QuotaResources{Disk: disk})
+}
+
+// CheckUsage returns ErrQuotaExceeded if current usage of owner or project
+// is over quota. It checks resources which are already counted, like
+// imported machine or machine being moved between nodes.
+func (service *QuotaService) CheckUsage(owner, project string) error {
+	return service.check(owner, project, QuotaResources{})
+}
+
+func (service *QuotaService) check(owner, project string, requested QuotaResources) error {
+	quotas := []*Quota{}
+	for _, quota := range service.quotas {
+		if quota.Applies(owner, project) {
+			quotas = append(quotas, &Quota{Subject: quota.Subject, Name: quota.Name, Limit: quota.Limit})
+		}
+	}
+	if len(quotas) == 0 {
+		return nil
+	}
+	if err := service.fillUsage(quotas); err != nil {
+		return err
+	}
+	for _, quota := range quotas {
+		if resource := quota.Usage.Exceeds(requested, quota.Limit); resource != "" {
+			return util.NewError(ErrQuotaExceeded, "%s %s %s limit reached", quota.Subject, quota.Name, resource)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package compute
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuotaResourcesExceeds(t *testing.T) {
+	limit := QuotaResources{VCpus: 4, Memory: NewSize(4, SizeUnitG), Disk: NewSize(100, SizeUnitG), Vms: 2}
+	tests := []struct {
+		name      string
+		usage     QuotaResources
+		requested QuotaResources
+		limit     QuotaResources
+		want      string
+	}{
+		{
+			name:      "fits",
+			usage:     QuotaResources{VCpus: 2, Memory: NewSize(2, SizeUnitG), Disk: NewSize(50, SizeUnitG), Vms: 1},
+			requested: QuotaResources{VCpus: 2, Memory: NewSize(2, SizeUnitG), Disk: NewSize(50, SizeUnitG), Vms: 1},
+			limit:     limit,
+			want:      "",
+		},
+		{
+			name:      "vcpus",
+			usage:     QuotaResources{VCpus: 3},
+			requested: QuotaResources{VCpus: 2},
+			limit:     limit,
+			want:      "vcpus",
+		},
+		{
+			name:      "memory in other units",
+			usage:     QuotaResources{Memory: NewSize(3072, SizeUnitM)},
+			requested: QuotaResources{Memory: NewSize(1025, SizeUnitM)},
+			limit:     limit,
+			want:      "memory",
+		},
+		{
+			name:      "disk",
+			usage:     QuotaResources{Disk: NewSize(60, SizeUnitG)},
+			requested: QuotaResources{Disk: NewSize(41, SizeUnitG)},
+			limit:     limit,
+			want:      "disk",
+		},
+		{
+			name:      "vms",
+			usage:     QuotaResources{Vms: 2},
+			requested: QuotaResources{Vms: 1},
+			limit:     limit,
+			want:      "vms",
+		},
+		{
+			name:      "zero limit is unlimited",
+			usage:     QuotaResources{VCpus: 100, Vms: 100},
+			requested: QuotaResources{VCpus: 100, Memory: NewSize(1024, SizeUnitG), Vms: 1},
+			limit:     QuotaResources{},
+			want:      "",
+		},
+		{
+			name:      "unknown size is zero",
+			usage:     QuotaResources{Disk: Size{}},
+			requested: QuotaResources{Disk: NewSize(100, SizeUnitG)},
+			limit:     limit,
+			want:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.usage.Exceeds(tt.requested, tt.limit); got != tt.want {
+				t.Errorf("Exceeds() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaServiceCheck(t *testing.T) {
+	vms := []*VirtualMachine{
+		{Id: "a1", NodeId: "n1", Owner: "alice", VCpus: 2, Memory: NewSize(2, SizeUnitG)},
+		{Id: "b1", NodeId: "n1", Owner: "bob", Project: "web", VCpus: 1, Memory: NewSize(1, SizeUnitG)},
+	}
+	volumes := []*Volume{
+		{NodeId: "n1", Path: "/a1-disk", AttachedTo: "a1", Owner: "alice", Size: NewSize(20, SizeUnitG)},
+		{NodeId: "n1", Path: "/alice-detached", Size: NewSize(20, SizeUnitG)},
+		{NodeId: "n1", Path: "/shared", Size: NewSize(500, SizeUnitG)},
+	}
+	owners := []*VolumeOwnership{{NodeId: "n1", Path: "/alice-detached", Owner: "alice"}}
+	quotas := []*Quota{
+		{Subject: QuotaSubjectUser, Name: "alice", Limit: QuotaResources{VCpus: 4, Disk: NewSize(50, SizeUnitG)}},
+		{Subject: QuotaSubjectProject, Name: "web", Limit: QuotaResources{Vms: 1}},
+	}
+	tests := []struct {
+		name    string
+		vm      *VirtualMachine
+		disk    Size
+		wantErr bool
+	}{
+		{"fits", &VirtualMachine{Owner: "alice", VCpus: 2}, NewSize(10, SizeUnitG), false},
+		{"vcpus exceeded", &VirtualMachine{Owner: "alice", VCpus: 3}, NewSize(0, SizeUnitB), true},
+		{"detached volume counts", &VirtualMachine{Owner: "alice", VCpus: 1}, NewSize(11, SizeUnitG), true},
+		{"project vms exceeded", &VirtualMachine{Owner: "carol", Project: "web"}, NewSize(0, SizeUnitB), true},
+		{"no quota", &VirtualMachine{Owner: "carol", VCpus: 100}, NewSize(1024, SizeUnitG), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmService := NewVirtualMachineService(&fakeVirtualMachineRepository{vms: vms}, &fakeEventPublisher{}, time.Second)
+			volService := NewVolumeService(&fakeVolumeRepository{volumes: volumes}, &fakeVolumeOwnershipRepository{owners: owners}, &fakeEventPublisher{})
+			service := NewQuotaService(vmService, volService, quotas)
+			err := service.Check(tt.vm, tt.disk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuotaServiceLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    [2]string
+		second   [2]string
+		wantWait bool
+	}{
+		{"same owner", [2]string{"alice", ""}, [2]string{"alice", ""}, true},
+		{"same project", [2]string{"alice", "web"}, [2]string{"bob", "web"}, true},
+		{"different subjects", [2]string{"alice", "web"}, [2]string{"bob", "db"}, false},
+		{"owner named as project", [2]string{"web", ""}, [2]string{"", "web"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewQuotaService(nil, nil, nil)
+			release := service.Lock(tt.first[0], tt.first[1])
+			acquired := make(chan struct{})
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				service.Lock(tt.second[0], tt.second[1])()
+				close(acquired)
+			}()
+			select {
+			case <-acquired:
+				if tt.wantWait {
+					t.Errorf("Lock() of %v is not blocked by %v", tt.second, tt.first)
+				}
+			case <-time.After(50 * time.Millisecond):
+				if !tt.wantWait {
+					t.Errorf("Lock() of %v is blocked by %v", tt.second, tt.first)
+				}
+			}
+			release()
+			wg.Wait()
+		})
+	}
+}
+
+func TestQuotaServiceCheckUsage(t *testing.T) {
+	vms := []*VirtualMachine{
+		{Id: "a1", NodeId: "n1", Owner: "alice", VCpus: 4},
+		{Id: "b1", NodeId: "n1", Owner: "bob", VCpus: 2},
+	}
+	quotas := []*Quota{
+		{Subject: QuotaSubjectUser, Name: "alice", Limit: QuotaResources{VCpus: 4}},
+		{Subject: QuotaSubjectUser, Name: "bob", Limit: QuotaResources{VCpus: 1}},
+	}
+	tests := []struct {
+		name    string
+		owner   string
+		wantErr bool
+	}{
+		{"at limit", "alice", false},
+		{"over limit", "bob", true},
+		{"no quota", "carol", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmService := NewVirtualMachineService(&fakeVirtualMachineRepository{vms: vms}, &fakeEventPublisher{}, time.Second)
+			volService := NewVolumeService(&fakeVolumeRepository{}, &fakeVolumeOwnershipRepository{}, &fakeEventPublisher{})
+			err := NewQuotaService(vmService, volService, quotas).CheckUsage(tt.owner, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckUsage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
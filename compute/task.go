@@ -0,0 +1,86 @@
+package compute
+
+import (
+	"time"
+)
+
+type TaskState int
+
+const (
+	TaskStateUnknown TaskState = iota
+	TaskStatePending
+	TaskStateRunning
+	TaskStateDone
+	TaskStateFailed
+)
+
+func (state TaskState) String() string {
+	switch state {
+	default:
+		return "unknown"
+	case TaskStatePending:
+		return "pending"
+	case TaskStateRunning:
+		return "running"
+	case TaskStateDone:
+		return "done"
+	case TaskStateFailed:
+		return "failed"
+	}
+}
+
+func NewTaskState(input string) TaskState {
+	switch input {
+	default:
+		return TaskStateUnknown
+	case "pending":
+		return TaskStatePending
+	case "running":
+		return TaskStateRunning
+	case "done":
+		return TaskStateDone
+	case "failed":
+		return TaskStateFailed
+	}
+}
+
+// Task is a long-running operation executed in background.
+// TargetId identifies object created or changed by task.
+type Task struct {
+	Id       string
+	Name     string
+	NodeId   string
+	TargetId string
+	Owner    string
+	Project  string
+	State    TaskState
+	Progress int
+	Message  string
+	Error    string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+}
+
+func (task *Task) Completed() bool {
+	return task.State == TaskStateDone || task.State == TaskStateFailed
+}
+
+type TaskListOptions struct {
+	Name   string
+	NodeId string
+	State  TaskState
+}
+
+func (options TaskListOptions) Match(task *Task) bool {
+	if options.Name != "" && task.Name != options.Name {
+		return false
+	}
+	if options.NodeId != "" && task.NodeId != options.NodeId {
+		return false
+	}
+	if options.State != TaskStateUnknown && task.State != options.State {
+		return false
+	}
+	return true
+}
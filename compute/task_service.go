@@ -0,0 +1,190 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrTaskNotFound = errors.New("task not found")
+var ErrTaskQueueFull = errors.New("task queue is full")
+
+const taskQueueSize = 1000
+
+// TaskFunc does the work of task and reports its progress.
+type TaskFunc func(progress *TaskProgress) error
+
+// TaskProgress updates running task, nil progress does nothing,
+// so operations may be run outside of task too.
+type TaskProgress struct {
+	service *TaskService
+	id      string
+}
+
+func (progress *TaskProgress) Report(percent int, message string) {
+	if progress == nil {
+		return
+	}
+	progress.service.update(progress.id, func(task *Task) {
+		task.Progress = percent
+		task.Message = message
+	})
+}
+
+// TaskId returns id of the task, empty for nil progress.
+func (progress *TaskProgress) TaskId() string {
+	if progress == nil {
+		return ""
+	}
+	return progress.id
+}
+
+func (progress *TaskProgress) Target(id string) {
+	if progress == nil {
+		return
+	}
+	progress.service.update(progress.id, func(task *Task) {
+		task.TargetId = id
+	})
+}
+
+type queuedTask struct {
+	id string
+	fn TaskFunc
+}
+
+// TaskService runs tasks with fixed number of workers and keeps their
+// state in memory. Only the latest completed tasks are kept.
+type TaskService struct {
+	mu    sync.Mutex
+	tasks map[string]*Task
+	order []string
+	keep  int
+	queue chan queuedTask
+}
+
+func NewTaskService(workers, keep int) *TaskService {
+	service := &TaskService{
+		tasks: map[string]*Task{},
+		keep:  keep,
+		queue: make(chan queuedTask, taskQueueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go service.work()
+	}
+	return service
+}
+
+// Submit enqueues task, task fields except Name, NodeId, TargetId, Owner
+// and Project are set by service. Returns snapshot of submitted task.
+func (service *TaskService) Submit(task *Task, fn TaskFunc) (*Task, error) {
+	task.Id = uuid.New().String()
+	task.State = TaskStatePending
+	task.Progress = 0
+	task.Created = time.Now()
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	select {
+	case service.queue <- queuedTask{id: task.Id, fn: fn}:
+	default:
+		return nil, ErrTaskQueueFull
+	}
+	service.tasks[task.Id] = task
+	service.order = append(service.order, task.Id)
+	service.prune()
+	snapshot := *task
+	return &snapshot, nil
+}
+
+func (service *TaskService) Get(id string) (*Task, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	task, exists := service.tasks[id]
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+	snapshot := *task
+	return &snapshot, nil
+}
+
+// List returns matching tasks, newest first
+func (service *TaskService) List(options TaskListOptions) ([]*Task, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	tasks := []*Task{}
+	for idx := len(service.order) - 1; idx >= 0; idx-- {
+		task := service.tasks[service.order[idx]]
+		if options.Match(task) {
+			snapshot := *task
+			tasks = append(tasks, &snapshot)
+		}
+	}
+	return tasks, nil
+}
+
+func (service *TaskService) update(id string, change func(task *Task)) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if task, exists := service.tasks[id]; exists {
+		change(task)
+	}
+}
+
+// prune forgets oldest completed tasks above the limit, must be called with lock held
+func (service *TaskService) prune() {
+	completed := 0
+	for _, id := range service.order {
+		if service.tasks[id].Completed() {
+			completed++
+		}
+	}
+	order := service.order[:0]
+	for _, id := range service.order {
+		if completed > service.keep && service.tasks[id].Completed() {
+			delete(service.tasks, id)
+			completed--
+			continue
+		}
+		order = append(order, id)
+	}
+	service.order = order
+}
+
+func (service *TaskService) work() {
+	for queued := range service.queue {
+		service.run(queued)
+	}
+}
+
+func (service *TaskService) run(queued queuedTask) {
+	service.update(queued.id, func(task *Task) {
+		task.State = TaskStateRunning
+		task.Started = time.Now()
+	})
+	err := service.call(queued)
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	task := service.tasks[queued.id]
+	task.Finished = time.Now()
+	if err != nil {
+		task.State = TaskStateFailed
+		task.Error = err.Error()
+	} else {
+		task.State = TaskStateDone
+		task.Progress = 100
+	}
+	service.prune()
+}
+
+func (service *TaskService) call(queued queuedTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panic: %v", r)
+		}
+	}()
+	return queued.fn(&TaskProgress{service: service, id: queued.id})
+}
@@ -0,0 +1,139 @@
+package compute
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitTask returns task once it is completed.
+func waitTask(t *testing.T, service *TaskService, id string) *Task {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		task, err := service.Get(id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if task.Completed() {
+			return task
+		}
+	}
+	t.Fatalf("task %s is not completed in time", id)
+	return nil
+}
+
+func TestTaskServiceRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		fn           TaskFunc
+		wantState    TaskState
+		wantProgress int
+		wantError    string
+		wantTarget   string
+	}{
+		{
+			name: "done",
+			fn: func(progress *TaskProgress) error {
+				progress.Report(50, "copying")
+				progress.Target("web1")
+				return nil
+			},
+			wantState:    TaskStateDone,
+			wantProgress: 100,
+			wantTarget:   "web1",
+		},
+		{
+			name: "failed",
+			fn: func(progress *TaskProgress) error {
+				progress.Report(30, "copying")
+				return errors.New("no space left")
+			},
+			wantState:    TaskStateFailed,
+			wantProgress: 30,
+			wantError:    "no space left",
+		},
+		{
+			name: "panic",
+			fn: func(progress *TaskProgress) error {
+				panic("nil map")
+			},
+			wantState: TaskStateFailed,
+			wantError: "task panic: nil map",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewTaskService(1, 10)
+			submitted, err := service.Submit(&Task{Name: "test", NodeId: "n1"}, tt.fn)
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+			if submitted.State != TaskStatePending {
+				t.Errorf("Submit() state = %s, want pending", submitted.State)
+			}
+			task := waitTask(t, service, submitted.Id)
+			if task.State != tt.wantState {
+				t.Errorf("task state = %s, want %s", task.State, tt.wantState)
+			}
+			if task.Progress != tt.wantProgress {
+				t.Errorf("task progress = %d, want %d", task.Progress, tt.wantProgress)
+			}
+			if task.Error != tt.wantError {
+				t.Errorf("task error = %q, want %q", task.Error, tt.wantError)
+			}
+			if task.TargetId != tt.wantTarget {
+				t.Errorf("task target = %q, want %q", task.TargetId, tt.wantTarget)
+			}
+			if task.NodeId != "n1" || task.Started.IsZero() || task.Finished.IsZero() {
+				t.Errorf("task = %+v, want node, start and finish time", task)
+			}
+		})
+	}
+}
+
+func TestTaskServicePrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		keep    int
+		tasks   int
+		wantLen int
+	}{
+		{"below limit", 5, 3, 3},
+		{"oldest completed are forgotten", 2, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewTaskService(1, tt.keep)
+			ids := []string{}
+			for i := 0; i < tt.tasks; i++ {
+				task, err := service.Submit(&Task{Name: "test"}, func(progress *TaskProgress) error { return nil })
+				if err != nil {
+					t.Fatalf("Submit() error = %v", err)
+				}
+				waitTask(t, service, task.Id)
+				ids = append(ids, task.Id)
+			}
+			tasks, err := service.List(TaskListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(tasks) != tt.wantLen {
+				t.Fatalf("List() returned %d tasks, want %d", len(tasks), tt.wantLen)
+			}
+			if tasks[0].Id != ids[len(ids)-1] {
+				t.Errorf("List() first task = %s, want newest %s", tasks[0].Id, ids[len(ids)-1])
+			}
+		})
+	}
+}
+
+func TestTaskServiceQueueFull(t *testing.T) {
+	service := NewTaskService(0, 10)
+	for i := 0; i < taskQueueSize; i++ {
+		if _, err := service.Submit(&Task{Name: "test"}, func(progress *TaskProgress) error { return nil }); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	if _, err := service.Submit(&Task{Name: "test"}, nil); err != ErrTaskQueueFull {
+		t.Errorf("Submit() error = %v, want %v", err, ErrTaskQueueFull)
+	}
+}
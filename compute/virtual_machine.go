@@ -11,11 +11,31 @@ type VirtualMachineGraphicStream VirtualMachineConsoleStream
 type VirtualMachineState int
 
 const (
-	StateUnknown = VirtualMachineState(0)
-	StateStopped = VirtualMachineState(1)
-	StateRunning = VirtualMachineState(2)
+	StateUnknown      = VirtualMachineState(0)
+	StateStopped      = VirtualMachineState(1)
+	StateRunning      = VirtualMachineState(2)
+	StatePaused       = VirtualMachineState(3)
+	StateCrashed      = VirtualMachineState(4)
+	StateSuspended    = VirtualMachineState(5)
+	StateSaved        = VirtualMachineState(6)
+	StateBlocked      = VirtualMachineState(7)
+	StateShuttingDown = VirtualMachineState(8)
 )
 
+var VirtualMachineStates = []VirtualMachineState{
+	StateRunning, StateBlocked, StatePaused, StateShuttingDown,
+	StateStopped, StateSaved, StateSuspended, StateCrashed,
+}
+
+func NewVirtualMachineState(input string) VirtualMachineState {
+	for _, state := range VirtualMachineStates {
+		if state.String() == input {
+			return state
+		}
+	}
+	return StateUnknown
+}
+
 func (state VirtualMachineState) String() string {
 	switch state {
 	default:
@@ -24,6 +44,18 @@ func (state VirtualMachineState) String() string {
 		return "stopped"
 	case StateRunning:
 		return "running"
+	case StatePaused:
+		return "paused"
+	case StateCrashed:
+		return "crashed"
+	case StateSuspended:
+		return "suspended"
+	case StateSaved:
+		return "saved"
+	case StateBlocked:
+		return "blocked"
+	case StateShuttingDown:
+		return "shutting_down"
 	}
 }
 
@@ -48,22 +80,29 @@ func (g VirtualMachineGraphic) Vnc() bool {
 	return g.Type == GraphicTypeVnc
 }
 
+func (g VirtualMachineGraphic) Spice() bool {
+	return g.Type == GraphicTypeSpice
+}
+
 type VirtualMachine struct {
-	Id         string
-	NodeId     string
-	VCpus      int
-	Arch       Arch
-	State      VirtualMachineState
-	Memory     Size
-	Interfaces []*VirtualMachineAttachedInterface
-	Volumes    []*VirtualMachineAttachedVolume
-	Config     *VirtualMachineConfig
-	Cpupin     *VirtualMachineCpuPin
-	GuestAgent bool
-	Autostart  bool
-	Graphic    VirtualMachineGraphic
-	VideoModel VideoModel
-	Hugepages  bool
+	Id          string
+	NodeId      string
+	VCpus       int
+	Arch        Arch
+	State       VirtualMachineState
+	StateReason string
+	Memory      Size
+	Interfaces  []*VirtualMachineAttachedInterface
+	Volumes     []*VirtualMachineAttachedVolume
+	Config      *VirtualMachineConfig
+	Cpupin      *VirtualMachineCpuPin
+	GuestAgent  bool
+	Autostart   bool
+	Graphic     VirtualMachineGraphic
+	VideoModel  VideoModel
+	Hugepages   bool
+	Owner       string
+	Project     string
 }
 
 func (vm *VirtualMachine) AttachmentInfo(path string) *VirtualMachineAttachedVolume {
@@ -83,8 +122,29 @@ func (vm *VirtualMachine) IpAddressList() []string {
 	return iplist
 }
 
+// IsRunning reports whether guest is executing, blocked machine is
+// running but waiting for a resource.
 func (vm *VirtualMachine) IsRunning() bool {
-	return vm.State == StateRunning
+	return vm.State == StateRunning || vm.State == StateBlocked
+}
+
+// IsActive reports whether machine holds resources on its node, i.e. it is
+// running, paused, shutting down, suspended by guest or crashed and not
+// destroyed yet.
+func (vm *VirtualMachine) IsActive() bool {
+	switch vm.State {
+	case StateRunning, StateBlocked, StatePaused, StateShuttingDown, StateCrashed, StateSuspended:
+		return true
+	}
+	return false
+}
+
+func (vm *VirtualMachine) IsPaused() bool {
+	return vm.State == StatePaused
+}
+
+func (vm *VirtualMachine) IsSaved() bool {
+	return vm.State == StateSaved
 }
 
 type VirtualMachineAttachedVolume struct {
@@ -0,0 +1,226 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"subuk/vmango/util"
+)
+
+var ErrDeletePlanChanged = errors.New("delete plan changed")
+
+// VirtualMachineDeletePlanVolume is attached volume with decision whether
+// it is removed together with the machine, KeepReason explains why not.
+type VirtualMachineDeletePlanVolume struct {
+	Path       string
+	DeviceType DeviceType
+	Delete     bool
+	KeepReason string
+}
+
+// VirtualMachineDeletePlan describes what machine deletion does: running
+// machine is shut down gracefully first, then the domain is undefined
+// together with metadata of its snapshots and volumes marked for deletion
+// are removed. ShutdownReason explains what happens to active or saved
+// machine. Volumes include overlays and base volumes of external
+// snapshots, they are not attached to machine anymore or not yet.
+type VirtualMachineDeletePlan struct {
+	Vm             *VirtualMachine
+	Shutdown       bool
+	ShutdownReason string
+	Snapshots      []*VirtualMachineSnapshot
+	Volumes        []*VirtualMachineDeletePlanVolume
+	deleteVolumes  bool
+}
+
+func (plan *VirtualMachineDeletePlan) DeletedVolumes() []string {
+	paths := []string{}
+	for _, volume := range plan.Volumes {
+		if volume.Delete {
+			paths = append(paths, volume.Path)
+		}
+	}
+	return paths
+}
+
+// Matches reports whether plan deletes exactly the given volumes.
+func (plan *VirtualMachineDeletePlan) Matches(paths []string) bool {
+	planned := plan.DeletedVolumes()
+	confirmed := append([]string{}, paths...)
+	sort.Strings(planned)
+	sort.Strings(confirmed)
+	return strings.Join(planned, "\n") == strings.Join(confirmed, "\n")
+}
+
+func (manager *VirtualMachineManager) isConfigDrive(vm *VirtualMachine, attachedVolume *VirtualMachineAttachedVolume) bool {
+	return attachedVolume.Alias == "configdrive" || filepath.Base(attachedVolume.Path) == vm.Id+manager.settings[vm.NodeId].CdSuffix
+}
+
+// deleteShutdown decides whether machine is shut down gracefully before
+// deletion. Guest of paused, suspended or crashed machine can't handle
+// shutdown request, so such machine is powered off right away.
+func deleteShutdown(vm *VirtualMachine) (bool, string) {
+	switch {
+	case vm.IsRunning():
+		return true, "Machine is running, it will be shut down gracefully and powered off if it doesn't stop in time"
+	case vm.State == StateShuttingDown:
+		return true, "Guest is already shutting down, deletion waits for it and powers machine off if it doesn't stop in time"
+	case vm.IsActive():
+		return false, fmt.Sprintf("Machine is %s, its guest can't handle shutdown request, so it will be powered off", vm.State)
+	case vm.IsSaved():
+		return false, "Saved memory state of machine will be discarded"
+	}
+	return false, ""
+}
+
+// PlanDelete decides which volumes are removed with the machine. Cdroms
+// except configdrive, protected images, volumes attached to other machines
+// and volumes missing in storage pools are always kept.
+func (manager *VirtualMachineManager) PlanDelete(vm *VirtualMachine, deleteVolumes bool) (*VirtualMachineDeletePlan, error) {
+	plan := &VirtualMachineDeletePlan{Vm: vm, deleteVolumes: deleteVolumes}
+	plan.Shutdown, plan.ShutdownReason = deleteShutdown(vm)
+	snapshots, err := manager.vms.ListSnapshots(vm.Id, vm.NodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot list snapshots")
+	}
+	plan.Snapshots = snapshots
+	known := map[string]*Volume{}
+	users := map[string][]string{}
+	if deleteVolumes {
+		volumes, err := manager.volumes.List(VolumeListOptions{NodeIds: []string{vm.NodeId}})
+		if err != nil {
+			return nil, util.NewError(err, "cannot list volumes")
+		}
+		for _, volume := range volumes {
+			known[volume.Path] = volume
+		}
+		vms, err := manager.vms.List(VirtualMachineListOptions{NodeIds: []string{vm.NodeId}})
+		if err != nil {
+			return nil, util.NewError(err, "cannot list machines")
+		}
+		for _, other := range vms {
+			if other.Id == vm.Id {
+				continue
+			}
+			for _, attachedVolume := range other.Volumes {
+				users[attachedVolume.Path] = append(users[attachedVolume.Path], other.Id)
+			}
+		}
+	}
+	volumes := append([]*VirtualMachineAttachedVolume{}, vm.Volumes...)
+	for _, snapshot := range snapshots {
+		for _, path := range snapshot.Volumes {
+			volumes = append(volumes, &VirtualMachineAttachedVolume{Path: path, DeviceType: DeviceTypeDisk})
+		}
+	}
+	seen := map[string]bool{}
+	for _, attachedVolume := range volumes {
+		if seen[attachedVolume.Path] {
+			continue
+		}
+		seen[attachedVolume.Path] = true
+		planned := &VirtualMachineDeletePlanVolume{Path: attachedVolume.Path, DeviceType: attachedVolume.DeviceType}
+		volume := known[attachedVolume.Path]
+		switch {
+		case !deleteVolumes:
+			planned.KeepReason = "volumes are kept"
+		case volume == nil:
+			planned.KeepReason = "not found in storage pools"
+		case attachedVolume.DeviceType == DeviceTypeCdrom && !manager.isConfigDrive(vm, attachedVolume):
+			planned.KeepReason = "cdrom"
+		case volume.Metadata.Protected:
+			planned.KeepReason = "protected image"
+		case len(users[attachedVolume.Path]) > 0:
+			planned.KeepReason = "attached to " + strings.Join(users[attachedVolume.Path], ", ")
+		default:
+			planned.Delete = true
+		}
+		plan.Volumes = append(plan.Volumes, planned)
+	}
+	return plan, nil
+}
+
+// Delete removes machine according to fresh delete plan. If confirmed is
+// not nil, the plan must delete exactly these volumes, so nothing besides
+// what user has seen in preview is removed. Machine is locked from planning
+// until it is removed, see lockPlanned for volumes.
+func (manager *VirtualMachineManager) Delete(id, node string, deleteVolumes bool, confirmed []string, progress *TaskProgress) error {
+	unlock := manager.vms.lock(id, node)
+	defer unlock()
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return util.NewError(err, "cannot fetch vm info")
+	}
+	plan, err := manager.PlanDelete(vm, deleteVolumes)
+	if err != nil {
+		return util.NewError(err, "cannot plan deletion")
+	}
+	if confirmed != nil && !plan.Matches(confirmed) {
+		return util.NewError(ErrDeletePlanChanged, "volumes to delete are now %s, please review deletion again", strings.Join(plan.DeletedVolumes(), ", "))
+	}
+	unlockVolumes, err := manager.lockPlanned(plan)
+	if err != nil {
+		return err
+	}
+	defer unlockVolumes()
+	if err := publishBefore(manager.epub, "before_vm_delete", NewEventVirtualMachineDeleted(vm, deleteVolumes)); err != nil {
+		return err
+	}
+	return manager.delete(plan, progress)
+}
+
+// lockPlanned locks volumes planned for deletion, so they can't be attached
+// to other machines until they are removed, and plans again to make sure
+// none of them was attached since the plan was made. Returned function
+// unlocks the volumes.
+func (manager *VirtualMachineManager) lockPlanned(plan *VirtualMachineDeletePlan) (func(), error) {
+	deleted := plan.DeletedVolumes()
+	unlock := manager.vms.lockVolumes(plan.Vm.NodeId, deleted)
+	if len(deleted) == 0 {
+		return unlock, nil
+	}
+	fresh, err := manager.PlanDelete(plan.Vm, plan.deleteVolumes)
+	if err != nil {
+		unlock()
+		return nil, util.NewError(err, "cannot plan deletion")
+	}
+	if !fresh.Matches(deleted) {
+		unlock()
+		return nil, util.NewError(ErrDeletePlanChanged, "volumes to delete are now %s, please review deletion again", strings.Join(fresh.DeletedVolumes(), ", "))
+	}
+	return unlock, nil
+}
+
+// delete executes plan, callers ask before_vm_delete subscribers. Volumes
+// are removed only after the domain is gone, failure to remove one of them
+// doesn't stop removal of others.
+func (manager *VirtualMachineManager) delete(plan *VirtualMachineDeletePlan, progress *TaskProgress) error {
+	vm := plan.Vm
+	if plan.Shutdown {
+		progress.Report(0, "shutting down machine")
+		if err := manager.vms.Shutdown(vm.Id, vm.NodeId, progress); err != nil {
+			return util.NewError(err, "cannot shut down machine")
+		}
+	}
+	progress.Report(99, "removing machine")
+	if err := manager.vms.Delete(vm.Id, vm.NodeId); err != nil {
+		return util.NewError(err, "cannot delete vm")
+	}
+	deleted := plan.DeletedVolumes()
+	left := []string{}
+	for _, path := range deleted {
+		progress.Report(99, "removing volume "+path)
+		if err := manager.volumes.remove(path, vm.NodeId); err != nil {
+			left = append(left, fmt.Sprintf("%s (%s)", path, err))
+		}
+	}
+	if err := manager.epub.Publish(NewEventVirtualMachineDeleted(vm, len(deleted) > 0)); err != nil {
+		return util.NewError(err, "cannot publish event virtual machine deleted")
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("machine is deleted, but volumes are left: %s", strings.Join(left, ", "))
+	}
+	return nil
+}
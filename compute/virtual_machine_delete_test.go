@@ -0,0 +1,167 @@
+package compute
+
+import (
+	"reflect"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+func TestVirtualMachineManagerPlanDelete(t *testing.T) {
+	vm := &VirtualMachine{Id: "web1", NodeId: "n1", Volumes: []*VirtualMachineAttachedVolume{
+		{Path: "/default/web1-disk.overlay", DeviceType: DeviceTypeDisk},
+		{Path: "/default/web1_config.iso", DeviceType: DeviceTypeCdrom, Alias: "configdrive"},
+		{Path: "/iso/ubuntu.iso", DeviceType: DeviceTypeCdrom},
+		{Path: "/default/shared", DeviceType: DeviceTypeDisk},
+		{Path: "/default/missing", DeviceType: DeviceTypeDisk},
+	}}
+	other := &VirtualMachine{Id: "db1", NodeId: "n1", Volumes: []*VirtualMachineAttachedVolume{{Path: "/default/shared"}}}
+	volumes := []*Volume{
+		{NodeId: "n1", Path: "/default/web1-disk.overlay"},
+		{NodeId: "n1", Path: "/default/web1-disk"},
+		{NodeId: "n1", Path: "/default/web1_config.iso"},
+		{NodeId: "n1", Path: "/iso/ubuntu.iso"},
+		{NodeId: "n1", Path: "/default/shared"},
+		{NodeId: "n1", Path: "/images/ubuntu", Metadata: VolumeMetadata{Protected: true}},
+	}
+	type planned struct {
+		Path       string
+		Delete     bool
+		KeepReason string
+	}
+	tests := []struct {
+		name          string
+		deleteVolumes bool
+		snapshots     []*VirtualMachineSnapshot
+		want          []planned
+	}{
+		{
+			name: "volumes are kept",
+			want: []planned{
+				{"/default/web1-disk.overlay", false, "volumes are kept"},
+				{"/default/web1_config.iso", false, "volumes are kept"},
+				{"/iso/ubuntu.iso", false, "volumes are kept"},
+				{"/default/shared", false, "volumes are kept"},
+				{"/default/missing", false, "volumes are kept"},
+			},
+		},
+		{
+			name:          "only owned volumes are deleted",
+			deleteVolumes: true,
+			want: []planned{
+				{"/default/web1-disk.overlay", true, ""},
+				{"/default/web1_config.iso", true, ""},
+				{"/iso/ubuntu.iso", false, "cdrom"},
+				{"/default/shared", false, "attached to db1"},
+				{"/default/missing", false, "not found in storage pools"},
+			},
+		},
+		{
+			name:          "external snapshot volumes",
+			deleteVolumes: true,
+			snapshots: []*VirtualMachineSnapshot{
+				{Name: "internal"},
+				{Name: "before-upgrade", External: true, Volumes: []string{"/default/web1-disk.overlay", "/default/web1-disk"}},
+				{Name: "from-image", External: true, Volumes: []string{"/images/ubuntu"}},
+			},
+			want: []planned{
+				{"/default/web1-disk.overlay", true, ""},
+				{"/default/web1_config.iso", true, ""},
+				{"/iso/ubuntu.iso", false, "cdrom"},
+				{"/default/shared", false, "attached to db1"},
+				{"/default/missing", false, "not found in storage pools"},
+				{"/default/web1-disk", true, ""},
+				{"/images/ubuntu", false, "protected image"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmRepo := &fakeVirtualMachineRepository{vms: []*VirtualMachine{vm, other}, snapshots: tt.snapshots}
+			vms := NewVirtualMachineService(vmRepo, &fakeEventPublisher{}, time.Second)
+			volumes := NewVolumeService(&fakeVolumeRepository{volumes: volumes}, &fakeVolumeOwnershipRepository{}, &fakeEventPublisher{})
+			manager := NewVirtualMachineManager(vms, volumes, nil, nil, nil, &fakeEventPublisher{}, map[string]VirtualMachineManagerNodeSettings{"n1": {CdSuffix: "_config.iso"}})
+			plan, err := manager.PlanDelete(vm, tt.deleteVolumes)
+			if err != nil {
+				t.Fatalf("PlanDelete() error = %v", err)
+			}
+			got := []planned{}
+			for _, volume := range plan.Volumes {
+				got = append(got, planned{volume.Path, volume.Delete, volume.KeepReason})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanDelete() volumes = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(plan.Snapshots, tt.snapshots) {
+				t.Errorf("PlanDelete() snapshots = %v, want %v", plan.Snapshots, tt.snapshots)
+			}
+		})
+	}
+}
+
+func TestDeleteShutdown(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      VirtualMachineState
+		want       bool
+		wantReason bool
+	}{
+		{"running", StateRunning, true, true},
+		{"shutting down", StateShuttingDown, true, true},
+		{"paused", StatePaused, false, true},
+		{"crashed", StateCrashed, false, true},
+		{"saved", StateSaved, false, true},
+		{"stopped", StateStopped, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := deleteShutdown(&VirtualMachine{State: tt.state})
+			if got != tt.want {
+				t.Errorf("deleteShutdown() = %v, want %v", got, tt.want)
+			}
+			if (reason != "") != tt.wantReason {
+				t.Errorf("deleteShutdown() reason = %q, want reason %v", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerLockPlanned(t *testing.T) {
+	tests := []struct {
+		name     string
+		attached []*VirtualMachineAttachedVolume
+		wantErr  error
+	}{
+		{
+			name: "plan unchanged",
+		},
+		{
+			name:     "volume attached to other machine since planning",
+			attached: []*VirtualMachineAttachedVolume{{Path: "/default/web1-disk"}},
+			wantErr:  ErrDeletePlanChanged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &VirtualMachine{Id: "web1", NodeId: "n1", Volumes: []*VirtualMachineAttachedVolume{{Path: "/default/web1-disk", DeviceType: DeviceTypeDisk}}}
+			other := &VirtualMachine{Id: "db1", NodeId: "n1"}
+			vmRepo := &fakeVirtualMachineRepository{vms: []*VirtualMachine{vm, other}}
+			vms := NewVirtualMachineService(vmRepo, &fakeEventPublisher{}, time.Second)
+			volumes := NewVolumeService(&fakeVolumeRepository{volumes: []*Volume{{NodeId: "n1", Path: "/default/web1-disk"}}}, &fakeVolumeOwnershipRepository{}, &fakeEventPublisher{})
+			manager := NewVirtualMachineManager(vms, volumes, nil, nil, nil, &fakeEventPublisher{}, nil)
+			plan, err := manager.PlanDelete(vm, true)
+			if err != nil {
+				t.Fatalf("PlanDelete() error = %v", err)
+			}
+			other.Volumes = tt.attached
+			unlock, err := manager.lockPlanned(plan)
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Fatalf("lockPlanned() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			unlock()
+		})
+	}
+}
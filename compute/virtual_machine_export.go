@@ -0,0 +1,313 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"subuk/vmango/util"
+)
+
+var errMoveImportFinished = errors.New("import finished")
+
+// VirtualMachineImportParams describes import of exported machine.
+// Volumes are created in pools with the same names as on the source
+// node, unless Pool is set. Owner and Project replace ownership recorded
+// in the archive. Shared are paths of existing volumes the archive may
+// reference instead of carrying them, manager sets them.
+type VirtualMachineImportParams struct {
+	NodeId  string
+	Pool    string
+	Owner   string
+	Project string
+	Shared  []string
+}
+
+// VirtualMachineMoveParams describes offline move of stopped machine
+// to another node, see VirtualMachineImportParams for Pool meaning.
+type VirtualMachineMoveParams struct {
+	NodeId string
+	Pool   string
+}
+
+// sharedVolumes returns paths of machine volumes shared with other
+// machines, cdroms except configdrive and protected images, which are kept
+// by PlanDelete. Export references them instead of copying and import
+// expects them to exist on the node.
+func (manager *VirtualMachineManager) sharedVolumes(vm *VirtualMachine) ([]string, error) {
+	shared := []string{}
+	for _, attachedVolume := range vm.Volumes {
+		if attachedVolume.DeviceType == DeviceTypeCdrom && !manager.isConfigDrive(vm, attachedVolume) {
+			shared = append(shared, attachedVolume.Path)
+			continue
+		}
+		volume, err := manager.volumes.Get(attachedVolume.Path, vm.NodeId)
+		if err != nil {
+			if util.ErrorCause(err) == ErrVolumeNotFound {
+				continue
+			}
+			return nil, util.NewError(err, "cannot get volume %s", attachedVolume.Path)
+		}
+		if volume.Metadata.Protected {
+			shared = append(shared, attachedVolume.Path)
+		}
+	}
+	return shared, nil
+}
+
+// referableVolumes returns paths of volumes existing on the node and
+// paths of those import archive may reference: protected images, volumes
+// without owner and volumes of the importing owner or project.
+func (manager *VirtualMachineManager) referableVolumes(nodeId, owner, project string) ([]string, []string, error) {
+	volumes, err := manager.volumes.List(VolumeListOptions{NodeIds: []string{nodeId}})
+	if err != nil {
+		return nil, nil, util.NewError(err, "cannot list volumes")
+	}
+	existing := []string{}
+	referable := []string{}
+	for _, volume := range volumes {
+		existing = append(existing, volume.Path)
+		if volume.Metadata.Protected || !volume.Owned() || (owner != "" && volume.Owner == owner) || (project != "" && volume.Project == project) {
+			referable = append(referable, volume.Path)
+		}
+	}
+	return existing, referable, nil
+}
+
+// Export writes stopped machine definition and its volumes as tar archive.
+func (manager *VirtualMachineManager) Export(id, node string, w io.Writer, progress *TaskProgress) error {
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return util.NewError(err, "cannot get machine")
+	}
+	if vm.State != StateStopped {
+		return util.NewError(ErrMigrationNotPossible, "machine must be stopped")
+	}
+	shared, err := manager.sharedVolumes(vm)
+	if err != nil {
+		return err
+	}
+	return manager.vms.Export(id, node, shared, w, progress)
+}
+
+// ownImported records owner and project of imported machine for volumes
+// created by import, existing are volumes of the node before import.
+func (manager *VirtualMachineManager) ownImported(vm *VirtualMachine, existing []string) error {
+	for _, attachedVolume := range vm.Volumes {
+		if util.ArrayContainsString(existing, attachedVolume.Path) {
+			continue
+		}
+		volume, err := manager.volumes.Get(attachedVolume.Path, vm.NodeId)
+		if err != nil {
+			return util.NewError(err, "cannot get imported volume %s", attachedVolume.Path)
+		}
+		if err := manager.volumes.saveOwnership(volume, vm.Owner, vm.Project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import defines machine from archive written by Export. Machine is known
+// only from the archive, so before_vm_create and quota are checked once it
+// is defined, rejected machine or one exceeding quota is deleted together
+// with its volumes.
+func (manager *VirtualMachineManager) Import(params VirtualMachineImportParams, r io.Reader, progress *TaskProgress) (*VirtualMachine, error) {
+	release := manager.quotas.Lock(params.Owner, params.Project)
+	defer release()
+	existing, referable, err := manager.referableVolumes(params.NodeId, params.Owner, params.Project)
+	if err != nil {
+		return nil, err
+	}
+	params.Shared = referable
+	vm, err := manager.vms.Import(params, r, progress)
+	if err != nil {
+		return nil, err
+	}
+	undo := &rollback{}
+	for _, attachedVolume := range vm.Volumes {
+		path := attachedVolume.Path
+		if util.ArrayContainsString(existing, path) {
+			continue
+		}
+		undo.add("delete volume "+path, func() error { return manager.volumes.remove(path, vm.NodeId) })
+	}
+	undo.add("delete virtual machine "+vm.Id, func() error { return manager.vms.Delete(vm.Id, vm.NodeId) })
+	if err := publishBefore(manager.epub, "before_vm_create", NewEventVirtualMachineCreated(vm)); err != nil {
+		return nil, undo.fail(err)
+	}
+	if err := manager.ownImported(vm, existing); err != nil {
+		return nil, undo.fail(err)
+	}
+	if err := manager.quotas.CheckUsage(vm.Owner, vm.Project); err != nil {
+		return nil, undo.fail(err)
+	}
+	if err := manager.epub.Publish(NewEventVirtualMachineCreated(vm)); err != nil {
+		return nil, undo.fail(util.NewError(err, "cannot publish event virtual machine created"))
+	}
+	return vm, nil
+}
+
+// CheckMove verifies that stopped machine may be moved to destination node
+// and its owner and project are within quota. Moved machine replaces the
+// source one, so it is already counted in usage.
+func (manager *VirtualMachineManager) CheckMove(vm *VirtualMachine, params VirtualMachineMoveParams) error {
+	if params.NodeId == vm.NodeId {
+		return util.NewError(ErrMigrationNotPossible, "machine is already on node %s", vm.NodeId)
+	}
+	if vm.State != StateStopped {
+		return util.NewError(ErrMigrationNotPossible, "machine must be stopped")
+	}
+	if _, err := manager.vms.Get(vm.Id, params.NodeId); err == nil {
+		return util.NewError(ErrMigrationNotPossible, "machine %s already exists on node %s", vm.Id, params.NodeId)
+	} else if util.ErrorCause(err) != ErrVirtualMachineNotFound {
+		return util.NewError(err, "cannot check destination node")
+	}
+	destination, err := manager.nodes.Get(params.NodeId, NodeGetOptions{NoPins: true})
+	if err != nil {
+		return util.NewError(err, "cannot get destination node")
+	}
+	if len(destination.Cpus) < vm.VCpus {
+		return util.NewError(ErrMigrationNotPossible, "destination node has %d cpus, machine needs %d", len(destination.Cpus), vm.VCpus)
+	}
+	if err := manager.checkNetworks(vm, params.NodeId); err != nil {
+		return err
+	}
+	return manager.quotas.CheckUsage(vm.Owner, vm.Project)
+}
+
+// verifyMoved checks that machine on destination node has the same
+// devices as the source one and all its volumes exist.
+func (manager *VirtualMachineManager) verifyMoved(source *VirtualMachine, nodeId string) error {
+	moved, err := manager.vms.Get(source.Id, nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot get machine on destination node")
+	}
+	if len(moved.Volumes) != len(source.Volumes) {
+		return fmt.Errorf("machine on destination node has %d volumes, expected %d", len(moved.Volumes), len(source.Volumes))
+	}
+	if len(moved.Interfaces) != len(source.Interfaces) {
+		return fmt.Errorf("machine on destination node has %d interfaces, expected %d", len(moved.Interfaces), len(source.Interfaces))
+	}
+	for _, attachedVolume := range moved.Volumes {
+		if _, err := manager.volumes.Get(attachedVolume.Path, nodeId); err != nil {
+			return util.NewError(err, "volume %s is not available on destination node", attachedVolume.Path)
+		}
+	}
+	return nil
+}
+
+// Move copies stopped machine to another node through export and import,
+// recreates its configdrive with destination node settings and removes
+// the machine and its volumes from source node once the copy is verified.
+// If any step before removal fails, the copy is deleted. Subscribers are
+// asked with before_vm_create for destination and before_vm_delete for
+// source node before anything is copied, and notified with vm_created and
+// vm_deleted.
+func (manager *VirtualMachineManager) Move(id, node string, params VirtualMachineMoveParams, progress *TaskProgress) error {
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return util.NewError(err, "cannot get machine")
+	}
+	release := manager.quotas.Lock(vm.Owner, vm.Project)
+	defer release()
+	unlock := manager.vms.lock(id, node)
+	defer unlock()
+	if err := manager.CheckMove(vm, params); err != nil {
+		return err
+	}
+	sourceDelete, err := manager.PlanDelete(vm, true)
+	if err != nil {
+		return util.NewError(err, "cannot plan removal from source node")
+	}
+	shared, err := manager.sharedVolumes(vm)
+	if err != nil {
+		return err
+	}
+	existing, referable, err := manager.referableVolumes(params.NodeId, vm.Owner, vm.Project)
+	if err != nil {
+		return err
+	}
+	destination := *vm
+	destination.NodeId = params.NodeId
+	if err := publishBefore(manager.epub, "before_vm_create", NewEventVirtualMachineCreated(&destination)); err != nil {
+		return err
+	}
+	if err := publishBefore(manager.epub, "before_vm_delete", NewEventVirtualMachineDeleted(vm, true)); err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	exported := make(chan error, 1)
+	go func() {
+		err := manager.vms.Export(id, node, shared, writer, nil)
+		writer.CloseWithError(err)
+		exported <- err
+	}()
+	importParams := VirtualMachineImportParams{NodeId: params.NodeId, Pool: params.Pool, Owner: vm.Owner, Project: vm.Project, Shared: referable}
+	moved, err := manager.vms.Import(importParams, reader, progress)
+	reader.CloseWithError(errMoveImportFinished)
+	if exportErr := <-exported; exportErr != nil && util.ErrorCause(exportErr) != errMoveImportFinished && err == nil {
+		err = exportErr
+	}
+	if err != nil {
+		return util.NewError(err, "cannot copy machine to destination node")
+	}
+
+	undo := &rollback{}
+	deleteVolume := func(path string) func() error {
+		return func() error { return manager.volumes.remove(path, params.NodeId) }
+	}
+	copiedConfigDrives := []*VirtualMachineAttachedVolume{}
+	for _, attachedVolume := range moved.Volumes {
+		if vm.Config != nil && attachedVolume.Alias == "configdrive" {
+			copiedConfigDrives = append(copiedConfigDrives, attachedVolume)
+			continue
+		}
+		if util.ArrayContainsString(existing, attachedVolume.Path) {
+			continue
+		}
+		undo.add("delete volume "+attachedVolume.Path, deleteVolume(attachedVolume.Path))
+	}
+	undo.add("delete virtual machine "+id, func() error { return manager.vms.Delete(id, params.NodeId) })
+	if vm.Config != nil {
+		progress.Report(99, "recreating configdrive")
+		for _, attachedVolume := range copiedConfigDrives {
+			if err := manager.vms.DetachVolume(id, params.NodeId, attachedVolume.Path); err != nil {
+				undo.add("delete volume "+attachedVolume.Path, deleteVolume(attachedVolume.Path))
+				return undo.fail(util.NewError(err, "cannot detach copied configdrive"))
+			}
+			if err := manager.volumes.remove(attachedVolume.Path, params.NodeId); err != nil {
+				return undo.fail(util.NewError(err, "cannot delete copied configdrive"))
+			}
+		}
+		moved.Config = vm.Config
+		if err := manager.attachConfigDrive(moved, undo); err != nil {
+			return undo.fail(err)
+		}
+	}
+
+	progress.Report(99, "verifying machine on destination node")
+	if err := manager.verifyMoved(vm, params.NodeId); err != nil {
+		return undo.fail(err)
+	}
+	moved, err = manager.vms.Get(id, params.NodeId)
+	if err != nil {
+		return undo.fail(util.NewError(err, "cannot get machine on destination node"))
+	}
+	if err := manager.ownImported(moved, existing); err != nil {
+		return undo.fail(err)
+	}
+	unlockVolumes, err := manager.lockPlanned(sourceDelete)
+	if err != nil {
+		return undo.fail(err)
+	}
+	defer unlockVolumes()
+	if err := manager.epub.Publish(NewEventVirtualMachineCreated(moved)); err != nil {
+		return undo.fail(util.NewError(err, "cannot publish event virtual machine created"))
+	}
+	progress.Report(99, "removing machine from source node")
+	if err := manager.delete(sourceDelete, nil); err != nil {
+		return util.NewError(err, "machine is copied to node %s, but cannot be removed from node %s", params.NodeId, node)
+	}
+	return nil
+}
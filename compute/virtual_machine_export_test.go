@@ -0,0 +1,127 @@
+package compute
+
+import (
+	"reflect"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+func TestVirtualMachineManagerImport(t *testing.T) {
+	archived := &VirtualMachine{Id: "web1", Owner: "mallory", VCpus: 2, Memory: NewSize(2, SizeUnitG), Volumes: []*VirtualMachineAttachedVolume{
+		{Path: "/default/web1", DeviceType: DeviceTypeDisk},
+		{Path: "/iso/ubuntu.iso", DeviceType: DeviceTypeCdrom},
+	}}
+	tests := []struct {
+		name        string
+		quotas      []*Quota
+		reject      map[string]bool
+		wantErr     error
+		wantVms     []string
+		wantOwners  []string
+		wantDeleted []string
+		wantEvents  []string
+	}{
+		{
+			name:       "owned by importing user",
+			wantVms:    []string{"web1"},
+			wantOwners: []string{"alice"},
+			wantEvents: []string{"before_vm_create", "vm_created"},
+		},
+		{
+			name:        "over quota",
+			quotas:      []*Quota{{Subject: QuotaSubjectUser, Name: "alice", Limit: QuotaResources{VCpus: 1}}},
+			wantErr:     ErrQuotaExceeded,
+			wantVms:     []string{},
+			wantOwners:  []string{},
+			wantDeleted: []string{"/default/web1"},
+			wantEvents:  []string{"before_vm_create", "volume_deleted"},
+		},
+		{
+			name:        "rejected",
+			reject:      map[string]bool{"before_vm_create": true},
+			wantErr:     ErrActionRejected,
+			wantVms:     []string{},
+			wantOwners:  []string{},
+			wantDeleted: []string{"/default/web1"},
+			wantEvents:  []string{"before_vm_create", "volume_deleted"},
+		},
+		{
+			name:       "quota of archive owner doesn't apply",
+			quotas:     []*Quota{{Subject: QuotaSubjectUser, Name: "mallory", Limit: QuotaResources{VCpus: 1}}},
+			wantVms:    []string{"web1"},
+			wantOwners: []string{"alice"},
+			wantEvents: []string{"before_vm_create", "vm_created"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epub := &fakeEventPublisher{reject: tt.reject}
+			volRepo := &fakeVolumeRepository{volumes: []*Volume{
+				{NodeId: "n1", Path: "/iso/ubuntu.iso", Size: NewSize(1, SizeUnitG)},
+				{NodeId: "n1", Path: "/images/debian", Size: NewSize(1, SizeUnitG), Owner: "bob", Metadata: VolumeMetadata{Protected: true}},
+				{NodeId: "n1", Path: "/default/db1", Size: NewSize(10, SizeUnitG), Owner: "bob"},
+				{NodeId: "n1", Path: "/default/web2", Size: NewSize(10, SizeUnitG), Owner: "alice"},
+			}}
+			vmRepo := &fakeVirtualMachineRepository{imported: archived, volumes: volRepo, created: []*Volume{{NodeId: "n1", Path: "/default/web1", Size: NewSize(10, SizeUnitG)}}}
+			owners := &fakeVolumeOwnershipRepository{}
+			vms := NewVirtualMachineService(vmRepo, epub, time.Second)
+			volumes := NewVolumeService(volRepo, owners, epub)
+			manager := NewVirtualMachineManager(vms, volumes, nil, nil, NewQuotaService(vms, volumes, tt.quotas), epub, nil)
+			vm, err := manager.Import(VirtualMachineImportParams{NodeId: "n1", Owner: "alice"}, nil, nil)
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Fatalf("Import() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && vm.Owner != "alice" {
+				t.Errorf("Import() owner = %s, want alice", vm.Owner)
+			}
+			gotVms := []string{}
+			for _, vm := range vmRepo.vms {
+				gotVms = append(gotVms, vm.Id)
+			}
+			if !reflect.DeepEqual(gotVms, tt.wantVms) {
+				t.Errorf("Import() left machines %v, want %v", gotVms, tt.wantVms)
+			}
+			gotOwners := []string{}
+			for _, ownership := range owners.owners {
+				gotOwners = append(gotOwners, ownership.Owner)
+			}
+			if !reflect.DeepEqual(gotOwners, tt.wantOwners) {
+				t.Errorf("Import() volume owners = %v, want %v", gotOwners, tt.wantOwners)
+			}
+			if !reflect.DeepEqual(volRepo.deleted, tt.wantDeleted) {
+				t.Errorf("Import() deleted volumes %v, want %v", volRepo.deleted, tt.wantDeleted)
+			}
+			if want := []string{"/iso/ubuntu.iso", "/images/debian", "/default/web2"}; !reflect.DeepEqual(vmRepo.importParams.Shared, want) {
+				t.Errorf("Import() shared = %v, want %v", vmRepo.importParams.Shared, want)
+			}
+			if !reflect.DeepEqual(epub.published, tt.wantEvents) {
+				t.Errorf("Import() events = %v, want %v", epub.published, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerSharedVolumes(t *testing.T) {
+	vm := &VirtualMachine{Id: "web1", NodeId: "n1", Volumes: []*VirtualMachineAttachedVolume{
+		{Path: "/default/web1", DeviceType: DeviceTypeDisk},
+		{Path: "/default/web1_config.iso", DeviceType: DeviceTypeCdrom, Alias: "configdrive"},
+		{Path: "/iso/ubuntu.iso", DeviceType: DeviceTypeCdrom},
+		{Path: "/images/debian", DeviceType: DeviceTypeDisk},
+		{Path: "/default/missing", DeviceType: DeviceTypeDisk},
+	}}
+	volRepo := &fakeVolumeRepository{volumes: []*Volume{
+		{NodeId: "n1", Path: "/default/web1"},
+		{NodeId: "n1", Path: "/default/web1_config.iso"},
+		{NodeId: "n1", Path: "/images/debian", Metadata: VolumeMetadata{Protected: true}},
+	}}
+	volumes := NewVolumeService(volRepo, &fakeVolumeOwnershipRepository{}, &fakeEventPublisher{})
+	manager := NewVirtualMachineManager(nil, volumes, nil, nil, nil, &fakeEventPublisher{}, nil)
+	shared, err := manager.sharedVolumes(vm)
+	if err != nil {
+		t.Fatalf("sharedVolumes() error = %v", err)
+	}
+	if want := []string{"/iso/ubuntu.iso", "/images/debian"}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("sharedVolumes() = %v, want %v", shared, want)
+	}
+}
@@ -47,21 +47,124 @@ type VirtualMachineManagerCreateParams struct {
 type VirtualMachineManager struct {
 	vms      *VirtualMachineService
 	volumes  *VolumeService
+	nodes    *NodeService
+	networks *NetworkService
+	quotas   *QuotaService
 	settings map[string]VirtualMachineManagerNodeSettings
 	epub     EventPublisher
 }
 
-func NewVirtualMachineManager(vms *VirtualMachineService, volumes *VolumeService, epub EventPublisher, settings map[string]VirtualMachineManagerNodeSettings) *VirtualMachineManager {
+func NewVirtualMachineManager(vms *VirtualMachineService, volumes *VolumeService, nodes *NodeService, networks *NetworkService, quotas *QuotaService, epub EventPublisher, settings map[string]VirtualMachineManagerNodeSettings) *VirtualMachineManager {
 	return &VirtualMachineManager{
 		vms:      vms,
 		volumes:  volumes,
+		nodes:    nodes,
+		networks: networks,
+		quotas:   quotas,
 		epub:     epub,
 		settings: settings,
 	}
 }
 
-func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []VirtualMachineManagerClonedVolumeParams, newVols []VirtualMachineManagerCreatedVolumeParams, start bool) error {
+func (manager *VirtualMachineManager) requestedDisk(nodeId string, cloneVols []VirtualMachineManagerClonedVolumeParams, newVols []VirtualMachineManagerCreatedVolumeParams) (Size, error) {
+	total := uint64(0)
 	for _, p := range cloneVols {
+		if p.NewSize.Value > 0 {
+			total += quotaBytes(p.NewSize)
+			continue
+		}
+		original, err := manager.volumes.Get(p.OriginalPath, nodeId)
+		if err != nil {
+			return Size{}, util.NewError(err, "cannot get original volume")
+		}
+		total += quotaBytes(original.Size)
+	}
+	for _, p := range newVols {
+		total += quotaBytes(p.Size)
+	}
+	return NewSize(total, SizeUnitB), nil
+}
+
+// CheckQuota verifies that machine with given volumes fits into quotas.
+// It allows to reject creation before running it in background, Create
+// checks quota again holding quota lock of machine owner and project.
+func (manager *VirtualMachineManager) CheckQuota(vm *VirtualMachine, cloneVols []VirtualMachineManagerClonedVolumeParams, newVols []VirtualMachineManagerCreatedVolumeParams) error {
+	disk, err := manager.requestedDisk(vm.NodeId, cloneVols, newVols)
+	if err != nil {
+		return err
+	}
+	return manager.quotas.Check(vm, disk)
+}
+
+// CreateVolume creates standalone volume if it fits into quota of its
+// owner and project, quota is checked holding their quota lock.
+func (manager *VirtualMachineManager) CreateVolume(params VolumeCreateParams) (*Volume, error) {
+	release := manager.quotas.Lock(params.Owner, params.Project)
+	defer release()
+	if err := manager.quotas.CheckDisk(params.Owner, params.Project, params.Size); err != nil {
+		return nil, err
+	}
+	return manager.volumes.Create(params)
+}
+
+// CloneVolume clones volume if the clone fits into quota of its owner and
+// project, see CreateVolume.
+func (manager *VirtualMachineManager) CloneVolume(params VolumeCloneParams) (*Volume, error) {
+	release := manager.quotas.Lock(params.Owner, params.Project)
+	defer release()
+	disk, err := manager.requestedDisk(params.NodeId, []VirtualMachineManagerClonedVolumeParams{{OriginalPath: params.OriginalPath, NewSize: params.NewSize}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.quotas.CheckDisk(params.Owner, params.Project, disk); err != nil {
+		return nil, err
+	}
+	return manager.volumes.Clone(params)
+}
+
+// Create clones and creates volumes, defines machine, uploads configdrive and starts
+// the machine. If any step fails, everything done before is undone and returned
+// *RollbackError describes the failure and rollback outcome. Existing machine
+// with the same name is never touched, ErrVirtualMachineAlreadyExists is
+// returned for it. Subscribers of before_vm_create may reject the machine
+// before anything is done. If vm_created was published before the failure,
+// vm_deleted is published when the machine is undone.
+func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []VirtualMachineManagerClonedVolumeParams, newVols []VirtualMachineManagerCreatedVolumeParams, start bool, progress *TaskProgress) error {
+	release := manager.quotas.Lock(vm.Owner, vm.Project)
+	defer release()
+	unlock := manager.vms.lock(vm.Id, vm.NodeId)
+	defer unlock()
+	if _, err := manager.vms.Get(vm.Id, vm.NodeId); err == nil {
+		return util.NewError(ErrVirtualMachineAlreadyExists, "machine %s already exists on node %s", vm.Id, vm.NodeId)
+	} else if util.ErrorCause(err) != ErrVirtualMachineNotFound {
+		return util.NewError(err, "cannot check if machine already exists")
+	}
+	if err := manager.CheckQuota(vm, cloneVols, newVols); err != nil {
+		return err
+	}
+	if err := publishBefore(manager.epub, "before_vm_create", NewEventVirtualMachineCreated(vm)); err != nil {
+		return err
+	}
+	steps := len(cloneVols) + len(newVols) + 2
+	if vm.Config != nil {
+		steps++
+	}
+	step := 0
+	report := func(message string) {
+		progress.Report(step*100/steps, message)
+		step++
+	}
+	undo := &rollback{}
+	fail := func(err error) error {
+		progress.Report(step*100/steps, "rolling back")
+		return undo.fail(err)
+	}
+	deleteVolume := func(path string) func() error {
+		return func() error { return manager.volumes.remove(path, vm.NodeId) }
+	}
+
+	for _, p := range cloneVols {
+		report(fmt.Sprintf("cloning volume %s", p.OriginalPath))
 		params := VolumeCloneParams{
 			NodeId:       vm.NodeId,
 			Format:       p.NewFormat,
@@ -69,11 +172,14 @@ func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []Vir
 			NewName:      p.NewName,
 			NewPool:      p.NewPool,
 			NewSize:      p.NewSize,
+			Owner:        vm.Owner,
+			Project:      vm.Project,
 		}
 		volume, err := manager.volumes.Clone(params)
 		if err != nil {
-			return util.NewError(err, "cannot clone volume")
+			return fail(util.NewError(err, "cannot clone volume"))
 		}
+		undo.add("delete volume "+volume.Path, deleteVolume(volume.Path))
 		vm.Volumes = append(vm.Volumes, &VirtualMachineAttachedVolume{
 			Path:       volume.Path,
 			Alias:      p.Alias,
@@ -82,17 +188,21 @@ func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []Vir
 		})
 	}
 	for _, p := range newVols {
+		report(fmt.Sprintf("creating volume %s", p.Name))
 		params := VolumeCreateParams{
-			NodeId: vm.NodeId,
-			Name:   p.Name,
-			Pool:   p.Pool,
-			Format: p.Format,
-			Size:   p.Size,
+			NodeId:  vm.NodeId,
+			Name:    p.Name,
+			Pool:    p.Pool,
+			Format:  p.Format,
+			Size:    p.Size,
+			Owner:   vm.Owner,
+			Project: vm.Project,
 		}
 		volume, err := manager.volumes.Create(params)
 		if err != nil {
-			return util.NewError(err, "cannot create volume")
+			return fail(util.NewError(err, "cannot create volume"))
 		}
+		undo.add("delete volume "+volume.Path, deleteVolume(volume.Path))
 		vm.Volumes = append(vm.Volumes, &VirtualMachineAttachedVolume{
 			Path:       volume.Path,
 			Alias:      p.Alias,
@@ -100,76 +210,81 @@ func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []Vir
 			DeviceBus:  p.DeviceBus,
 		})
 	}
+	report("defining virtual machine")
 	if err := manager.vms.Save(vm); err != nil {
-		return err
+		return fail(err)
 	}
-	settings := manager.settings[vm.NodeId]
-	if vm.Config != nil {
-		cdFile, err := manager.generateConfigDrive(vm.Config, settings.CdFormat)
-		if err != nil {
-			return util.NewError(err, "cannot generate configdrive")
+	created := false
+	undo.add("delete virtual machine "+vm.Id, func() error {
+		if err := manager.vms.Delete(vm.Id, vm.NodeId); err != nil {
+			return err
 		}
-		cdLen, err := cdFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			return util.NewError(err, "cannot get configdrive length")
+		if created {
+			return manager.epub.Publish(NewEventVirtualMachineDeleted(vm, false))
 		}
-		if _, err := cdFile.Seek(0, io.SeekStart); err != nil {
-			return util.NewError(err, "configdrive seek to start failed")
-		}
-		cdVolumeParams := VolumeCreateParams{
-			NodeId: vm.NodeId,
-			Name:   vm.Id + settings.CdSuffix,
-			Pool:   settings.CdPool,
-			Format: VolumeFormatIso,
-			Size:   NewSize(uint64(cdLen), SizeUnitB),
-		}
-		cdVolume, err := manager.volumes.Create(cdVolumeParams)
-		if err != nil {
-			return util.NewError(err, "cannot create configdrive volume")
-		}
-		if err := manager.volumes.Upload(cdVolume.Path, cdVolume.NodeId, cdFile, cdVolume.Size.Bytes()); err != nil {
-			return util.NewError(err, "cannot upload configdrive volume")
-		}
-		attachedVolume := &VirtualMachineAttachedVolume{
-			Path:       cdVolume.Path,
-			Alias:      "configdrive",
-			DeviceType: DeviceTypeCdrom,
-			DeviceBus:  DeviceBusIde,
-		}
-		if err := manager.vms.AttachVolume(vm.Id, vm.NodeId, attachedVolume); err != nil {
-			return util.NewError(err, "cannot attach configdrive volume")
+		return nil
+	})
+
+	if vm.Config != nil {
+		report("uploading configdrive")
+		if err := manager.attachConfigDrive(vm, undo); err != nil {
+			return fail(err)
 		}
 	}
 	if err := manager.epub.Publish(NewEventVirtualMachineCreated(vm)); err != nil {
-		manager.vms.Delete(vm.Id, vm.NodeId) // Ignore error
-		return util.NewError(err, "cannot publish event virtual machine created")
+		return fail(util.NewError(err, "cannot publish event virtual machine created"))
 	}
+	created = true
 	if start {
+		report("starting virtual machine")
 		if err := manager.vms.Start(vm.Id, vm.NodeId); err != nil {
-			return util.NewError(err, "cannot start vm")
+			return fail(util.NewError(err, "cannot start vm"))
 		}
 	}
 	return nil
 }
 
-func (manager *VirtualMachineManager) Delete(id, node string, deleteVolumes bool) error {
-	volumesToDelete := []*VirtualMachineAttachedVolume{}
-	if deleteVolumes {
-		vm, err := manager.vms.Get(id, node)
-		if err != nil {
-			return util.NewError(err, "cannot fetch vm info")
-		}
-		for _, volume := range vm.Volumes {
-			volumesToDelete = append(volumesToDelete, volume)
-		}
+// attachConfigDrive generates configdrive from machine config, uploads it
+// to configdrive pool of machine node and attaches to the machine.
+func (manager *VirtualMachineManager) attachConfigDrive(vm *VirtualMachine, undo *rollback) error {
+	settings := manager.settings[vm.NodeId]
+	cdFile, err := manager.generateConfigDrive(vm.Config, settings.CdFormat)
+	if err != nil {
+		return util.NewError(err, "cannot generate configdrive")
+	}
+	defer cdFile.Close()
+	cdLen, err := cdFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return util.NewError(err, "cannot get configdrive length")
 	}
-	if err := manager.vms.Delete(id, node); err != nil {
-		return util.NewError(err, "cannot delete vm")
+	if _, err := cdFile.Seek(0, io.SeekStart); err != nil {
+		return util.NewError(err, "configdrive seek to start failed")
 	}
-	for _, volume := range volumesToDelete {
-		if err := manager.volumes.Delete(volume.Path, node); err != nil {
-			return util.NewError(err, "cannot delete volume")
-		}
+	cdVolumeParams := VolumeCreateParams{
+		NodeId:  vm.NodeId,
+		Name:    vm.Id + settings.CdSuffix,
+		Pool:    settings.CdPool,
+		Format:  VolumeFormatIso,
+		Size:    NewSize(uint64(cdLen), SizeUnitB),
+		Owner:   vm.Owner,
+		Project: vm.Project,
+	}
+	cdVolume, err := manager.volumes.Create(cdVolumeParams)
+	if err != nil {
+		return util.NewError(err, "cannot create configdrive volume")
+	}
+	undo.add("delete volume "+cdVolume.Path, func() error { return manager.volumes.remove(cdVolume.Path, cdVolume.NodeId) })
+	if err := manager.volumes.Upload(cdVolume.Path, cdVolume.NodeId, cdFile, cdVolume.Size.Bytes()); err != nil {
+		return util.NewError(err, "cannot upload configdrive volume")
+	}
+	attachedVolume := &VirtualMachineAttachedVolume{
+		Path:       cdVolume.Path,
+		Alias:      "configdrive",
+		DeviceType: DeviceTypeCdrom,
+		DeviceBus:  DeviceBusIde,
+	}
+	if err := manager.vms.AttachVolume(vm.Id, vm.NodeId, attachedVolume); err != nil {
+		return util.NewError(err, "cannot attach configdrive volume")
 	}
 	return nil
 }
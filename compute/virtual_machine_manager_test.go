@@ -0,0 +1,237 @@
+package compute
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+// fakeVirtualMachineRepository keeps machines in memory, methods not
+// overridden here panic. Errors of failing map are returned by methods
+// with the same name. Import defines imported machine and adds its
+// volumes listed in created to volumes.
+type fakeVirtualMachineRepository struct {
+	VirtualMachineRepository
+	vms          []*VirtualMachine
+	snapshots    []*VirtualMachineSnapshot
+	imported     *VirtualMachine
+	created      []*Volume
+	volumes      *fakeVolumeRepository
+	importParams VirtualMachineImportParams
+	failing      map[string]error
+	calls        []string
+}
+
+func (repo *fakeVirtualMachineRepository) call(name string) error {
+	repo.calls = append(repo.calls, name)
+	return repo.failing[name]
+}
+
+func (repo *fakeVirtualMachineRepository) List(options VirtualMachineListOptions) ([]*VirtualMachine, error) {
+	return repo.vms, nil
+}
+
+func (repo *fakeVirtualMachineRepository) Get(id, node string) (*VirtualMachine, error) {
+	for _, vm := range repo.vms {
+		if vm.Id == id && vm.NodeId == node {
+			return vm, nil
+		}
+	}
+	return nil, ErrVirtualMachineNotFound
+}
+
+func (repo *fakeVirtualMachineRepository) Save(vm *VirtualMachine) error {
+	if err := repo.call("Save"); err != nil {
+		return err
+	}
+	repo.vms = append(repo.vms, vm)
+	return nil
+}
+
+func (repo *fakeVirtualMachineRepository) Delete(id, node string) error {
+	if err := repo.call("Delete"); err != nil {
+		return err
+	}
+	result := []*VirtualMachine{}
+	for _, vm := range repo.vms {
+		if vm.Id != id || vm.NodeId != node {
+			result = append(result, vm)
+		}
+	}
+	repo.vms = result
+	return nil
+}
+
+func (repo *fakeVirtualMachineRepository) Start(id, node string) error {
+	return repo.call("Start")
+}
+
+func (repo *fakeVirtualMachineRepository) Pause(id, node string) error {
+	return repo.call("Pause")
+}
+
+func (repo *fakeVirtualMachineRepository) Resume(id, node string) error {
+	return repo.call("Resume")
+}
+
+func (repo *fakeVirtualMachineRepository) Import(params VirtualMachineImportParams, r io.Reader, progress *TaskProgress) (*VirtualMachine, error) {
+	if err := repo.call("Import"); err != nil {
+		return nil, err
+	}
+	repo.importParams = params
+	for _, volume := range repo.created {
+		repo.volumes.volumes = append(repo.volumes.volumes, volume)
+	}
+	vm := *repo.imported
+	vm.NodeId = params.NodeId
+	vm.Owner = params.Owner
+	vm.Project = params.Project
+	repo.vms = append(repo.vms, &vm)
+	return &vm, nil
+}
+
+func (repo *fakeVirtualMachineRepository) CheckMigrationCpu(id, node, targetNode string) error {
+	return repo.call("CheckMigrationCpu")
+}
+
+func (repo *fakeVirtualMachineRepository) Migrate(id, node string, params VirtualMachineMigrateParams, progress *TaskProgress) error {
+	return repo.call("Migrate")
+}
+
+func (repo *fakeVirtualMachineRepository) RevertSnapshot(id, node, name string) error {
+	return repo.call("RevertSnapshot")
+}
+
+func (repo *fakeVirtualMachineRepository) ListSnapshots(id, node string) ([]*VirtualMachineSnapshot, error) {
+	return repo.snapshots, repo.failing["ListSnapshots"]
+}
+
+func TestVirtualMachineManagerCreate(t *testing.T) {
+	existing := &VirtualMachine{Id: "web1", NodeId: "n1", VCpus: 1}
+	tests := []struct {
+		name       string
+		vmId       string
+		failing    map[string]error
+		reject     map[string]bool
+		wantErr    error
+		wantVms    []string
+		wantVols   int
+		wantEvents []string
+	}{
+		{
+			name:       "created and started",
+			vmId:       "web2",
+			wantVms:    []string{"web1", "web2"},
+			wantVols:   1,
+			wantEvents: []string{"before_vm_create", "before_volume_create", "volume_created", "vm_created", "before_vm_start", "vm_started"},
+		},
+		{
+			name:       "rejected",
+			vmId:       "web2",
+			reject:     map[string]bool{"before_vm_create": true},
+			wantErr:    ErrActionRejected,
+			wantVms:    []string{"web1"},
+			wantEvents: []string{"before_vm_create"},
+		},
+		{
+			name:       "start failed",
+			vmId:       "web2",
+			failing:    map[string]error{"Start": errors.New("no memory")},
+			wantVms:    []string{"web1"},
+			wantEvents: []string{"before_vm_create", "before_volume_create", "volume_created", "vm_created", "before_vm_start", "vm_deleted", "volume_deleted"},
+		},
+		{
+			name:       "define failed",
+			vmId:       "web2",
+			failing:    map[string]error{"Save": errors.New("invalid xml")},
+			wantVms:    []string{"web1"},
+			wantEvents: []string{"before_vm_create", "before_volume_create", "volume_created", "volume_deleted"},
+		},
+		{
+			name:    "existing machine is not touched",
+			vmId:    "web1",
+			wantErr: ErrVirtualMachineAlreadyExists,
+			wantVms: []string{"web1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epub := &fakeEventPublisher{reject: tt.reject}
+			vmRepo := &fakeVirtualMachineRepository{vms: []*VirtualMachine{existing}, failing: tt.failing}
+			volRepo := &fakeVolumeRepository{}
+			vms := NewVirtualMachineService(vmRepo, epub, time.Second)
+			volumes := NewVolumeService(volRepo, &fakeVolumeOwnershipRepository{}, epub)
+			manager := NewVirtualMachineManager(vms, volumes, nil, nil, NewQuotaService(vms, volumes, nil), epub, nil)
+			vm := &VirtualMachine{Id: tt.vmId, NodeId: "n1", VCpus: 1}
+			newVols := []VirtualMachineManagerCreatedVolumeParams{{Name: tt.vmId + "-disk", Pool: "default", Size: NewSize(10, SizeUnitG)}}
+			err := manager.Create(vm, nil, newVols, true, nil)
+			if tt.wantErr != nil && util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("Create() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (err == nil) != (tt.failing == nil) {
+				t.Errorf("Create() error = %v", err)
+			}
+			gotVms := []string{}
+			for _, vm := range vmRepo.vms {
+				gotVms = append(gotVms, vm.Id)
+			}
+			if !reflect.DeepEqual(gotVms, tt.wantVms) {
+				t.Errorf("Create() left machines %v, want %v", gotVms, tt.wantVms)
+			}
+			if len(volRepo.volumes) != tt.wantVols {
+				t.Errorf("Create() left %d volumes, want %d", len(volRepo.volumes), tt.wantVols)
+			}
+			if len(vmRepo.vms) == 0 || vmRepo.vms[0] != existing {
+				t.Errorf("Create() changed existing machine")
+			}
+			if !reflect.DeepEqual(epub.published, tt.wantEvents) {
+				t.Errorf("Create() events = %v, want %v", epub.published, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerCreateVolume(t *testing.T) {
+	quotas := []*Quota{{Subject: QuotaSubjectUser, Name: "alice", Limit: QuotaResources{Disk: NewSize(30, SizeUnitG)}}}
+	tests := []struct {
+		name    string
+		owner   string
+		size    Size
+		clone   bool
+		wantErr error
+	}{
+		{"within quota", "alice", NewSize(10, SizeUnitG), false, nil},
+		{"over quota", "alice", NewSize(11, SizeUnitG), false, ErrQuotaExceeded},
+		{"no quota", "bob", NewSize(100, SizeUnitG), false, nil},
+		{"clone within quota", "alice", NewSize(10, SizeUnitG), true, nil},
+		{"clone over quota", "alice", NewSize(11, SizeUnitG), true, ErrQuotaExceeded},
+		{"clone of original size over quota", "alice", NewSize(0, SizeUnitG), true, ErrQuotaExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epub := &fakeEventPublisher{}
+			volRepo := &fakeVolumeRepository{volumes: []*Volume{
+				{NodeId: "n1", Path: "/default/web1", Size: NewSize(20, SizeUnitG), Owner: "alice"},
+				{NodeId: "n1", Path: "/images/debian", Size: NewSize(12, SizeUnitG)},
+			}}
+			vms := NewVirtualMachineService(&fakeVirtualMachineRepository{}, epub, time.Second)
+			volumes := NewVolumeService(volRepo, &fakeVolumeOwnershipRepository{}, epub)
+			manager := NewVirtualMachineManager(vms, volumes, nil, nil, NewQuotaService(vms, volumes, quotas), epub, nil)
+			var err error
+			if tt.clone {
+				_, err = manager.CloneVolume(VolumeCloneParams{NodeId: "n1", OriginalPath: "/images/debian", NewName: "web2", NewPool: "default", NewSize: tt.size, Owner: tt.owner})
+			} else {
+				_, err = manager.CreateVolume(VolumeCreateParams{NodeId: "n1", Name: "web2", Pool: "default", Size: tt.size, Owner: tt.owner})
+			}
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if created := len(volRepo.volumes) == 3; created != (tt.wantErr == nil) {
+				t.Errorf("volume created = %v", created)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package compute
+
+import (
+	"errors"
+	"net"
+	"subuk/vmango/util"
+)
+
+var ErrMigrationNotPossible = errors.New("migration is not possible")
+
+// VirtualMachineMigrateParams describes live migration to another node.
+// Without CopyStorage all volumes must be available on destination node
+// with the same paths, i.e. reside on shared storage.
+type VirtualMachineMigrateParams struct {
+	NodeId      string
+	CopyStorage bool
+}
+
+func nodeFreeMemory(node *Node, hugepages bool) uint64 {
+	free := uint64(0)
+	for _, numa := range node.Numas {
+		if hugepages {
+			free += numa.Pages2mFreeSize().Bytes() + numa.Pages1gFreeSize().Bytes()
+		} else {
+			free += numa.Pages4kFreeSize().Bytes()
+		}
+	}
+	return free
+}
+
+// checkNetworks verifies that every network of the machine exists on the node.
+func (manager *VirtualMachineManager) checkNetworks(vm *VirtualMachine, nodeId string) error {
+	networks, err := manager.networks.List(NetworkListOptions{NodeIds: []string{nodeId}})
+	if err != nil {
+		return util.NewError(err, "cannot list destination networks")
+	}
+	available := map[string]bool{}
+	for _, network := range networks {
+		available[network.Name] = true
+	}
+	for _, iface := range vm.Interfaces {
+		if !available[iface.NetworkName] {
+			return util.NewError(ErrMigrationNotPossible, "network %s is missing on destination node", iface.NetworkName)
+		}
+	}
+	return nil
+}
+
+// CheckMigration verifies that running machine may be moved to destination
+// node: destination hypervisor provides cpu model of the machine and has
+// enough cores, memory and networks are available.
+func (manager *VirtualMachineManager) CheckMigration(vm *VirtualMachine, params VirtualMachineMigrateParams) error {
+	if params.NodeId == vm.NodeId {
+		return util.NewError(ErrMigrationNotPossible, "machine is already on node %s", vm.NodeId)
+	}
+	if !vm.IsRunning() {
+		return util.NewError(ErrMigrationNotPossible, "machine is not running")
+	}
+	if _, err := manager.vms.Get(vm.Id, params.NodeId); err == nil {
+		return util.NewError(ErrMigrationNotPossible, "machine %s already exists on node %s", vm.Id, params.NodeId)
+	} else if util.ErrorCause(err) != ErrVirtualMachineNotFound {
+		return util.NewError(err, "cannot check destination node")
+	}
+	if listen := net.ParseIP(vm.Graphic.Listen); listen != nil && !listen.IsUnspecified() && !listen.IsLoopback() {
+		return util.NewError(ErrMigrationNotPossible, "graphic listens on source node address %s", vm.Graphic.Listen)
+	}
+
+	source, err := manager.nodes.Get(vm.NodeId, NodeGetOptions{NoPins: true})
+	if err != nil {
+		return util.NewError(err, "cannot get source node")
+	}
+	destination, err := manager.nodes.Get(params.NodeId, NodeGetOptions{NoPins: true})
+	if err != nil {
+		return util.NewError(err, "cannot get destination node")
+	}
+	if destination.CpuArch != source.CpuArch || destination.CpuVendor != source.CpuVendor {
+		return util.NewError(ErrMigrationNotPossible, "destination cpu %s %s is not compatible with %s %s", destination.CpuVendor, destination.CpuArch, source.CpuVendor, source.CpuArch)
+	}
+	if err := manager.vms.CheckMigrationCpu(vm.Id, vm.NodeId, params.NodeId); err != nil {
+		return util.NewError(err, "cannot check destination cpu")
+	}
+	if len(destination.Cpus) < vm.VCpus {
+		return util.NewError(ErrMigrationNotPossible, "destination node has %d cpus, machine needs %d", len(destination.Cpus), vm.VCpus)
+	}
+	if vm.Cpupin != nil {
+		pinned := append([]uint{}, vm.Cpupin.Emulator...)
+		for _, hostCpus := range vm.Cpupin.Vcpus {
+			pinned = append(pinned, hostCpus...)
+		}
+		for _, hostCpu := range pinned {
+			if int(hostCpu) >= len(destination.Cpus) {
+				return util.NewError(ErrMigrationNotPossible, "machine is pinned to cpu %d missing on destination node", hostCpu)
+			}
+		}
+	}
+	if free := nodeFreeMemory(destination, vm.Hugepages); free < vm.Memory.Bytes() {
+		return util.NewError(ErrMigrationNotPossible, "destination node has %d MiB of free memory, machine needs %d MiB", free/1024/1024, vm.Memory.M())
+	}
+
+	if err := manager.checkNetworks(vm, params.NodeId); err != nil {
+		return err
+	}
+
+	if !params.CopyStorage {
+		for _, attachedVolume := range vm.Volumes {
+			if _, err := manager.volumes.Get(attachedVolume.Path, params.NodeId); err != nil {
+				return util.NewError(ErrMigrationNotPossible, "volume %s is not available on destination node, storage must be copied", attachedVolume.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate moves running machine to another node, see CheckMigration for requirements.
+func (manager *VirtualMachineManager) Migrate(id, nodeId string, params VirtualMachineMigrateParams, progress *TaskProgress) error {
+	unlock := manager.vms.lock(id, nodeId)
+	defer unlock()
+	vm, err := manager.vms.Get(id, nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot get machine")
+	}
+	if err := manager.CheckMigration(vm, params); err != nil {
+		return err
+	}
+	event := NewEventVirtualMachineMigrated(id, nodeId, params)
+	if err := publishBefore(manager.epub, "before_vm_migrate", event); err != nil {
+		return err
+	}
+	if err := manager.vms.Migrate(id, nodeId, params, progress); err != nil {
+		return err
+	}
+	if err := manager.epub.Publish(event); err != nil {
+		return util.NewError(err, "cannot publish event virtual machine migrated")
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package compute
+
+import (
+	"errors"
+	"reflect"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+type fakeNodeRepository struct {
+	nodes []*Node
+}
+
+func (repo *fakeNodeRepository) Get(id string, options NodeGetOptions) (*Node, error) {
+	for _, node := range repo.nodes {
+		if node.Id == id {
+			return node, nil
+		}
+	}
+	return nil, errors.New("node not found")
+}
+
+func (repo *fakeNodeRepository) List(options NodeListOptions) ([]*Node, error) {
+	return repo.nodes, nil
+}
+
+type fakeNetworkRepository struct {
+	networks []*Network
+}
+
+func (repo *fakeNetworkRepository) List(options NetworkListOptions) ([]*Network, error) {
+	return repo.networks, nil
+}
+
+func (repo *fakeNetworkRepository) Get(name, node string) (*Network, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestVirtualMachineManagerCheckMigration(t *testing.T) {
+	source := &Node{Id: "n1", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 4), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	destination := &Node{Id: "n2", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 2), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	tests := []struct {
+		name    string
+		vm      *VirtualMachine
+		params  VirtualMachineMigrateParams
+		failing map[string]error
+		wantErr error
+	}{
+		{
+			name:   "possible",
+			vm:     &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 2, Memory: NewSize(1, SizeUnitG)},
+			params: VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true},
+		},
+		{
+			name:    "stopped",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateStopped},
+			params:  VirtualMachineMigrateParams{NodeId: "n2"},
+			wantErr: ErrMigrationNotPossible,
+		},
+		{
+			name:    "cpu model is not provided",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 2, Memory: NewSize(1, SizeUnitG)},
+			params:  VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true},
+			failing: map[string]error{"CheckMigrationCpu": ErrMigrationNotPossible},
+			wantErr: ErrMigrationNotPossible,
+		},
+		{
+			name:    "not enough cpus",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 4, Memory: NewSize(1, SizeUnitG)},
+			params:  VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true},
+			wantErr: ErrMigrationNotPossible,
+		},
+		{
+			name:    "not enough memory",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 1, Memory: NewSize(8, SizeUnitG)},
+			params:  VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true},
+			wantErr: ErrMigrationNotPossible,
+		},
+		{
+			name:    "network is missing",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 1, Memory: NewSize(1, SizeUnitG), Interfaces: []*VirtualMachineAttachedInterface{{NetworkName: "dmz"}}},
+			params:  VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true},
+			wantErr: ErrMigrationNotPossible,
+		},
+		{
+			name:    "volume is not shared",
+			vm:      &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 1, Memory: NewSize(1, SizeUnitG), Volumes: []*VirtualMachineAttachedVolume{{Path: "/default/web1"}}},
+			params:  VirtualMachineMigrateParams{NodeId: "n2"},
+			wantErr: ErrMigrationNotPossible,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmRepo := &fakeVirtualMachineRepository{vms: []*VirtualMachine{tt.vm}, failing: tt.failing}
+			vms := NewVirtualMachineService(vmRepo, &fakeEventPublisher{}, time.Second)
+			volumes := NewVolumeService(&fakeVolumeRepository{}, &fakeVolumeOwnershipRepository{}, &fakeEventPublisher{})
+			nodes := NewNodeService(&fakeNodeRepository{nodes: []*Node{source, destination}})
+			networks := NewNetworkService(&fakeNetworkRepository{networks: []*Network{{Name: "default"}}})
+			manager := NewVirtualMachineManager(vms, volumes, nodes, networks, nil, &fakeEventPublisher{}, nil)
+			err := manager.CheckMigration(tt.vm, tt.params)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("CheckMigration() error = %v", err)
+			}
+			if tt.wantErr != nil && util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("CheckMigration() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerMigrate(t *testing.T) {
+	source := &Node{Id: "n1", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 4), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	destination := &Node{Id: "n2", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 2), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	tests := []struct {
+		name       string
+		reject     map[string]bool
+		failing    map[string]error
+		wantErr    error
+		wantCalls  []string
+		wantEvents []string
+	}{
+		{
+			name:       "migrated",
+			wantCalls:  []string{"CheckMigrationCpu", "Migrate"},
+			wantEvents: []string{"before_vm_migrate", "vm_migrated"},
+		},
+		{
+			name:       "rejected",
+			reject:     map[string]bool{"before_vm_migrate": true},
+			wantErr:    ErrActionRejected,
+			wantCalls:  []string{"CheckMigrationCpu"},
+			wantEvents: []string{"before_vm_migrate"},
+		},
+		{
+			name:       "failed",
+			failing:    map[string]error{"Migrate": ErrMigrationNotPossible},
+			wantErr:    ErrMigrationNotPossible,
+			wantCalls:  []string{"CheckMigrationCpu", "Migrate"},
+			wantEvents: []string{"before_vm_migrate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 1, Memory: NewSize(1, SizeUnitG)}
+			epub := &fakeEventPublisher{reject: tt.reject}
+			vmRepo := &fakeVirtualMachineRepository{vms: []*VirtualMachine{vm}, failing: tt.failing}
+			vms := NewVirtualMachineService(vmRepo, epub, time.Second)
+			volumes := NewVolumeService(&fakeVolumeRepository{}, &fakeVolumeOwnershipRepository{}, epub)
+			nodes := NewNodeService(&fakeNodeRepository{nodes: []*Node{source, destination}})
+			networks := NewNetworkService(&fakeNetworkRepository{})
+			manager := NewVirtualMachineManager(vms, volumes, nodes, networks, nil, epub, nil)
+			err := manager.Migrate("web1", "n1", VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true}, nil)
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("Migrate() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(vmRepo.calls, tt.wantCalls) {
+				t.Errorf("Migrate() calls = %v, want %v", vmRepo.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(epub.published, tt.wantEvents) {
+				t.Errorf("Migrate() events = %v, want %v", epub.published, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerMigrateLocked(t *testing.T) {
+	source := &Node{Id: "n1", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 4), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	destination := &Node{Id: "n2", CpuArch: ArchAmd64, CpuVendor: "Intel", Cpus: make([]NodeCpu, 2), Numas: []NodeNuma{{Pages4kFree: 1024 * 1024}}}
+	vm := &VirtualMachine{Id: "web1", NodeId: "n1", State: StateRunning, VCpus: 1, Memory: NewSize(1, SizeUnitG)}
+	epub := &fakeEventPublisher{}
+	vms := NewVirtualMachineService(&fakeVirtualMachineRepository{vms: []*VirtualMachine{vm}}, epub, time.Second)
+	volumes := NewVolumeService(&fakeVolumeRepository{}, &fakeVolumeOwnershipRepository{}, epub)
+	nodes := NewNodeService(&fakeNodeRepository{nodes: []*Node{source, destination}})
+	manager := NewVirtualMachineManager(vms, volumes, nodes, NewNetworkService(&fakeNetworkRepository{}), nil, epub, nil)
+
+	unlock := vms.lock("web1", "n1")
+	migrated := make(chan error, 1)
+	go func() {
+		migrated <- manager.Migrate("web1", "n1", VirtualMachineMigrateParams{NodeId: "n2", CopyStorage: true}, nil)
+	}()
+	select {
+	case <-migrated:
+		t.Fatalf("Migrate() finished while machine is locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-migrated; err != nil {
+		t.Errorf("Migrate() error = %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RollbackStep is the outcome of undoing one completed step of
+// failed operation, Err is nil if step was undone.
+type RollbackStep struct {
+	Name string
+	Err  error
+}
+
+// RollbackError is returned when operation failed midway and its
+// completed steps were undone. Err is the original failure.
+type RollbackError struct {
+	Err   error
+	Steps []RollbackStep
+}
+
+func (e *RollbackError) Error() string {
+	if len(e.Steps) == 0 {
+		return e.Err.Error()
+	}
+	steps := []string{}
+	for _, step := range e.Steps {
+		if step.Err != nil {
+			steps = append(steps, fmt.Sprintf("%s failed: %s", step.Name, step.Err))
+		} else {
+			steps = append(steps, step.Name+" ok")
+		}
+	}
+	return fmt.Sprintf("%s (rollback: %s)", e.Err, strings.Join(steps, ", "))
+}
+
+func (e *RollbackError) Cause() error {
+	return e.Err
+}
+
+// Complete reports whether every completed step was undone.
+func (e *RollbackError) Complete() bool {
+	for _, step := range e.Steps {
+		if step.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+type rollbackAction struct {
+	name string
+	undo func() error
+}
+
+// rollback collects compensating actions of completed steps,
+// they are run in reverse order when operation fails.
+type rollback struct {
+	actions []rollbackAction
+}
+
+func (r *rollback) add(name string, undo func() error) {
+	r.actions = append(r.actions, rollbackAction{name: name, undo: undo})
+}
+
+func (r *rollback) fail(err error) error {
+	rerr := &RollbackError{Err: err}
+	for idx := len(r.actions) - 1; idx >= 0; idx-- {
+		action := r.actions[idx]
+		rerr.Steps = append(rerr.Steps, RollbackStep{Name: action.name, Err: action.undo()})
+	}
+	return rerr
+}
@@ -0,0 +1,74 @@
+package compute
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRollbackFail(t *testing.T) {
+	failure := errors.New("cannot start vm")
+	tests := []struct {
+		name         string
+		steps        []string
+		failing      map[string]bool
+		wantOrder    []string
+		wantComplete bool
+		wantError    string
+	}{
+		{
+			name:         "nothing to undo",
+			wantOrder:    []string{},
+			wantComplete: true,
+			wantError:    "cannot start vm",
+		},
+		{
+			name:         "reverse order",
+			steps:        []string{"delete volume a", "delete volume b", "delete virtual machine"},
+			wantOrder:    []string{"delete virtual machine", "delete volume b", "delete volume a"},
+			wantComplete: true,
+			wantError:    "cannot start vm (rollback: delete virtual machine ok, delete volume b ok, delete volume a ok)",
+		},
+		{
+			name:         "failed step doesn't stop rollback",
+			steps:        []string{"delete volume a", "delete virtual machine"},
+			failing:      map[string]bool{"delete virtual machine": true},
+			wantOrder:    []string{"delete virtual machine", "delete volume a"},
+			wantComplete: false,
+			wantError:    "cannot start vm (rollback: delete virtual machine failed: busy, delete volume a ok)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			undo := &rollback{}
+			order := []string{}
+			for _, step := range tt.steps {
+				step := step
+				undo.add(step, func() error {
+					order = append(order, step)
+					if tt.failing[step] {
+						return errors.New("busy")
+					}
+					return nil
+				})
+			}
+			err := undo.fail(failure)
+			rerr, ok := err.(*RollbackError)
+			if !ok {
+				t.Fatalf("fail() = %T, want *RollbackError", err)
+			}
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("fail() order = %v, want %v", order, tt.wantOrder)
+			}
+			if rerr.Complete() != tt.wantComplete {
+				t.Errorf("Complete() = %v, want %v", rerr.Complete(), tt.wantComplete)
+			}
+			if rerr.Error() != tt.wantError {
+				t.Errorf("Error() = %q, want %q", rerr.Error(), tt.wantError)
+			}
+			if rerr.Cause() != failure {
+				t.Errorf("Cause() = %v, want %v", rerr.Cause(), failure)
+			}
+		})
+	}
+}
@@ -1,9 +1,55 @@
 package compute
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"subuk/vmango/util"
+	"time"
+)
+
+var ErrVirtualMachineNotFound = errors.New("virtual machine not found")
+var ErrVirtualMachineAlreadyExists = errors.New("virtual machine already exists")
 
 type VirtualMachineListOptions struct {
-	NodeIds []string
+	NodeIds  []string
+	Owners   []string
+	Projects []string
+	States   []VirtualMachineState
+}
+
+// MatchState reports whether vm is in one of States, options without
+// states match everything.
+func (options VirtualMachineListOptions) MatchState(vm *VirtualMachine) bool {
+	if len(options.States) == 0 {
+		return true
+	}
+	for _, state := range options.States {
+		if vm.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchOwner reports whether vm is owned by one of Owners or belongs
+// to one of Projects. Options without owners and projects match everything.
+func (options VirtualMachineListOptions) MatchOwner(vm *VirtualMachine) bool {
+	if len(options.Owners) == 0 && len(options.Projects) == 0 {
+		return true
+	}
+	for _, owner := range options.Owners {
+		if vm.Owner != "" && vm.Owner == owner {
+			return true
+		}
+	}
+	for _, project := range options.Projects {
+		if vm.Project != "" && vm.Project == project {
+			return true
+		}
+	}
+	return false
 }
 
 type VirtualMachineRepository interface {
@@ -16,18 +62,204 @@ type VirtualMachineRepository interface {
 	AttachInterface(id, node string, iface *VirtualMachineAttachedInterface) error
 	DetachInterface(id, node, mac string) error
 	GetConsoleStream(id, node string) (VirtualMachineConsoleStream, error)
-	GetGraphicStream(id, node string) (VirtualMachineGraphicStream, error)
+	GetGraphicStream(id, node string, graphicType GraphicType) (VirtualMachineGraphicStream, error)
 	Poweroff(id, node string) error
 	Reboot(id, node string) error
 	Start(id, node string) error
+	Shutdown(id, node string) error
+	Reset(id, node string) error
+	Pause(id, node string) error
+	Resume(id, node string) error
+	ManagedSave(id, node string) error
+	ListSnapshots(id, node string) ([]*VirtualMachineSnapshot, error)
+	CreateSnapshot(id, node string, params VirtualMachineSnapshotCreateParams) (*VirtualMachineSnapshot, error)
+	RevertSnapshot(id, node, name string) error
+	DeleteSnapshot(id, node, name string) error
+	CheckMigrationCpu(id, node, targetNode string) error
+	Migrate(id, node string, params VirtualMachineMigrateParams, progress *TaskProgress) error
+	Export(id, node string, shared []string, w io.Writer, progress *TaskProgress) error
+	Import(params VirtualMachineImportParams, r io.Reader, progress *TaskProgress) (*VirtualMachine, error)
 }
 
 type VirtualMachineService struct {
 	VirtualMachineRepository
+	epub            EventPublisher
+	shutdownTimeout time.Duration
+	locks           *lockSet
+}
+
+func NewVirtualMachineService(repo VirtualMachineRepository, epub EventPublisher, shutdownTimeout time.Duration) *VirtualMachineService {
+	return &VirtualMachineService{repo, epub, shutdownTimeout, newLockSet()}
+}
+
+// lock serializes operations which must not interleave on the same
+// machine, like creation of machines with the same name. Returned
+// function releases the lock.
+func (service *VirtualMachineService) lock(id, node string) func() {
+	return service.locks.lock(node + "/" + id)
+}
+
+// lockVolumes serializes attaching of volumes with their removal together
+// with deleted machine. Returned function releases the lock.
+func (service *VirtualMachineService) lockVolumes(node string, paths []string) func() {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	names := []string{}
+	for _, path := range sorted {
+		names = append(names, node+" volume "+path)
+	}
+	return service.locks.lock(names...)
+}
+
+var shutdownPollInterval = time.Second
+
+func (service *VirtualMachineService) publish(event Event) error {
+	if err := service.epub.Publish(event); err != nil {
+		return util.NewError(err, "cannot publish event %s", event.Name())
+	}
+	return nil
+}
+
+// act does action between publishing before_* event and the event itself,
+// action is not done if before_* subscriber rejects it.
+func (service *VirtualMachineService) act(before string, event Event, action func() error) error {
+	if err := publishBefore(service.epub, before, event); err != nil {
+		return err
+	}
+	if err := action(); err != nil {
+		return err
+	}
+	return service.publish(event)
+}
+
+// Update saves settings of existing machine.
+func (service *VirtualMachineService) Update(vm *VirtualMachine) error {
+	return service.act("before_vm_update", NewEventVirtualMachineUpdated(vm), func() error {
+		return service.VirtualMachineRepository.Save(vm)
+	})
 }
 
-func NewVirtualMachineService(repo VirtualMachineRepository) *VirtualMachineService {
-	return &VirtualMachineService{repo}
+func (service *VirtualMachineService) Start(id, node string) error {
+	return service.act("before_vm_start", NewEventVirtualMachineStarted(id, node, "start"), func() error {
+		return service.VirtualMachineRepository.Start(id, node)
+	})
+}
+
+func (service *VirtualMachineService) Poweroff(id, node string) error {
+	return service.act("before_vm_stop", NewEventVirtualMachineStopped(id, node, "poweroff"), func() error {
+		return service.VirtualMachineRepository.Poweroff(id, node)
+	})
+}
+
+func (service *VirtualMachineService) ManagedSave(id, node string) error {
+	return service.act("before_vm_stop", NewEventVirtualMachineStopped(id, node, "save"), func() error {
+		return service.VirtualMachineRepository.ManagedSave(id, node)
+	})
+}
+
+func (service *VirtualMachineService) Reboot(id, node string) error {
+	return service.act("before_vm_reboot", NewEventVirtualMachineRebooted(id, node, "reboot"), func() error {
+		return service.VirtualMachineRepository.Reboot(id, node)
+	})
+}
+
+func (service *VirtualMachineService) Reset(id, node string) error {
+	return service.act("before_vm_reboot", NewEventVirtualMachineRebooted(id, node, "reset"), func() error {
+		return service.VirtualMachineRepository.Reset(id, node)
+	})
+}
+
+func (service *VirtualMachineService) Pause(id, node string) error {
+	return service.act("before_vm_pause", NewEventVirtualMachinePaused(id, node, "pause"), func() error {
+		return service.VirtualMachineRepository.Pause(id, node)
+	})
+}
+
+func (service *VirtualMachineService) Resume(id, node string) error {
+	return service.act("before_vm_resume", NewEventVirtualMachineResumed(id, node, "resume"), func() error {
+		return service.VirtualMachineRepository.Resume(id, node)
+	})
+}
+
+func (service *VirtualMachineService) AttachVolume(id, node string, attachedVolume *VirtualMachineAttachedVolume) error {
+	unlock := service.lockVolumes(node, []string{attachedVolume.Path})
+	defer unlock()
+	if err := service.VirtualMachineRepository.AttachVolume(id, node, attachedVolume); err != nil {
+		return err
+	}
+	return service.publish(NewEventVirtualMachineVolumeAttached(id, node, attachedVolume))
+}
+
+func (service *VirtualMachineService) DetachVolume(id, node, path string) error {
+	if err := service.VirtualMachineRepository.DetachVolume(id, node, path); err != nil {
+		return err
+	}
+	return service.publish(NewEventVirtualMachineVolumeDetached(id, node, path))
+}
+
+func (service *VirtualMachineService) AttachInterface(id, node string, iface *VirtualMachineAttachedInterface) error {
+	if err := service.VirtualMachineRepository.AttachInterface(id, node, iface); err != nil {
+		return err
+	}
+	return service.publish(NewEventVirtualMachineInterfaceAttached(id, node, iface))
+}
+
+func (service *VirtualMachineService) DetachInterface(id, node, mac string) error {
+	if err := service.VirtualMachineRepository.DetachInterface(id, node, mac); err != nil {
+		return err
+	}
+	return service.publish(NewEventVirtualMachineInterfaceDetached(id, node, mac))
+}
+
+func (service *VirtualMachineService) CreateSnapshot(id, node string, params VirtualMachineSnapshotCreateParams) (*VirtualMachineSnapshot, error) {
+	if err := publishBefore(service.epub, "before_vm_snapshot", NewEventVirtualMachineSnapshotted(id, node, "create", params.Name)); err != nil {
+		return nil, err
+	}
+	snapshot, err := service.VirtualMachineRepository.CreateSnapshot(id, node, params)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, service.publish(NewEventVirtualMachineSnapshotted(id, node, "create", snapshot.Name))
+}
+
+func (service *VirtualMachineService) RevertSnapshot(id, node, name string) error {
+	return service.act("before_vm_snapshot", NewEventVirtualMachineSnapshotted(id, node, "revert", name), func() error {
+		return service.VirtualMachineRepository.RevertSnapshot(id, node, name)
+	})
+}
+
+func (service *VirtualMachineService) DeleteSnapshot(id, node, name string) error {
+	return service.act("before_vm_snapshot", NewEventVirtualMachineSnapshotted(id, node, "delete", name), func() error {
+		return service.VirtualMachineRepository.DeleteSnapshot(id, node, name)
+	})
+}
+
+// Shutdown asks guest to power off via ACPI and waits until it stops.
+// Machine is forced off if it is still active after shutdown timeout.
+func (service *VirtualMachineService) Shutdown(id, node string, progress *TaskProgress) error {
+	if err := publishBefore(service.epub, "before_vm_stop", NewEventVirtualMachineStopped(id, node, "shutdown")); err != nil {
+		return err
+	}
+	if err := service.VirtualMachineRepository.Shutdown(id, node); err != nil {
+		return util.NewError(err, "cannot request shutdown")
+	}
+	started := time.Now()
+	for time.Since(started) < service.shutdownTimeout {
+		vm, err := service.VirtualMachineRepository.Get(id, node)
+		if err != nil {
+			return util.NewError(err, "cannot get machine state")
+		}
+		if !vm.IsActive() {
+			return service.publish(NewEventVirtualMachineStopped(id, node, "shutdown"))
+		}
+		progress.Report(int(time.Since(started)*100/service.shutdownTimeout), "waiting for guest to shut down")
+		time.Sleep(shutdownPollInterval)
+	}
+	progress.Report(99, "guest didn't shut down in time, powering off")
+	if err := service.VirtualMachineRepository.Poweroff(id, node); err != nil {
+		return err
+	}
+	return service.publish(NewEventVirtualMachineStopped(id, node, "poweroff"))
 }
 
 func (service *VirtualMachineService) Action(id string, node, action string) error {
@@ -35,10 +267,20 @@ func (service *VirtualMachineService) Action(id string, node, action string) err
 	default:
 		return fmt.Errorf("unknown action %s", action)
 	case "reboot":
-		return service.VirtualMachineRepository.Reboot(id, node)
+		return service.Reboot(id, node)
 	case "poweroff":
-		return service.VirtualMachineRepository.Poweroff(id, node)
-	case "start":
-		return service.VirtualMachineRepository.Start(id, node)
+		return service.Poweroff(id, node)
+	case "start", "restore":
+		return service.Start(id, node)
+	case "shutdown":
+		return service.Shutdown(id, node, nil)
+	case "reset":
+		return service.Reset(id, node)
+	case "pause":
+		return service.Pause(id, node)
+	case "resume":
+		return service.Resume(id, node)
+	case "save":
+		return service.ManagedSave(id, node)
 	}
 }
@@ -0,0 +1,101 @@
+package compute
+
+import (
+	"reflect"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+)
+
+func TestVirtualMachineServiceAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		reject     map[string]bool
+		wantErr    error
+		wantCalls  []string
+		wantEvents []string
+	}{
+		{
+			name:       "start",
+			action:     "start",
+			wantCalls:  []string{"Start"},
+			wantEvents: []string{"before_vm_start", "vm_started"},
+		},
+		{
+			name:       "pause",
+			action:     "pause",
+			wantCalls:  []string{"Pause"},
+			wantEvents: []string{"before_vm_pause", "vm_paused"},
+		},
+		{
+			name:       "resume",
+			action:     "resume",
+			wantCalls:  []string{"Resume"},
+			wantEvents: []string{"before_vm_resume", "vm_resumed"},
+		},
+		{
+			name:       "pause rejected",
+			action:     "pause",
+			reject:     map[string]bool{"before_vm_pause": true},
+			wantErr:    ErrActionRejected,
+			wantEvents: []string{"before_vm_pause"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epub := &fakeEventPublisher{reject: tt.reject}
+			repo := &fakeVirtualMachineRepository{}
+			service := NewVirtualMachineService(repo, epub, time.Second)
+			err := service.Action("web1", "n1", tt.action)
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("Action() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(repo.calls, tt.wantCalls) {
+				t.Errorf("Action() calls = %v, want %v", repo.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(epub.published, tt.wantEvents) {
+				t.Errorf("Action() events = %v, want %v", epub.published, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineServiceRevertSnapshot(t *testing.T) {
+	tests := []struct {
+		name       string
+		reject     map[string]bool
+		wantErr    error
+		wantCalls  []string
+		wantEvents []string
+	}{
+		{
+			name:       "reverted",
+			wantCalls:  []string{"RevertSnapshot"},
+			wantEvents: []string{"before_vm_snapshot", "vm_snapshotted"},
+		},
+		{
+			name:       "rejected",
+			reject:     map[string]bool{"before_vm_snapshot": true},
+			wantErr:    ErrActionRejected,
+			wantEvents: []string{"before_vm_snapshot"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epub := &fakeEventPublisher{reject: tt.reject}
+			repo := &fakeVirtualMachineRepository{}
+			service := NewVirtualMachineService(repo, epub, time.Second)
+			err := service.RevertSnapshot("web1", "n1", "before-upgrade")
+			if util.ErrorCause(err) != tt.wantErr {
+				t.Errorf("RevertSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(repo.calls, tt.wantCalls) {
+				t.Errorf("RevertSnapshot() calls = %v, want %v", repo.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(epub.published, tt.wantEvents) {
+				t.Errorf("RevertSnapshot() events = %v, want %v", epub.published, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package compute
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// VirtualMachineSnapshot is a point-in-time state of machine.
+// Internal snapshots are kept inside qcow2 volumes and capture memory of
+// running machine, external (disk-only) ones switch disks to overlay files.
+// Volumes of external snapshot are overlays it created and the volumes
+// they are based on.
+type VirtualMachineSnapshot struct {
+	Name        string
+	Description string
+	Created     time.Time
+	State       string
+	Memory      bool
+	External    bool
+	Current     bool
+	Parent      string
+	Volumes     []string
+}
+
+type VirtualMachineSnapshotCreateParams struct {
+	Name        string
+	Description string
+	External    bool
+}
@@ -0,0 +1,30 @@
+package compute
+
+import "testing"
+
+func TestNewVirtualMachineState(t *testing.T) {
+	for _, state := range VirtualMachineStates {
+		t.Run(state.String(), func(t *testing.T) {
+			if got := NewVirtualMachineState(state.String()); got != state {
+				t.Errorf("NewVirtualMachineState() = %v, want %v", got, state)
+			}
+		})
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  VirtualMachineState
+	}{
+		{"shutting down", "shutting_down", StateShuttingDown},
+		{"blocked", "blocked", StateBlocked},
+		{"unknown", "hibernated", StateUnknown},
+		{"empty", "", StateUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewVirtualMachineState(tt.input); got != tt.want {
+				t.Errorf("NewVirtualMachineState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -22,6 +22,23 @@ type Volume struct {
 	AttachedTo string
 	AttachedAs DeviceType
 	Metadata   VolumeMetadata
+	Owner      string
+	Project    string
+}
+
+// VolumeOwnership is owner and project persisted for volume, it doesn't
+// depend on whether volume is attached to any machine.
+type VolumeOwnership struct {
+	NodeId  string
+	Path    string
+	Owner   string
+	Project string
+}
+
+// Owned reports whether access to volume is limited to its owner and
+// project members.
+func (volume *Volume) Owned() bool {
+	return volume.Owner != "" || volume.Project != "" || volume.AttachedTo != ""
 }
 
 func (volume *Volume) Base() string {
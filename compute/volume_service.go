@@ -3,6 +3,7 @@ package compute
 import (
 	"errors"
 	"io"
+	"subuk/vmango/util"
 )
 
 var ErrVolumeNotFound = errors.New("volume not found")
@@ -14,14 +15,18 @@ type VolumeCloneParams struct {
 	NewName      string
 	NewPool      string
 	NewSize      Size
+	Owner        string
+	Project      string
 }
 
 type VolumeCreateParams struct {
-	NodeId string
-	Name   string
-	Pool   string
-	Format VolumeFormat
-	Size   Size
+	NodeId  string
+	Name    string
+	Pool    string
+	Format  VolumeFormat
+	Size    Size
+	Owner   string
+	Project string
 }
 
 type VolumeListOptions struct {
@@ -39,10 +44,168 @@ type VolumeRepository interface {
 	List(options VolumeListOptions) ([]*Volume, error)
 }
 
+type VolumeOwnershipRepository interface {
+	List() ([]*VolumeOwnership, error)
+	Save(ownership *VolumeOwnership) error
+	Delete(path, node string) error
+}
+
 type VolumeService struct {
 	VolumeRepository
+	owners VolumeOwnershipRepository
+	epub   EventPublisher
+}
+
+func NewVolumeService(repo VolumeRepository, owners VolumeOwnershipRepository, epub EventPublisher) *VolumeService {
+	return &VolumeService{repo, owners, epub}
+}
+
+// fillOwnership sets persisted owner and project of volumes. Volumes
+// without persisted ownership keep owner and project of attached machine.
+func (service *VolumeService) fillOwnership(volumes []*Volume) error {
+	owners, err := service.owners.List()
+	if err != nil {
+		return util.NewError(err, "cannot list volume owners")
+	}
+	for _, ownership := range owners {
+		for _, volume := range volumes {
+			if volume.NodeId == ownership.NodeId && volume.Path == ownership.Path {
+				volume.Owner = ownership.Owner
+				volume.Project = ownership.Project
+			}
+		}
+	}
+	return nil
+}
+
+// saveOwnership persists owner and project of just created volume.
+func (service *VolumeService) saveOwnership(volume *Volume, owner, project string) error {
+	if owner == "" && project == "" {
+		return nil
+	}
+	ownership := &VolumeOwnership{NodeId: volume.NodeId, Path: volume.Path, Owner: owner, Project: project}
+	if err := service.owners.Save(ownership); err != nil {
+		return util.NewError(err, "cannot save volume owner")
+	}
+	volume.Owner = owner
+	volume.Project = project
+	return nil
+}
+
+func (service *VolumeService) Get(path, node string) (*Volume, error) {
+	volume, err := service.VolumeRepository.Get(path, node)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.fillOwnership([]*Volume{volume}); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+func (service *VolumeService) List(options VolumeListOptions) ([]*Volume, error) {
+	volumes, err := service.VolumeRepository.List(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.fillOwnership(volumes); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// undoCreate removes volume which creation is rejected by mandatory
+// subscriber, like machine creation is rolled back.
+func (service *VolumeService) undoCreate(volume *Volume, err error) error {
+	if deleteErr := service.VolumeRepository.Delete(volume.Path, volume.NodeId); deleteErr != nil {
+		return util.NewError(err, "volume %s is left, cannot delete it (%s)", volume.Path, deleteErr)
+	}
+	return err
+}
+
+func (service *VolumeService) Create(params VolumeCreateParams) (*Volume, error) {
+	requested := &Volume{NodeId: params.NodeId, Name: params.Name, Pool: params.Pool, Format: params.Format, Size: params.Size, Owner: params.Owner, Project: params.Project}
+	if err := publishBefore(service.epub, "before_volume_create", NewEventVolumeCreated(requested)); err != nil {
+		return nil, err
+	}
+	volume, err := service.VolumeRepository.Create(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.saveOwnership(volume, params.Owner, params.Project); err != nil {
+		return nil, service.undoCreate(volume, err)
+	}
+	if err := service.epub.Publish(NewEventVolumeCreated(volume)); err != nil {
+		return nil, service.undoCreate(volume, util.NewError(err, "cannot publish event volume created"))
+	}
+	return volume, nil
+}
+
+func (service *VolumeService) Clone(params VolumeCloneParams) (*Volume, error) {
+	requested := &Volume{NodeId: params.NodeId, Name: params.NewName, Pool: params.NewPool, Format: params.Format, Owner: params.Owner, Project: params.Project}
+	if params.NewSize.Value > 0 {
+		requested.Size = params.NewSize
+	}
+	if err := publishBefore(service.epub, "before_volume_clone", NewEventVolumeCloned(params.OriginalPath, requested)); err != nil {
+		return nil, err
+	}
+	volume, err := service.VolumeRepository.Clone(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.saveOwnership(volume, params.Owner, params.Project); err != nil {
+		return nil, service.undoCreate(volume, err)
+	}
+	if err := service.epub.Publish(NewEventVolumeCloned(params.OriginalPath, volume)); err != nil {
+		return nil, service.undoCreate(volume, util.NewError(err, "cannot publish event volume cloned"))
+	}
+	return volume, nil
+}
+
+func (service *VolumeService) Resize(path, node string, newSize Size) error {
+	event := NewEventVolumeResized(path, node, newSize)
+	if err := publishBefore(service.epub, "before_volume_resize", event); err != nil {
+		return err
+	}
+	if err := service.VolumeRepository.Resize(path, node, newSize); err != nil {
+		return err
+	}
+	if err := service.epub.Publish(event); err != nil {
+		return util.NewError(err, "cannot publish event volume resized")
+	}
+	return nil
+}
+
+func (service *VolumeService) Delete(path, node string) error {
+	volume, err := service.Get(path, node)
+	if err != nil {
+		return util.NewError(err, "cannot get volume")
+	}
+	if err := publishBefore(service.epub, "before_volume_delete", NewEventVolumeDeleted(volume)); err != nil {
+		return err
+	}
+	return service.delete(volume)
+}
+
+// remove deletes volume without asking before_volume_delete subscribers,
+// it is used to undo failed operations.
+func (service *VolumeService) remove(path, node string) error {
+	volume, err := service.Get(path, node)
+	if err != nil {
+		return util.NewError(err, "cannot get volume")
+	}
+	return service.delete(volume)
 }
 
-func NewVolumeService(repo VolumeRepository) *VolumeService {
-	return &VolumeService{repo}
+func (service *VolumeService) delete(volume *Volume) error {
+	if err := service.VolumeRepository.Delete(volume.Path, volume.NodeId); err != nil {
+		return err
+	}
+	if err := service.owners.Delete(volume.Path, volume.NodeId); err != nil {
+		return util.NewError(err, "cannot delete volume owner")
+	}
+	if err := service.epub.Publish(NewEventVolumeDeleted(volume)); err != nil {
+		return util.NewError(err, "cannot publish event volume deleted")
+	}
+	return nil
 }
@@ -0,0 +1,224 @@
+package compute
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type fakeVolumeRepository struct {
+	volumes []*Volume
+	deleted []string
+}
+
+func (repo *fakeVolumeRepository) Get(path, node string) (*Volume, error) {
+	for _, volume := range repo.volumes {
+		if volume.Path == path && volume.NodeId == node {
+			copied := *volume
+			return &copied, nil
+		}
+	}
+	return nil, ErrVolumeNotFound
+}
+
+func (repo *fakeVolumeRepository) Create(params VolumeCreateParams) (*Volume, error) {
+	volume := &Volume{NodeId: params.NodeId, Path: "/" + params.Pool + "/" + params.Name, Name: params.Name, Pool: params.Pool, Format: params.Format, Size: params.Size}
+	repo.volumes = append(repo.volumes, volume)
+	copied := *volume
+	return &copied, nil
+}
+
+func (repo *fakeVolumeRepository) Clone(params VolumeCloneParams) (*Volume, error) {
+	return repo.Create(VolumeCreateParams{NodeId: params.NodeId, Name: params.NewName, Pool: params.NewPool, Format: params.Format, Size: params.NewSize})
+}
+
+func (repo *fakeVolumeRepository) Resize(path, node string, newSize Size) error {
+	return nil
+}
+
+func (repo *fakeVolumeRepository) Delete(path, node string) error {
+	result := []*Volume{}
+	for _, volume := range repo.volumes {
+		if volume.Path != path || volume.NodeId != node {
+			result = append(result, volume)
+		}
+	}
+	repo.volumes = result
+	repo.deleted = append(repo.deleted, path)
+	return nil
+}
+
+func (repo *fakeVolumeRepository) Upload(path, nodeId string, content io.Reader, size uint64) error {
+	return nil
+}
+
+func (repo *fakeVolumeRepository) List(options VolumeListOptions) ([]*Volume, error) {
+	volumes := []*Volume{}
+	for _, volume := range repo.volumes {
+		copied := *volume
+		volumes = append(volumes, &copied)
+	}
+	return volumes, nil
+}
+
+type fakeVolumeOwnershipRepository struct {
+	owners  []*VolumeOwnership
+	saveErr error
+}
+
+func (repo *fakeVolumeOwnershipRepository) List() ([]*VolumeOwnership, error) {
+	return repo.owners, nil
+}
+
+func (repo *fakeVolumeOwnershipRepository) Save(ownership *VolumeOwnership) error {
+	if repo.saveErr != nil {
+		return repo.saveErr
+	}
+	repo.owners = append(repo.owners, ownership)
+	return nil
+}
+
+func (repo *fakeVolumeOwnershipRepository) Delete(path, node string) error {
+	result := []*VolumeOwnership{}
+	for _, ownership := range repo.owners {
+		if ownership.Path != path || ownership.NodeId != node {
+			result = append(result, ownership)
+		}
+	}
+	repo.owners = result
+	return nil
+}
+
+// fakeEventPublisher records names of published events, events listed
+// in reject are rejected with error.
+type fakeEventPublisher struct {
+	published []string
+	reject    map[string]bool
+}
+
+func (epub *fakeEventPublisher) Publish(event Event) error {
+	epub.published = append(epub.published, event.Name())
+	if epub.reject[event.Name()] {
+		return errors.New("rejected by test")
+	}
+	return nil
+}
+
+func TestVolumeServiceList(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []*Volume
+		owners  []*VolumeOwnership
+		want    map[string][2]string
+	}{
+		{
+			name:    "persisted ownership of detached volume",
+			volumes: []*Volume{{NodeId: "n1", Path: "/a"}},
+			owners:  []*VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice", Project: "web"}},
+			want:    map[string][2]string{"/a": {"alice", "web"}},
+		},
+		{
+			name:    "persisted ownership wins over attached machine",
+			volumes: []*Volume{{NodeId: "n1", Path: "/a", AttachedTo: "vm1", Owner: "bob"}},
+			owners:  []*VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}},
+			want:    map[string][2]string{"/a": {"alice", ""}},
+		},
+		{
+			name:    "attached machine ownership without persisted one",
+			volumes: []*Volume{{NodeId: "n1", Path: "/a", AttachedTo: "vm1", Owner: "bob", Project: "db"}},
+			want:    map[string][2]string{"/a": {"bob", "db"}},
+		},
+		{
+			name:    "same path on other node",
+			volumes: []*Volume{{NodeId: "n2", Path: "/a"}},
+			owners:  []*VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}},
+			want:    map[string][2]string{"/a": {"", ""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewVolumeService(&fakeVolumeRepository{volumes: tt.volumes}, &fakeVolumeOwnershipRepository{owners: tt.owners}, &fakeEventPublisher{})
+			volumes, err := service.List(VolumeListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			got := map[string][2]string{}
+			for _, volume := range volumes {
+				got[volume.Path] = [2]string{volume.Owner, volume.Project}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() ownership = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeServiceCreateOwnership(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     VolumeCreateParams
+		saveErr    error
+		wantErr    bool
+		wantOwners int
+		wantLeft   int
+	}{
+		{
+			name:       "owned volume",
+			params:     VolumeCreateParams{NodeId: "n1", Name: "disk", Pool: "default", Owner: "alice", Project: "web"},
+			wantOwners: 1,
+			wantLeft:   1,
+		},
+		{
+			name:     "not owned volume",
+			params:   VolumeCreateParams{NodeId: "n1", Name: "disk", Pool: "default"},
+			wantLeft: 1,
+		},
+		{
+			name:    "ownership cannot be saved",
+			params:  VolumeCreateParams{NodeId: "n1", Name: "disk", Pool: "default", Owner: "alice"},
+			saveErr: errors.New("disk full"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeVolumeRepository{}
+			owners := &fakeVolumeOwnershipRepository{saveErr: tt.saveErr}
+			service := NewVolumeService(repo, owners, &fakeEventPublisher{})
+			volume, err := service.Create(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (volume.Owner != tt.params.Owner || volume.Project != tt.params.Project) {
+				t.Errorf("Create() owner = %s/%s, want %s/%s", volume.Owner, volume.Project, tt.params.Owner, tt.params.Project)
+			}
+			if len(owners.owners) != tt.wantOwners {
+				t.Errorf("Create() saved %d owners, want %d", len(owners.owners), tt.wantOwners)
+			}
+			if len(repo.volumes) != tt.wantLeft {
+				t.Errorf("Create() left %d volumes, want %d", len(repo.volumes), tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestVolumeOwned(t *testing.T) {
+	tests := []struct {
+		name   string
+		volume *Volume
+		want   bool
+	}{
+		{"not owned", &Volume{}, false},
+		{"owner", &Volume{Owner: "alice"}, true},
+		{"project", &Volume{Project: "web"}, true},
+		{"attached", &Volume{AttachedTo: "vm1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.volume.Owned(); got != tt.want {
+				t.Errorf("Owned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"subuk/vmango/auth"
 	"subuk/vmango/configdrive"
 	"subuk/vmango/util"
 
@@ -10,11 +13,27 @@ import (
 	"github.com/imdario/mergo"
 )
 
+type ApiTokenWebConfig struct {
+	Name        string `hcl:",key"`
+	HashedToken string `hcl:"hashed_token"`
+}
+
+type GrantWebConfig struct {
+	Role       string   `hcl:",key"`
+	Nodes      []string `hcl:"nodes"`
+	VmPrefixes []string `hcl:"vm_prefixes"`
+}
+
 type UserWebConfig struct {
-	Id             string `hcl:",key"`
-	FullName       string `hcl:"full_name"`
-	Email          string `hcl:"email"`
-	HashedPassword string `hcl:"hashed_password"`
+	Id             string              `hcl:",key"`
+	FullName       string              `hcl:"full_name"`
+	Email          string              `hcl:"email"`
+	HashedPassword string              `hcl:"hashed_password"`
+	Role           string              `hcl:"role"`
+	Grants         []GrantWebConfig    `hcl:"grant"`
+	Projects       []string            `hcl:"projects"`
+	OidcId         string              `hcl:"oidc_id"`
+	ApiTokens      []ApiTokenWebConfig `hcl:"api_token"`
 }
 
 type WebConfigLink struct {
@@ -23,6 +42,27 @@ type WebConfigLink struct {
 	Url    string `hcl:"url"`
 }
 
+type OidcWebConfig struct {
+	Title                 string            `hcl:"title"`
+	Issuer                string            `hcl:"issuer"`
+	ClientId              string            `hcl:"client_id"`
+	ClientSecret          string            `hcl:"client_secret"`
+	RedirectUrl           string            `hcl:"redirect_url"`
+	PostLogoutRedirectUrl string            `hcl:"post_logout_redirect_url"`
+	Scopes                []string          `hcl:"scopes"`
+	IdClaim               string            `hcl:"id_claim"`
+	FullNameClaim         string            `hcl:"full_name_claim"`
+	EmailClaim            string            `hcl:"email_claim"`
+	GroupsClaim           string            `hcl:"groups_claim"`
+	DefaultRole           string            `hcl:"default_role"`
+	DisablePasswordLogin  bool              `hcl:"disable_password_login"`
+	Groups                []AuthGroupConfig `hcl:"group"`
+}
+
+func (oidc OidcWebConfig) Enabled() bool {
+	return oidc.Issuer != ""
+}
+
 type WebConfig struct {
 	Listen         string          `hcl:"listen"`
 	Debug          bool            `hcl:"debug"`
@@ -35,6 +75,7 @@ type WebConfig struct {
 	Users          []UserWebConfig `hcl:"user"`
 	Links          []WebConfigLink `hcl:"link"`
 	LinksTitle     string          `hcl:"links_title"`
+	Oidc           OidcWebConfig   `hcl:"oidc"`
 }
 
 type ImageConfig struct {
@@ -49,26 +90,82 @@ type ImageConfig struct {
 type SubscribeConfig struct {
 	Event     string `hcl:",key"`
 	Script    string `hcl:"script"`
+	Url       string `hcl:"url"`
+	Secret    string `hcl:"secret"`
+	Attempts  int    `hcl:"attempts"`
+	Timeout   int    `hcl:"timeout"`
 	Mandatory bool   `hcl:"mandatory"`
 }
 
+type ConsoleRecordingConfig struct {
+	Disabled   bool   `hcl:"disabled"`
+	Dir        string `hcl:"dir"`
+	MaxAge     int    `hcl:"max_age"`
+	MaxSizeMib int    `hcl:"max_size_mib"`
+}
+
+type QuotaConfig struct {
+	Name     string `hcl:",key"`
+	Vcpus    int    `hcl:"vcpus"`
+	MemoryMb uint64 `hcl:"memory_mb"`
+	DiskGb   uint64 `hcl:"disk_gb"`
+	Vms      int    `hcl:"vms"`
+}
+
+type AuthGroupConfig struct {
+	Name       string   `hcl:",key"`
+	Role       string   `hcl:"role"`
+	Nodes      []string `hcl:"nodes"`
+	VmPrefixes []string `hcl:"vm_prefixes"`
+	Projects   []string `hcl:"projects"`
+}
+
+type AuthConfig struct {
+	Type               string            `hcl:",key"`
+	Url                string            `hcl:"url"`
+	StartTLS           bool              `hcl:"start_tls"`
+	InsecureSkipVerify bool              `hcl:"insecure_skip_verify"`
+	BindDn             string            `hcl:"bind_dn"`
+	BindPassword       string            `hcl:"bind_password"`
+	BaseDn             string            `hcl:"base_dn"`
+	UserFilter         string            `hcl:"user_filter"`
+	IdAttribute        string            `hcl:"id_attribute"`
+	FullNameAttribute  string            `hcl:"full_name_attribute"`
+	EmailAttribute     string            `hcl:"email_attribute"`
+	GroupAttribute     string            `hcl:"group_attribute"`
+	Groups             []AuthGroupConfig `hcl:"group"`
+}
+
 type LibvirtConfig struct {
-	Name                   string `hcl:",key"`
-	Uri                    string `hcl:"uri"`
-	ConfigDriveSuffix      string `hcl:"config_drive_suffix"`
-	ConfigDrivePool        string `hcl:"config_drive_pool"`
-	ConfigDriveWriteFormat string `hcl:"config_drive_write_format"`
-	Cache                  bool   `hcl:"cache"`
+	Name                   string   `hcl:",key"`
+	Uri                    string   `hcl:"uri"`
+	ConfigDriveSuffix      string   `hcl:"config_drive_suffix"`
+	ConfigDrivePool        string   `hcl:"config_drive_pool"`
+	ConfigDriveWriteFormat string   `hcl:"config_drive_write_format"`
+	Cache                  bool     `hcl:"cache"`
+	SshKeys                []string `hcl:"ssh_keys"`
+	SshKnownHosts          string   `hcl:"ssh_known_hosts"`
 }
 
 type Config struct {
-	LogLevel   string            `hcl:"log_level"`
-	Images     []ImageConfig     `hcl:"image"`
-	Bridges    []string          `hcl:"bridges"`
-	Libvirts   []LibvirtConfig   `hcl:"libvirt"`
-	KeyFile    string            `hcl:"key_file"`
-	Web        WebConfig         `hcl:"web"`
-	Subscribes []SubscribeConfig `hcl:"subscribe"`
+	LogLevel         string                 `hcl:"log_level"`
+	Images           []ImageConfig          `hcl:"image"`
+	Bridges          []string               `hcl:"bridges"`
+	Libvirts         []LibvirtConfig        `hcl:"libvirt"`
+	KeyFile          string                 `hcl:"key_file"`
+	ApiTokenFile     string                 `hcl:"api_token_file"`
+	AuditFile        string                 `hcl:"audit_file"`
+	VolumeOwnerFile  string                 `hcl:"volume_owner_file"`
+	EventOutboxDir   string                 `hcl:"event_outbox_dir"`
+	TaskWorkers      int                    `hcl:"task_workers"`
+	TaskHistory      int                    `hcl:"task_history"`
+	ShutdownTimeout  int                    `hcl:"shutdown_timeout"`
+	ConsoleRecording ConsoleRecordingConfig `hcl:"console_recording"`
+	Web              WebConfig              `hcl:"web"`
+	Subscribes       []SubscribeConfig      `hcl:"subscribe"`
+	Auths            []AuthConfig           `hcl:"auth"`
+	UserQuotas       []QuotaConfig          `hcl:"user_quota"`
+	ProjectQuotas    []QuotaConfig          `hcl:"project_quota"`
 
 	LegacyLibvirtUri                    string   `hcl:"libvirt_uri"`
 	LegacyLibvirtConfigDriveSuffix      string   `hcl:"libvirt_config_drive_suffix"`
@@ -79,8 +176,20 @@ type Config struct {
 
 func Default() *Config {
 	return &Config{
-		LogLevel: "info",
-		KeyFile:  "~/.vmango/authorized_keys",
+		LogLevel:        "info",
+		KeyFile:         "~/.vmango/authorized_keys",
+		ApiTokenFile:    "~/.vmango/api_tokens.json",
+		AuditFile:       "~/.vmango/audit.log",
+		VolumeOwnerFile: "~/.vmango/volume_owners.json",
+		EventOutboxDir:  "~/.vmango/outbox",
+		TaskWorkers:     4,
+		TaskHistory:     1000,
+		ShutdownTimeout: 60,
+		ConsoleRecording: ConsoleRecordingConfig{
+			Dir:        "~/.vmango/recordings",
+			MaxAge:     30,
+			MaxSizeMib: 1024,
+		},
 		Web: WebConfig{
 			Listen:         ":8080",
 			Debug:          false,
@@ -127,6 +236,119 @@ func Parse(filename string) (*Config, error) {
 		fmt.Println(OLD_BRIDGES_WARNING)
 	}
 
+	for _, user := range config.Web.Users {
+		if user.Role != "" && auth.NewRole(user.Role) == auth.RoleUnknown {
+			return nil, fmt.Errorf("unknown role '%s' for user '%s'", user.Role, user.Id)
+		}
+		for _, grant := range user.Grants {
+			if auth.NewRole(grant.Role) == auth.RoleUnknown {
+				return nil, fmt.Errorf("unknown grant role '%s' for user '%s'", grant.Role, user.Id)
+			}
+		}
+		for _, token := range user.ApiTokens {
+			if _, err := hex.DecodeString(token.HashedToken); err != nil || len(token.HashedToken) != 64 {
+				return nil, fmt.Errorf("invalid hashed_token for api token '%s' of user '%s', generate new one with `vmango gentoken`", token.Name, user.Id)
+			}
+		}
+	}
+
+	for idx := range config.Libvirts {
+		if config.Libvirts[idx].SshKnownHosts == "" {
+			config.Libvirts[idx].SshKnownHosts = "~/.ssh/known_hosts"
+		}
+	}
+
+	for idx := range config.Subscribes {
+		sub := &config.Subscribes[idx]
+		if (sub.Script == "") == (sub.Url == "") {
+			return nil, fmt.Errorf("subscribe '%s' must have either script or url", sub.Event)
+		}
+		if sub.Url != "" {
+			if parsed, err := url.Parse(sub.Url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return nil, fmt.Errorf("invalid url '%s' for subscribe '%s'", sub.Url, sub.Event)
+			}
+		}
+		if sub.Attempts == 0 && sub.Url != "" {
+			sub.Attempts = 4
+		}
+		if sub.Attempts == 0 {
+			sub.Attempts = 1
+		}
+		if sub.Timeout == 0 {
+			sub.Timeout = 10
+		}
+	}
+
+	if oidc := &config.Web.Oidc; oidc.Enabled() {
+		if oidc.ClientId == "" || oidc.RedirectUrl == "" {
+			return nil, fmt.Errorf("client_id and redirect_url are required for oidc")
+		}
+		if oidc.Title == "" {
+			oidc.Title = "SSO"
+		}
+		if len(oidc.Scopes) == 0 {
+			oidc.Scopes = []string{"profile", "email"}
+		}
+		if oidc.IdClaim == "" {
+			oidc.IdClaim = "sub"
+		}
+		if oidc.FullNameClaim == "" {
+			oidc.FullNameClaim = "name"
+		}
+		if oidc.EmailClaim == "" {
+			oidc.EmailClaim = "email"
+		}
+		if oidc.GroupsClaim == "" {
+			oidc.GroupsClaim = "groups"
+		}
+		if oidc.DefaultRole != "" && auth.NewRole(oidc.DefaultRole) == auth.RoleUnknown {
+			return nil, fmt.Errorf("unknown oidc default_role '%s'", oidc.DefaultRole)
+		}
+		for _, group := range oidc.Groups {
+			if auth.NewRole(group.Role) == auth.RoleUnknown {
+				return nil, fmt.Errorf("unknown role '%s' for oidc group '%s'", group.Role, group.Name)
+			}
+		}
+	}
+
+	for index := range config.Auths {
+		authConfig := &config.Auths[index]
+		switch authConfig.Type {
+		default:
+			return nil, fmt.Errorf("unknown auth type '%s'", authConfig.Type)
+		case "ldap":
+			if authConfig.Url == "" || authConfig.BaseDn == "" {
+				return nil, fmt.Errorf("url and base_dn are required for ldap auth")
+			}
+			if authConfig.UserFilter == "" {
+				authConfig.UserFilter = "(uid=%s)"
+			}
+			if authConfig.IdAttribute == "" {
+				authConfig.IdAttribute = "uid"
+			}
+			if authConfig.FullNameAttribute == "" {
+				authConfig.FullNameAttribute = "cn"
+			}
+			if authConfig.EmailAttribute == "" {
+				authConfig.EmailAttribute = "mail"
+			}
+			if authConfig.GroupAttribute == "" {
+				authConfig.GroupAttribute = "memberOf"
+			}
+		}
+		for _, group := range authConfig.Groups {
+			if auth.NewRole(group.Role) == auth.RoleUnknown {
+				return nil, fmt.Errorf("unknown role '%s' for %s group '%s'", group.Role, authConfig.Type, group.Name)
+			}
+		}
+	}
+
+	for _, quota := range append(config.UserQuotas, config.ProjectQuotas...) {
+		if quota.Vcpus < 0 || quota.Vms < 0 {
+			return nil, fmt.Errorf("negative limit in quota '%s'", quota.Name)
+		}
+	}
+
 	libvirt_ids := map[string]struct{}{}
 	for index := range config.Libvirts {
 		libvirt := &config.Libvirts[index]
@@ -0,0 +1,97 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"subuk/vmango/auth"
+	"subuk/vmango/util"
+	"sync"
+)
+
+type ApiTokenRepository struct {
+	filename string
+	lock     sync.Mutex
+}
+
+func NewApiTokenRepository(filename string) (*ApiTokenRepository, error) {
+	dirname := filepath.Dir(filename)
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filename, []byte("[]\n"), 0600); err != nil {
+			return nil, util.NewError(err, "api_token_file doesn't exist and cannot be created")
+		}
+	}
+	return &ApiTokenRepository{filename: filename}, nil
+}
+
+func (repo *ApiTokenRepository) load() ([]*auth.ApiToken, error) {
+	content, err := ioutil.ReadFile(repo.filename)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read token file")
+	}
+	tokens := []*auth.ApiToken{}
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, util.NewError(err, "cannot parse token file")
+	}
+	return tokens, nil
+}
+
+func (repo *ApiTokenRepository) store(tokens []*auth.ApiToken) error {
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize tokens")
+	}
+	tmpFilename := repo.filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, append(content, '\n'), 0600); err != nil {
+		return util.NewError(err, "cannot write token file")
+	}
+	if err := os.Rename(tmpFilename, repo.filename); err != nil {
+		return util.NewError(err, "cannot replace token file")
+	}
+	return nil
+}
+
+func (repo *ApiTokenRepository) List() ([]*auth.ApiToken, error) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	return repo.load()
+}
+
+func (repo *ApiTokenRepository) Save(token *auth.ApiToken) error {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	tokens, err := repo.load()
+	if err != nil {
+		return err
+	}
+	for index, existing := range tokens {
+		if existing.Id == token.Id {
+			tokens[index] = token
+			return repo.store(tokens)
+		}
+	}
+	return repo.store(append(tokens, token))
+}
+
+func (repo *ApiTokenRepository) Delete(id string) error {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	tokens, err := repo.load()
+	if err != nil {
+		return err
+	}
+	result := []*auth.ApiToken{}
+	for _, token := range tokens {
+		if token.Id != id {
+			result = append(result, token)
+		}
+	}
+	if len(result) == len(tokens) {
+		return auth.ErrApiTokenNotFound
+	}
+	return repo.store(result)
+}
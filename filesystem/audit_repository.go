@@ -0,0 +1,80 @@
+package filesystem
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"subuk/vmango/audit"
+	"subuk/vmango/util"
+	"sync"
+)
+
+// AuditRepository keeps audit entries in append-only file, one json document per line.
+type AuditRepository struct {
+	filename string
+	lock     sync.Mutex
+}
+
+func NewAuditRepository(filename string) (*AuditRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, util.NewError(err, "audit_file cannot be opened for writing")
+	}
+	file.Close()
+	return &AuditRepository{filename: filename}, nil
+}
+
+func (repo *AuditRepository) Append(entry *audit.Entry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return util.NewError(err, "cannot serialize audit entry")
+	}
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	file, err := os.OpenFile(repo.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return util.NewError(err, "cannot open audit file")
+	}
+	defer file.Close()
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		return util.NewError(err, "cannot write audit entry")
+	}
+	return file.Sync()
+}
+
+func (repo *AuditRepository) List(options audit.EntryListOptions) ([]*audit.Entry, error) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	file, err := os.Open(repo.filename)
+	if err != nil {
+		return nil, util.NewError(err, "cannot open audit file")
+	}
+	defer file.Close()
+
+	entries := []*audit.Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		entry := &audit.Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			continue
+		}
+		if options.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.NewError(err, "cannot read audit file")
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if options.Limit > 0 && len(entries) > options.Limit {
+		entries = entries[:options.Limit]
+	}
+	return entries, nil
+}
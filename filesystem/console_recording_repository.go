@@ -0,0 +1,192 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+)
+
+type consoleRecordingFile struct {
+	Id        string    `json:"id"`
+	VmId      string    `json:"vm"`
+	NodeId    string    `json:"node"`
+	User      string    `json:"user"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// ConsoleRecordingRepository keeps every recording as <id>.cast file
+// with asciicast content and <id>.json file with metadata.
+type ConsoleRecordingRepository struct {
+	dirname string
+}
+
+func NewConsoleRecordingRepository(dirname string) (*ConsoleRecordingRepository, error) {
+	if err := os.MkdirAll(dirname, 0700); err != nil {
+		return nil, util.NewError(err, "cannot create recordings directory")
+	}
+	return &ConsoleRecordingRepository{dirname: dirname}, nil
+}
+
+func (repo *ConsoleRecordingRepository) filename(id, ext string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", compute.ErrConsoleRecordingNotFound
+	}
+	return filepath.Join(repo.dirname, id+ext), nil
+}
+
+func (repo *ConsoleRecordingRepository) List(options compute.ConsoleRecordingListOptions) ([]*compute.ConsoleRecording, error) {
+	entries, err := ioutil.ReadDir(repo.dirname)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read recordings directory")
+	}
+	recordings := []*compute.ConsoleRecording{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		recording, err := repo.Get(strings.TrimSuffix(entry.Name(), ".json"))
+		if err == compute.ErrConsoleRecordingNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if options.Match(recording) {
+			recordings = append(recordings, recording)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Started.After(recordings[j].Started)
+	})
+	return recordings, nil
+}
+
+func (repo *ConsoleRecordingRepository) Get(id string) (*compute.ConsoleRecording, error) {
+	filename, err := repo.filename(id, ".json")
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, compute.ErrConsoleRecordingNotFound
+		}
+		return nil, util.NewError(err, "cannot read recording metadata")
+	}
+	file := consoleRecordingFile{}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, util.NewError(err, "cannot parse recording metadata %s", filename)
+	}
+	recording := &compute.ConsoleRecording{
+		Id:        file.Id,
+		VmId:      file.VmId,
+		NodeId:    file.NodeId,
+		User:      file.User,
+		Width:     file.Width,
+		Height:    file.Height,
+		Started:   file.Started,
+		Finished:  file.Finished,
+		Truncated: file.Truncated,
+	}
+	if info, err := os.Stat(filepath.Join(repo.dirname, id+".cast")); err == nil {
+		recording.Size = uint64(info.Size())
+	}
+	return recording, nil
+}
+
+func (repo *ConsoleRecordingRepository) Create(recording *compute.ConsoleRecording) (io.WriteCloser, error) {
+	filename, err := repo.filename(recording.Id, ".cast")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create recording file")
+	}
+	if err := repo.Save(recording); err != nil {
+		file.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	return file, nil
+}
+
+func (repo *ConsoleRecordingRepository) Save(recording *compute.ConsoleRecording) error {
+	filename, err := repo.filename(recording.Id, ".json")
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(consoleRecordingFile{
+		Id:        recording.Id,
+		VmId:      recording.VmId,
+		NodeId:    recording.NodeId,
+		User:      recording.User,
+		Width:     recording.Width,
+		Height:    recording.Height,
+		Started:   recording.Started,
+		Finished:  recording.Finished,
+		Truncated: recording.Truncated,
+	}, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize recording metadata")
+	}
+	tmpFile, err := ioutil.TempFile(repo.dirname, ".tmp-")
+	if err != nil {
+		return util.NewError(err, "cannot create recording metadata file")
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(append(content, '\n')); err != nil {
+		tmpFile.Close()
+		return util.NewError(err, "cannot write recording metadata")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return util.NewError(err, "cannot close recording metadata file")
+	}
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		return util.NewError(err, "cannot replace recording metadata file")
+	}
+	return nil
+}
+
+func (repo *ConsoleRecordingRepository) Open(id string) (io.ReadCloser, error) {
+	filename, err := repo.filename(id, ".cast")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, compute.ErrConsoleRecordingNotFound
+		}
+		return nil, util.NewError(err, "cannot open recording")
+	}
+	return file, nil
+}
+
+func (repo *ConsoleRecordingRepository) Delete(id string) error {
+	metaFilename, err := repo.filename(id, ".json")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(metaFilename); err != nil {
+		if os.IsNotExist(err) {
+			return compute.ErrConsoleRecordingNotFound
+		}
+		return util.NewError(err, "cannot delete recording metadata")
+	}
+	if err := os.Remove(filepath.Join(repo.dirname, id+".cast")); err != nil && !os.IsNotExist(err) {
+		return util.NewError(err, "cannot delete recording")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+)
+
+type eventDeliveryFile struct {
+	Id          string            `json:"id"`
+	Event       string            `json:"event"`
+	Subscriber  string            `json:"subscriber"`
+	Data        map[string]string `json:"data"`
+	State       string            `json:"state"`
+	Attempts    int               `json:"attempts"`
+	LastError   string            `json:"last_error,omitempty"`
+	Created     time.Time         `json:"created"`
+	LastAttempt time.Time         `json:"last_attempt"`
+	NextAttempt time.Time         `json:"next_attempt"`
+}
+
+// EventDeliveryRepository keeps every delivery in its own json file,
+// files are replaced atomically so a crash never leaves partial delivery.
+type EventDeliveryRepository struct {
+	dirname string
+}
+
+func NewEventDeliveryRepository(dirname string) (*EventDeliveryRepository, error) {
+	if err := os.MkdirAll(dirname, 0700); err != nil {
+		return nil, util.NewError(err, "cannot create outbox directory")
+	}
+	return &EventDeliveryRepository{dirname: dirname}, nil
+}
+
+func (repo *EventDeliveryRepository) filename(id string) string {
+	return filepath.Join(repo.dirname, id+".json")
+}
+
+func (repo *EventDeliveryRepository) load(filename string) (*compute.EventDelivery, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, compute.ErrEventDeliveryNotFound
+		}
+		return nil, util.NewError(err, "cannot read delivery file")
+	}
+	file := eventDeliveryFile{}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, util.NewError(err, "cannot parse delivery file %s", filename)
+	}
+	return &compute.EventDelivery{
+		Id:          file.Id,
+		Event:       file.Event,
+		Subscriber:  file.Subscriber,
+		Data:        file.Data,
+		State:       compute.NewEventDeliveryState(file.State),
+		Attempts:    file.Attempts,
+		LastError:   file.LastError,
+		Created:     file.Created,
+		LastAttempt: file.LastAttempt,
+		NextAttempt: file.NextAttempt,
+	}, nil
+}
+
+func (repo *EventDeliveryRepository) List(options compute.EventDeliveryListOptions) ([]*compute.EventDelivery, error) {
+	entries, err := ioutil.ReadDir(repo.dirname)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read outbox directory")
+	}
+	deliveries := []*compute.EventDelivery{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		delivery, err := repo.load(filepath.Join(repo.dirname, entry.Name()))
+		if err == compute.ErrEventDeliveryNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if options.Match(delivery) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.Before(deliveries[j].Created)
+	})
+	return deliveries, nil
+}
+
+func (repo *EventDeliveryRepository) Get(id string) (*compute.EventDelivery, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, compute.ErrEventDeliveryNotFound
+	}
+	return repo.load(repo.filename(id))
+}
+
+func (repo *EventDeliveryRepository) Save(delivery *compute.EventDelivery) error {
+	content, err := json.MarshalIndent(eventDeliveryFile{
+		Id:          delivery.Id,
+		Event:       delivery.Event,
+		Subscriber:  delivery.Subscriber,
+		Data:        delivery.Data,
+		State:       delivery.State.String(),
+		Attempts:    delivery.Attempts,
+		LastError:   delivery.LastError,
+		Created:     delivery.Created,
+		LastAttempt: delivery.LastAttempt,
+		NextAttempt: delivery.NextAttempt,
+	}, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize delivery")
+	}
+	tmpFile, err := ioutil.TempFile(repo.dirname, ".tmp-")
+	if err != nil {
+		return util.NewError(err, "cannot create delivery file")
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(append(content, '\n')); err != nil {
+		tmpFile.Close()
+		return util.NewError(err, "cannot write delivery file")
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return util.NewError(err, "cannot sync delivery file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return util.NewError(err, "cannot close delivery file")
+	}
+	if err := os.Rename(tmpFile.Name(), repo.filename(delivery.Id)); err != nil {
+		return util.NewError(err, "cannot replace delivery file")
+	}
+	return nil
+}
+
+func (repo *EventDeliveryRepository) Delete(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return compute.ErrEventDeliveryNotFound
+	}
+	if err := os.Remove(repo.filename(id)); err != nil {
+		if os.IsNotExist(err) {
+			return compute.ErrEventDeliveryNotFound
+		}
+		return util.NewError(err, "cannot delete delivery file")
+	}
+	return nil
+}
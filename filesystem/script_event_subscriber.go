@@ -0,0 +1,79 @@
+package filesystem
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/rs/zerolog"
+)
+
+// ScriptEventSubscriber runs shell script for every event, event values
+// are passed in VMANGO_* environment variables.
+type ScriptEventSubscriber struct {
+	event     string
+	script    string
+	mandatory bool
+	attempts  int
+	logger    zerolog.Logger
+}
+
+func NewScriptEventSubscriber(event, script string, mandatory bool, attempts int, logger zerolog.Logger) *ScriptEventSubscriber {
+	return &ScriptEventSubscriber{
+		event:     event,
+		script:    script,
+		mandatory: mandatory,
+		attempts:  attempts,
+		logger:    logger,
+	}
+}
+
+func (sub *ScriptEventSubscriber) Name() string {
+	return "script " + sub.script
+}
+
+func (sub *ScriptEventSubscriber) Event() string {
+	return sub.event
+}
+
+func (sub *ScriptEventSubscriber) Mandatory() bool {
+	return sub.mandatory
+}
+
+func (sub *ScriptEventSubscriber) Attempts() int {
+	return sub.attempts
+}
+
+func (sub *ScriptEventSubscriber) Deliver(delivery *compute.EventDelivery) error {
+	cmd := exec.Command("sh", "-c", sub.script)
+	env := os.Environ()
+	for key, value := range delivery.Data {
+		env = append(env, "VMANGO_"+strings.ToUpper(key)+"="+value)
+	}
+	cmd.Env = env
+	sub.logger.Info().
+		Str("script", sub.script).
+		Str("event", delivery.Event).
+		Str("delivery", delivery.Id).
+		Int("attempt", delivery.Attempts).
+		Msg("running script")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		sub.logger.Warn().Err(err).
+			Str("out", string(out)).
+			Str("script", sub.script).
+			Str("event", delivery.Event).
+			Str("delivery", delivery.Id).
+			Msg("script failed")
+		// Script exit status of before event is its answer, running it
+		// again would only delay the rejected action.
+		if _, exited := err.(*exec.ExitError); exited && strings.HasPrefix(delivery.Event, "before_") {
+			return util.NewError(compute.ErrEventRejected, "script rejected event: %s", strings.TrimSpace(string(out)))
+		}
+		return util.NewError(err, "script failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"sync"
+)
+
+type volumeOwnershipRecord struct {
+	NodeId  string `json:"node"`
+	Path    string `json:"path"`
+	Owner   string `json:"owner,omitempty"`
+	Project string `json:"project,omitempty"`
+}
+
+type VolumeOwnershipRepository struct {
+	filename string
+	lock     sync.Mutex
+}
+
+func NewVolumeOwnershipRepository(filename string) (*VolumeOwnershipRepository, error) {
+	dirname := filepath.Dir(filename)
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filename, []byte("[]\n"), 0644); err != nil {
+			return nil, util.NewError(err, "volume_owner_file doesn't exist and cannot be created")
+		}
+	}
+	return &VolumeOwnershipRepository{filename: filename}, nil
+}
+
+func (repo *VolumeOwnershipRepository) load() ([]*volumeOwnershipRecord, error) {
+	content, err := ioutil.ReadFile(repo.filename)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read volume owner file")
+	}
+	records := []*volumeOwnershipRecord{}
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, util.NewError(err, "cannot parse volume owner file")
+	}
+	return records, nil
+}
+
+func (repo *VolumeOwnershipRepository) store(records []*volumeOwnershipRecord) error {
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize volume owners")
+	}
+	tmpFilename := repo.filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, append(content, '\n'), 0644); err != nil {
+		return util.NewError(err, "cannot write volume owner file")
+	}
+	if err := os.Rename(tmpFilename, repo.filename); err != nil {
+		return util.NewError(err, "cannot replace volume owner file")
+	}
+	return nil
+}
+
+func (repo *VolumeOwnershipRepository) List() ([]*compute.VolumeOwnership, error) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	records, err := repo.load()
+	if err != nil {
+		return nil, err
+	}
+	owners := []*compute.VolumeOwnership{}
+	for _, record := range records {
+		owners = append(owners, &compute.VolumeOwnership{
+			NodeId:  record.NodeId,
+			Path:    record.Path,
+			Owner:   record.Owner,
+			Project: record.Project,
+		})
+	}
+	return owners, nil
+}
+
+func (repo *VolumeOwnershipRepository) Save(ownership *compute.VolumeOwnership) error {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	records, err := repo.load()
+	if err != nil {
+		return err
+	}
+	record := &volumeOwnershipRecord{
+		NodeId:  ownership.NodeId,
+		Path:    ownership.Path,
+		Owner:   ownership.Owner,
+		Project: ownership.Project,
+	}
+	for index, existing := range records {
+		if existing.NodeId == record.NodeId && existing.Path == record.Path {
+			records[index] = record
+			return repo.store(records)
+		}
+	}
+	return repo.store(append(records, record))
+}
+
+// Delete removes ownership of volume, it is not an error if volume
+// has no persisted ownership.
+func (repo *VolumeOwnershipRepository) Delete(path, node string) error {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	records, err := repo.load()
+	if err != nil {
+		return err
+	}
+	result := []*volumeOwnershipRecord{}
+	for _, record := range records {
+		if record.NodeId != node || record.Path != path {
+			result = append(result, record)
+		}
+	}
+	if len(result) == len(records) {
+		return nil
+	}
+	return repo.store(result)
+}
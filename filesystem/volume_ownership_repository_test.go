@@ -0,0 +1,72 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"subuk/vmango/compute"
+	"testing"
+)
+
+func TestVolumeOwnershipRepository(t *testing.T) {
+	tests := []struct {
+		name   string
+		save   []*compute.VolumeOwnership
+		delete [][2]string
+		want   []*compute.VolumeOwnership
+	}{
+		{
+			name: "save",
+			save: []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}, {NodeId: "n2", Path: "/a", Project: "web"}},
+			want: []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}, {NodeId: "n2", Path: "/a", Project: "web"}},
+		},
+		{
+			name: "save replaces",
+			save: []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}, {NodeId: "n1", Path: "/a", Owner: "bob"}},
+			want: []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "bob"}},
+		},
+		{
+			name:   "delete",
+			save:   []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}, {NodeId: "n2", Path: "/a", Owner: "bob"}},
+			delete: [][2]string{{"/a", "n1"}},
+			want:   []*compute.VolumeOwnership{{NodeId: "n2", Path: "/a", Owner: "bob"}},
+		},
+		{
+			name:   "delete missing",
+			save:   []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}},
+			delete: [][2]string{{"/b", "n1"}},
+			want:   []*compute.VolumeOwnership{{NodeId: "n1", Path: "/a", Owner: "alice"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirname, err := ioutil.TempDir("", "vmango-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dirname)
+			repo, err := NewVolumeOwnershipRepository(filepath.Join(dirname, "volume_owners.json"))
+			if err != nil {
+				t.Fatalf("NewVolumeOwnershipRepository() error = %v", err)
+			}
+			for _, ownership := range tt.save {
+				if err := repo.Save(ownership); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			for _, args := range tt.delete {
+				if err := repo.Delete(args[0], args[1]); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+			}
+			got, err := repo.List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
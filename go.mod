@@ -4,8 +4,10 @@ go 1.12
 
 require (
 	github.com/akamensky/argparse v0.0.0-20190829110830-5293d9863374
+	github.com/coreos/go-oidc v2.2.1+incompatible
 	github.com/dustin/go-humanize v1.0.0
 	github.com/go-bindata/go-bindata v3.1.2+incompatible
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/csrf v1.6.0
 	github.com/gorilla/mux v1.7.3
@@ -15,8 +17,11 @@ require (
 	github.com/imdario/mergo v0.3.7
 	github.com/libvirt/libvirt-go v6.1.0+incompatible
 	github.com/libvirt/libvirt-go-xml v5.6.0+incompatible
+	github.com/pquerna/cachecontrol v0.2.0 // indirect
 	github.com/rs/zerolog v1.15.0
 	github.com/unrolled/render v1.0.1
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
 	gopkg.in/yaml.v2 v2.2.2
 )
@@ -1,15 +1,30 @@
 package libvirt
 
 import (
+	"fmt"
 	"subuk/vmango/compute"
 	"subuk/vmango/util"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	libvirt "github.com/libvirt/libvirt-go"
 )
 
+const (
+	eventKeepAliveInterval = 5
+	eventKeepAliveCount    = 3
+	eventReconnectInterval = 10 * time.Second
+)
+
+// libvirt event implementation is process wide and must be registered
+// before any connection is opened.
+var eventLoopOnce = &sync.Once{}
+
+// DomainLifecycleHandler is called from libvirt event loop and must not block.
+type DomainLifecycleHandler func(node, domain string, event *libvirt.DomainEventLifecycle)
+
 type connection struct {
 	Conn *libvirt.Connect
 	Mu   *sync.Mutex
@@ -108,3 +123,89 @@ func (p *ConnectionPool) Release(node string) {
 	uri := p.nodeUri[node]
 	p.cache[uri].Mu.Unlock()
 }
+
+// Open establishes new connection bypassing the cache, it must be closed by caller.
+// It is intended for long operations which shouldn't block pooled connection.
+func (p *ConnectionPool) Open(node string) (*libvirt.Connect, error) {
+	uri, nodeExists := p.nodeUri[node]
+	if !nodeExists {
+		return nil, compute.ErrUnknownNode
+	}
+	conn, err := libvirt.NewConnect(uri)
+	if err != nil {
+		return nil, util.NewError(err, "cannot open libvirt connection")
+	}
+	return conn, nil
+}
+
+// WatchDomainLifecycle starts libvirt event loop and calls handler for
+// every domain lifecycle event on all nodes. Each node is watched with a
+// dedicated connection, which is reopened when lost.
+func (p *ConnectionPool) WatchDomainLifecycle(handler DomainLifecycleHandler) error {
+	var loopErr error
+	eventLoopOnce.Do(func() {
+		if err := libvirt.EventRegisterDefaultImpl(); err != nil {
+			loopErr = util.NewError(err, "cannot register libvirt event implementation")
+			return
+		}
+		go func() {
+			for {
+				if err := libvirt.EventRunDefaultImpl(); err != nil {
+					p.logger.Error().Err(err).Msg("libvirt event loop iteration failed")
+				}
+			}
+		}()
+	})
+	if loopErr != nil {
+		return loopErr
+	}
+	for _, node := range p.Nodes(nil) {
+		go func(node string) {
+			for {
+				if err := p.watchNode(node, handler); err != nil {
+					p.logger.Warn().Err(err).Str("node", node).Msg("domain events watch interrupted")
+				}
+				time.Sleep(eventReconnectInterval)
+			}
+		}(node)
+	}
+	return nil
+}
+
+func (p *ConnectionPool) watchNode(node string, handler DomainLifecycleHandler) error {
+	conn, err := p.Open(node)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	closed := make(chan libvirt.ConnectCloseReason, 1)
+	if err := conn.RegisterCloseCallback(func(c *libvirt.Connect, reason libvirt.ConnectCloseReason) {
+		select {
+		case closed <- reason:
+		default:
+		}
+	}); err != nil {
+		return util.NewError(err, "cannot register close callback")
+	}
+	defer conn.UnregisterCloseCallback()
+	if err := conn.SetKeepAlive(eventKeepAliveInterval, eventKeepAliveCount); err != nil {
+		return util.NewError(err, "cannot enable keepalive")
+	}
+	callbackId, err := conn.DomainEventLifecycleRegister(nil, func(c *libvirt.Connect, domain *libvirt.Domain, event *libvirt.DomainEventLifecycle) {
+		name, err := domain.GetName()
+		if err != nil {
+			p.logger.Warn().Err(err).Str("node", node).Msg("cannot get domain name for event")
+			return
+		}
+		handler(node, name, event)
+	})
+	if err != nil {
+		return util.NewError(err, "cannot register domain lifecycle callback")
+	}
+	defer conn.DomainEventDeregister(callbackId)
+
+	p.logger.Info().Str("node", node).Msg("watching domain events")
+	reason := <-closed
+	return fmt.Errorf("connection closed, reason %d", reason)
+}
@@ -46,6 +46,9 @@ func (repo *NetworkRepository) Get(name, nodeId string) (*compute.Network, error
 
 	virNetwork, err := conn.LookupNetworkByName(name)
 	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_NETWORK) {
+			return nil, compute.ErrNetworkNotFound
+		}
 		return nil, util.NewError(err, "cannot lookup network")
 	}
 	network, err := repo.virNetworkToNetwork(virNetwork, nodeId)
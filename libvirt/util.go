@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 	"subuk/vmango/compute"
+	"subuk/vmango/sshtunnel"
 	"subuk/vmango/util"
 
 	libvirt "github.com/libvirt/libvirt-go"
@@ -14,6 +15,8 @@ import (
 type NodeSettings struct {
 	CdSuffix string
 	Cache    bool
+	// Tunnel reaches node ports for qemu+ssh connections
+	Tunnel *sshtunnel.Tunnel
 }
 
 func ComputeSizeUnitToLibvirtUnit(input compute.SizeUnit) string {
@@ -103,3 +106,8 @@ func getVolTargetFormatType(vol *libvirtxml.StorageVolume) string {
 	}
 	return "raw"
 }
+
+func isVirErrorCode(err error, code libvirt.ErrorNumber) bool {
+	virErr, ok := err.(libvirt.Error)
+	return ok && virErr.Code == code
+}
@@ -0,0 +1,102 @@
+package libvirt
+
+import (
+	"reflect"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/libvirt/libvirt-go"
+)
+
+const eventQueueSize = 1024
+
+// Addresses are reported by dhcp leases or guest agent some time after
+// start, so machine is reread a few times to publish them.
+var eventAddressRefreshDelays = []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second}
+
+type domainLifecycleEvent struct {
+	node   string
+	domain string
+	action string
+}
+
+func domainEventAction(event libvirt.DomainEventType) string {
+	switch event {
+	default:
+		return "unknown"
+	case libvirt.DOMAIN_EVENT_DEFINED:
+		return "defined"
+	case libvirt.DOMAIN_EVENT_UNDEFINED:
+		return "undefined"
+	case libvirt.DOMAIN_EVENT_STARTED:
+		return "started"
+	case libvirt.DOMAIN_EVENT_SUSPENDED:
+		return "suspended"
+	case libvirt.DOMAIN_EVENT_RESUMED:
+		return "resumed"
+	case libvirt.DOMAIN_EVENT_STOPPED:
+		return "stopped"
+	case libvirt.DOMAIN_EVENT_SHUTDOWN:
+		return "shutdown"
+	case libvirt.DOMAIN_EVENT_PMSUSPENDED:
+		return "pmsuspended"
+	case libvirt.DOMAIN_EVENT_CRASHED:
+		return "crashed"
+	}
+}
+
+// WatchEvents publishes EventVirtualMachineStateChanged for every domain
+// lifecycle event on all nodes. Events are queued and processed outside
+// of libvirt event loop, events are dropped if the queue is full.
+func (repo *VirtualMachineRepository) WatchEvents(epub compute.EventPublisher) error {
+	queue := make(chan domainLifecycleEvent, eventQueueSize)
+	go func() {
+		for event := range queue {
+			repo.publishStateChanged(epub, event)
+		}
+	}()
+	return repo.pool.WatchDomainLifecycle(func(node, domain string, event *libvirt.DomainEventLifecycle) {
+		select {
+		case queue <- domainLifecycleEvent{node: node, domain: domain, action: domainEventAction(event.Event)}:
+		default:
+			repo.logger.Warn().Str("node", node).Str("domain", domain).Msg("domain event queue is full, event dropped")
+		}
+	})
+}
+
+func (repo *VirtualMachineRepository) publishStateChanged(epub compute.EventPublisher, event domainLifecycleEvent) {
+	var vm *compute.VirtualMachine
+	if event.action != "undefined" {
+		found, err := repo.Get(event.domain, event.node)
+		if err != nil && util.ErrorCause(err) != compute.ErrVirtualMachineNotFound {
+			repo.logger.Warn().Err(err).Str("node", event.node).Str("domain", event.domain).Msg("cannot get machine for event")
+			return
+		}
+		vm = found
+	}
+	if err := epub.Publish(compute.NewEventVirtualMachineStateChanged(event.node, event.domain, event.action, vm)); err != nil {
+		repo.logger.Warn().Err(err).Str("node", event.node).Str("domain", event.domain).Msg("cannot publish state changed event")
+	}
+	if vm != nil && (event.action == "started" || event.action == "resumed") {
+		go repo.refreshAddresses(epub, event, vm.IpAddressList())
+	}
+}
+
+func (repo *VirtualMachineRepository) refreshAddresses(epub compute.EventPublisher, event domainLifecycleEvent, addresses []string) {
+	for _, delay := range eventAddressRefreshDelays {
+		time.Sleep(delay)
+		vm, err := repo.Get(event.domain, event.node)
+		if err != nil || !vm.IsActive() {
+			return
+		}
+		current := vm.IpAddressList()
+		if reflect.DeepEqual(current, addresses) {
+			continue
+		}
+		addresses = current
+		if err := epub.Publish(compute.NewEventVirtualMachineStateChanged(event.node, event.domain, "refreshed", vm)); err != nil {
+			repo.logger.Warn().Err(err).Str("node", event.node).Str("domain", event.domain).Msg("cannot publish state changed event")
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package libvirt
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/libvirt/libvirt-go"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// Export archive is a tar stream with domain definition first and
+// volumes after it, original volume properties are kept in pax records.
+// Shared volumes are empty entries referencing volume of the same name.
+const (
+	exportDomainEntry    = "domain.xml"
+	exportDiskPrefix     = "disks/"
+	exportRecordPath     = "VMANGO.path"
+	exportRecordPool     = "VMANGO.pool"
+	exportRecordFormat   = "VMANGO.format"
+	exportRecordCapacity = "VMANGO.capacity"
+	exportRecordShared   = "VMANGO.shared"
+)
+
+func domainFileDisks(domainConfig *libvirtxml.Domain) []*libvirtxml.DomainDisk {
+	disks := []*libvirtxml.DomainDisk{}
+	if domainConfig.Devices == nil {
+		return disks
+	}
+	for idx := range domainConfig.Devices.Disks {
+		disk := &domainConfig.Devices.Disks[idx]
+		if disk.Source == nil || disk.Source.File == nil || disk.Source.File.File == "" {
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+func chardevSourceAllowed(source *libvirtxml.DomainChardevSource) bool {
+	return source == nil || source.Pty != nil || source.SpiceVMC != nil || (source.UNIX != nil && source.UNIX.Path == "")
+}
+
+// checkImportedDomain allows only devices vmango creates itself, so
+// archive cannot give machine access to host devices, files or commands.
+// Every disk source must be a file, it is replaced with imported volume.
+func checkImportedDomain(domainConfig *libvirtxml.Domain) error {
+	if domainConfig.Type != "kvm" && domainConfig.Type != "qemu" {
+		return fmt.Errorf("domain type %s is not supported", domainConfig.Type)
+	}
+	if domainConfig.QEMUCommandline != nil || domainConfig.QEMUCapabilities != nil {
+		return fmt.Errorf("qemu command line passthrough is not allowed")
+	}
+	if os := domainConfig.OS; os != nil && (os.Kernel != "" || os.Initrd != "" || os.DTB != "" || os.Loader != nil || os.NVRam != nil) {
+		return fmt.Errorf("kernel, initrd, dtb and firmware paths are not allowed")
+	}
+	devices := domainConfig.Devices
+	if devices == nil {
+		return nil
+	}
+	notAllowed := []struct {
+		name    string
+		present bool
+	}{
+		{"lease", len(devices.Leases) > 0},
+		{"filesystem", len(devices.Filesystems) > 0},
+		{"smartcard", len(devices.Smartcards) > 0},
+		{"parallel", len(devices.Parallels) > 0},
+		{"tpm", len(devices.TPMs) > 0},
+		{"sound", len(devices.Sounds) > 0},
+		{"hostdev", len(devices.Hostdevs) > 0},
+		{"redirdev", len(devices.RedirDevs) > 0 || len(devices.RedirFilters) > 0},
+		{"hub", len(devices.Hubs) > 0},
+		{"nvram", devices.NVRAM != nil},
+		{"shmem", len(devices.Shmems) > 0},
+		{"memory", len(devices.Memorydevs) > 0},
+		{"iommu", devices.IOMMU != nil},
+		{"vsock", devices.VSock != nil},
+	}
+	for _, device := range notAllowed {
+		if device.present {
+			return fmt.Errorf("%s devices are not allowed", device.name)
+		}
+	}
+	for _, disk := range devices.Disks {
+		if disk.Source == nil {
+			continue
+		}
+		if disk.Source.File == nil || disk.Source.File.File == "" {
+			return fmt.Errorf("only file disks are allowed")
+		}
+	}
+	for _, iface := range devices.Interfaces {
+		if iface.Source == nil || (iface.Source.Network == nil && iface.Source.Bridge == nil) {
+			return fmt.Errorf("only network and bridge interfaces are allowed")
+		}
+		if iface.Script != nil || iface.Backend != nil || (iface.Target != nil && iface.Target.Dev != "") {
+			return fmt.Errorf("interface scripts, backends and target devices are not allowed")
+		}
+	}
+	for _, serial := range devices.Serials {
+		if !chardevSourceAllowed(serial.Source) || serial.Log != nil {
+			return fmt.Errorf("only pty serial devices are allowed")
+		}
+	}
+	for _, console := range devices.Consoles {
+		if !chardevSourceAllowed(console.Source) || console.Log != nil {
+			return fmt.Errorf("only pty consoles are allowed")
+		}
+	}
+	for _, channel := range devices.Channels {
+		if !chardevSourceAllowed(channel.Source) || channel.Log != nil {
+			return fmt.Errorf("only unix channels with generated path are allowed")
+		}
+	}
+	for _, rng := range devices.RNGs {
+		if rng.Backend == nil {
+			continue
+		}
+		if rng.Backend.Random == nil || (rng.Backend.Random.Device != "" && rng.Backend.Random.Device != "/dev/random" && rng.Backend.Random.Device != "/dev/urandom") {
+			return fmt.Errorf("only /dev/random and /dev/urandom rng backends are allowed")
+		}
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) exportVolume(conn *libvirt.Connect, tw *tar.Writer, path string, shared bool) error {
+	virVolume, err := conn.LookupStorageVolByPath(path)
+	if err != nil {
+		return util.NewError(err, "cannot lookup volume")
+	}
+	defer virVolume.Free()
+	virVolumeXml, err := virVolume.GetXMLDesc(0)
+	if err != nil {
+		return util.NewError(err, "cannot get volume xml")
+	}
+	virVolumeConfig := &libvirtxml.StorageVolume{}
+	if err := virVolumeConfig.Unmarshal(virVolumeXml); err != nil {
+		return util.NewError(err, "cannot parse volume xml")
+	}
+	if virVolumeConfig.BackingStore != nil && !shared {
+		return fmt.Errorf("volume has backing store %s", virVolumeConfig.BackingStore.Path)
+	}
+	virPool, err := virVolume.LookupPoolByVolume()
+	if err != nil {
+		return util.NewError(err, "cannot lookup volume pool")
+	}
+	defer virPool.Free()
+	poolName, err := virPool.GetName()
+	if err != nil {
+		return util.NewError(err, "cannot get volume pool name")
+	}
+	info, err := virVolume.GetInfoFlags(libvirt.STORAGE_VOL_GET_PHYSICAL)
+	if err != nil {
+		return util.NewError(err, "cannot get volume info")
+	}
+	format := ""
+	if virVolumeConfig.Target != nil && virVolumeConfig.Target.Format != nil {
+		format = virVolumeConfig.Target.Format.Type
+	}
+
+	header := &tar.Header{
+		Name:    exportDiskPrefix + virVolumeConfig.Name,
+		Mode:    0600,
+		Size:    int64(info.Allocation),
+		ModTime: time.Now(),
+		Format:  tar.FormatPAX,
+		PAXRecords: map[string]string{
+			exportRecordPath:     path,
+			exportRecordPool:     poolName,
+			exportRecordFormat:   format,
+			exportRecordCapacity: strconv.FormatUint(info.Capacity, 10),
+		},
+	}
+	if shared {
+		header.Size = 0
+		header.PAXRecords[exportRecordShared] = "true"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return util.NewError(err, "cannot write archive header")
+	}
+	if shared {
+		return nil
+	}
+	stream, err := conn.NewStream(0)
+	if err != nil {
+		return util.NewError(err, "cannot initialize download stream")
+	}
+	defer stream.Free()
+	if err := virVolume.Download(stream, 0, info.Allocation, 0); err != nil {
+		return util.NewError(err, "cannot start download")
+	}
+	if _, err := io.CopyN(tw, &virStreamReader{stream}, header.Size); err != nil {
+		stream.Abort()
+		return util.NewError(err, "download failed")
+	}
+	if err := stream.Finish(); err != nil {
+		return util.NewError(err, "cannot finalize download")
+	}
+	return nil
+}
+
+// Export writes stopped domain definition and all its volumes as a tar
+// archive, content of shared volumes is not written. Volumes with backing
+// store (e.g. external snapshot overlays) cannot be exported, snapshot
+// metadata is not exported.
+func (repo *VirtualMachineRepository) Export(id, nodeId string, shared []string, w io.Writer, progress *compute.TaskProgress) error {
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot connect to node")
+	}
+	defer conn.Close()
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return compute.ErrVirtualMachineNotFound
+		}
+		return util.NewError(err, "domain lookup failed")
+	}
+	defer domain.Free()
+	active, err := domain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is active")
+	}
+	if active {
+		return fmt.Errorf("domain is running")
+	}
+	domainXml, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE | libvirt.DOMAIN_XML_SECURE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch domain xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(domainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+
+	tw := tar.NewWriter(w)
+	header := &tar.Header{
+		Name:    exportDomainEntry,
+		Mode:    0600,
+		Size:    int64(len(domainXml)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return util.NewError(err, "cannot write archive header")
+	}
+	if _, err := io.WriteString(tw, domainXml); err != nil {
+		return util.NewError(err, "cannot write domain xml")
+	}
+	disks := domainFileDisks(domainConfig)
+	for idx, disk := range disks {
+		path := disk.Source.File.File
+		progress.Report(idx*100/len(disks), fmt.Sprintf("exporting volume %s", path))
+		if err := repo.exportVolume(conn, tw, path, util.ArrayContainsString(shared, path)); err != nil {
+			return util.NewError(err, "cannot export volume %s", path)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return util.NewError(err, "cannot finalize archive")
+	}
+	return nil
+}
+
+// sharedVolumePath returns path of volume referenced by shared archive
+// entry, it must exist in the pool it had on source node and be allowed
+// by params.
+func (repo *VirtualMachineRepository) sharedVolumePath(conn *libvirt.Connect, header *tar.Header, params compute.VirtualMachineImportParams) (string, error) {
+	name := strings.TrimPrefix(header.Name, exportDiskPrefix)
+	pool := header.PAXRecords[exportRecordPool]
+	virPool, err := conn.LookupStoragePoolByName(pool)
+	if err != nil {
+		return "", util.NewError(err, "shared volume %s must exist in pool %s, cannot lookup pool", name, pool)
+	}
+	defer virPool.Free()
+	virVolume, err := virPool.LookupStorageVolByName(name)
+	if err != nil {
+		return "", util.NewError(err, "shared volume %s must exist in pool %s", name, pool)
+	}
+	defer virVolume.Free()
+	path, err := virVolume.GetPath()
+	if err != nil {
+		return "", util.NewError(err, "cannot get shared volume path")
+	}
+	if !util.ArrayContainsString(params.Shared, path) {
+		return "", fmt.Errorf("volume %s cannot be referenced by imported machine", path)
+	}
+	return path, nil
+}
+
+func (repo *VirtualMachineRepository) importVolume(conn *libvirt.Connect, header *tar.Header, content io.Reader, pool string) (*libvirt.StorageVol, error) {
+	if pool == "" {
+		pool = header.PAXRecords[exportRecordPool]
+	}
+	virPool, err := conn.LookupStoragePoolByName(pool)
+	if err != nil {
+		return nil, util.NewError(err, "cannot lookup pool %s", pool)
+	}
+	defer virPool.Free()
+	capacity, err := strconv.ParseUint(header.PAXRecords[exportRecordCapacity], 10, 64)
+	if err != nil {
+		return nil, util.NewError(err, "invalid volume capacity")
+	}
+	virVolumeConfig := &libvirtxml.StorageVolume{
+		Name:     strings.TrimPrefix(header.Name, exportDiskPrefix),
+		Capacity: &libvirtxml.StorageVolumeSize{Unit: "bytes", Value: capacity},
+	}
+	if format := header.PAXRecords[exportRecordFormat]; format != "" {
+		virVolumeConfig.Target = &libvirtxml.StorageVolumeTarget{
+			Format: &libvirtxml.StorageVolumeTargetFormat{Type: format},
+		}
+	}
+	virVolumeXml, err := virVolumeConfig.Marshal()
+	if err != nil {
+		return nil, util.NewError(err, "cannot marshal volume xml")
+	}
+	virVolume, err := virPool.StorageVolCreateXML(virVolumeXml, 0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create volume")
+	}
+	stream, err := conn.NewStream(0)
+	if err != nil {
+		return virVolume, util.NewError(err, "cannot initialize upload stream")
+	}
+	defer stream.Free()
+	if err := virVolume.Upload(stream, 0, uint64(header.Size), 0); err != nil {
+		return virVolume, util.NewError(err, "cannot start upload")
+	}
+	if _, err := io.CopyN(&virStreamWrapper{stream}, content, header.Size); err != nil {
+		stream.Abort()
+		return virVolume, util.NewError(err, "upload failed")
+	}
+	if err := stream.Finish(); err != nil {
+		return virVolume, util.NewError(err, "cannot finalize upload")
+	}
+	return virVolume, nil
+}
+
+// Import reads archive written by Export, creates volumes on the node
+// and defines domain using them, shared volumes must already exist and be
+// listed in params. Devices are limited by checkImportedDomain and vmango
+// metadata of the archive is replaced with owner and project of params.
+// Volumes created so far are deleted if import fails.
+func (repo *VirtualMachineRepository) Import(params compute.VirtualMachineImportParams, r io.Reader, progress *compute.TaskProgress) (*compute.VirtualMachine, error) {
+	conn, err := repo.pool.Open(params.NodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot connect to node")
+	}
+	defer conn.Close()
+
+	created := []*libvirt.StorageVol{}
+	success := false
+	defer func() {
+		for _, virVolume := range created {
+			if !success {
+				if err := virVolume.Delete(0); err != nil {
+					repo.logger.Warn().Err(err).Msg("cannot delete imported volume")
+				}
+			}
+			virVolume.Free()
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, util.NewError(err, "cannot read archive")
+	}
+	if header.Name != exportDomainEntry {
+		return nil, fmt.Errorf("archive must start with %s, got %s", exportDomainEntry, header.Name)
+	}
+	domainXml, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read domain xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(string(domainXml)); err != nil {
+		return nil, util.NewError(err, "cannot parse domain xml")
+	}
+	if _, err := conn.LookupDomainByName(domainConfig.Name); err == nil {
+		return nil, util.NewError(compute.ErrVirtualMachineAlreadyExists, "machine %s already exists on node %s", domainConfig.Name, params.NodeId)
+	} else if !isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+		return nil, util.NewError(err, "domain lookup failed")
+	}
+
+	if err := checkImportedDomain(domainConfig); err != nil {
+		return nil, err
+	}
+	// Emulator and security labels of the source node are not trusted,
+	// destination node defaults are used.
+	if domainConfig.Devices != nil {
+		domainConfig.Devices.Emulator = ""
+	}
+	domainConfig.SecLabel = nil
+
+	disks := domainFileDisks(domainConfig)
+	paths := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, util.NewError(err, "cannot read archive")
+		}
+		originalPath := header.PAXRecords[exportRecordPath]
+		if originalPath == "" || !strings.HasPrefix(header.Name, exportDiskPrefix) {
+			return nil, fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+		if header.PAXRecords[exportRecordShared] == "true" {
+			sharedPath, err := repo.sharedVolumePath(conn, header, params)
+			if err != nil {
+				return nil, err
+			}
+			paths[originalPath] = sharedPath
+			continue
+		}
+		progress.Report(len(paths)*100/(len(disks)+1), fmt.Sprintf("importing volume %s", originalPath))
+		virVolume, err := repo.importVolume(conn, header, tr, params.Pool)
+		if virVolume != nil {
+			created = append(created, virVolume)
+		}
+		if err != nil {
+			return nil, util.NewError(err, "cannot import volume %s", originalPath)
+		}
+		newPath, err := virVolume.GetPath()
+		if err != nil {
+			return nil, util.NewError(err, "cannot get imported volume path")
+		}
+		paths[originalPath] = newPath
+	}
+	for _, disk := range disks {
+		newPath, ok := paths[disk.Source.File.File]
+		if !ok {
+			return nil, fmt.Errorf("volume %s is missing in archive", disk.Source.File.File)
+		}
+		disk.Source.File.File = newPath
+		disk.BackingStore = nil
+	}
+
+	progress.Report(99, "defining domain")
+	newDomainXml, err := domainConfig.Marshal()
+	if err != nil {
+		return nil, util.NewError(err, "cannot marshal domain xml")
+	}
+	domain, err := conn.DomainDefineXML(newDomainXml)
+	if err != nil {
+		return nil, util.NewError(err, "cannot define domain")
+	}
+	defer domain.Free()
+	if err := setDomainOwner(domain, params.Owner, params.Project); err != nil {
+		if undefineErr := domain.Undefine(); undefineErr != nil {
+			repo.logger.Warn().Err(undefineErr).Msg("cannot undefine imported domain")
+		}
+		return nil, err
+	}
+	success = true
+	return repo.domainToVm(conn, params.NodeId, domain, repo.settings[params.NodeId])
+}
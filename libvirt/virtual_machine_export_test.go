@@ -0,0 +1,74 @@
+package libvirt
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestCheckImportedDomain(t *testing.T) {
+	fileDisk := libvirtxml.DomainDisk{Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/default/web1"}}}
+	network := libvirtxml.DomainInterface{Source: &libvirtxml.DomainInterfaceSource{Network: &libvirtxml.DomainInterfaceSourceNetwork{Network: "default"}}}
+	tests := []struct {
+		name    string
+		domain  *libvirtxml.Domain
+		wantErr bool
+	}{
+		{
+			name: "created by vmango",
+			domain: &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{
+				Disks:      []libvirtxml.DomainDisk{fileDisk, {Device: "cdrom"}},
+				Interfaces: []libvirtxml.DomainInterface{network},
+				Consoles:   []libvirtxml.DomainConsole{{}},
+				Channels:   []libvirtxml.DomainChannel{{Source: &libvirtxml.DomainChardevSource{UNIX: &libvirtxml.DomainChardevSourceUNIX{}}}},
+			}},
+		},
+		{
+			name:    "block disk",
+			domain:  &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{Disks: []libvirtxml.DomainDisk{{Source: &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: "/dev/sda"}}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "hostdev",
+			domain:  &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{Hostdevs: []libvirtxml.DomainHostdev{{}}}},
+			wantErr: true,
+		},
+		{
+			name:    "filesystem passthrough",
+			domain:  &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{Filesystems: []libvirtxml.DomainFilesystem{{}}}},
+			wantErr: true,
+		},
+		{
+			name:    "qemu command line",
+			domain:  &libvirtxml.Domain{Type: "kvm", QEMUCommandline: &libvirtxml.DomainQEMUCommandline{}},
+			wantErr: true,
+		},
+		{
+			name:    "direct interface",
+			domain:  &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{Interfaces: []libvirtxml.DomainInterface{{Source: &libvirtxml.DomainInterfaceSource{Direct: &libvirtxml.DomainInterfaceSourceDirect{Dev: "eth0"}}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "serial to host file",
+			domain:  &libvirtxml.Domain{Type: "kvm", Devices: &libvirtxml.DomainDeviceList{Serials: []libvirtxml.DomainSerial{{Source: &libvirtxml.DomainChardevSource{File: &libvirtxml.DomainChardevSourceFile{Path: "/etc/shadow"}}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "kernel",
+			domain:  &libvirtxml.Domain{Type: "kvm", OS: &libvirtxml.DomainOS{Kernel: "/boot/vmlinuz"}},
+			wantErr: true,
+		},
+		{
+			name:    "lxc",
+			domain:  &libvirtxml.Domain{Type: "lxc"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkImportedDomain(tt.domain); (err != nil) != tt.wantErr {
+				t.Errorf("checkImportedDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
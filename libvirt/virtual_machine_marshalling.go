@@ -1,6 +1,7 @@
 package libvirt
 
 import (
+	"encoding/xml"
 	"fmt"
 	"strings"
 	"subuk/vmango/compute"
@@ -9,6 +10,30 @@ import (
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 )
 
+const VmangoMetadataUri = "https://github.com/subuk/vmango/xmlns/instance/1.0"
+const VmangoMetadataKey = "vmango"
+
+type VmangoDomainMetadata struct {
+	XMLName xml.Name `xml:"instance"`
+	Owner   string   `xml:"owner,omitempty"`
+	Project string   `xml:"project,omitempty"`
+}
+
+type domainMetadataContainer struct {
+	Vmango *VmangoDomainMetadata `xml:"https://github.com/subuk/vmango/xmlns/instance/1.0 instance"`
+}
+
+func VmangoDomainMetadataFromDomainConfig(domainConfig *libvirtxml.Domain) *VmangoDomainMetadata {
+	if domainConfig.Metadata == nil {
+		return nil
+	}
+	container := &domainMetadataContainer{}
+	if err := xml.Unmarshal([]byte("<metadata>"+domainConfig.Metadata.XML+"</metadata>"), container); err != nil {
+		return nil
+	}
+	return container.Vmango
+}
+
 func DomainDiskConfigFromVirtualMachineAttachedVolume(volume *compute.VirtualMachineAttachedVolume, volTargetFormatType, volumeType string, namer *DeviceNamer) *libvirtxml.DomainDisk {
 	diskDriverType := "raw"
 	if volTargetFormatType == "qcow2" {
@@ -104,7 +129,7 @@ func VirtualMachineAttachedInterfaceFromInterfaceConfig(ifaceConfig libvirtxml.D
 	return iface
 }
 
-func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo *libvirt.DomainInfo) (*compute.VirtualMachine, error) {
+func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, state libvirt.DomainState) (*compute.VirtualMachine, error) {
 	vm := &compute.VirtualMachine{}
 	vm.Id = domainConfig.Name
 	vm.VCpus = domainConfig.VCPU.Value
@@ -117,26 +142,7 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 		vm.Arch = compute.ArchAmd64
 	}
 
-	switch domainInfo.State {
-	default:
-		vm.State = compute.StateUnknown
-	case libvirt.DOMAIN_NOSTATE:
-		vm.State = compute.StateUnknown
-	case libvirt.DOMAIN_RUNNING:
-		vm.State = compute.StateRunning
-	case libvirt.DOMAIN_BLOCKED:
-		vm.State = compute.StateStopped
-	case libvirt.DOMAIN_PAUSED:
-		vm.State = compute.StateStopped
-	case libvirt.DOMAIN_SHUTDOWN:
-		vm.State = compute.StateStopped
-	case libvirt.DOMAIN_CRASHED:
-		vm.State = compute.StateStopped
-	case libvirt.DOMAIN_PMSUSPENDED:
-		vm.State = compute.StateStopped
-	case libvirt.DOMAIN_SHUTOFF:
-		vm.State = compute.StateStopped
-	}
+	vm.State = domainState(state)
 
 	if domainConfig.CPUTune != nil {
 		vm.Cpupin = &compute.VirtualMachineCpuPin{
@@ -198,5 +204,120 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 		vm.VideoModel = compute.VideoModelNone
 	}
 
+	if metadata := VmangoDomainMetadataFromDomainConfig(domainConfig); metadata != nil {
+		vm.Owner = metadata.Owner
+		vm.Project = metadata.Project
+	}
+
 	return vm, nil
 }
+
+func domainState(state libvirt.DomainState) compute.VirtualMachineState {
+	switch state {
+	default:
+		return compute.StateUnknown
+	case libvirt.DOMAIN_RUNNING:
+		return compute.StateRunning
+	case libvirt.DOMAIN_BLOCKED:
+		return compute.StateBlocked
+	case libvirt.DOMAIN_PAUSED:
+		return compute.StatePaused
+	case libvirt.DOMAIN_SHUTDOWN:
+		return compute.StateShuttingDown
+	case libvirt.DOMAIN_CRASHED:
+		return compute.StateCrashed
+	case libvirt.DOMAIN_PMSUSPENDED:
+		return compute.StateSuspended
+	case libvirt.DOMAIN_SHUTOFF:
+		return compute.StateStopped
+	}
+}
+
+// domainStateReason returns short name of libvirt state reason, unknown
+// reasons are returned as empty string.
+func domainStateReason(state libvirt.DomainState, reason int) string {
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		switch libvirt.DomainRunningReason(reason) {
+		case libvirt.DOMAIN_RUNNING_BOOTED:
+			return "booted"
+		case libvirt.DOMAIN_RUNNING_MIGRATED:
+			return "migrated"
+		case libvirt.DOMAIN_RUNNING_RESTORED:
+			return "restored"
+		case libvirt.DOMAIN_RUNNING_FROM_SNAPSHOT:
+			return "from_snapshot"
+		case libvirt.DOMAIN_RUNNING_UNPAUSED:
+			return "unpaused"
+		case libvirt.DOMAIN_RUNNING_MIGRATION_CANCELED:
+			return "migration_canceled"
+		case libvirt.DOMAIN_RUNNING_SAVE_CANCELED:
+			return "save_canceled"
+		case libvirt.DOMAIN_RUNNING_WAKEUP:
+			return "wakeup"
+		case libvirt.DOMAIN_RUNNING_CRASHED:
+			return "crashed"
+		case libvirt.DOMAIN_RUNNING_POSTCOPY:
+			return "postcopy"
+		}
+	case libvirt.DOMAIN_PAUSED:
+		switch libvirt.DomainPausedReason(reason) {
+		case libvirt.DOMAIN_PAUSED_USER:
+			return "user"
+		case libvirt.DOMAIN_PAUSED_MIGRATION:
+			return "migrating"
+		case libvirt.DOMAIN_PAUSED_SAVE:
+			return "saving"
+		case libvirt.DOMAIN_PAUSED_DUMP:
+			return "dumping"
+		case libvirt.DOMAIN_PAUSED_IOERROR:
+			return "ioerror"
+		case libvirt.DOMAIN_PAUSED_WATCHDOG:
+			return "watchdog"
+		case libvirt.DOMAIN_PAUSED_FROM_SNAPSHOT:
+			return "from_snapshot"
+		case libvirt.DOMAIN_PAUSED_SHUTTING_DOWN:
+			return "shutting_down"
+		case libvirt.DOMAIN_PAUSED_SNAPSHOT:
+			return "snapshot"
+		case libvirt.DOMAIN_PAUSED_CRASHED:
+			return "crashed"
+		case libvirt.DOMAIN_PAUSED_STARTING_UP:
+			return "starting_up"
+		case libvirt.DOMAIN_PAUSED_POSTCOPY:
+			return "postcopy"
+		case libvirt.DOMAIN_PAUSED_POSTCOPY_FAILED:
+			return "postcopy_failed"
+		}
+	case libvirt.DOMAIN_SHUTDOWN:
+		switch libvirt.DomainShutdownReason(reason) {
+		case libvirt.DOMAIN_SHUTDOWN_USER:
+			return "user"
+		}
+	case libvirt.DOMAIN_SHUTOFF:
+		switch libvirt.DomainShutoffReason(reason) {
+		case libvirt.DOMAIN_SHUTOFF_SHUTDOWN:
+			return "shutdown"
+		case libvirt.DOMAIN_SHUTOFF_DESTROYED:
+			return "destroyed"
+		case libvirt.DOMAIN_SHUTOFF_CRASHED:
+			return "crashed"
+		case libvirt.DOMAIN_SHUTOFF_MIGRATED:
+			return "migrated"
+		case libvirt.DOMAIN_SHUTOFF_SAVED:
+			return "saved"
+		case libvirt.DOMAIN_SHUTOFF_FAILED:
+			return "failed"
+		case libvirt.DOMAIN_SHUTOFF_FROM_SNAPSHOT:
+			return "from_snapshot"
+		case libvirt.DOMAIN_SHUTOFF_DAEMON:
+			return "daemon"
+		}
+	case libvirt.DOMAIN_CRASHED:
+		switch libvirt.DomainCrashedReason(reason) {
+		case libvirt.DOMAIN_CRASHED_PANICKED:
+			return "panicked"
+		}
+	}
+	return ""
+}
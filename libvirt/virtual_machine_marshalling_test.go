@@ -0,0 +1,58 @@
+package libvirt
+
+import (
+	"subuk/vmango/compute"
+	"testing"
+
+	"github.com/libvirt/libvirt-go"
+)
+
+func TestDomainState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state libvirt.DomainState
+		want  compute.VirtualMachineState
+	}{
+		{"running", libvirt.DOMAIN_RUNNING, compute.StateRunning},
+		{"blocked", libvirt.DOMAIN_BLOCKED, compute.StateBlocked},
+		{"paused", libvirt.DOMAIN_PAUSED, compute.StatePaused},
+		{"shutting down", libvirt.DOMAIN_SHUTDOWN, compute.StateShuttingDown},
+		{"shutoff", libvirt.DOMAIN_SHUTOFF, compute.StateStopped},
+		{"crashed", libvirt.DOMAIN_CRASHED, compute.StateCrashed},
+		{"pmsuspended", libvirt.DOMAIN_PMSUSPENDED, compute.StateSuspended},
+		{"nostate", libvirt.DOMAIN_NOSTATE, compute.StateUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domainState(tt.state); got != tt.want {
+				t.Errorf("domainState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainStateReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  libvirt.DomainState
+		reason int
+		want   string
+	}{
+		{"booted", libvirt.DOMAIN_RUNNING, int(libvirt.DOMAIN_RUNNING_BOOTED), "booted"},
+		{"paused by user", libvirt.DOMAIN_PAUSED, int(libvirt.DOMAIN_PAUSED_USER), "user"},
+		{"paused on io error", libvirt.DOMAIN_PAUSED, int(libvirt.DOMAIN_PAUSED_IOERROR), "ioerror"},
+		{"shutdown by user", libvirt.DOMAIN_SHUTDOWN, int(libvirt.DOMAIN_SHUTDOWN_USER), "user"},
+		{"destroyed", libvirt.DOMAIN_SHUTOFF, int(libvirt.DOMAIN_SHUTOFF_DESTROYED), "destroyed"},
+		{"panicked", libvirt.DOMAIN_CRASHED, int(libvirt.DOMAIN_CRASHED_PANICKED), "panicked"},
+		{"crashed for unknown reason", libvirt.DOMAIN_CRASHED, int(libvirt.DOMAIN_CRASHED_UNKNOWN), ""},
+		{"nostate has no reasons", libvirt.DOMAIN_NOSTATE, 1, ""},
+		{"unknown", libvirt.DOMAIN_RUNNING, int(libvirt.DOMAIN_RUNNING_UNKNOWN), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domainStateReason(tt.state, tt.reason); got != tt.want {
+				t.Errorf("domainStateReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
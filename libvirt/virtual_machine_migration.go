@@ -0,0 +1,326 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/libvirt/libvirt-go"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const migrationProgressInterval = time.Second
+
+// copyVolume creates volume with the same name in the pool with the same
+// name on destination node and copies content to it. Created volume is
+// returned even if copy fails, caller deletes and frees it.
+func (repo *VirtualMachineRepository) copyVolume(srcConn, dstConn *libvirt.Connect, path string) (*libvirt.StorageVol, error) {
+	srcVolume, err := srcConn.LookupStorageVolByPath(path)
+	if err != nil {
+		return nil, util.NewError(err, "cannot lookup volume")
+	}
+	defer srcVolume.Free()
+	srcPool, err := srcVolume.LookupPoolByVolume()
+	if err != nil {
+		return nil, util.NewError(err, "cannot lookup volume pool")
+	}
+	defer srcPool.Free()
+	poolName, err := srcPool.GetName()
+	if err != nil {
+		return nil, util.NewError(err, "cannot get volume pool name")
+	}
+	dstPool, err := dstConn.LookupStoragePoolByName(poolName)
+	if err != nil {
+		return nil, util.NewError(err, "cannot lookup pool %s on destination node", poolName)
+	}
+	defer dstPool.Free()
+	srcVolumeXml, err := srcVolume.GetXMLDesc(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get volume xml")
+	}
+	srcVolumeConfig := &libvirtxml.StorageVolume{}
+	if err := srcVolumeConfig.Unmarshal(srcVolumeXml); err != nil {
+		return nil, util.NewError(err, "cannot parse volume xml")
+	}
+	dstVolumeConfig := &libvirtxml.StorageVolume{
+		Name:     srcVolumeConfig.Name,
+		Capacity: srcVolumeConfig.Capacity,
+	}
+	if srcVolumeConfig.Target != nil && srcVolumeConfig.Target.Format != nil {
+		dstVolumeConfig.Target = &libvirtxml.StorageVolumeTarget{Format: srcVolumeConfig.Target.Format}
+	}
+	dstVolumeXml, err := dstVolumeConfig.Marshal()
+	if err != nil {
+		return nil, util.NewError(err, "cannot marshal volume xml")
+	}
+	dstVolume, err := dstPool.StorageVolCreateXML(dstVolumeXml, 0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create volume on destination node")
+	}
+
+	srcStream, err := srcConn.NewStream(0)
+	if err != nil {
+		return dstVolume, util.NewError(err, "cannot initialize download stream")
+	}
+	defer srcStream.Free()
+	if err := srcVolume.Download(srcStream, 0, 0, 0); err != nil {
+		return dstVolume, util.NewError(err, "cannot start download")
+	}
+	dstStream, err := dstConn.NewStream(0)
+	if err != nil {
+		srcStream.Abort()
+		return dstVolume, util.NewError(err, "cannot initialize upload stream")
+	}
+	defer dstStream.Free()
+	if err := dstVolume.Upload(dstStream, 0, 0, 0); err != nil {
+		srcStream.Abort()
+		return dstVolume, util.NewError(err, "cannot start upload")
+	}
+	if _, err := io.Copy(&virStreamWrapper{dstStream}, &virStreamReader{srcStream}); err != nil {
+		srcStream.Abort()
+		dstStream.Abort()
+		return dstVolume, util.NewError(err, "volume copy failed")
+	}
+	if err := srcStream.Finish(); err != nil {
+		dstStream.Abort()
+		return dstVolume, util.NewError(err, "cannot finalize download")
+	}
+	if err := dstStream.Finish(); err != nil {
+		return dstVolume, util.NewError(err, "cannot finalize upload")
+	}
+	return dstVolume, nil
+}
+
+// copyReadonlyVolumes copies volumes which libvirt doesn't migrate with
+// non-shared storage, like configdrive, unless destination already has them.
+// Volumes copied so far are returned even on failure.
+func (repo *VirtualMachineRepository) copyReadonlyVolumes(srcConn, dstConn *libvirt.Connect, domainConfig *libvirtxml.Domain) ([]*libvirt.StorageVol, error) {
+	copied := []*libvirt.StorageVol{}
+	if domainConfig.Devices == nil {
+		return copied, nil
+	}
+	for _, disk := range domainConfig.Devices.Disks {
+		if disk.ReadOnly == nil && disk.Device != "cdrom" {
+			continue
+		}
+		if disk.Source == nil || disk.Source.File == nil {
+			continue
+		}
+		path := disk.Source.File.File
+		if existing, err := dstConn.LookupStorageVolByPath(path); err == nil {
+			existing.Free()
+			continue
+		}
+		dstVolume, err := repo.copyVolume(srcConn, dstConn, path)
+		if dstVolume != nil {
+			copied = append(copied, dstVolume)
+		}
+		if err != nil {
+			return copied, util.NewError(err, "cannot copy volume %s", path)
+		}
+	}
+	return copied, nil
+}
+
+// migrationCpuXml returns cpu definition destination must provide: cpu of
+// running domain with host-model already expanded, or source host cpu for
+// host-passthrough. Empty string means default model of the hypervisor.
+func migrationCpuXml(srcConn *libvirt.Connect, domainConfig *libvirtxml.Domain) (string, bool, error) {
+	if domainConfig.CPU == nil {
+		return "", false, nil
+	}
+	if domainConfig.CPU.Mode == "host-passthrough" {
+		capsXml, err := srcConn.GetCapabilities()
+		if err != nil {
+			return "", false, util.NewError(err, "cannot get source node capabilities")
+		}
+		capsConfig := &libvirtxml.Caps{}
+		if err := capsConfig.Unmarshal(capsXml); err != nil {
+			return "", false, util.NewError(err, "cannot parse source node capabilities")
+		}
+		if capsConfig.Host.CPU == nil {
+			return "", false, nil
+		}
+		cpuXml, err := xml.Marshal(capsConfig.Host.CPU)
+		if err != nil {
+			return "", false, util.NewError(err, "cannot marshal source node cpu")
+		}
+		return string(cpuXml), true, nil
+	}
+	if domainConfig.CPU.Model == nil || domainConfig.CPU.Model.Value == "" {
+		return "", false, nil
+	}
+	cpuXml, err := xml.Marshal(domainConfig.CPU)
+	if err != nil {
+		return "", false, util.NewError(err, "cannot marshal domain cpu")
+	}
+	return string(cpuXml), false, nil
+}
+
+// CheckMigrationCpu asks destination node whether it is able to provide
+// cpu of running domain.
+func (repo *VirtualMachineRepository) CheckMigrationCpu(id, nodeId, targetNodeId string) error {
+	srcConn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire source connection")
+	}
+	defer repo.pool.Release(nodeId)
+	dstConn, err := repo.pool.Acquire(targetNodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire destination connection")
+	}
+	defer repo.pool.Release(targetNodeId)
+
+	domain, err := srcConn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return compute.ErrVirtualMachineNotFound
+		}
+		return util.NewError(err, "domain lookup failed")
+	}
+	defer domain.Free()
+	domainXml, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_MIGRATABLE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch domain xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(domainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	cpuXml, hostCpu, err := migrationCpuXml(srcConn, domainConfig)
+	if err != nil {
+		return err
+	}
+	if cpuXml == "" {
+		return nil
+	}
+	result := libvirt.CPU_COMPARE_ERROR
+	if !hostCpu {
+		emulator, arch, machine := "", "", ""
+		if domainConfig.Devices != nil {
+			emulator = domainConfig.Devices.Emulator
+		}
+		if domainConfig.OS != nil && domainConfig.OS.Type != nil {
+			arch = domainConfig.OS.Type.Arch
+			machine = domainConfig.OS.Type.Machine
+		}
+		result, err = dstConn.CompareHypervisorCPU(emulator, arch, machine, domainConfig.Type, cpuXml, 0)
+		if err != nil && !isVirErrorCode(err, libvirt.ERR_NO_SUPPORT) {
+			return util.NewError(err, "cannot compare cpu with destination hypervisor")
+		}
+	}
+	if result == libvirt.CPU_COMPARE_ERROR {
+		// Host cpu or libvirt without hypervisor cpu api, compare
+		// with destination host cpu.
+		result, err = dstConn.CompareCPU(cpuXml, 0)
+		if err != nil {
+			return util.NewError(err, "cannot compare cpu with destination node")
+		}
+	}
+	if result == libvirt.CPU_COMPARE_INCOMPATIBLE {
+		model := "host"
+		if domainConfig.CPU.Model != nil && domainConfig.CPU.Model.Value != "" {
+			model = domainConfig.CPU.Model.Value
+		}
+		return util.NewError(compute.ErrMigrationNotPossible, "destination node cannot provide %s cpu of machine", model)
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) reportMigrationProgress(domain *libvirt.Domain, progress *compute.TaskProgress, done chan struct{}) {
+	ticker := time.NewTicker(migrationProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		info, err := domain.GetJobInfo()
+		if err != nil || !info.DataTotalSet || info.DataTotal == 0 {
+			continue
+		}
+		percent := int(info.DataProcessed * 100 / info.DataTotal)
+		if percent > 99 {
+			percent = 99
+		}
+		progress.Report(percent, fmt.Sprintf("transferred %d of %d MiB", info.DataProcessed/1024/1024, info.DataTotal/1024/1024))
+	}
+}
+
+// Migrate moves running domain to another node, destination keeps it
+// defined and source undefines it. Non-shared disks are copied if
+// requested, source volumes are left in place. Read-only volumes copied
+// before the domain are deleted from destination if migration fails.
+func (repo *VirtualMachineRepository) Migrate(id, nodeId string, params compute.VirtualMachineMigrateParams, progress *compute.TaskProgress) error {
+	// Migration may take a long time, pooled connections would block
+	// every other request to both nodes meanwhile.
+	srcConn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot connect to source node")
+	}
+	defer srcConn.Close()
+	dstConn, err := repo.pool.Open(params.NodeId)
+	if err != nil {
+		return util.NewError(err, "cannot connect to destination node")
+	}
+	defer dstConn.Close()
+
+	domain, err := srcConn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return compute.ErrVirtualMachineNotFound
+		}
+		return util.NewError(err, "domain lookup failed")
+	}
+	defer domain.Free()
+	success := false
+	flags := libvirt.MIGRATE_LIVE | libvirt.MIGRATE_PERSIST_DEST | libvirt.MIGRATE_UNDEFINE_SOURCE | libvirt.MIGRATE_AUTO_CONVERGE
+	if params.CopyStorage {
+		domainXml, err := domain.GetXMLDesc(0)
+		if err != nil {
+			return util.NewError(err, "cannot fetch domain xml")
+		}
+		domainConfig := &libvirtxml.Domain{}
+		if err := domainConfig.Unmarshal(domainXml); err != nil {
+			return util.NewError(err, "cannot parse domain xml")
+		}
+		progress.Report(0, "copying read-only volumes")
+		copied, err := repo.copyReadonlyVolumes(srcConn, dstConn, domainConfig)
+		defer func() {
+			for _, dstVolume := range copied {
+				if !success {
+					if err := dstVolume.Delete(0); err != nil {
+						repo.logger.Warn().Err(err).Msg("cannot delete volume copied to destination node")
+					}
+				}
+				dstVolume.Free()
+			}
+		}()
+		if err != nil {
+			return err
+		}
+		flags |= libvirt.MIGRATE_NON_SHARED_DISK
+	}
+
+	progress.Report(0, fmt.Sprintf("migrating from %s to %s", nodeId, params.NodeId))
+	// Reporter must stop before domain is freed.
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		repo.reportMigrationProgress(domain, progress, done)
+		close(reported)
+	}()
+	migrated, err := domain.Migrate3(dstConn, &libvirt.DomainMigrateParameters{}, flags)
+	close(done)
+	<-reported
+	if err != nil {
+		return util.NewError(err, "migration failed")
+	}
+	success = true
+	migrated.Free()
+	return nil
+}
@@ -1,12 +1,10 @@
 package libvirt
 
 import (
+	"encoding/xml"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/url"
-	"os/exec"
 	"strings"
 	"subuk/vmango/compute"
 	"subuk/vmango/configdrive"
@@ -97,11 +95,13 @@ func (repo *VirtualMachineRepository) domainToVm(conn *libvirt.Connect, nodeId s
 	if err := domainConfig.Unmarshal(domainXml); err != nil {
 		return nil, util.NewError(err, "cannot unmarshal domain xml")
 	}
-	domainInfo, err := domain.GetInfo()
+	// State and reason must come from the same call, state may change
+	// between two calls.
+	state, reason, err := domain.GetState()
 	if err != nil {
-		return nil, util.NewError(err, "cannot get domain info")
+		return nil, util.NewError(err, "cannot get domain state")
 	}
-	vm, err := VirtualMachineFromDomainConfig(domainConfig, domainInfo)
+	vm, err := VirtualMachineFromDomainConfig(domainConfig, state)
 	if err != nil {
 		return nil, util.NewError(err, "cannot create virtual machine from domain config")
 	}
@@ -114,6 +114,17 @@ func (repo *VirtualMachineRepository) domainToVm(conn *libvirt.Connect, nodeId s
 
 	vm.NodeId = nodeId
 
+	vm.StateReason = domainStateReason(state, reason)
+	if vm.State == compute.StateStopped {
+		saved, err := domain.HasManagedSaveImage(0)
+		if err != nil {
+			return nil, util.NewError(err, "cannot check domain managed save image")
+		}
+		if saved {
+			vm.State = compute.StateSaved
+		}
+	}
+
 	if vm.IsRunning() && len(vm.Interfaces) > 0 {
 		virDomainIfaces := []libvirt.DomainInterface{}
 		if vm.GuestAgent {
@@ -233,26 +244,24 @@ func (repo *VirtualMachineRepository) GetConsoleStream(id, nodeId string) (compu
 	return &virStreamReadWriteCloser{stream}, nil
 }
 
-type cmdIoWrapper struct {
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
-}
-
-func (w *cmdIoWrapper) Read(p []byte) (int, error) {
-	return w.stdout.Read(p)
-}
-
-func (w *cmdIoWrapper) Write(p []byte) (int, error) {
-	return w.stdin.Write(p)
-}
-
-func (w *cmdIoWrapper) Close() error {
-	w.stdin.Close()
-	return w.stdout.Close()
-
+// domainGraphicPort returns port of the first graphic of requested type,
+// machine may have both VNC and SPICE graphics listening on different ports.
+func domainGraphicPort(domainConfig *libvirtxml.Domain, graphicType compute.GraphicType) int {
+	if domainConfig.Devices == nil {
+		return 0
+	}
+	for _, graphic := range domainConfig.Devices.Graphics {
+		if graphicType == compute.GraphicTypeVnc && graphic.VNC != nil {
+			return graphic.VNC.Port
+		}
+		if graphicType == compute.GraphicTypeSpice && graphic.Spice != nil {
+			return graphic.Spice.Port
+		}
+	}
+	return 0
 }
 
-func (repo *VirtualMachineRepository) GetGraphicStream(id, nodeId string) (compute.VirtualMachineGraphicStream, error) {
+func (repo *VirtualMachineRepository) GetGraphicStream(id, nodeId string, graphicType compute.GraphicType) (compute.VirtualMachineGraphicStream, error) {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return nil, util.NewError(err, "cannot acquire libvirt connection")
@@ -286,50 +295,17 @@ func (repo *VirtualMachineRepository) GetGraphicStream(id, nodeId string) (compu
 	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
 		return nil, util.NewError(err, "cannot parse domain xml")
 	}
-	graphicPort := 0
-	for _, graphic := range virDomainConfig.Devices.Graphics {
-		if graphic.VNC != nil {
-			graphicPort = graphic.VNC.Port
-		}
-	}
+	graphicPort := domainGraphicPort(virDomainConfig, graphicType)
 	if graphicPort <= 0 {
-		return nil, fmt.Errorf("no graphic port found")
+		return nil, fmt.Errorf("no %s graphic port found", graphicType)
 	}
 
 	if strings.Contains(connUri.Scheme, "ssh") {
-		portStr := fmt.Sprintf("%d", graphicPort)
-		args := []string{
-			"ssh", "-l", connUri.User.Username(), connUri.Hostname(),
-			"if (command -v socat) >/dev/null 2>&1; then socat - TCP:localhost:" + portStr + "; else nc localhost " + portStr + "; fi",
+		tunnel := repo.settings[nodeId].Tunnel
+		if tunnel == nil {
+			return nil, fmt.Errorf("ssh tunnel is not configured for node %s", nodeId)
 		}
-		cmd := exec.Command(args[0], args[1:]...)
-		repo.logger.Debug().Strs("args", cmd.Args).Msg("executing ssh forwarding command")
-
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			return nil, util.NewError(err, "cannot initialize cmd stdout")
-		}
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			return nil, util.NewError(err, "cannot initialize cmd stdin")
-		}
-		stdin, err := cmd.StdinPipe()
-		if err != nil {
-			return nil, util.NewError(err, "cannot initialize cmd stdin")
-		}
-		if err := cmd.Start(); err != nil {
-			return nil, util.NewError(err, "cannot start ssh forwarding command")
-		}
-		repo.logger.Debug().Msg("ssh started successfully")
-		go func(cmd *exec.Cmd) {
-			if err := cmd.Wait(); err != nil {
-				errText, _ := ioutil.ReadAll(stderr)
-				repo.logger.Warn().Err(err).Str("text", string(errText)).Msg("graphics forwarding command failed")
-				return
-			}
-			repo.logger.Debug().Msg("graphics command finished successfully")
-		}(cmd)
-		return &cmdIoWrapper{stdin: stdin, stdout: stdout}, nil
+		return tunnel.Dial("tcp", fmt.Sprintf("localhost:%d", graphicPort))
 	}
 	addr := fmt.Sprintf("%s:%d", connUri.Hostname(), graphicPort)
 	repo.logger.Debug().Str("addr", addr).Msg("connecting directly to console")
@@ -540,6 +516,28 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 			return util.NewError(err, "cannot set domain autostart state")
 		}
 	}
+	if vm.Owner != "" || vm.Project != "" {
+		if err := setDomainOwner(virDomain, vm.Owner, vm.Project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDomainOwner replaces vmango metadata of domain, metadata is removed
+// if both owner and project are empty.
+func setDomainOwner(virDomain *libvirt.Domain, owner, project string) error {
+	metadataXml := ""
+	if owner != "" || project != "" {
+		marshalled, err := xml.Marshal(&VmangoDomainMetadata{Owner: owner, Project: project})
+		if err != nil {
+			return util.NewError(err, "cannot marshal domain metadata")
+		}
+		metadataXml = string(marshalled)
+	}
+	if err := virDomain.SetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, metadataXml, VmangoMetadataKey, VmangoMetadataUri, libvirt.DOMAIN_AFFECT_CONFIG); err != nil {
+		return util.NewError(err, "cannot set domain metadata")
+	}
 	return nil
 }
 
@@ -571,13 +569,13 @@ func (repo *VirtualMachineRepository) Delete(id, nodeId string) error {
 			return util.NewError(err, "cannot destroy domain")
 		}
 	}
-	if err := virDomain.Undefine(); err != nil {
+	if err := virDomain.UndefineFlags(libvirt.DOMAIN_UNDEFINE_MANAGED_SAVE | libvirt.DOMAIN_UNDEFINE_SNAPSHOTS_METADATA); err != nil {
 		return util.NewError(err, "cannot undefine domain")
 	}
 	return nil
 }
 
-func (repo *VirtualMachineRepository) nodeList(nodeId string) ([]*compute.VirtualMachine, error) {
+func (repo *VirtualMachineRepository) nodeList(nodeId string, options compute.VirtualMachineListOptions) ([]*compute.VirtualMachine, error) {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return nil, util.NewError(err, "cannot acquire libvirt connection")
@@ -591,6 +589,9 @@ func (repo *VirtualMachineRepository) nodeList(nodeId string) ([]*compute.Virtua
 		if err != nil {
 			return nil, util.NewError(err, "cannot convert libvirt domain to vm")
 		}
+		if !options.MatchOwner(vm) || !options.MatchState(vm) {
+			continue
+		}
 		vms = append(vms, vm)
 	}
 	return vms, nil
@@ -607,7 +608,7 @@ func (repo *VirtualMachineRepository) List(options compute.VirtualMachineListOpt
 		go func(nodeId string) {
 			defer wg.Done()
 			nodeStart := time.Now()
-			vms, err := repo.nodeList(nodeId)
+			vms, err := repo.nodeList(nodeId, options)
 			if err != nil {
 				repo.logger.Warn().Err(err).Str("node", nodeId).Msg("cannot list vms")
 				return
@@ -633,6 +634,9 @@ func (repo *VirtualMachineRepository) Get(id, nodeId string) (*compute.VirtualMa
 	settings := repo.settings[nodeId]
 	domain, err := conn.LookupDomainByName(id)
 	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return nil, compute.ErrVirtualMachineNotFound
+		}
 		return nil, util.NewError(err, "failed to lookup vm")
 	}
 	vm, err := repo.domainToVm(conn, nodeId, domain, settings)
@@ -642,7 +646,8 @@ func (repo *VirtualMachineRepository) Get(id, nodeId string) (*compute.VirtualMa
 	return vm, nil
 }
 
-func (repo *VirtualMachineRepository) Poweroff(id, nodeId string) error {
+// withDomain runs action on domain looked up with pooled connection.
+func (repo *VirtualMachineRepository) withDomain(id, nodeId string, action func(domain *libvirt.Domain) error) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -653,35 +658,58 @@ func (repo *VirtualMachineRepository) Poweroff(id, nodeId string) error {
 	if err != nil {
 		return util.NewError(err, "domain lookup failed")
 	}
-	return domain.Destroy()
+	defer domain.Free()
+	return action(domain)
 }
 
-func (repo *VirtualMachineRepository) Reboot(id, nodeId string) error {
-	conn, err := repo.pool.Acquire(nodeId)
-	if err != nil {
-		return util.NewError(err, "cannot acquire connection")
-	}
-	defer repo.pool.Release(nodeId)
+func (repo *VirtualMachineRepository) Poweroff(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Destroy()
+	})
+}
 
-	domain, err := conn.LookupDomainByName(id)
-	if err != nil {
-		return util.NewError(err, "domain lookup failed")
-	}
-	return domain.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+func (repo *VirtualMachineRepository) Reboot(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+	})
 }
 
 func (repo *VirtualMachineRepository) Start(id, nodeId string) error {
-	conn, err := repo.pool.Acquire(nodeId)
-	if err != nil {
-		return util.NewError(err, "cannot acquire connection")
-	}
-	defer repo.pool.Release(nodeId)
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Create()
+	})
+}
 
-	domain, err := conn.LookupDomainByName(id)
-	if err != nil {
-		return util.NewError(err, "domain lookup failed")
-	}
-	return domain.Create()
+func (repo *VirtualMachineRepository) Shutdown(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.ShutdownFlags(libvirt.DOMAIN_SHUTDOWN_ACPI_POWER_BTN)
+	})
+}
+
+func (repo *VirtualMachineRepository) Reset(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Reset(0)
+	})
+}
+
+func (repo *VirtualMachineRepository) Pause(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Suspend()
+	})
+}
+
+func (repo *VirtualMachineRepository) Resume(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.Resume()
+	})
+}
+
+// ManagedSave stops domain keeping its memory in managed save image,
+// Start restores domain from the image.
+func (repo *VirtualMachineRepository) ManagedSave(id, nodeId string) error {
+	return repo.withDomain(id, nodeId, func(domain *libvirt.Domain) error {
+		return domain.ManagedSave(0)
+	})
 }
 
 func (repo *VirtualMachineRepository) attachVolume(conn *libvirt.Connect, virDomainConfig *libvirtxml.Domain, attachedVolume *compute.VirtualMachineAttachedVolume, namer *DeviceNamer) error {
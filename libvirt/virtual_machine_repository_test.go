@@ -0,0 +1,37 @@
+package libvirt
+
+import (
+	"subuk/vmango/compute"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestDomainGraphicPort(t *testing.T) {
+	both := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{Graphics: []libvirtxml.DomainGraphic{
+		{VNC: &libvirtxml.DomainGraphicVNC{Port: 5900}},
+		{Spice: &libvirtxml.DomainGraphicSpice{Port: 5901}},
+	}}}
+	vncOnly := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{Graphics: []libvirtxml.DomainGraphic{
+		{VNC: &libvirtxml.DomainGraphicVNC{Port: 5902}},
+	}}}
+	tests := []struct {
+		name         string
+		domainConfig *libvirtxml.Domain
+		graphicType  compute.GraphicType
+		want         int
+	}{
+		{"vnc of both", both, compute.GraphicTypeVnc, 5900},
+		{"spice of both", both, compute.GraphicTypeSpice, 5901},
+		{"vnc only", vncOnly, compute.GraphicTypeVnc, 5902},
+		{"spice is missing", vncOnly, compute.GraphicTypeSpice, 0},
+		{"no devices", &libvirtxml.Domain{}, compute.GraphicTypeVnc, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domainGraphicPort(tt.domainConfig, tt.graphicType); got != tt.want {
+				t.Errorf("domainGraphicPort() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
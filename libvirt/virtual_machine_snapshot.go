@@ -0,0 +1,211 @@
+package libvirt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/libvirt/libvirt-go"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func snapshotFromLibvirt(snapshot *libvirt.DomainSnapshot) (*compute.VirtualMachineSnapshot, error) {
+	snapshotXml, err := snapshot.GetXMLDesc(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get snapshot xml")
+	}
+	snapshotConfig := &libvirtxml.DomainSnapshot{}
+	if err := snapshotConfig.Unmarshal(snapshotXml); err != nil {
+		return nil, util.NewError(err, "cannot parse snapshot xml")
+	}
+	current, err := snapshot.IsCurrent(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot check if snapshot is current")
+	}
+	result := &compute.VirtualMachineSnapshot{
+		Name:        snapshotConfig.Name,
+		Description: snapshotConfig.Description,
+		State:       snapshotConfig.State,
+		Current:     current,
+	}
+	if created, err := strconv.ParseInt(snapshotConfig.CreationTime, 10, 64); err == nil {
+		result.Created = time.Unix(created, 0)
+	}
+	if snapshotConfig.Parent != nil {
+		result.Parent = snapshotConfig.Parent.Name
+	}
+	if snapshotConfig.Memory != nil {
+		result.Memory = snapshotConfig.Memory.Snapshot == "internal" || snapshotConfig.Memory.Snapshot == "external"
+		result.External = snapshotConfig.Memory.Snapshot == "external"
+	}
+	external := map[string]bool{}
+	if snapshotConfig.Disks != nil {
+		for _, disk := range snapshotConfig.Disks.Disks {
+			if disk.Snapshot != "external" {
+				continue
+			}
+			result.External = true
+			external[disk.Name] = true
+			if disk.Source != nil && disk.Source.File != nil && disk.Source.File.File != "" {
+				result.Volumes = append(result.Volumes, disk.Source.File.File)
+			}
+		}
+	}
+	if snapshotConfig.Domain != nil && snapshotConfig.Domain.Devices != nil {
+		for _, disk := range snapshotConfig.Domain.Devices.Disks {
+			if disk.Target == nil || disk.Source == nil || disk.Source.File == nil || disk.Source.File.File == "" {
+				continue
+			}
+			if external[disk.Target.Dev] || external[disk.Source.File.File] {
+				result.Volumes = append(result.Volumes, disk.Source.File.File)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (repo *VirtualMachineRepository) lookupSnapshot(conn *libvirt.Connect, id, name string) (*libvirt.DomainSnapshot, error) {
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return nil, compute.ErrVirtualMachineNotFound
+		}
+		return nil, util.NewError(err, "domain lookup failed")
+	}
+	snapshot, err := domain.SnapshotLookupByName(name, 0)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN_SNAPSHOT) {
+			return nil, compute.ErrSnapshotNotFound
+		}
+		return nil, util.NewError(err, "snapshot lookup failed")
+	}
+	return snapshot, nil
+}
+
+func (repo *VirtualMachineRepository) ListSnapshots(id, nodeId string) ([]*compute.VirtualMachineSnapshot, error) {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return nil, compute.ErrVirtualMachineNotFound
+		}
+		return nil, util.NewError(err, "domain lookup failed")
+	}
+	virSnapshots, err := domain.ListAllSnapshots(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot list snapshots")
+	}
+	snapshots := []*compute.VirtualMachineSnapshot{}
+	for idx := range virSnapshots {
+		snapshot, err := snapshotFromLibvirt(&virSnapshots[idx])
+		virSnapshots[idx].Free()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// CreateSnapshot creates internal snapshot, memory is included if machine
+// is running. External snapshot is disk-only, disks are switched to overlay
+// files created next to the original volumes.
+func (repo *VirtualMachineRepository) CreateSnapshot(id, nodeId string, params compute.VirtualMachineSnapshotCreateParams) (*compute.VirtualMachineSnapshot, error) {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return nil, compute.ErrVirtualMachineNotFound
+		}
+		return nil, util.NewError(err, "domain lookup failed")
+	}
+	snapshotConfig := &libvirtxml.DomainSnapshot{
+		Name:        params.Name,
+		Description: params.Description,
+	}
+	flags := libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC
+	if params.External {
+		flags |= libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY
+	}
+	snapshotXml, err := snapshotConfig.Marshal()
+	if err != nil {
+		return nil, util.NewError(err, "cannot marshal snapshot xml")
+	}
+	virSnapshot, err := domain.CreateSnapshotXML(snapshotXml, flags)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create snapshot")
+	}
+	defer virSnapshot.Free()
+	return snapshotFromLibvirt(virSnapshot)
+}
+
+// RevertSnapshot reverts machine to internal snapshot, libvirt is not able
+// to revert external ones.
+func (repo *VirtualMachineRepository) RevertSnapshot(id, nodeId, name string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virSnapshot, err := repo.lookupSnapshot(conn, id, name)
+	if err != nil {
+		return err
+	}
+	defer virSnapshot.Free()
+	snapshot, err := snapshotFromLibvirt(virSnapshot)
+	if err != nil {
+		return err
+	}
+	if snapshot.External {
+		return fmt.Errorf("cannot revert external snapshot '%s'", name)
+	}
+	if err := virSnapshot.RevertToSnapshot(0); err != nil {
+		return util.NewError(err, "cannot revert snapshot")
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes internal snapshot data. For external snapshot
+// only metadata is removed, overlay files stay in use by machine disks.
+func (repo *VirtualMachineRepository) DeleteSnapshot(id, nodeId, name string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virSnapshot, err := repo.lookupSnapshot(conn, id, name)
+	if err != nil {
+		return err
+	}
+	defer virSnapshot.Free()
+	snapshot, err := snapshotFromLibvirt(virSnapshot)
+	if err != nil {
+		return err
+	}
+	flags := libvirt.DomainSnapshotDeleteFlags(0)
+	if snapshot.External {
+		flags |= libvirt.DOMAIN_SNAPSHOT_DELETE_METADATA_ONLY
+	}
+	if err := virSnapshot.Delete(flags); err != nil {
+		return util.NewError(err, "cannot delete snapshot")
+	}
+	return nil
+}
@@ -77,6 +77,7 @@ func (repo *VolumeRepository) fetchAttachedVm(conn *libvirt.Connect, volumes []*
 		if err := domainConfig.Unmarshal(domainXml); err != nil {
 			return util.NewError(err, "cannot unmarshal domain xml")
 		}
+		metadata := VmangoDomainMetadataFromDomainConfig(domainConfig)
 		for _, diskConfig := range domainConfig.Devices.Disks {
 			attachedVolume := VirtualMachineAttachedVolumeFromDomainDiskConfig(diskConfig)
 			if attachedVolume == nil {
@@ -86,6 +87,10 @@ func (repo *VolumeRepository) fetchAttachedVm(conn *libvirt.Connect, volumes []*
 				if volume.Path == attachedVolume.Path {
 					volume.AttachedTo = domainConfig.Name
 					volume.AttachedAs = attachedVolume.DeviceType
+					if metadata != nil {
+						volume.Owner = metadata.Owner
+						volume.Project = metadata.Project
+					}
 				}
 			}
 		}
@@ -102,6 +107,9 @@ func (repo *VolumeRepository) Get(path, node string) (*compute.Volume, error) {
 
 	virVolume, err := conn.LookupStorageVolByPath(path)
 	if err != nil {
+		if isVirErrorCode(err, libvirt.ERR_NO_STORAGE_VOL) {
+			return nil, compute.ErrVolumeNotFound
+		}
 		return nil, util.NewError(err, "cannot lookup volume by path %s", path)
 	}
 	pool, err := virVolume.LookupPoolByVolume()
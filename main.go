@@ -17,6 +17,7 @@ func main() {
 	})
 	webCommand := parser.NewCommand("web", "Start web server")
 	genpwCommand := parser.NewCommand("genpw", "Generate password")
+	gentokenCommand := parser.NewCommand("gentoken", "Generate api token")
 	if err := parser.Parse(os.Args); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 		os.Exit(1)
@@ -26,5 +27,7 @@ func main() {
 		bootstrap.Web(*configFilename)
 	case genpwCommand.Happened():
 		bootstrap.GenPassword()
+	case gentokenCommand.Happened():
+		bootstrap.GenApiToken()
 	}
 }
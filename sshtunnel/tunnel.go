@@ -0,0 +1,125 @@
+package sshtunnel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Config describes ssh server, Addr is host:port.
+type Config struct {
+	Addr       string
+	User       string
+	KeyFiles   []string
+	KnownHosts []string
+	Timeout    time.Duration
+}
+
+// Tunnel keeps one ssh connection to a host and opens tcp connections
+// from there through it. Connection is established on first Dial and
+// reestablished after it breaks, so a Tunnel may be shared by everything
+// that needs to reach ports on the host.
+type Tunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+	client *ssh.Client
+	mu     *sync.Mutex
+	logger zerolog.Logger
+}
+
+// New reads keys and known hosts, it doesn't connect yet.
+func New(config Config, logger zerolog.Logger) (*Tunnel, error) {
+	signers := []ssh.Signer{}
+	for _, filename := range config.KeyFiles {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, util.NewError(err, "cannot read ssh key")
+		}
+		signer, err := ssh.ParsePrivateKey(content)
+		if err != nil {
+			return nil, util.NewError(err, "cannot parse ssh key %s", filename)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no ssh keys configured for %s", config.Addr)
+	}
+	hostKeyCallback, err := knownhosts.New(config.KnownHosts...)
+	if err != nil {
+		return nil, util.NewError(err, "cannot load ssh known hosts")
+	}
+	return &Tunnel{
+		addr: config.Addr,
+		config: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         config.Timeout,
+		},
+		mu:     &sync.Mutex{},
+		logger: logger,
+	}, nil
+}
+
+func (t *Tunnel) connect() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	client, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		return nil, util.NewError(err, "cannot connect to %s", t.addr)
+	}
+	t.logger.Debug().Str("addr", t.addr).Msg("ssh connection established")
+	t.client = client
+	go t.watch(client)
+	return client, nil
+}
+
+func (t *Tunnel) watch(client *ssh.Client) {
+	err := client.Wait()
+	t.logger.Debug().Err(err).Str("addr", t.addr).Msg("ssh connection closed")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == client {
+		t.client = nil
+	}
+}
+
+// Dial opens connection to addr as seen from ssh server, e.g.
+// localhost:5900 for a port listening on loopback of the host.
+func (t *Tunnel) Dial(network, addr string) (net.Conn, error) {
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(network, addr)
+	if err != nil {
+		if _, refused := err.(*ssh.OpenChannelError); !refused {
+			// connection is probably dead, next Dial starts over
+			client.Close()
+		}
+		return nil, util.NewError(err, "cannot open %s through ssh %s", addr, t.addr)
+	}
+	return conn, nil
+}
+
+// Close closes ssh connection and all connections opened through it.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
@@ -0,0 +1,103 @@
+(function(exports){
+    exports.Vmango = exports.Vmango || {};
+    // Plays asciicast v2 recording in xterm.js terminal.
+    exports.Vmango.CastPlayer = function(el){
+        var $playerEl = $(el),
+            $playEl = $playerEl.find('.JS-CastPlayer-Play'),
+            $restartEl = $playerEl.find('.JS-CastPlayer-Restart'),
+            $speedEl = $playerEl.find('.JS-CastPlayer-Speed'),
+            $timeEl = $playerEl.find('.JS-CastPlayer-Time'),
+            $markerEl = $playerEl.find('.JS-CastPlayer-Marker'),
+            terminal = new Terminal({disableStdin: true}),
+            events = [],
+            duration = 0,
+            position = 0,
+            elapsed = 0,
+            timer = null;
+
+        function formatTime(seconds){
+            var minutes = Math.floor(seconds / 60);
+            seconds = Math.floor(seconds % 60);
+            return minutes + ":" + (seconds < 10 ? "0" : "") + seconds;
+        }
+        function showTime(){
+            $timeEl.text(formatTime(elapsed) + " / " + formatTime(duration));
+        }
+        function pause(){
+            if (timer !== null) {
+                clearTimeout(timer);
+                timer = null;
+            }
+            $playEl.text(position < events.length ? "Play" : "Replay");
+        }
+        function step(){
+            timer = null;
+            if (position >= events.length) {
+                pause();
+                return;
+            }
+            var event = events[position];
+            if (event[1] === "m") {
+                $markerEl.text(event[2]);
+            } else {
+                terminal.write(event[2]);
+            }
+            elapsed = event[0];
+            position++;
+            showTime();
+            if (position >= events.length) {
+                pause();
+                return;
+            }
+            var delay = (events[position][0] - elapsed) * 1000 / parseFloat($speedEl.val());
+            timer = setTimeout(step, Math.min(delay, 2000));
+        }
+        function restart(){
+            pause();
+            terminal.reset();
+            $markerEl.text("");
+            position = 0;
+            elapsed = 0;
+            showTime();
+        }
+        function play(){
+            if (position >= events.length) {
+                restart();
+            }
+            $playEl.text("Pause");
+            step();
+        }
+
+        $playEl.on('click', function(){
+            if (timer !== null) {
+                pause();
+            } else {
+                play();
+            }
+        });
+        $restartEl.on('click', restart);
+
+        terminal.open($playerEl.find('.JS-CastPlayer-Window')[0]);
+        terminal.write("Loading...\r\n");
+        $.ajax({url: $playerEl.attr('data-CastPlayer-Url'), dataType: 'text'}).done(function(content){
+            var lines = content.split("\n"),
+                header = JSON.parse(lines[0]);
+            terminal.resize(header.width, header.height);
+            for (var i = 1; i < lines.length; i++) {
+                if (lines[i] === "") {
+                    continue;
+                }
+                var event = JSON.parse(lines[i]);
+                if (event[1] === "o" || event[1] === "m") {
+                    events.push(event);
+                }
+            }
+            if (events.length > 0) {
+                duration = events[events.length - 1][0];
+            }
+            restart();
+        }).fail(function(xhr){
+            terminal.write("Cannot load recording: " + xhr.status + " " + xhr.statusText + "\r\n");
+        });
+    }
+})(window);
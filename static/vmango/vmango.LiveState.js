@@ -0,0 +1,47 @@
+(function(exports){
+    exports.Vmango = exports.Vmango || {};
+    exports.Vmango.LiveState = function(el){
+        var $el = $(el),
+            url = $el.data('url');
+
+        if (!exports.EventSource || !url) {
+            return;
+        }
+
+        var matching = function(attr, key){
+            return $el.find('[' + attr + ']').filter(function(){
+                return $(this).attr(attr) === key;
+            });
+        };
+
+        var source = new EventSource(url);
+        source.addEventListener('vm_state_changed', function(message){
+            var event = JSON.parse(message.data),
+                key = event.node + '/' + event.id,
+                vm = event.vm;
+
+            matching('data-vm-state', key).each(function(idx, cell){
+                var $cell = $(cell);
+                if (!vm) {
+                    $cell.text('undefined');
+                    return;
+                }
+                $cell.text(vm.state);
+                if (vm.state_reason) {
+                    $cell.append(' ', $('<span class="text-muted"></span>').text('(' + vm.state_reason + ')'));
+                }
+            });
+            matching('data-vm-ips', key).each(function(idx, cell){
+                var $cell = $(cell),
+                    mac = $cell.data('mac'),
+                    addresses = [];
+                $.each(vm ? vm.interfaces || [] : [], function(idx, iface){
+                    if (!mac || iface.mac === mac) {
+                        addresses = addresses.concat(iface.ip_addresses || []);
+                    }
+                });
+                $cell.text(addresses.join(' '));
+            });
+        });
+    }
+})(window);
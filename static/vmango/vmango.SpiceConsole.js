@@ -0,0 +1,1140 @@
+(function(exports){
+    exports.Vmango = exports.Vmango || {};
+
+    // Minimal SPICE client. Every channel is a separate websocket proxied
+    // to machine graphic port. Server is asked not to compress images, so
+    // only raw bitmaps and mjpeg video streams have to be decoded.
+    var LINK_MAGIC = 0x51444552,
+        LINK_ERRORS = [
+            "ok", "error", "invalid magic", "invalid data", "version mismatch",
+            "tls required", "plain connection required", "permission denied (password protected console?)",
+            "bad connection id", "channel not available"
+        ],
+        TICKET_SIZE = 128,
+        COMMON_CAP_MINI_HEADER = 3,
+        DISPLAY_CAP_PREF_COMPRESSION = 6,
+        IMAGE_COMPRESSION_OFF = 1,
+        PIXMAP_CACHE_SIZE = 16 * 1024 * 1024,
+        GLZ_WINDOW_SIZE = 1024 * 1024,
+        MOTION_ACK_BUNCH = 4;
+
+    var CHANNEL_MAIN = 1,
+        CHANNEL_DISPLAY = 2,
+        CHANNEL_INPUTS = 3,
+        CHANNEL_CURSOR = 4;
+
+    var MSG_SET_ACK = 3,
+        MSG_PING = 4,
+        MSGC_ACK_SYNC = 1,
+        MSGC_ACK = 2,
+        MSGC_PONG = 3;
+
+    var MSG_MAIN_INIT = 103,
+        MSG_MAIN_CHANNELS_LIST = 104,
+        MSG_MAIN_MOUSE_MODE = 105,
+        MSGC_MAIN_ATTACH_CHANNELS = 104,
+        MSGC_MAIN_MOUSE_MODE_REQUEST = 105,
+        MOUSE_MODE_SERVER = 1,
+        MOUSE_MODE_CLIENT = 2;
+
+    var MSG_DISPLAY_INVAL_LIST = 105,
+        MSG_DISPLAY_INVAL_ALL_PIXMAPS = 106,
+        MSG_DISPLAY_INVAL_PALETTE = 107,
+        MSG_DISPLAY_INVAL_ALL_PALETTES = 108,
+        MSG_DISPLAY_COPY_BITS = 104,
+        MSG_DISPLAY_STREAM_CREATE = 122,
+        MSG_DISPLAY_STREAM_DATA = 123,
+        MSG_DISPLAY_STREAM_CLIP = 124,
+        MSG_DISPLAY_STREAM_DESTROY = 125,
+        MSG_DISPLAY_STREAM_DESTROY_ALL = 126,
+        MSG_DISPLAY_DRAW_FILL = 302,
+        MSG_DISPLAY_DRAW_OPAQUE = 303,
+        MSG_DISPLAY_DRAW_COPY = 304,
+        MSG_DISPLAY_DRAW_BLEND = 305,
+        MSG_DISPLAY_DRAW_BLACKNESS = 306,
+        MSG_DISPLAY_DRAW_WHITENESS = 307,
+        MSG_DISPLAY_SURFACE_CREATE = 314,
+        MSG_DISPLAY_SURFACE_DESTROY = 315,
+        MSGC_DISPLAY_INIT = 101,
+        MSGC_DISPLAY_PREFERRED_COMPRESSION = 103;
+
+    var MSG_INPUTS_MOUSE_MOTION_ACK = 111,
+        MSGC_INPUTS_KEY_DOWN = 101,
+        MSGC_INPUTS_KEY_UP = 102,
+        MSGC_INPUTS_MOUSE_MOTION = 111,
+        MSGC_INPUTS_MOUSE_POSITION = 112,
+        MSGC_INPUTS_MOUSE_PRESS = 113,
+        MSGC_INPUTS_MOUSE_RELEASE = 114;
+
+    var MSG_CURSOR_INIT = 101,
+        MSG_CURSOR_RESET = 102,
+        MSG_CURSOR_SET = 103,
+        MSG_CURSOR_MOVE = 104,
+        MSG_CURSOR_HIDE = 105,
+        MSG_CURSOR_INVAL_ONE = 107,
+        MSG_CURSOR_INVAL_ALL = 108,
+        CURSOR_FLAGS_NONE = 1,
+        CURSOR_FLAGS_CACHE_ME = 2,
+        CURSOR_FLAGS_FROM_CACHE = 4,
+        CURSOR_TYPE_ALPHA = 0,
+        CURSOR_TYPE_MONO = 1;
+
+    var CLIP_TYPE_RECTS = 1,
+        SURFACE_FLAGS_PRIMARY = 1,
+        SURFACE_FMT_16_555 = 16,
+        BRUSH_SOLID = 1,
+        BRUSH_PATTERN = 2,
+        ROPD_INVERS_BRUSH = 2,
+        ROPD_OP_PUT = 8,
+        STREAM_FLAGS_TOP_DOWN = 1,
+        VIDEO_CODEC_MJPEG = 1;
+
+    var IMAGE_TYPE_BITMAP = 0,
+        IMAGE_TYPE_FROM_CACHE = 103,
+        IMAGE_TYPE_SURFACE = 104,
+        IMAGE_TYPE_FROM_CACHE_LOSSLESS = 106,
+        IMAGE_FLAGS_CACHE_ME = 1,
+        BITMAP_FLAGS_PAL_CACHE_ME = 1,
+        BITMAP_FLAGS_PAL_FROM_CACHE = 2,
+        BITMAP_FLAGS_TOP_DOWN = 4,
+        BITMAP_FMT_1BIT_LE = 1,
+        BITMAP_FMT_1BIT_BE = 2,
+        BITMAP_FMT_4BIT_LE = 3,
+        BITMAP_FMT_4BIT_BE = 4,
+        BITMAP_FMT_8BIT = 5,
+        BITMAP_FMT_16BIT = 6,
+        BITMAP_FMT_24BIT = 7,
+        BITMAP_FMT_32BIT = 8,
+        BITMAP_FMT_RGBA = 9;
+
+    var MOUSE_BUTTONS = {0: 1, 1: 2, 2: 3},
+        MOUSE_BUTTON_UP = 4,
+        MOUSE_BUTTON_DOWN = 5;
+
+    // PC AT set 1 scancodes, 0x100 marks extended (0xe0 prefixed) keys.
+    var SCANCODES = {
+        Escape: 0x01, Digit1: 0x02, Digit2: 0x03, Digit3: 0x04, Digit4: 0x05, Digit5: 0x06,
+        Digit6: 0x07, Digit7: 0x08, Digit8: 0x09, Digit9: 0x0a, Digit0: 0x0b, Minus: 0x0c,
+        Equal: 0x0d, Backspace: 0x0e, Tab: 0x0f, KeyQ: 0x10, KeyW: 0x11, KeyE: 0x12, KeyR: 0x13,
+        KeyT: 0x14, KeyY: 0x15, KeyU: 0x16, KeyI: 0x17, KeyO: 0x18, KeyP: 0x19, BracketLeft: 0x1a,
+        BracketRight: 0x1b, Enter: 0x1c, ControlLeft: 0x1d, KeyA: 0x1e, KeyS: 0x1f, KeyD: 0x20,
+        KeyF: 0x21, KeyG: 0x22, KeyH: 0x23, KeyJ: 0x24, KeyK: 0x25, KeyL: 0x26, Semicolon: 0x27,
+        Quote: 0x28, Backquote: 0x29, ShiftLeft: 0x2a, Backslash: 0x2b, KeyZ: 0x2c, KeyX: 0x2d,
+        KeyC: 0x2e, KeyV: 0x2f, KeyB: 0x30, KeyN: 0x31, KeyM: 0x32, Comma: 0x33, Period: 0x34,
+        Slash: 0x35, ShiftRight: 0x36, NumpadMultiply: 0x37, AltLeft: 0x38, Space: 0x39,
+        CapsLock: 0x3a, F1: 0x3b, F2: 0x3c, F3: 0x3d, F4: 0x3e, F5: 0x3f, F6: 0x40, F7: 0x41,
+        F8: 0x42, F9: 0x43, F10: 0x44, NumLock: 0x45, ScrollLock: 0x46, Numpad7: 0x47,
+        Numpad8: 0x48, Numpad9: 0x49, NumpadSubtract: 0x4a, Numpad4: 0x4b, Numpad5: 0x4c,
+        Numpad6: 0x4d, NumpadAdd: 0x4e, Numpad1: 0x4f, Numpad2: 0x50, Numpad3: 0x51,
+        Numpad0: 0x52, NumpadDecimal: 0x53, IntlBackslash: 0x56, F11: 0x57, F12: 0x58,
+        NumpadEnter: 0x11c, ControlRight: 0x11d, NumpadDivide: 0x135, PrintScreen: 0x137,
+        AltRight: 0x138, Home: 0x147, ArrowUp: 0x148, PageUp: 0x149, ArrowLeft: 0x14b,
+        ArrowRight: 0x14d, End: 0x14f, ArrowDown: 0x150, PageDown: 0x151, Insert: 0x152,
+        Delete: 0x153, MetaLeft: 0x15b, MetaRight: 0x15c, ContextMenu: 0x15d
+    };
+
+    var FIELD_SIZES = {u8: 1, u16: 2, u32: 4, i32: 4, u64: 8};
+
+    // pack(["u32", 1, "u8", 2]) encodes little endian message payload.
+    function pack(fields){
+        var size = 0, at = 0, i;
+        for (i = 0; i < fields.length; i += 2) {
+            size += FIELD_SIZES[fields[i]];
+        }
+        var buf = new Uint8Array(size),
+            dv = new DataView(buf.buffer);
+        for (i = 0; i < fields.length; i += 2) {
+            var value = fields[i + 1];
+            switch (fields[i]) {
+            case "u8":
+                dv.setUint8(at, value);
+                break;
+            case "u16":
+                dv.setUint16(at, value, true);
+                break;
+            case "u32":
+                dv.setUint32(at, value, true);
+                break;
+            case "i32":
+                dv.setInt32(at, value, true);
+                break;
+            case "u64":
+                dv.setUint32(at, value % 0x100000000, true);
+                dv.setUint32(at + 4, Math.floor(value / 0x100000000), true);
+                break;
+            }
+            at += FIELD_SIZES[fields[i]];
+        }
+        return buf;
+    }
+
+    function hasCap(caps, cap){
+        return ((caps[cap >> 5] || 0) & (1 << (cap & 31))) !== 0;
+    }
+
+    function u64key(dv, at){
+        return dv.getUint32(at + 4, true) + ":" + dv.getUint32(at, true);
+    }
+
+    function readRect(dv, at){
+        return {
+            top: dv.getInt32(at, true),
+            left: dv.getInt32(at + 4, true),
+            bottom: dv.getInt32(at + 8, true),
+            right: dv.getInt32(at + 12, true)
+        };
+    }
+
+    function readClip(dv, at){
+        var rects = null;
+        if (dv.getUint8(at) === CLIP_TYPE_RECTS) {
+            var count = dv.getUint32(at + 1, true);
+            at += 5;
+            rects = [];
+            for (var i = 0; i < count; i++, at += 16) {
+                rects.push(readRect(dv, at));
+            }
+        } else {
+            at += 1;
+        }
+        return {rects: rects, end: at};
+    }
+
+    // DisplayBase starts every draw message.
+    function readBase(dv){
+        var clip = readClip(dv, 20);
+        return {surface: dv.getUint32(0, true), box: readRect(dv, 4), clip: clip.rects, end: clip.end};
+    }
+
+    function rectWidth(rect){
+        return rect.right - rect.left;
+    }
+
+    function rectHeight(rect){
+        return rect.bottom - rect.top;
+    }
+
+    function newCanvas(width, height){
+        var canvas = document.createElement("canvas");
+        canvas.width = width;
+        canvas.height = height;
+        return canvas;
+    }
+
+    // ByteQueue collects websocket frames until whole message is received,
+    // so large bitmaps are copied only once.
+    function ByteQueue(){
+        this.chunks = [];
+        this.length = 0;
+    }
+
+    ByteQueue.prototype.push = function(chunk){
+        if (chunk.length > 0) {
+            this.chunks.push(chunk);
+            this.length += chunk.length;
+        }
+    };
+
+    ByteQueue.prototype.shift = function(size){
+        var out = new Uint8Array(size), pos = 0;
+        while (pos < size) {
+            var chunk = this.chunks[0],
+                take = Math.min(chunk.length, size - pos);
+            out.set(chunk.subarray(0, take), pos);
+            pos += take;
+            if (take === chunk.length) {
+                this.chunks.shift();
+            } else {
+                this.chunks[0] = chunk.subarray(take);
+            }
+        }
+        this.length -= size;
+        return out;
+    };
+
+    // Channel links to server and passes messages to handler, which has
+    // ready(channel), message(channel, type, dataview) and close(channel, error).
+    function Channel(url, type, id, connectionId, handler){
+        var channel = this;
+        this.type = type;
+        this.id = id;
+        this.connectionId = connectionId;
+        this.handler = handler;
+        this.queue = new ByteQueue();
+        this.state = "link";
+        this.need = 16;
+        this.connected = false;
+        this.miniHeader = false;
+        this.serial = 0;
+        this.ackWindow = 0;
+        this.ackCount = 0;
+        this.serverCaps = [];
+        this.serverChannelCaps = [];
+        this.error = null;
+        this.socket = new WebSocket(url);
+        this.socket.binaryType = "arraybuffer";
+        this.socket.onopen = function(){
+            var message = pack([
+                "u32", channel.connectionId, "u8", channel.type, "u8", channel.id,
+                "u32", 1, "u32", 0, "u32", 18, "u32", 1 << COMMON_CAP_MINI_HEADER
+            ]);
+            channel.socket.send(pack(["u32", LINK_MAGIC, "u32", 2, "u32", 2, "u32", message.length]));
+            channel.socket.send(message);
+        };
+        this.socket.onmessage = function(event){
+            channel.queue.push(new Uint8Array(event.data));
+            channel.receive();
+        };
+        this.socket.onclose = function(){
+            channel.connected = false;
+            channel.handler.close(channel, channel.error);
+        };
+    }
+
+    Channel.prototype.fail = function(error){
+        this.error = error;
+        this.socket.close();
+    };
+
+    Channel.prototype.close = function(){
+        this.socket.close();
+    };
+
+    Channel.prototype.receive = function(){
+        while (this.state !== "failed" && this.queue.length >= this.need) {
+            var data = this.queue.shift(this.need),
+                dv = new DataView(data.buffer),
+                error;
+            switch (this.state) {
+            case "link":
+                if (dv.getUint32(0, true) !== LINK_MAGIC) {
+                    this.state = "failed";
+                    this.fail("not a spice server");
+                    return;
+                }
+                this.state = "link-reply";
+                this.need = dv.getUint32(12, true);
+                break;
+            case "link-reply":
+                error = dv.getUint32(0, true);
+                if (error) {
+                    this.state = "failed";
+                    this.fail(LINK_ERRORS[error] || "link error " + error);
+                    return;
+                }
+                var commonCaps = dv.getUint32(166, true),
+                    channelCaps = dv.getUint32(170, true),
+                    at = dv.getUint32(174, true);
+                for (var i = 0; i < commonCaps; i++, at += 4) {
+                    this.serverCaps.push(dv.getUint32(at, true));
+                }
+                for (i = 0; i < channelCaps; i++, at += 4) {
+                    this.serverChannelCaps.push(dv.getUint32(at, true));
+                }
+                this.miniHeader = hasCap(this.serverCaps, COMMON_CAP_MINI_HEADER);
+                // vmango doesn't set console passwords, server has ticketing
+                // disabled and ignores the ticket
+                this.socket.send(new Uint8Array(TICKET_SIZE));
+                this.state = "link-result";
+                this.need = 4;
+                break;
+            case "link-result":
+                error = dv.getUint32(0, true);
+                if (error) {
+                    this.state = "failed";
+                    this.fail(LINK_ERRORS[error] || "link error " + error);
+                    return;
+                }
+                this.state = "header";
+                this.need = this.miniHeader ? 6 : 18;
+                this.connected = true;
+                this.handler.ready(this);
+                break;
+            case "header":
+                if (this.miniHeader) {
+                    this.messageType = dv.getUint16(0, true);
+                    this.need = dv.getUint32(2, true);
+                } else {
+                    this.messageType = dv.getUint16(8, true);
+                    this.need = dv.getUint32(10, true);
+                }
+                this.state = "body";
+                break;
+            case "body":
+                this.state = "header";
+                this.need = this.miniHeader ? 6 : 18;
+                this.dispatch(this.messageType, dv);
+                break;
+            }
+        }
+    };
+
+    Channel.prototype.dispatch = function(type, dv){
+        if (this.ackWindow > 0 && ++this.ackCount >= this.ackWindow) {
+            this.ackCount = 0;
+            this.send(MSGC_ACK);
+        }
+        switch (type) {
+        case MSG_SET_ACK:
+            this.ackWindow = dv.getUint32(4, true);
+            this.ackCount = 0;
+            this.send(MSGC_ACK_SYNC, pack(["u32", dv.getUint32(0, true)]));
+            return;
+        case MSG_PING:
+            this.send(MSGC_PONG, new Uint8Array(dv.buffer, 0, 12));
+            return;
+        }
+        try {
+            this.handler.message(this, type, dv);
+        } catch (err) {
+            console.error("spice channel", this.type, "message", type, err);
+        }
+    };
+
+    Channel.prototype.send = function(type, payload){
+        if (this.socket.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        payload = payload || new Uint8Array(0);
+        var headerSize = this.miniHeader ? 6 : 18,
+            message = new Uint8Array(headerSize + payload.length),
+            dv = new DataView(message.buffer);
+        if (this.miniHeader) {
+            dv.setUint16(0, type, true);
+            dv.setUint32(2, payload.length, true);
+        } else {
+            this.serial++;
+            dv.setUint32(0, this.serial, true);
+            dv.setUint16(8, type, true);
+            dv.setUint32(10, payload.length, true);
+        }
+        message.set(payload, headerSize);
+        this.socket.send(message);
+    };
+
+    exports.Vmango.SpiceConsole = function(el){
+        var loc = window.location,
+            $consoleEl = $(el),
+            $statusEl = $consoleEl.find('.JS-SpiceConsole-Status'),
+            $warningEl = $consoleEl.find('.JS-SpiceConsole-Warning'),
+            $screenEl = $consoleEl.find('.JS-SpiceConsole-Screen'),
+            $ctrlAltDelEl = $consoleEl.find('.JS-SpiceConsole-CtrlAltDel'),
+            cursorCanvas = $consoleEl.find('.JS-SpiceConsole-Cursor')[0],
+            wsUri = (loc.protocol === "https:" ? "wss:" : "ws:") + "//" + loc.host + $consoleEl.attr('data-SpiceConsole-WSUrl'),
+            channels = [],
+            inputs = null,
+            mouseMode = MOUSE_MODE_SERVER,
+            surfaces = {},
+            primary = null,
+            images = {},
+            palettes = {},
+            streams = {},
+            cursors = {},
+            cursor = null,
+            cursorX = 0,
+            cursorY = 0,
+            buttons = 0,
+            pointerX = 0,
+            pointerY = 0,
+            pointerDx = 0,
+            pointerDy = 0,
+            pointerMoved = false,
+            motionPending = 0,
+            pressedKeys = {},
+            warnings = {};
+
+        function status(text){
+            $statusEl.text(text);
+        }
+
+        // warnOnce shows what client cannot display next to the status,
+        // the latest warning is shown and all of them are in the tooltip.
+        function warnOnce(text){
+            if (!warnings[text]) {
+                warnings[text] = true;
+                console.warn("spice:", text);
+                $warningEl.text("Warning: " + text).attr("title", Object.keys(warnings).join("\n"));
+            }
+        }
+
+        function open(type, id, connectionId, handler){
+            var channel = new Channel(wsUri, type, id, connectionId, handler);
+            channels.push(channel);
+            return channel;
+        }
+
+        function showStatus(){
+            if (mouseMode === MOUSE_MODE_CLIENT) {
+                status("Connected");
+            } else {
+                status("Connected, click the screen to capture mouse, Esc releases it");
+            }
+        }
+
+        function setMouseMode(mode){
+            mouseMode = mode;
+            if (mouseMode === MOUSE_MODE_CLIENT && document.pointerLockElement === $screenEl[0]) {
+                document.exitPointerLock();
+            }
+            showStatus();
+            showCursor();
+        }
+
+        var mainHandler = {
+            ready: function(){
+                status("Connected, waiting for display...");
+            },
+            message: function(channel, type, dv){
+                switch (type) {
+                case MSG_MAIN_INIT:
+                    var sessionId = dv.getUint32(0, true);
+                    channel.sessionId = sessionId;
+                    setMouseMode(dv.getUint32(12, true));
+                    channel.send(MSGC_MAIN_ATTACH_CHANNELS);
+                    if ((dv.getUint32(8, true) & MOUSE_MODE_CLIENT) && mouseMode !== MOUSE_MODE_CLIENT) {
+                        channel.send(MSGC_MAIN_MOUSE_MODE_REQUEST, pack(["u16", MOUSE_MODE_CLIENT]));
+                    }
+                    break;
+                case MSG_MAIN_CHANNELS_LIST:
+                    var count = dv.getUint32(0, true);
+                    for (var i = 0; i < count; i++) {
+                        var channelType = dv.getUint8(4 + i * 2),
+                            channelId = dv.getUint8(5 + i * 2);
+                        if (channelId !== 0) {
+                            continue;
+                        }
+                        switch (channelType) {
+                        case CHANNEL_DISPLAY:
+                            open(channelType, channelId, channel.sessionId, displayHandler);
+                            break;
+                        case CHANNEL_INPUTS:
+                            inputs = open(channelType, channelId, channel.sessionId, inputsHandler);
+                            break;
+                        case CHANNEL_CURSOR:
+                            open(channelType, channelId, channel.sessionId, cursorHandler);
+                            break;
+                        }
+                    }
+                    break;
+                case MSG_MAIN_MOUSE_MODE:
+                    setMouseMode(dv.getUint16(2, true));
+                    break;
+                }
+            },
+            close: function(channel, error){
+                status(error ? "Disconnected: " + error : "Disconnected, reload page to reconnect");
+                channels.forEach(function(other){
+                    other.close();
+                });
+                if (document.pointerLockElement === $screenEl[0]) {
+                    document.exitPointerLock();
+                }
+            }
+        };
+
+        // Display
+
+        function draw(surfaceId, clip, fn){
+            var surface = surfaces[surfaceId];
+            if (!surface) {
+                return;
+            }
+            var ctx = surface.ctx;
+            ctx.save();
+            if (clip) {
+                ctx.beginPath();
+                clip.forEach(function(rect){
+                    ctx.rect(rect.left, rect.top, rectWidth(rect), rectHeight(rect));
+                });
+                ctx.clip();
+            }
+            fn(ctx);
+            ctx.restore();
+        }
+
+        function color(surfaceId, value){
+            var surface = surfaces[surfaceId], r, g, b;
+            if (surface && surface.format === SURFACE_FMT_16_555) {
+                r = (value >> 10) & 0x1f;
+                g = (value >> 5) & 0x1f;
+                b = value & 0x1f;
+                return "rgb(" + (r << 3 | r >> 2) + "," + (g << 3 | g >> 2) + "," + (b << 3 | b >> 2) + ")";
+            }
+            return "rgb(" + ((value >> 16) & 0xff) + "," + ((value >> 8) & 0xff) + "," + (value & 0xff) + ")";
+        }
+
+        function readPalette(dv, at){
+            var count = dv.getUint16(at + 8, true), entries = [];
+            for (var i = 0; i < count; i++) {
+                entries.push(dv.getUint32(at + 10 + i * 4, true));
+            }
+            return entries;
+        }
+
+        function decodeBitmap(dv, at){
+            var format = dv.getUint8(at),
+                flags = dv.getUint8(at + 1),
+                width = dv.getUint32(at + 2, true),
+                height = dv.getUint32(at + 6, true),
+                stride = dv.getUint32(at + 10, true),
+                palette = null;
+            at += 14;
+            if (flags & BITMAP_FLAGS_PAL_FROM_CACHE) {
+                palette = palettes[u64key(dv, at)];
+                at += 8;
+            } else {
+                var paletteAt = dv.getUint32(at, true);
+                at += 4;
+                if (paletteAt) {
+                    palette = readPalette(dv, paletteAt);
+                    if (flags & BITMAP_FLAGS_PAL_CACHE_ME) {
+                        palettes[u64key(dv, paletteAt)] = palette;
+                    }
+                }
+            }
+            if (format < BITMAP_FMT_16BIT && !palette) {
+                warnOnce("bitmap palette is missing");
+                return null;
+            }
+            var canvas = newCanvas(width, height),
+                ctx = canvas.getContext("2d"),
+                image = ctx.createImageData(width, height),
+                src = new Uint8Array(dv.buffer, at, stride * height),
+                dst = image.data,
+                out = 0;
+            for (var y = 0; y < height; y++) {
+                var row = (flags & BITMAP_FLAGS_TOP_DOWN ? y : height - 1 - y) * stride;
+                for (var x = 0; x < width; x++, out += 4) {
+                    var value = 0, pos;
+                    switch (format) {
+                    case BITMAP_FMT_32BIT:
+                    case BITMAP_FMT_RGBA:
+                        pos = row + x * 4;
+                        dst[out] = src[pos + 2];
+                        dst[out + 1] = src[pos + 1];
+                        dst[out + 2] = src[pos];
+                        dst[out + 3] = format === BITMAP_FMT_RGBA ? src[pos + 3] : 255;
+                        continue;
+                    case BITMAP_FMT_24BIT:
+                        pos = row + x * 3;
+                        dst[out] = src[pos + 2];
+                        dst[out + 1] = src[pos + 1];
+                        dst[out + 2] = src[pos];
+                        dst[out + 3] = 255;
+                        continue;
+                    case BITMAP_FMT_16BIT:
+                        pos = row + x * 2;
+                        value = src[pos] | src[pos + 1] << 8;
+                        dst[out] = (value >> 7) & 0xf8 | (value >> 12) & 0x7;
+                        dst[out + 1] = (value >> 2) & 0xf8 | (value >> 7) & 0x7;
+                        dst[out + 2] = (value << 3) & 0xf8 | (value >> 2) & 0x7;
+                        dst[out + 3] = 255;
+                        continue;
+                    case BITMAP_FMT_8BIT:
+                        value = palette[src[row + x]];
+                        break;
+                    case BITMAP_FMT_4BIT_BE:
+                        value = palette[x & 1 ? src[row + (x >> 1)] & 0xf : src[row + (x >> 1)] >> 4];
+                        break;
+                    case BITMAP_FMT_4BIT_LE:
+                        value = palette[x & 1 ? src[row + (x >> 1)] >> 4 : src[row + (x >> 1)] & 0xf];
+                        break;
+                    case BITMAP_FMT_1BIT_BE:
+                        value = palette[(src[row + (x >> 3)] >> (7 - (x & 7))) & 1];
+                        break;
+                    case BITMAP_FMT_1BIT_LE:
+                        value = palette[(src[row + (x >> 3)] >> (x & 7)) & 1];
+                        break;
+                    default:
+                        warnOnce("unsupported bitmap format " + format);
+                        return null;
+                    }
+                    value = value || 0;
+                    dst[out] = (value >> 16) & 0xff;
+                    dst[out + 1] = (value >> 8) & 0xff;
+                    dst[out + 2] = value & 0xff;
+                    dst[out + 3] = 255;
+                }
+            }
+            ctx.putImageData(image, 0, 0);
+            return canvas;
+        }
+
+        // readImage returns canvas with image or null if it cannot be decoded.
+        function readImage(dv, at){
+            if (!at) {
+                return null;
+            }
+            var id = u64key(dv, at),
+                type = dv.getUint8(at + 8),
+                flags = dv.getUint8(at + 9),
+                image = null;
+            at += 18;
+            switch (type) {
+            case IMAGE_TYPE_BITMAP:
+                image = decodeBitmap(dv, at);
+                break;
+            case IMAGE_TYPE_FROM_CACHE:
+            case IMAGE_TYPE_FROM_CACHE_LOSSLESS:
+                image = images[id] || null;
+                break;
+            case IMAGE_TYPE_SURFACE:
+                var surface = surfaces[dv.getUint32(at, true)];
+                image = surface ? surface.canvas : null;
+                break;
+            default:
+                warnOnce("compressed image type " + type + " cannot be decoded, screen may be incomplete");
+            }
+            if (image && type !== IMAGE_TYPE_SURFACE && (flags & IMAGE_FLAGS_CACHE_ME)) {
+                images[id] = image;
+            }
+            return image;
+        }
+
+        function drawCopy(dv){
+            var base = readBase(dv),
+                image = readImage(dv, dv.getUint32(base.end, true)),
+                src = readRect(dv, base.end + 4),
+                box = base.box;
+            if (!image) {
+                return;
+            }
+            draw(base.surface, base.clip, function(ctx){
+                ctx.drawImage(image, src.left, src.top, rectWidth(src), rectHeight(src),
+                    box.left, box.top, rectWidth(box), rectHeight(box));
+            });
+        }
+
+        function drawFill(dv){
+            var base = readBase(dv),
+                at = base.end,
+                brush = dv.getUint8(at),
+                box = base.box,
+                style = null,
+                rop = 0;
+            if (brush === BRUSH_SOLID) {
+                var value = dv.getUint32(at + 1, true);
+                rop = dv.getUint16(at + 5, true);
+                style = color(base.surface, rop & ROPD_INVERS_BRUSH ? value ^ 0xffffff : value);
+            } else if (brush === BRUSH_PATTERN) {
+                var pattern = readImage(dv, dv.getUint32(at + 1, true));
+                rop = dv.getUint16(at + 13, true);
+                if (pattern && surfaces[base.surface]) {
+                    style = surfaces[base.surface].ctx.createPattern(pattern, "repeat");
+                }
+            }
+            if (!style || !(rop & ROPD_OP_PUT)) {
+                return;
+            }
+            draw(base.surface, base.clip, function(ctx){
+                ctx.fillStyle = style;
+                ctx.fillRect(box.left, box.top, rectWidth(box), rectHeight(box));
+            });
+        }
+
+        function drawSolid(dv, style){
+            var base = readBase(dv), box = base.box;
+            draw(base.surface, base.clip, function(ctx){
+                ctx.fillStyle = style;
+                ctx.fillRect(box.left, box.top, rectWidth(box), rectHeight(box));
+            });
+        }
+
+        function copyBits(dv){
+            var base = readBase(dv),
+                box = base.box,
+                x = dv.getInt32(base.end, true),
+                y = dv.getInt32(base.end + 4, true);
+            draw(base.surface, base.clip, function(ctx){
+                ctx.drawImage(ctx.canvas, x, y, rectWidth(box), rectHeight(box),
+                    box.left, box.top, rectWidth(box), rectHeight(box));
+            });
+        }
+
+        function createSurface(dv){
+            var id = dv.getUint32(0, true),
+                canvas = newCanvas(dv.getUint32(4, true), dv.getUint32(8, true));
+            surfaces[id] = {canvas: canvas, ctx: canvas.getContext("2d"), format: dv.getUint32(12, true)};
+            if (dv.getUint32(16, true) & SURFACE_FLAGS_PRIMARY) {
+                if (primary) {
+                    $(primary).remove();
+                }
+                primary = canvas;
+                $screenEl.prepend(canvas);
+                showStatus();
+            }
+        }
+
+        function streamFrame(dv){
+            var stream = streams[dv.getUint32(0, true)];
+            if (!stream) {
+                return;
+            }
+            if (stream.codec !== VIDEO_CODEC_MJPEG || !exports.createImageBitmap) {
+                warnOnce("unsupported video stream codec " + stream.codec);
+                return;
+            }
+            var data = new Blob([new Uint8Array(dv.buffer, 12, dv.getUint32(8, true))], {type: "image/jpeg"});
+            // frames are decoded asynchronously, chain keeps them in order
+            stream.frames = stream.frames.then(function(){
+                return exports.createImageBitmap(data);
+            }).then(function(frame){
+                var dest = stream.dest;
+                draw(stream.surface, stream.clip, function(ctx){
+                    if (!(stream.flags & STREAM_FLAGS_TOP_DOWN)) {
+                        ctx.translate(0, dest.top + dest.bottom);
+                        ctx.scale(1, -1);
+                    }
+                    ctx.drawImage(frame, dest.left, dest.top, rectWidth(dest), rectHeight(dest));
+                });
+            }).catch(function(err){
+                warnOnce("cannot decode video frame: " + err);
+            });
+        }
+
+        var displayHandler = {
+            ready: function(channel){
+                // must be sent before init, server starts sending screen right after it
+                if (hasCap(channel.serverChannelCaps, DISPLAY_CAP_PREF_COMPRESSION)) {
+                    channel.send(MSGC_DISPLAY_PREFERRED_COMPRESSION, pack(["u8", IMAGE_COMPRESSION_OFF]));
+                } else {
+                    warnOnce("server cannot disable image compression, screen may be incomplete");
+                }
+                channel.send(MSGC_DISPLAY_INIT, pack(["u8", 1, "u64", PIXMAP_CACHE_SIZE, "u8", 0, "i32", GLZ_WINDOW_SIZE]));
+            },
+            message: function(channel, type, dv){
+                var i;
+                switch (type) {
+                case MSG_DISPLAY_SURFACE_CREATE:
+                    createSurface(dv);
+                    break;
+                case MSG_DISPLAY_SURFACE_DESTROY:
+                    delete surfaces[dv.getUint32(0, true)];
+                    break;
+                case MSG_DISPLAY_DRAW_COPY:
+                case MSG_DISPLAY_DRAW_OPAQUE:
+                case MSG_DISPLAY_DRAW_BLEND:
+                    drawCopy(dv);
+                    break;
+                case MSG_DISPLAY_DRAW_FILL:
+                    drawFill(dv);
+                    break;
+                case MSG_DISPLAY_DRAW_BLACKNESS:
+                    drawSolid(dv, "#000");
+                    break;
+                case MSG_DISPLAY_DRAW_WHITENESS:
+                    drawSolid(dv, "#fff");
+                    break;
+                case MSG_DISPLAY_COPY_BITS:
+                    copyBits(dv);
+                    break;
+                case MSG_DISPLAY_INVAL_LIST:
+                    for (i = 0; i < dv.getUint16(0, true); i++) {
+                        delete images[u64key(dv, 2 + i * 9 + 1)];
+                    }
+                    break;
+                case MSG_DISPLAY_INVAL_ALL_PIXMAPS:
+                    images = {};
+                    break;
+                case MSG_DISPLAY_INVAL_PALETTE:
+                    delete palettes[u64key(dv, 0)];
+                    break;
+                case MSG_DISPLAY_INVAL_ALL_PALETTES:
+                    palettes = {};
+                    break;
+                case MSG_DISPLAY_STREAM_CREATE:
+                    streams[dv.getUint32(4, true)] = {
+                        surface: dv.getUint32(0, true),
+                        flags: dv.getUint8(8),
+                        codec: dv.getUint8(9),
+                        dest: readRect(dv, 34),
+                        clip: readClip(dv, 50).rects,
+                        frames: Promise.resolve()
+                    };
+                    break;
+                case MSG_DISPLAY_STREAM_DATA:
+                    streamFrame(dv);
+                    break;
+                case MSG_DISPLAY_STREAM_CLIP:
+                    if (streams[dv.getUint32(0, true)]) {
+                        streams[dv.getUint32(0, true)].clip = readClip(dv, 4).rects;
+                    }
+                    break;
+                case MSG_DISPLAY_STREAM_DESTROY:
+                    delete streams[dv.getUint32(0, true)];
+                    break;
+                case MSG_DISPLAY_STREAM_DESTROY_ALL:
+                    streams = {};
+                    break;
+                }
+            },
+            close: function(channel, error){
+                if (error) {
+                    status("Display disconnected: " + error);
+                }
+            }
+        };
+
+        // Inputs
+
+        function sendKey(code, down){
+            if (!inputs || !inputs.connected) {
+                return;
+            }
+            var scancode = SCANCODES[code], value;
+            if (!scancode) {
+                return;
+            }
+            if (scancode < 0x100) {
+                value = down ? scancode : scancode | 0x80;
+            } else {
+                value = (down ? 0xe0 : 0x80e0) | (scancode & 0xff) << 8;
+            }
+            inputs.send(down ? MSGC_INPUTS_KEY_DOWN : MSGC_INPUTS_KEY_UP, pack(["u32", value]));
+            if (down) {
+                pressedKeys[code] = true;
+            } else {
+                delete pressedKeys[code];
+            }
+        }
+
+        function releaseKeys(){
+            Object.keys(pressedKeys).forEach(function(code){
+                sendKey(code, false);
+            });
+        }
+
+        // sendPointer sends pending mouse movement unless server hasn't
+        // acknowledged previous ones yet, it is flushed again on ack.
+        function sendPointer(force){
+            if (!inputs || !inputs.connected || (!force && motionPending >= MOTION_ACK_BUNCH * 2)) {
+                return;
+            }
+            if (mouseMode === MOUSE_MODE_CLIENT) {
+                if (!pointerMoved) {
+                    return;
+                }
+                pointerMoved = false;
+                inputs.send(MSGC_INPUTS_MOUSE_POSITION, pack(["u32", pointerX, "u32", pointerY, "u16", buttons, "u8", 0]));
+            } else {
+                if (!pointerDx && !pointerDy) {
+                    return;
+                }
+                inputs.send(MSGC_INPUTS_MOUSE_MOTION, pack(["i32", pointerDx, "i32", pointerDy, "u16", buttons]));
+                pointerDx = 0;
+                pointerDy = 0;
+            }
+            motionPending++;
+        }
+
+        function sendButton(button, down){
+            if (!inputs || !inputs.connected) {
+                return;
+            }
+            sendPointer(true);
+            inputs.send(down ? MSGC_INPUTS_MOUSE_PRESS : MSGC_INPUTS_MOUSE_RELEASE, pack(["u8", button, "u16", buttons]));
+        }
+
+        function trackPointer(event){
+            if (mouseMode === MOUSE_MODE_CLIENT) {
+                var offset = $screenEl.offset();
+                pointerX = Math.max(0, Math.round(event.pageX - offset.left));
+                pointerY = Math.max(0, Math.round(event.pageY - offset.top));
+                pointerMoved = true;
+            } else if (document.pointerLockElement === $screenEl[0]) {
+                pointerDx += event.movementX;
+                pointerDy += event.movementY;
+            }
+        }
+
+        var inputsHandler = {
+            ready: function(){},
+            message: function(channel, type){
+                if (type === MSG_INPUTS_MOUSE_MOTION_ACK) {
+                    motionPending = Math.max(0, motionPending - MOTION_ACK_BUNCH);
+                    sendPointer(false);
+                }
+            },
+            close: function(){}
+        };
+
+        // Cursor
+
+        function decodeCursor(type, width, height, data){
+            var canvas = newCanvas(width, height),
+                ctx = canvas.getContext("2d"),
+                image = ctx.createImageData(width, height),
+                dst = image.data,
+                i;
+            if (type === CURSOR_TYPE_ALPHA) {
+                for (i = 0; i < width * height; i++) {
+                    dst[i * 4] = data[i * 4 + 2];
+                    dst[i * 4 + 1] = data[i * 4 + 1];
+                    dst[i * 4 + 2] = data[i * 4];
+                    dst[i * 4 + 3] = data[i * 4 + 3];
+                }
+            } else if (type === CURSOR_TYPE_MONO) {
+                var stride = (width + 7) >> 3, xorAt = stride * height;
+                for (var y = 0; y < height; y++) {
+                    for (var x = 0; x < width; x++) {
+                        var bit = 0x80 >> (x & 7),
+                            and = data[y * stride + (x >> 3)] & bit,
+                            xor = data[xorAt + y * stride + (x >> 3)] & bit;
+                        i = (y * width + x) * 4;
+                        // transparent when and mask is set and xor isn't,
+                        // inverted pixels are shown black
+                        dst[i] = dst[i + 1] = dst[i + 2] = !and && xor ? 255 : 0;
+                        dst[i + 3] = and && !xor ? 0 : 255;
+                    }
+                }
+            } else {
+                warnOnce("unsupported cursor type " + type);
+                return null;
+            }
+            ctx.putImageData(image, 0, 0);
+            return canvas;
+        }
+
+        function setCursor(dv, at, visible){
+            var flags = dv.getUint16(at, true);
+            if (flags & CURSOR_FLAGS_NONE || !visible) {
+                cursor = null;
+                showCursor();
+                return;
+            }
+            var id = u64key(dv, at + 2);
+            if (flags & CURSOR_FLAGS_FROM_CACHE) {
+                cursor = cursors[id] || null;
+            } else {
+                var width = dv.getUint16(at + 11, true),
+                    height = dv.getUint16(at + 13, true),
+                    image = decodeCursor(dv.getUint8(at + 10), width, height, new Uint8Array(dv.buffer, at + 19));
+                cursor = image ? {
+                    image: image,
+                    url: image.toDataURL(),
+                    hotX: dv.getUint16(at + 15, true),
+                    hotY: dv.getUint16(at + 17, true)
+                } : null;
+                if (cursor && (flags & CURSOR_FLAGS_CACHE_ME)) {
+                    cursors[id] = cursor;
+                }
+            }
+            showCursor();
+        }
+
+        // In client mouse mode guest cursor replaces browser one, in server
+        // mode browser cursor is captured and guest cursor is drawn on top.
+        function showCursor(){
+            if (mouseMode === MOUSE_MODE_CLIENT) {
+                cursorCanvas.style.display = "none";
+                $screenEl.css("cursor", cursor ? "url(" + cursor.url + ") " + cursor.hotX + " " + cursor.hotY + ", default" : "none");
+                return;
+            }
+            $screenEl.css("cursor", "default");
+            if (!cursor) {
+                cursorCanvas.style.display = "none";
+                return;
+            }
+            cursorCanvas.width = cursor.image.width;
+            cursorCanvas.height = cursor.image.height;
+            cursorCanvas.getContext("2d").drawImage(cursor.image, 0, 0);
+            cursorCanvas.style.display = "block";
+            moveCursor(cursorX, cursorY);
+        }
+
+        function moveCursor(x, y){
+            cursorX = x;
+            cursorY = y;
+            if (cursor) {
+                cursorCanvas.style.left = (x - cursor.hotX) + "px";
+                cursorCanvas.style.top = (y - cursor.hotY) + "px";
+            }
+        }
+
+        var cursorHandler = {
+            ready: function(){},
+            message: function(channel, type, dv){
+                switch (type) {
+                case MSG_CURSOR_INIT:
+                    moveCursor(dv.getInt16(0, true), dv.getInt16(2, true));
+                    setCursor(dv, 9, dv.getUint8(8));
+                    break;
+                case MSG_CURSOR_SET:
+                    moveCursor(dv.getInt16(0, true), dv.getInt16(2, true));
+                    setCursor(dv, 5, dv.getUint8(4));
+                    break;
+                case MSG_CURSOR_MOVE:
+                    moveCursor(dv.getInt16(0, true), dv.getInt16(2, true));
+                    break;
+                case MSG_CURSOR_HIDE:
+                    cursor = null;
+                    showCursor();
+                    break;
+                case MSG_CURSOR_RESET:
+                case MSG_CURSOR_INVAL_ALL:
+                    cursors = {};
+                    break;
+                case MSG_CURSOR_INVAL_ONE:
+                    delete cursors[u64key(dv, 0)];
+                    break;
+                }
+            },
+            close: function(){}
+        };
+
+        $screenEl.on('keydown keyup', function(event){
+            if (!SCANCODES[event.originalEvent.code]) {
+                return;
+            }
+            event.preventDefault();
+            sendKey(event.originalEvent.code, event.type === "keydown");
+        });
+        $screenEl.on('blur', releaseKeys);
+        $screenEl.on('mousemove', function(event){
+            trackPointer(event.originalEvent);
+            sendPointer(false);
+        });
+        $screenEl.on('mousedown mouseup', function(event){
+            var button = MOUSE_BUTTONS[event.originalEvent.button];
+            event.preventDefault();
+            $screenEl.focus();
+            if (mouseMode === MOUSE_MODE_SERVER && document.pointerLockElement !== $screenEl[0]) {
+                if (event.type === "mousedown" && $screenEl[0].requestPointerLock) {
+                    $screenEl[0].requestPointerLock();
+                }
+                return;
+            }
+            if (!button) {
+                return;
+            }
+            trackPointer(event.originalEvent);
+            if (event.type === "mousedown") {
+                buttons |= 1 << (button - 1);
+            } else {
+                buttons &= ~(1 << (button - 1));
+            }
+            sendButton(button, event.type === "mousedown");
+        });
+        $screenEl.on('wheel', function(event){
+            var button = event.originalEvent.deltaY < 0 ? MOUSE_BUTTON_UP : MOUSE_BUTTON_DOWN;
+            event.preventDefault();
+            sendButton(button, true);
+            sendButton(button, false);
+        });
+        $screenEl.on('contextmenu', function(event){
+            event.preventDefault();
+        });
+        $ctrlAltDelEl.on('click', function(){
+            ["ControlLeft", "AltLeft", "Delete"].forEach(function(code){
+                sendKey(code, true);
+            });
+            ["Delete", "AltLeft", "ControlLeft"].forEach(function(code){
+                sendKey(code, false);
+            });
+            $screenEl.focus();
+        });
+
+        open(CHANNEL_MAIN, 0, 0, mainHandler);
+        $screenEl.focus();
+    };
+})(window);
@@ -4,9 +4,15 @@
         var loc = window.location,
             $consoleEl = $(el),
             $consoleWindowEl = $consoleEl.find('.JS-WSConsole-Window'),
+            $statusEl = $consoleEl.find('.JS-WSConsole-Status'),
+            $viewersEl = $consoleEl.find('.JS-WSConsole-Viewers'),
+            $takeEl = $consoleEl.find('.JS-WSConsole-Take'),
+            $releaseEl = $consoleEl.find('.JS-WSConsole-Release'),
             terminal = new Terminal(),
             decoder = new TextDecoder("utf-8", {fatal: false}),
+            encoder = new TextEncoder(),
             firstMessage = true,
+            control = false,
             socket,
             wsUri;
         if (loc.protocol === "https:") {
@@ -21,16 +27,49 @@
         terminal.fit();
         terminal.focus();
         terminal.write("Connecting...\r\n");
+        wsUri += "?cols=" + terminal.cols + "&rows=" + terminal.rows;
         socket = new WebSocket(wsUri);
         socket.binaryType = "arraybuffer";
         terminal.onData(function(data){
-            socket.send(data);
+            if (control) {
+                socket.send(encoder.encode(data));
+            }
         })
+        $takeEl.on('click', function(){
+            socket.send(JSON.stringify({action: "take"}));
+        });
+        $releaseEl.on('click', function(){
+            socket.send(JSON.stringify({action: "release"}));
+        });
+        function showStatus(status){
+            control = status.control;
+            if (status.control) {
+                $statusEl.text("You are typing");
+            } else if (status.holder) {
+                $statusEl.text("Read-only, " + status.holder + " is typing");
+            } else {
+                $statusEl.text("Read-only, nobody is typing");
+            }
+            if (status.recording) {
+                $statusEl.append(' <span class="badge badge-danger">recorded</span>');
+            }
+            if (status.error) {
+                $statusEl.append(' <span class="text-danger"></span>');
+                $statusEl.find('.text-danger').text(status.error);
+            }
+            $viewersEl.text("Viewers: " + status.viewers.join(", "));
+            $takeEl.toggle(!status.control);
+            $releaseEl.toggle(status.control);
+        }
         socket.onopen = function(){
             terminal.on();
             terminal.write("Connected! Type any key to start\r\n");
         }
         socket.onmessage = function(event){
+            if (typeof event.data === "string") {
+                showStatus(JSON.parse(event.data));
+                return;
+            }
             if (firstMessage){
                 firstMessage = false;
                 terminal.clear();
@@ -38,7 +77,10 @@
             terminal.write(decoder.decode(event.data, {stream: true}));
         }
         socket.onclose = function(){
+            control = false;
             terminal.off();
+            $takeEl.hide();
+            $releaseEl.hide();
             terminal.write("Disconnected... Try to reload page to reconnect...\r\n");
         };
     }
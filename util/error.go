@@ -19,3 +19,18 @@ func NewError(err error, msg string, args ...interface{}) Error {
 func (e Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Message, e.Original)
 }
+
+func (e Error) Cause() error {
+	return e.Original
+}
+
+// ErrorCause unwraps errors which have a cause, like Error, and returns the innermost one
+func ErrorCause(err error) error {
+	for {
+		wrapped, ok := err.(interface{ Cause() error })
+		if !ok || wrapped.Cause() == nil {
+			return err
+		}
+		err = wrapped.Cause()
+	}
+}
@@ -0,0 +1,616 @@
+package web
+
+import (
+	"fmt"
+	"subuk/vmango/compute"
+	"time"
+)
+
+type ApiError struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+type ApiSize struct {
+	Value uint64 `json:"value"`
+	Unit  string `json:"unit"`
+}
+
+func NewApiSize(size compute.Size) ApiSize {
+	return ApiSize{Value: size.Value, Unit: size.Unit.String()}
+}
+
+func (s ApiSize) Size() (compute.Size, error) {
+	unit := compute.NewSizeUnit(s.Unit)
+	if unit == compute.SizeUnitUnknown {
+		return compute.Size{}, fmt.Errorf("unknown size unit '%s'", s.Unit)
+	}
+	return compute.NewSize(s.Value, unit), nil
+}
+
+type ApiVirtualMachineAttachedVolume struct {
+	Path       string `json:"path"`
+	Alias      string `json:"alias"`
+	DeviceType string `json:"device_type"`
+	DeviceBus  string `json:"device_bus"`
+}
+
+func NewApiVirtualMachineAttachedVolume(volume *compute.VirtualMachineAttachedVolume) ApiVirtualMachineAttachedVolume {
+	return ApiVirtualMachineAttachedVolume{
+		Path:       volume.Path,
+		Alias:      volume.Alias,
+		DeviceType: volume.DeviceType.String(),
+		DeviceBus:  volume.DeviceBus.String(),
+	}
+}
+
+func (v ApiVirtualMachineAttachedVolume) AttachedVolume() (*compute.VirtualMachineAttachedVolume, error) {
+	deviceType := compute.NewDeviceType(v.DeviceType)
+	if deviceType == compute.DeviceTypeUnknown {
+		return nil, fmt.Errorf("unknown device type '%s'", v.DeviceType)
+	}
+	deviceBus := compute.NewDeviceBus(v.DeviceBus)
+	if deviceBus == compute.DeviceBusUnknown {
+		return nil, fmt.Errorf("unknown device bus '%s'", v.DeviceBus)
+	}
+	return &compute.VirtualMachineAttachedVolume{
+		Path:       v.Path,
+		Alias:      v.Alias,
+		DeviceType: deviceType,
+		DeviceBus:  deviceBus,
+	}, nil
+}
+
+type ApiVirtualMachineAttachedInterface struct {
+	NetworkName   string   `json:"network"`
+	Mac           string   `json:"mac"`
+	Model         string   `json:"model"`
+	AccessVlan    uint     `json:"access_vlan"`
+	IpAddressList []string `json:"ip_addresses,omitempty"`
+}
+
+func NewApiVirtualMachineAttachedInterface(iface *compute.VirtualMachineAttachedInterface) ApiVirtualMachineAttachedInterface {
+	return ApiVirtualMachineAttachedInterface{
+		NetworkName:   iface.NetworkName,
+		Mac:           iface.Mac,
+		Model:         iface.Model,
+		AccessVlan:    iface.AccessVlan,
+		IpAddressList: iface.IpAddressList,
+	}
+}
+
+func (i ApiVirtualMachineAttachedInterface) AttachedInterface() (*compute.VirtualMachineAttachedInterface, error) {
+	if i.NetworkName == "" {
+		return nil, fmt.Errorf("no network specified")
+	}
+	if i.AccessVlan > 4096 {
+		return nil, fmt.Errorf("invalid vlan %d", i.AccessVlan)
+	}
+	return &compute.VirtualMachineAttachedInterface{
+		NetworkName: i.NetworkName,
+		Mac:         i.Mac,
+		Model:       i.Model,
+		AccessVlan:  i.AccessVlan,
+	}, nil
+}
+
+type ApiVirtualMachineGraphic struct {
+	Type   string `json:"type"`
+	Listen string `json:"listen"`
+	Port   int    `json:"port,omitempty"`
+}
+
+type ApiVirtualMachineConfig struct {
+	Hostname string   `json:"hostname"`
+	Keys     []string `json:"keys"`
+}
+
+type ApiVirtualMachineCpuPin struct {
+	Vcpus    map[uint][]uint `json:"vcpus"`
+	Emulator []uint          `json:"emulator"`
+}
+
+type ApiVirtualMachine struct {
+	Id          string                               `json:"id"`
+	NodeId      string                               `json:"node"`
+	Owner       string                               `json:"owner,omitempty"`
+	Project     string                               `json:"project,omitempty"`
+	VCpus       int                                  `json:"vcpus"`
+	Arch        string                               `json:"arch"`
+	State       string                               `json:"state"`
+	StateReason string                               `json:"state_reason,omitempty"`
+	Memory      ApiSize                              `json:"memory"`
+	Interfaces  []ApiVirtualMachineAttachedInterface `json:"interfaces"`
+	Volumes     []ApiVirtualMachineAttachedVolume    `json:"volumes"`
+	Config      *ApiVirtualMachineConfig             `json:"config,omitempty"`
+	Cpupin      *ApiVirtualMachineCpuPin             `json:"cpupin,omitempty"`
+	GuestAgent  bool                                 `json:"guest_agent"`
+	Autostart   bool                                 `json:"autostart"`
+	Graphic     ApiVirtualMachineGraphic             `json:"graphic"`
+	VideoModel  string                               `json:"video_model"`
+	Hugepages   bool                                 `json:"hugepages"`
+}
+
+func NewApiVirtualMachine(vm *compute.VirtualMachine) *ApiVirtualMachine {
+	result := &ApiVirtualMachine{
+		Id:          vm.Id,
+		NodeId:      vm.NodeId,
+		Owner:       vm.Owner,
+		Project:     vm.Project,
+		VCpus:       vm.VCpus,
+		Arch:        vm.Arch.String(),
+		State:       vm.State.String(),
+		StateReason: vm.StateReason,
+		Memory:      NewApiSize(vm.Memory),
+		Interfaces:  []ApiVirtualMachineAttachedInterface{},
+		Volumes:     []ApiVirtualMachineAttachedVolume{},
+		GuestAgent:  vm.GuestAgent,
+		Autostart:   vm.Autostart,
+		Graphic: ApiVirtualMachineGraphic{
+			Type:   vm.Graphic.Type.String(),
+			Listen: vm.Graphic.Listen,
+			Port:   vm.Graphic.Port,
+		},
+		VideoModel: vm.VideoModel.String(),
+		Hugepages:  vm.Hugepages,
+	}
+	for _, iface := range vm.Interfaces {
+		result.Interfaces = append(result.Interfaces, NewApiVirtualMachineAttachedInterface(iface))
+	}
+	for _, volume := range vm.Volumes {
+		result.Volumes = append(result.Volumes, NewApiVirtualMachineAttachedVolume(volume))
+	}
+	if vm.Config != nil {
+		result.Config = &ApiVirtualMachineConfig{Hostname: vm.Config.Hostname, Keys: []string{}}
+		for _, key := range vm.Config.Keys {
+			result.Config.Keys = append(result.Config.Keys, key.Fingerprint)
+		}
+	}
+	if vm.Cpupin != nil {
+		result.Cpupin = &ApiVirtualMachineCpuPin{Vcpus: vm.Cpupin.Vcpus, Emulator: vm.Cpupin.Emulator}
+	}
+	return result
+}
+
+type ApiVirtualMachineClonedVolumeParams struct {
+	OriginalPath string  `json:"original_path"`
+	NewName      string  `json:"name"`
+	NewPool      string  `json:"pool"`
+	NewSize      ApiSize `json:"size"`
+	NewFormat    string  `json:"format"`
+	Alias        string  `json:"alias"`
+	DeviceType   string  `json:"device_type"`
+	DeviceBus    string  `json:"device_bus"`
+}
+
+type ApiVirtualMachineCreatedVolumeParams struct {
+	Name       string  `json:"name"`
+	Pool       string  `json:"pool"`
+	Format     string  `json:"format"`
+	Size       ApiSize `json:"size"`
+	Alias      string  `json:"alias"`
+	DeviceType string  `json:"device_type"`
+	DeviceBus  string  `json:"device_bus"`
+}
+
+type ApiVirtualMachineUpdateParams struct {
+	VCpus      int                      `json:"vcpus"`
+	Memory     ApiSize                  `json:"memory"`
+	GuestAgent bool                     `json:"guest_agent"`
+	Autostart  bool                     `json:"autostart"`
+	Hugepages  bool                     `json:"hugepages"`
+	Graphic    ApiVirtualMachineGraphic `json:"graphic"`
+	VideoModel string                   `json:"video_model"`
+}
+
+// NewApiVirtualMachineUpdateParams returns current settings of machine,
+// update request body is decoded over them, so omitted fields are kept.
+func NewApiVirtualMachineUpdateParams(vm *compute.VirtualMachine) ApiVirtualMachineUpdateParams {
+	return ApiVirtualMachineUpdateParams{
+		VCpus:      vm.VCpus,
+		Memory:     NewApiSize(vm.Memory),
+		GuestAgent: vm.GuestAgent,
+		Autostart:  vm.Autostart,
+		Hugepages:  vm.Hugepages,
+		Graphic:    ApiVirtualMachineGraphic{Type: vm.Graphic.Type.String(), Listen: vm.Graphic.Listen},
+		VideoModel: vm.VideoModel.String(),
+	}
+}
+
+type ApiVirtualMachineCreateParams struct {
+	ApiVirtualMachineUpdateParams
+	Id            string                                 `json:"id"`
+	NodeId        string                                 `json:"node"`
+	Project       string                                 `json:"project"`
+	Interfaces    []ApiVirtualMachineAttachedInterface   `json:"interfaces"`
+	AttachVolumes []ApiVirtualMachineAttachedVolume      `json:"attach_volumes"`
+	CloneVolumes  []ApiVirtualMachineClonedVolumeParams  `json:"clone_volumes"`
+	CreateVolumes []ApiVirtualMachineCreatedVolumeParams `json:"create_volumes"`
+	Keys          []string                               `json:"keys"`
+	Userdata      string                                 `json:"userdata"`
+	Start         bool                                   `json:"start"`
+}
+
+type ApiVolumeMetadata struct {
+	OsName    string `json:"os_name,omitempty"`
+	OsVersion string `json:"os_version,omitempty"`
+	OsArch    string `json:"os_arch,omitempty"`
+	Protected bool   `json:"protected"`
+}
+
+type ApiVolume struct {
+	NodeId     string            `json:"node"`
+	Path       string            `json:"path"`
+	Name       string            `json:"name"`
+	Size       ApiSize           `json:"size"`
+	Pool       string            `json:"pool"`
+	Format     string            `json:"format"`
+	AttachedTo string            `json:"attached_to"`
+	AttachedAs string            `json:"attached_as,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Project    string            `json:"project,omitempty"`
+	Metadata   ApiVolumeMetadata `json:"metadata"`
+}
+
+func NewApiVolume(volume *compute.Volume) *ApiVolume {
+	result := &ApiVolume{
+		NodeId:     volume.NodeId,
+		Path:       volume.Path,
+		Name:       volume.Name,
+		Size:       NewApiSize(volume.Size),
+		Pool:       volume.Pool,
+		Format:     volume.Format.String(),
+		AttachedTo: volume.AttachedTo,
+		Owner:      volume.Owner,
+		Project:    volume.Project,
+		Metadata: ApiVolumeMetadata{
+			OsName:    volume.Metadata.OsName,
+			OsVersion: volume.Metadata.OsVersion,
+			Protected: volume.Metadata.Protected,
+		},
+	}
+	if volume.AttachedTo != "" {
+		result.AttachedAs = volume.AttachedAs.String()
+	}
+	if volume.Metadata.OsName != "" {
+		result.Metadata.OsArch = volume.Metadata.OsArch.String()
+	}
+	return result
+}
+
+type ApiVolumeCreateParams struct {
+	NodeId string  `json:"node"`
+	Name   string  `json:"name"`
+	Pool   string  `json:"pool"`
+	Format string  `json:"format"`
+	Size   ApiSize `json:"size"`
+}
+
+type ApiVolumeCloneParams struct {
+	Name   string  `json:"name"`
+	Pool   string  `json:"pool"`
+	Format string  `json:"format"`
+	Size   ApiSize `json:"size"`
+}
+
+type ApiVirtualMachineMigrateParams struct {
+	NodeId      string `json:"node"`
+	CopyStorage bool   `json:"copy_storage"`
+}
+
+type ApiVirtualMachineMoveParams struct {
+	NodeId string `json:"node"`
+	Pool   string `json:"pool"`
+}
+
+type ApiVirtualMachineSnapshot struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+	State       string    `json:"state"`
+	Memory      bool      `json:"memory"`
+	External    bool      `json:"external"`
+	Current     bool      `json:"current"`
+	Parent      string    `json:"parent,omitempty"`
+	Volumes     []string  `json:"volumes,omitempty"`
+}
+
+func NewApiVirtualMachineSnapshot(snapshot *compute.VirtualMachineSnapshot) *ApiVirtualMachineSnapshot {
+	return &ApiVirtualMachineSnapshot{
+		Name:        snapshot.Name,
+		Description: snapshot.Description,
+		Created:     snapshot.Created,
+		State:       snapshot.State,
+		Memory:      snapshot.Memory,
+		External:    snapshot.External,
+		Current:     snapshot.Current,
+		Parent:      snapshot.Parent,
+		Volumes:     snapshot.Volumes,
+	}
+}
+
+type ApiVirtualMachineSnapshotCreateParams struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	External    bool   `json:"external"`
+}
+
+type ApiVolumeResizeParams struct {
+	Size ApiSize `json:"size"`
+}
+
+type ApiVolumePool struct {
+	NodeId string  `json:"node"`
+	Name   string  `json:"name"`
+	Size   ApiSize `json:"size"`
+	Used   ApiSize `json:"used"`
+	Free   ApiSize `json:"free"`
+}
+
+func NewApiVolumePool(pool *compute.VolumePool) *ApiVolumePool {
+	return &ApiVolumePool{
+		NodeId: pool.NodeId,
+		Name:   pool.Name,
+		Size:   NewApiSize(pool.Size),
+		Used:   NewApiSize(pool.Used),
+		Free:   NewApiSize(pool.Free),
+	}
+}
+
+type ApiNetwork struct {
+	NodeId string `json:"node"`
+	Name   string `json:"name"`
+}
+
+func NewApiNetwork(network *compute.Network) *ApiNetwork {
+	return &ApiNetwork{NodeId: network.NodeId, Name: network.Name}
+}
+
+type ApiNodeNuma struct {
+	Memory      ApiSize `json:"memory"`
+	Pages4k     uint64  `json:"pages_4k"`
+	Pages4kFree uint64  `json:"pages_4k_free"`
+	Pages2m     uint64  `json:"pages_2m"`
+	Pages2mFree uint64  `json:"pages_2m_free"`
+	Pages1g     uint64  `json:"pages_1g"`
+	Pages1gFree uint64  `json:"pages_1g_free"`
+}
+
+type ApiNodeCpuPin struct {
+	VmId string `json:"vm_id"`
+	Desc string `json:"desc"`
+}
+
+type ApiNodeCpu struct {
+	SocketId int             `json:"socket_id"`
+	CoreId   int             `json:"core_id"`
+	NumaId   int             `json:"numa_id"`
+	Pins     []ApiNodeCpuPin `json:"pins"`
+}
+
+type ApiNode struct {
+	Id             string        `json:"id"`
+	Hostname       string        `json:"hostname"`
+	CpuArch        string        `json:"cpu_arch"`
+	CpuVendor      string        `json:"cpu_vendor"`
+	CpuModel       string        `json:"cpu_model"`
+	CpuInfo        string        `json:"cpu_info"`
+	ThreadsPerCore int           `json:"threads_per_core"`
+	Iommu          bool          `json:"iommu"`
+	Memory         ApiSize       `json:"memory"`
+	Numas          []ApiNodeNuma `json:"numas"`
+	Cpus           []ApiNodeCpu  `json:"cpus"`
+}
+
+func NewApiNode(node *compute.Node) *ApiNode {
+	result := &ApiNode{
+		Id:             node.Id,
+		Hostname:       node.Hostname,
+		CpuArch:        node.CpuArch.String(),
+		CpuVendor:      node.CpuVendor,
+		CpuModel:       node.CpuModel,
+		CpuInfo:        node.CpuInfo,
+		ThreadsPerCore: node.ThreadsPerCore,
+		Iommu:          node.Iommu,
+		Memory:         NewApiSize(node.Memory()),
+		Numas:          []ApiNodeNuma{},
+		Cpus:           []ApiNodeCpu{},
+	}
+	for _, numa := range node.Numas {
+		result.Numas = append(result.Numas, ApiNodeNuma{
+			Memory:      NewApiSize(numa.Memory),
+			Pages4k:     numa.Pages4k,
+			Pages4kFree: numa.Pages4kFree,
+			Pages2m:     numa.Pages2m,
+			Pages2mFree: numa.Pages2mFree,
+			Pages1g:     numa.Pages1g,
+			Pages1gFree: numa.Pages1gFree,
+		})
+	}
+	for _, cpu := range node.Cpus {
+		apiCpu := ApiNodeCpu{SocketId: cpu.SocketId, CoreId: cpu.CoreId, NumaId: cpu.NumaId, Pins: []ApiNodeCpuPin{}}
+		for _, pin := range cpu.Pins {
+			apiCpu.Pins = append(apiCpu.Pins, ApiNodeCpuPin{VmId: pin.VmId, Desc: pin.Desc})
+		}
+		result.Cpus = append(result.Cpus, apiCpu)
+	}
+	return result
+}
+
+type ApiKey struct {
+	Type        string   `json:"type"`
+	Value       string   `json:"value"`
+	Comment     string   `json:"comment"`
+	Options     []string `json:"options"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+func NewApiKey(key *compute.Key) *ApiKey {
+	return &ApiKey{
+		Type:        key.Type,
+		Value:       key.ValueString(),
+		Comment:     key.Comment,
+		Options:     key.Options,
+		Fingerprint: key.Fingerprint,
+	}
+}
+
+type ApiKeyAddParams struct {
+	Value string `json:"value"`
+}
+
+type ApiQuotaResources struct {
+	VCpus  int     `json:"vcpus"`
+	Memory ApiSize `json:"memory"`
+	Disk   ApiSize `json:"disk"`
+	Vms    int     `json:"vms"`
+}
+
+func NewApiQuotaResources(resources compute.QuotaResources) ApiQuotaResources {
+	return ApiQuotaResources{
+		VCpus:  resources.VCpus,
+		Memory: NewApiSize(resources.Memory),
+		Disk:   NewApiSize(resources.Disk),
+		Vms:    resources.Vms,
+	}
+}
+
+type ApiQuota struct {
+	Subject string            `json:"subject"`
+	Name    string            `json:"name"`
+	Limit   ApiQuotaResources `json:"limit"`
+	Usage   ApiQuotaResources `json:"usage"`
+}
+
+func NewApiQuota(quota *compute.Quota) *ApiQuota {
+	return &ApiQuota{
+		Subject: quota.Subject.String(),
+		Name:    quota.Name,
+		Limit:   NewApiQuotaResources(quota.Limit),
+		Usage:   NewApiQuotaResources(quota.Usage),
+	}
+}
+
+type ApiTask struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	NodeId   string     `json:"node,omitempty"`
+	TargetId string     `json:"target,omitempty"`
+	Owner    string     `json:"owner,omitempty"`
+	Project  string     `json:"project,omitempty"`
+	State    string     `json:"state"`
+	Progress int        `json:"progress"`
+	Message  string     `json:"message,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func NewApiTask(task *compute.Task) *ApiTask {
+	apiTask := &ApiTask{
+		Id:       task.Id,
+		Name:     task.Name,
+		NodeId:   task.NodeId,
+		TargetId: task.TargetId,
+		Owner:    task.Owner,
+		Project:  task.Project,
+		State:    task.State.String(),
+		Progress: task.Progress,
+		Message:  task.Message,
+		Error:    task.Error,
+		Created:  task.Created,
+	}
+	if !task.Started.IsZero() {
+		apiTask.Started = &task.Started
+	}
+	if !task.Finished.IsZero() {
+		apiTask.Finished = &task.Finished
+	}
+	return apiTask
+}
+
+type ApiVirtualMachineDeletePlanVolume struct {
+	Path       string `json:"path"`
+	DeviceType string `json:"device_type"`
+	Delete     bool   `json:"delete"`
+	KeepReason string `json:"keep_reason,omitempty"`
+}
+
+type ApiVirtualMachineDeletePlan struct {
+	Shutdown       bool                                 `json:"shutdown"`
+	ShutdownReason string                               `json:"shutdown_reason,omitempty"`
+	Snapshots      []string                             `json:"snapshots"`
+	Volumes        []*ApiVirtualMachineDeletePlanVolume `json:"volumes"`
+}
+
+func NewApiVirtualMachineDeletePlan(plan *compute.VirtualMachineDeletePlan) *ApiVirtualMachineDeletePlan {
+	apiPlan := &ApiVirtualMachineDeletePlan{Shutdown: plan.Shutdown, ShutdownReason: plan.ShutdownReason, Snapshots: []string{}, Volumes: []*ApiVirtualMachineDeletePlanVolume{}}
+	for _, snapshot := range plan.Snapshots {
+		apiPlan.Snapshots = append(apiPlan.Snapshots, snapshot.Name)
+	}
+	for _, volume := range plan.Volumes {
+		apiPlan.Volumes = append(apiPlan.Volumes, &ApiVirtualMachineDeletePlanVolume{
+			Path:       volume.Path,
+			DeviceType: volume.DeviceType.String(),
+			Delete:     volume.Delete,
+			KeepReason: volume.KeepReason,
+		})
+	}
+	return apiPlan
+}
+
+type ApiEventDelivery struct {
+	Id          string            `json:"id"`
+	Event       string            `json:"event"`
+	Subscriber  string            `json:"subscriber"`
+	Data        map[string]string `json:"data"`
+	State       string            `json:"state"`
+	Attempts    int               `json:"attempts"`
+	LastError   string            `json:"last_error,omitempty"`
+	Created     time.Time         `json:"created"`
+	LastAttempt *time.Time        `json:"last_attempt,omitempty"`
+	NextAttempt *time.Time        `json:"next_attempt,omitempty"`
+}
+
+func NewApiEventDelivery(delivery *compute.EventDelivery) *ApiEventDelivery {
+	apiDelivery := &ApiEventDelivery{
+		Id:         delivery.Id,
+		Event:      delivery.Event,
+		Subscriber: delivery.Subscriber,
+		Data:       delivery.Data,
+		State:      delivery.State.String(),
+		Attempts:   delivery.Attempts,
+		LastError:  delivery.LastError,
+		Created:    delivery.Created,
+	}
+	if !delivery.LastAttempt.IsZero() {
+		apiDelivery.LastAttempt = &delivery.LastAttempt
+	}
+	if delivery.State == compute.EventDeliveryStatePending {
+		apiDelivery.NextAttempt = &delivery.NextAttempt
+	}
+	return apiDelivery
+}
+
+type ApiVirtualMachineStateEvent struct {
+	Event  string             `json:"event"`
+	NodeId string             `json:"node"`
+	Id     string             `json:"id"`
+	Action string             `json:"action"`
+	Vm     *ApiVirtualMachine `json:"vm,omitempty"`
+}
+
+func NewApiVirtualMachineStateEvent(event *compute.EventVirtualMachineStateChanged) *ApiVirtualMachineStateEvent {
+	apiEvent := &ApiVirtualMachineStateEvent{
+		Event:  event.Name(),
+		NodeId: event.NodeId(),
+		Id:     event.VmId(),
+		Action: event.Action(),
+	}
+	if event.Vm() != nil {
+		apiEvent.Vm = NewApiVirtualMachine(event.Vm())
+	}
+	return apiEvent
+}
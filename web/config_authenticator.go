@@ -0,0 +1,45 @@
+package web
+
+import (
+	"subuk/vmango/auth"
+	"subuk/vmango/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConfigAuthenticator checks passwords of users defined in configuration file.
+type ConfigAuthenticator struct {
+	users []config.UserWebConfig
+}
+
+func NewConfigAuthenticator(users []config.UserWebConfig) *ConfigAuthenticator {
+	return &ConfigAuthenticator{users: users}
+}
+
+func (authenticator *ConfigAuthenticator) user(userId string) *config.UserWebConfig {
+	for index := range authenticator.users {
+		if authenticator.users[index].Id == userId {
+			return &authenticator.users[index]
+		}
+	}
+	return nil
+}
+
+func (authenticator *ConfigAuthenticator) Authenticate(username, password string) (*auth.Principal, error) {
+	user := authenticator.user(username)
+	if user == nil {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)); err != nil {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return PrincipalFromConfig(user), nil
+}
+
+func (authenticator *ConfigAuthenticator) Lookup(username string) (*auth.Principal, error) {
+	user := authenticator.user(username)
+	if user == nil {
+		return nil, auth.ErrPrincipalNotFound
+	}
+	return PrincipalFromConfig(user), nil
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	neturl "net/url"
 	"strings"
+	"subuk/vmango/audit"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
 	libcompute "subuk/vmango/compute"
 	"subuk/vmango/config"
@@ -20,7 +22,6 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 	"github.com/unrolled/render"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var AppVersion string
@@ -58,6 +59,16 @@ type Environ struct {
 	volumes  *libcompute.VolumeService
 	vms      *libcompute.VirtualMachineService
 	vmanager *libcompute.VirtualMachineManager
+	tasks    *libcompute.TaskService
+	tokens   *auth.ApiTokenService
+	authn    auth.Authenticator
+	oidc     *auth.OidcProvider
+	audit    *audit.Log
+	events   *libcompute.EventBroadcaster
+	outbox   *libcompute.EventOutbox
+	records  *libcompute.ConsoleRecordingService
+	consoles *libcompute.ConsoleMultiplexer
+	quotas   *libcompute.QuotaService
 	ws       *websocket.Upgrader
 	cfg      *config.WebConfig
 }
@@ -102,6 +113,12 @@ func TemplateFuncs(env *Environ) []template.FuncMap {
 			"IsAuthenticated": func(req *http.Request) bool {
 				return env.Session(req).IsAuthenticated()
 			},
+			"Can": func(user *User, role string, nodeId, vmId string) bool {
+				return user.Can(auth.NewRole(role), nodeId, vmId)
+			},
+			"CanNode": func(user *User, role string, nodeId string) bool {
+				return user.CanNode(auth.NewRole(role), nodeId)
+			},
 			"HasPrefix": strings.HasPrefix,
 			"HumanizeDate": func(date time.Time) string {
 				return date.Format("Mon Jan 2 15:04:05 -0700 MST 2006")
@@ -150,6 +167,16 @@ func New(
 	volumes *libcompute.VolumeService,
 	vms *libcompute.VirtualMachineService,
 	vmanager *libcompute.VirtualMachineManager,
+	tasks *libcompute.TaskService,
+	tokens *auth.ApiTokenService,
+	quotas *libcompute.QuotaService,
+	authn auth.Authenticator,
+	oidc *auth.OidcProvider,
+	auditLog *audit.Log,
+	events *libcompute.EventBroadcaster,
+	outbox *libcompute.EventOutbox,
+	recordings *libcompute.ConsoleRecordingService,
+	consoles *libcompute.ConsoleMultiplexer,
 ) http.Handler {
 
 	env := &Environ{cfg: &cfg.Web}
@@ -191,58 +218,150 @@ func New(
 	env.volumes = volumes
 	env.vms = vms
 	env.vmanager = vmanager
+	env.tasks = tasks
+	env.tokens = tokens
+	env.quotas = quotas
+	env.authn = authn
+	env.oidc = oidc
+	env.audit = auditLog
+	env.events = events
+	env.outbox = outbox
+	env.records = recordings
+	env.consoles = consoles
 	env.sessions = sessionStore
 
 	router.HandleFunc("/static/{name:.*}", env.Static(cfg)).Name("static")
 
 	router.HandleFunc("/login/", env.PasswordLoginFormProcess).Name("login").Methods("POST")
 	router.HandleFunc("/login/", env.PasswordLoginFormShow).Name("login")
+	router.HandleFunc("/login/oidc/", env.OidcLoginStart).Name("login-oidc")
+	router.HandleFunc("/login/oidc/callback/", env.OidcLoginCallback).Name("login-oidc-callback")
 	router.HandleFunc("/logout/", env.Logout).Name("logout")
 
 	router.HandleFunc("/volumes/", env.authenticated(env.VolumeList)).Name("volume-list")
 	router.HandleFunc("/volumes/add/", env.authenticated(env.VolumeAddFormProcess)).Methods("POST").Name("volume-add-form")
-	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.VolumeDeleteFormProcess)).Methods("POST").Name("volume-delete-form")
-	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.VolumeDeleteFormShow)).Name("volume-delete-form")
-	router.HandleFunc("/volumes/{node}/{path}/clone/", env.authenticated(env.VolumeCloneFormProcess)).Methods("POST").Name("volume-clone-form")
-	router.HandleFunc("/volumes/{node}/{path}/clone/", env.authenticated(env.VolumeCloneFormShow)).Name("volume-clone-form")
-	router.HandleFunc("/volumes/{node}/{path}/resize/", env.authenticated(env.VolumeResizeFormProcess)).Methods("POST").Name("volume-resize-form")
-	router.HandleFunc("/volumes/{node}/{path}/resize/", env.authenticated(env.VolumeResizeFormShow)).Name("volume-resize-form")
+	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeDeleteFormProcess))).Methods("POST").Name("volume-delete-form")
+	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeDeleteFormShow))).Name("volume-delete-form")
+	router.HandleFunc("/volumes/{node}/{path}/clone/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeCloneFormProcess))).Methods("POST").Name("volume-clone-form")
+	router.HandleFunc("/volumes/{node}/{path}/clone/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeCloneFormShow))).Name("volume-clone-form")
+	router.HandleFunc("/volumes/{node}/{path}/resize/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeResizeFormProcess))).Methods("POST").Name("volume-resize-form")
+	router.HandleFunc("/volumes/{node}/{path}/resize/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.VolumeResizeFormShow))).Name("volume-resize-form")
 
 	router.HandleFunc("/networks/", env.authenticated(env.NetworkList)).Name("network-list")
 
 	router.HandleFunc("/keys/", env.authenticated(env.KeyList)).Name("key-list")
-	router.HandleFunc("/keys/add/", env.authenticated(env.KeyAddFormProcess)).Methods("POST").Name("key-add")
+	router.HandleFunc("/keys/add/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.KeyAddFormProcess))).Methods("POST").Name("key-add")
 	router.HandleFunc("/keys/{fingerprint}/show/", env.authenticated(env.KeyShow)).Name("key-show")
-	router.HandleFunc("/keys/{fingerprint}/delete/", env.authenticated(env.KeyDeleteFormProcess)).Methods("POST").Name("key-delete-form")
-	router.HandleFunc("/keys/{fingerprint}/delete/", env.authenticated(env.KeyDeleteFormShow)).Name("key-delete-form")
+	router.HandleFunc("/keys/{fingerprint}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.KeyDeleteFormProcess))).Methods("POST").Name("key-delete-form")
+	router.HandleFunc("/keys/{fingerprint}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.KeyDeleteFormShow))).Name("key-delete-form")
+
+	router.HandleFunc("/tokens/", env.authenticated(env.ApiTokenList)).Name("api-token-list")
+	router.HandleFunc("/quotas/", env.authenticated(env.QuotaList)).Name("quota-list")
+	router.HandleFunc("/events/", env.authenticated(env.EventStream)).Name("event-stream")
+
+	router.HandleFunc("/tasks/", env.authenticated(env.TaskList)).Name("task-list")
+	router.HandleFunc("/tasks/{id}/", env.authenticated(env.TaskDetail)).Name("task-detail")
+	router.HandleFunc("/audit/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.AuditList))).Name("audit-list")
+	router.HandleFunc("/deliveries/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.EventDeliveryList))).Name("event-delivery-list")
+	router.HandleFunc("/deliveries/{id}/replay/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.EventDeliveryReplayFormProcess))).Methods("POST").Name("event-delivery-replay")
+	router.HandleFunc("/deliveries/{id}/discard/", env.authenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.EventDeliveryDiscardFormProcess))).Methods("POST").Name("event-delivery-discard")
+	router.HandleFunc("/tokens/add/", env.authenticated(env.ApiTokenAddFormProcess)).Methods("POST").Name("api-token-add")
+	router.HandleFunc("/tokens/{id}/delete/", env.authenticated(env.ApiTokenDeleteFormProcess)).Methods("POST").Name("api-token-delete-form")
+	router.HandleFunc("/tokens/{id}/delete/", env.authenticated(env.ApiTokenDeleteFormShow)).Name("api-token-delete-form")
 
 	router.HandleFunc("/machines/", env.authenticated(env.VirtualMachineList)).Name("virtual-machine-list")
 	router.HandleFunc("/machines/add/", env.authenticated(env.VirtualMachineAddFormProcess)).Methods("POST").Name("virtual-machine-add")
 	router.HandleFunc("/machines/add/", env.authenticated(env.VirtualMachineAddFormShow)).Name("virtual-machine-add")
-	router.HandleFunc("/machines/{node}/{id}/", env.authenticated(env.VirtualMachineDetail)).Name("virtual-machine-detail")
-	router.HandleFunc("/machines/{node}/{id}/attach-disk/", env.authenticated(env.VirtualMachineAttachDiskFormProcess)).Methods("POST").Name("virtual-machine-attach-disk")
-	router.HandleFunc("/machines/{node}/{id}/console/", env.authenticated(env.VirtualMachineConsoleShow)).Name("virtual-machine-console-show")
-	router.HandleFunc("/machines/{node}/{id}/console-ws/", env.authenticated(env.VirtualMachineConsoleWS)).Name("virtual-machine-console-ws")
-	router.HandleFunc("/machines/{node}/{id}/vnc/", env.authenticated(env.VirtualMachineVncShow)).Name("virtual-machine-vnc-show")
-	router.HandleFunc("/machines/{node}/{id}/vnc/ws/", env.authenticated(env.VirtualMachineVncWs)).Name("virtual-machine-vnc-ws")
-	router.HandleFunc("/machines/{node}/{id}/detach-volume/", env.authenticated(env.VirtualMachineDetachVolumeFormProcess)).Methods("POST").Name("virtual-machine-detach-volume")
-	router.HandleFunc("/machines/{node}/{id}/attach-interface/", env.authenticated(env.VirtualMachineAttachInterfaceFormProcess)).Methods("POST").Name("virtual-machine-attach-interface")
-	router.HandleFunc("/machines/{node}/{id}/detach-interface/", env.authenticated(env.VirtualMachineDetachInterfaceFormProcess)).Methods("POST").Name("virtual-machine-detach-interface")
-	router.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.authenticated(env.VirtualMachineStateSetFormProcess)).Name("virtual-machine-state-form").Methods("POST")
-	router.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.authenticated(env.VirtualMachineStateSetFormShow)).Name("virtual-machine-state-form")
-	router.HandleFunc("/machines/{node}/{id}/delete/", env.authenticated(env.VirtualMachineDeleteFormProcess)).Name("virtual-machine-delete").Methods("POST")
-	router.HandleFunc("/machines/{node}/{id}/delete/", env.authenticated(env.VirtualMachineDeleteFormShow)).Name("virtual-machine-delete")
-	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.VirtualMachineUpdateFormProcess)).Name("virtual-machine-update").Methods("POST")
-	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.VirtualMachineUpdateFormShow)).Name("virtual-machine-update")
+	router.HandleFunc("/machines/import/", env.authenticated(env.VirtualMachineImportFormProcess)).Methods("POST").Name("virtual-machine-import")
+	router.HandleFunc("/machines/import/", env.authenticated(env.VirtualMachineImportFormShow)).Name("virtual-machine-import")
+	router.HandleFunc("/machines/{node}/{id}/", env.authenticated(env.permitted(auth.RoleViewer, scopeVirtualMachine, env.VirtualMachineDetail))).Name("virtual-machine-detail")
+	router.HandleFunc("/machines/{node}/{id}/attach-disk/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineAttachDiskFormProcess))).Methods("POST").Name("virtual-machine-attach-disk")
+	router.HandleFunc("/machines/{node}/{id}/console/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineConsoleShow))).Name("virtual-machine-console-show")
+	router.HandleFunc("/machines/{node}/{id}/console-ws/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineConsoleWS))).Name("virtual-machine-console-ws")
+	router.HandleFunc("/machines/{node}/{id}/recordings/{recording}/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineRecordingShow))).Name("virtual-machine-recording-show")
+	router.HandleFunc("/machines/{node}/{id}/recordings/{recording}/cast/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineRecordingCast))).Name("virtual-machine-recording-cast")
+	router.HandleFunc("/machines/{node}/{id}/vnc/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineVncShow))).Name("virtual-machine-vnc-show")
+	router.HandleFunc("/machines/{node}/{id}/vnc/ws/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineVncWs))).Name("virtual-machine-vnc-ws")
+	router.HandleFunc("/machines/{node}/{id}/spice/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineSpiceShow))).Name("virtual-machine-spice-show")
+	router.HandleFunc("/machines/{node}/{id}/spice/ws/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineSpiceWs))).Name("virtual-machine-spice-ws")
+	router.HandleFunc("/machines/{node}/{id}/detach-volume/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineDetachVolumeFormProcess))).Methods("POST").Name("virtual-machine-detach-volume")
+	router.HandleFunc("/machines/{node}/{id}/attach-interface/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineAttachInterfaceFormProcess))).Methods("POST").Name("virtual-machine-attach-interface")
+	router.HandleFunc("/machines/{node}/{id}/detach-interface/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineDetachInterfaceFormProcess))).Methods("POST").Name("virtual-machine-detach-interface")
+	router.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineStateSetFormProcess))).Name("virtual-machine-state-form").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineStateSetFormShow))).Name("virtual-machine-state-form")
+	router.HandleFunc("/machines/{node}/{id}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineDeleteFormProcess))).Name("virtual-machine-delete").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineDeleteFormShow))).Name("virtual-machine-delete")
+	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineUpdateFormProcess))).Name("virtual-machine-update").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineUpdateFormShow))).Name("virtual-machine-update")
+	router.HandleFunc("/machines/{node}/{id}/migrate/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineMigrateFormProcess))).Methods("POST").Name("virtual-machine-migrate")
+	router.HandleFunc("/machines/{node}/{id}/migrate/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineMigrateFormShow))).Name("virtual-machine-migrate")
+	router.HandleFunc("/machines/{node}/{id}/move/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineMoveFormProcess))).Methods("POST").Name("virtual-machine-move")
+	router.HandleFunc("/machines/{node}/{id}/move/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineMoveFormShow))).Name("virtual-machine-move")
+	router.HandleFunc("/machines/{node}/{id}/export/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineExport))).Name("virtual-machine-export")
+	router.HandleFunc("/machines/{node}/{id}/snapshots/", env.authenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.VirtualMachineSnapshotCreateFormProcess))).Methods("POST").Name("virtual-machine-snapshot-create")
+	router.HandleFunc("/machines/{node}/{id}/snapshots/{snapshot}/revert/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineSnapshotRevertFormProcess))).Methods("POST").Name("virtual-machine-snapshot-revert")
+	router.HandleFunc("/machines/{node}/{id}/snapshots/{snapshot}/delete/", env.authenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.VirtualMachineSnapshotDeleteFormProcess))).Methods("POST").Name("virtual-machine-snapshot-delete")
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/machines/", env.apiAuthenticated(env.ApiVirtualMachineList)).Methods("GET").Name("api-virtual-machine-list")
+	api.HandleFunc("/machines/", env.apiAuthenticated(env.ApiVirtualMachineCreate)).Methods("POST").Name("api-virtual-machine-create")
+	api.HandleFunc("/machines/import/", env.apiAuthenticated(env.ApiVirtualMachineImport)).Methods("POST").Name("api-virtual-machine-import")
+	api.HandleFunc("/machines/{node}/{id}/", env.apiAuthenticated(env.permitted(auth.RoleViewer, scopeVirtualMachine, env.ApiVirtualMachineDetail))).Methods("GET").Name("api-virtual-machine-detail")
+	api.HandleFunc("/machines/{node}/{id}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineUpdate))).Methods("PUT").Name("api-virtual-machine-update")
+	api.HandleFunc("/machines/{node}/{id}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineDelete))).Methods("DELETE").Name("api-virtual-machine-delete")
+	api.HandleFunc("/machines/{node}/{id}/delete-plan/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineDeletePlan))).Methods("GET").Name("api-virtual-machine-delete-plan")
+	api.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.apiAuthenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.ApiVirtualMachineStateSet))).Methods("POST").Name("api-virtual-machine-state-set")
+	api.HandleFunc("/machines/{node}/{id}/volumes/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineAttachVolume))).Methods("POST").Name("api-virtual-machine-attach-volume")
+	api.HandleFunc("/machines/{node}/{id}/volumes/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineDetachVolume))).Methods("DELETE").Name("api-virtual-machine-detach-volume")
+	api.HandleFunc("/machines/{node}/{id}/interfaces/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineAttachInterface))).Methods("POST").Name("api-virtual-machine-attach-interface")
+	api.HandleFunc("/machines/{node}/{id}/interfaces/{mac}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineDetachInterface))).Methods("DELETE").Name("api-virtual-machine-detach-interface")
+	api.HandleFunc("/machines/{node}/{id}/migrate/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineMigrate))).Methods("POST").Name("api-virtual-machine-migrate")
+	api.HandleFunc("/machines/{node}/{id}/move/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineMove))).Methods("POST").Name("api-virtual-machine-move")
+	api.HandleFunc("/machines/{node}/{id}/export/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineExport))).Methods("GET").Name("api-virtual-machine-export")
+	api.HandleFunc("/machines/{node}/{id}/snapshots/", env.apiAuthenticated(env.permitted(auth.RoleViewer, scopeVirtualMachine, env.ApiVirtualMachineSnapshotList))).Methods("GET").Name("api-virtual-machine-snapshot-list")
+	api.HandleFunc("/machines/{node}/{id}/snapshots/", env.apiAuthenticated(env.permitted(auth.RoleOperator, scopeVirtualMachine, env.ApiVirtualMachineSnapshotCreate))).Methods("POST").Name("api-virtual-machine-snapshot-create")
+	api.HandleFunc("/machines/{node}/{id}/snapshots/{snapshot}/revert/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineSnapshotRevert))).Methods("POST").Name("api-virtual-machine-snapshot-revert")
+	api.HandleFunc("/machines/{node}/{id}/snapshots/{snapshot}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVirtualMachine, env.ApiVirtualMachineSnapshotDelete))).Methods("DELETE").Name("api-virtual-machine-snapshot-delete")
+
+	api.HandleFunc("/volumes/", env.apiAuthenticated(env.ApiVolumeList)).Methods("GET").Name("api-volume-list")
+	api.HandleFunc("/volumes/", env.apiAuthenticated(env.ApiVolumeCreate)).Methods("POST").Name("api-volume-create")
+	api.HandleFunc("/volumes/{node}/{path}/", env.apiAuthenticated(env.ApiVolumeDetail)).Methods("GET").Name("api-volume-detail")
+	api.HandleFunc("/volumes/{node}/{path}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.ApiVolumeDelete))).Methods("DELETE").Name("api-volume-delete")
+	api.HandleFunc("/volumes/{node}/{path}/clone/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.ApiVolumeClone))).Methods("POST").Name("api-volume-clone")
+	api.HandleFunc("/volumes/{node}/{path}/resize/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeVolume, env.ApiVolumeResize))).Methods("POST").Name("api-volume-resize")
+
+	api.HandleFunc("/pools/", env.apiAuthenticated(env.ApiVolumePoolList)).Methods("GET").Name("api-volume-pool-list")
+	api.HandleFunc("/networks/", env.apiAuthenticated(env.ApiNetworkList)).Methods("GET").Name("api-network-list")
+	api.HandleFunc("/networks/{node}/{name}/", env.apiAuthenticated(env.ApiNetworkDetail)).Methods("GET").Name("api-network-detail")
+	api.HandleFunc("/nodes/", env.apiAuthenticated(env.ApiNodeList)).Methods("GET").Name("api-node-list")
+	api.HandleFunc("/nodes/{id}/", env.apiAuthenticated(env.ApiNodeDetail)).Methods("GET").Name("api-node-detail")
+
+	api.HandleFunc("/quotas/", env.apiAuthenticated(env.ApiQuotaList)).Methods("GET").Name("api-quota-list")
+	api.HandleFunc("/events/", env.apiAuthenticated(env.EventStream)).Methods("GET").Name("api-event-stream")
+	api.HandleFunc("/tasks/", env.apiAuthenticated(env.ApiTaskList)).Methods("GET").Name("api-task-list")
+	api.HandleFunc("/tasks/{id}/", env.apiAuthenticated(env.ApiTaskDetail)).Methods("GET").Name("api-task-detail")
+	api.HandleFunc("/audit/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiAuditList))).Methods("GET").Name("api-audit-list")
+	api.HandleFunc("/deliveries/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiEventDeliveryList))).Methods("GET").Name("api-event-delivery-list")
+	api.HandleFunc("/deliveries/{id}/replay/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiEventDeliveryReplay))).Methods("POST").Name("api-event-delivery-replay")
+	api.HandleFunc("/deliveries/{id}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiEventDeliveryDiscard))).Methods("DELETE").Name("api-event-delivery-discard")
+	api.HandleFunc("/keys/", env.apiAuthenticated(env.ApiKeyList)).Methods("GET").Name("api-key-list")
+	api.HandleFunc("/keys/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiKeyAdd))).Methods("POST").Name("api-key-add")
+	api.HandleFunc("/keys/{fingerprint}/", env.apiAuthenticated(env.ApiKeyDetail)).Methods("GET").Name("api-key-detail")
+	api.HandleFunc("/keys/{fingerprint}/", env.apiAuthenticated(env.permitted(auth.RoleAdmin, scopeGlobal, env.ApiKeyDelete))).Methods("DELETE").Name("api-key-delete")
 
 	router.HandleFunc("/nodes/{id}/", env.authenticated(env.NodeDetail)).Name("node-detail")
 	router.HandleFunc("/", env.authenticated(env.NodeList)).Name("node-list")
 
-	return csrfProtect(env)
+	router.Use(env.audited)
+
+	return env.bearerAuthenticated(csrfProtect(env), env)
 }
 
 func (env *Environ) error(rw http.ResponseWriter, req *http.Request, err error, message string, status int) {
 	if err != nil {
+		if util.ErrorCause(err) == compute.ErrActionRejected {
+			status = http.StatusForbidden
+		}
 		env.logger.Warn().Int("Status", status).Err(err).Msg("request error occured")
 	}
 	switch status {
@@ -292,26 +411,35 @@ func (env *Environ) authenticated(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (env *Environ) checkPassword(userId string, password string) *User {
-	for _, user := range env.cfg.Users {
-		if user.Id != userId {
-			continue
+func (env *Environ) configUser(userId string) *config.UserWebConfig {
+	for index := range env.cfg.Users {
+		if env.cfg.Users[index].Id == userId {
+			return &env.cfg.Users[index]
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)); err != nil {
-			env.logger.Warn().Err(err).Msg("authentication failure")
-			return nil
-		}
-		return &User{
-			Id:            userId,
-			Email:         user.Email,
-			FullName:      user.FullName,
-			Authenticated: true,
+	}
+	return nil
+}
+
+// oidcConfigUser returns user from configuration file mapped to oidc
+// principal id with oidc_id option.
+func (env *Environ) oidcConfigUser(oidcId string) *config.UserWebConfig {
+	for index := range env.cfg.Users {
+		if oidcId != "" && env.cfg.Users[index].OidcId == oidcId {
+			return &env.cfg.Users[index]
 		}
 	}
-	env.logger.Warn().Str("id", userId).Msg("user not found")
 	return nil
 }
 
+func (env *Environ) checkPassword(userId string, password string) *User {
+	principal, err := env.authn.Authenticate(userId, password)
+	if err != nil {
+		env.logger.Warn().Err(err).Str("id", userId).Msg("authentication failure")
+		return nil
+	}
+	return NewUserFromPrincipal(principal)
+}
+
 func (env *Environ) ServeHTTP(w http.ResponseWriter, request *http.Request) {
 	env.router.ServeHTTP(w, request)
 }
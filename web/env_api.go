@@ -0,0 +1,69 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/gorilla/csrf"
+)
+
+func apiErrorStatus(err error) int {
+	switch util.ErrorCause(err) {
+	default:
+		return http.StatusInternalServerError
+	case compute.ErrVirtualMachineNotFound, compute.ErrVolumeNotFound, compute.ErrKeyNotFound, compute.ErrNetworkNotFound, compute.ErrUnknownNode, compute.ErrTaskNotFound, compute.ErrSnapshotNotFound, compute.ErrEventDeliveryNotFound:
+		return http.StatusNotFound
+	case compute.ErrKeyAlreadyExists, compute.ErrMigrationNotPossible, compute.ErrVirtualMachineAlreadyExists, compute.ErrEventDeliveryNotFailed, compute.ErrDeletePlanChanged:
+		return http.StatusConflict
+	case auth.ErrPermissionDenied, compute.ErrQuotaExceeded, compute.ErrActionRejected:
+		return http.StatusForbidden
+	case compute.ErrTaskQueueFull:
+		return http.StatusServiceUnavailable
+	}
+}
+
+func (env *Environ) apiResponse(rw http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	if token := csrf.Token(req); token != "" {
+		rw.Header().Set("X-CSRF-Token", token)
+	}
+	if status == http.StatusNoContent {
+		rw.WriteHeader(status)
+		return
+	}
+	if err := env.render.JSON(rw, status, data); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render api response")
+	}
+}
+
+func (env *Environ) apiError(rw http.ResponseWriter, req *http.Request, err error, message string) {
+	status := apiErrorStatus(err)
+	if status >= http.StatusInternalServerError {
+		env.logger.Warn().Int("Status", status).Err(err).Msg("api request error occured")
+	}
+	env.apiResponse(rw, req, status, ApiError{Status: status, Error: message, Details: err.Error()})
+}
+
+func (env *Environ) apiBadRequest(rw http.ResponseWriter, req *http.Request, message string) {
+	env.apiResponse(rw, req, http.StatusBadRequest, ApiError{Status: http.StatusBadRequest, Error: message})
+}
+
+func (env *Environ) apiDecode(rw http.ResponseWriter, req *http.Request, params interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(params); err != nil {
+		env.apiBadRequest(rw, req, "invalid json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (env *Environ) apiAuthenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if !env.Session(req).IsAuthenticated() {
+			env.apiResponse(rw, req, http.StatusUnauthorized, ApiError{Status: http.StatusUnauthorized, Error: "authentication required"})
+			return
+		}
+		handler(rw, req)
+	}
+}
@@ -0,0 +1,23 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/audit"
+)
+
+func (env *Environ) ApiAuditList(rw http.ResponseWriter, req *http.Request) {
+	options, err := auditListOptionsFromQuery(req.URL.Query())
+	if err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	entries, err := env.audit.List(options)
+	if err != nil {
+		env.apiError(rw, req, err, "audit list failed")
+		return
+	}
+	if entries == nil {
+		entries = []*audit.Entry{}
+	}
+	env.apiResponse(rw, req, http.StatusOK, entries)
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiEventDeliveryList(rw http.ResponseWriter, req *http.Request) {
+	options, _, err := eventDeliveryListOptionsFromQuery(req.URL.Query(), "")
+	if err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	deliveries, err := env.outbox.List(options)
+	if err != nil {
+		env.apiError(rw, req, err, "event delivery list failed")
+		return
+	}
+	response := []*ApiEventDelivery{}
+	for _, delivery := range deliveries {
+		response = append(response, NewApiEventDelivery(delivery))
+	}
+	env.apiResponse(rw, req, http.StatusOK, response)
+}
+
+func (env *Environ) ApiEventDeliveryReplay(rw http.ResponseWriter, req *http.Request) {
+	if err := env.outbox.Replay(mux.Vars(req)["id"]); err != nil {
+		env.apiError(rw, req, err, "cannot replay event delivery")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusNoContent, nil)
+}
+
+func (env *Environ) ApiEventDeliveryDiscard(rw http.ResponseWriter, req *http.Request) {
+	if err := env.outbox.Discard(mux.Vars(req)["id"]); err != nil {
+		env.apiError(rw, req, err, "cannot discard event delivery")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusNoContent, nil)
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiVirtualMachineMove(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	params := ApiVirtualMachineMoveParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	if params.NodeId == "" {
+		env.apiBadRequest(rw, req, "node is required")
+		return
+	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, params.NodeId, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	moveParams := compute.VirtualMachineMoveParams{NodeId: params.NodeId, Pool: params.Pool}
+	if err := env.vmanager.CheckMove(vm, moveParams); err != nil {
+		env.apiError(rw, req, err, "cannot move vm")
+		return
+	}
+	task := &compute.Task{Name: "vm_move", NodeId: moveParams.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Move(vm.Id, vm.NodeId, moveParams, progress)
+	})
+}
+
+func (env *Environ) ApiVirtualMachineExport(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	if vm.State != compute.StateStopped {
+		env.apiError(rw, req, util.NewError(compute.ErrMigrationNotPossible, "machine must be stopped"), "cannot export vm")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, vm.Id))
+	if err := env.vmanager.Export(vm.Id, vm.NodeId, rw, nil); err != nil {
+		env.logger.Warn().Err(err).Str("id", vm.Id).Str("node", vm.NodeId).Msg("vm export failed")
+	}
+}
+
+// ApiVirtualMachineImport reads archive from request body, so import
+// runs within the request instead of background task.
+func (env *Environ) ApiVirtualMachineImport(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	params := compute.VirtualMachineImportParams{
+		NodeId:  req.URL.Query().Get("node"),
+		Pool:    req.URL.Query().Get("pool"),
+		Owner:   user.Id,
+		Project: req.URL.Query().Get("project"),
+	}
+	if params.NodeId == "" {
+		env.apiBadRequest(rw, req, "node is required")
+		return
+	}
+	if !user.Can(auth.RoleAdmin, params.NodeId, "") {
+		env.forbidden(rw, req)
+		return
+	}
+	if params.Project != "" && user.Scoped() && !user.MemberOf(params.Project) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm, err := env.vmanager.Import(params, req.Body, nil)
+	if err != nil {
+		env.apiError(rw, req, err, "cannot import vm")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusCreated, NewApiVirtualMachine(vm))
+}
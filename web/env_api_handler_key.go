@@ -0,0 +1,62 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+)
+
+func (env *Environ) ApiKeyList(rw http.ResponseWriter, req *http.Request) {
+	keys, err := env.keys.List()
+	if err != nil {
+		env.apiError(rw, req, err, "key list failed")
+		return
+	}
+	result := []*ApiKey{}
+	for _, key := range keys {
+		result = append(result, NewApiKey(key))
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiKeyDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	key, err := env.keys.Get(urlvars["fingerprint"])
+	if err != nil {
+		env.apiError(rw, req, err, "key get failed")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiKey(key))
+}
+
+func (env *Environ) ApiKeyAdd(rw http.ResponseWriter, req *http.Request) {
+	params := ApiKeyAddParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(params.Value))
+	if err != nil {
+		env.apiBadRequest(rw, req, "invalid key: "+err.Error())
+		return
+	}
+	if err := env.keys.Add(params.Value); err != nil {
+		env.apiError(rw, req, err, "cannot add key")
+		return
+	}
+	key, err := env.keys.Get(ssh.FingerprintLegacyMD5(pubkey))
+	if err != nil {
+		env.apiError(rw, req, err, "key get failed")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusCreated, NewApiKey(key))
+}
+
+func (env *Environ) ApiKeyDelete(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.keys.Delete(urlvars["fingerprint"]); err != nil {
+		env.apiError(rw, req, err, "cannot delete key")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusNoContent, nil)
+}
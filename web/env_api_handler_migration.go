@@ -0,0 +1,39 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiVirtualMachineMigrate(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	params := ApiVirtualMachineMigrateParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	if params.NodeId == "" {
+		env.apiBadRequest(rw, req, "node is required")
+		return
+	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, params.NodeId, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	migrateParams := compute.VirtualMachineMigrateParams{NodeId: params.NodeId, CopyStorage: params.CopyStorage}
+	if err := env.vmanager.CheckMigration(vm, migrateParams); err != nil {
+		env.apiError(rw, req, err, "cannot migrate vm")
+		return
+	}
+	task := &compute.Task{Name: "vm_migrate", NodeId: migrateParams.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Migrate(vm.Id, vm.NodeId, migrateParams, progress)
+	})
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiNodeList(rw http.ResponseWriter, req *http.Request) {
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: req.URL.Query().Get("pins") != "true"})
+	if err != nil {
+		env.apiError(rw, req, err, "node list failed")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	result := []*ApiNode{}
+	for _, node := range nodes {
+		if user.CanNode(auth.RoleViewer, node.Id) {
+			result = append(result, NewApiNode(node))
+		}
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiNodeDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if !env.Session(req).AuthUser().CanNode(auth.RoleViewer, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	node, err := env.nodes.Get(urlvars["id"], compute.NodeGetOptions{})
+	if err != nil {
+		env.apiError(rw, req, err, "node get failed")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiNode(node))
+}
+
+func (env *Environ) ApiNetworkList(rw http.ResponseWriter, req *http.Request) {
+	networks, err := env.networks.List(compute.NetworkListOptions{NodeIds: req.URL.Query()["node"]})
+	if err != nil {
+		env.apiError(rw, req, err, "network list failed")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	result := []*ApiNetwork{}
+	for _, network := range networks {
+		if user.CanNode(auth.RoleViewer, network.NodeId) {
+			result = append(result, NewApiNetwork(network))
+		}
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiNetworkDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if !env.Session(req).AuthUser().CanNode(auth.RoleViewer, urlvars["node"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	network, err := env.networks.Get(urlvars["name"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "network get failed")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiNetwork(network))
+}
@@ -0,0 +1,18 @@
+package web
+
+import (
+	"net/http"
+)
+
+func (env *Environ) ApiQuotaList(rw http.ResponseWriter, req *http.Request) {
+	quotas, err := env.userQuotas(env.Session(req).AuthUser())
+	if err != nil {
+		env.apiError(rw, req, err, "quota list failed")
+		return
+	}
+	result := []*ApiQuota{}
+	for _, quota := range quotas {
+		result = append(result, NewApiQuota(quota))
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
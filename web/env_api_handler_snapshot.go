@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiVirtualMachineSnapshotList(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	snapshots, err := env.vms.ListSnapshots(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "cannot list snapshots")
+		return
+	}
+	result := []*ApiVirtualMachineSnapshot{}
+	for _, snapshot := range snapshots {
+		result = append(result, NewApiVirtualMachineSnapshot(snapshot))
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiVirtualMachineSnapshotCreate(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	params := ApiVirtualMachineSnapshotCreateParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	createParams := compute.VirtualMachineSnapshotCreateParams{
+		Name:        params.Name,
+		Description: params.Description,
+		External:    params.External,
+	}
+	task := &compute.Task{Name: "vm_snapshot_create", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "creating snapshot "+createParams.Name)
+		_, err := env.vms.CreateSnapshot(vm.Id, vm.NodeId, createParams)
+		return err
+	})
+}
+
+func (env *Environ) ApiVirtualMachineSnapshotRevert(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	name := urlvars["snapshot"]
+	task := &compute.Task{Name: "vm_snapshot_revert", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "reverting to snapshot "+name)
+		return env.vms.RevertSnapshot(vm.Id, vm.NodeId, name)
+	})
+}
+
+func (env *Environ) ApiVirtualMachineSnapshotDelete(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.vms.DeleteSnapshot(urlvars["id"], urlvars["node"], urlvars["snapshot"]); err != nil {
+		env.apiError(rw, req, err, "cannot delete snapshot")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusNoContent, nil)
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+// apiSubmitTask starts task on behalf of request user and responds with
+// 202 Accepted, Location header points to the task.
+func (env *Environ) apiSubmitTask(rw http.ResponseWriter, req *http.Request, task *compute.Task, fn compute.TaskFunc) {
+	if task.Owner == "" {
+		task.Owner = env.Session(req).AuthUser().Id
+	}
+	fn, recordTask := env.auditTask(req, fn)
+	submitted, err := env.tasks.Submit(task, fn)
+	if err != nil {
+		env.apiError(rw, req, err, "cannot submit task")
+		return
+	}
+	recordTask(submitted.Id)
+	rw.Header().Set("Location", env.url("api-task-detail", "id", submitted.Id).Path)
+	env.apiResponse(rw, req, http.StatusAccepted, NewApiTask(submitted))
+}
+
+func (env *Environ) ApiTaskList(rw http.ResponseWriter, req *http.Request) {
+	tasks, err := env.userTasks(env.Session(req).AuthUser(), taskListOptionsFromRequest(req))
+	if err != nil {
+		env.apiError(rw, req, err, "task list failed")
+		return
+	}
+	result := []*ApiTask{}
+	for _, task := range tasks {
+		result = append(result, NewApiTask(task))
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiTaskDetail(rw http.ResponseWriter, req *http.Request) {
+	task, err := env.tasks.Get(mux.Vars(req)["id"])
+	if err != nil {
+		env.apiError(rw, req, err, "task get failed")
+		return
+	}
+	if !taskVisible(env.Session(req).AuthUser(), task) {
+		env.forbidden(rw, req)
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiTask(task))
+}
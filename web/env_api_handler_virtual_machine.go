@@ -0,0 +1,367 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiVirtualMachineList(rw http.ResponseWriter, req *http.Request) {
+	options := compute.VirtualMachineListOptions{
+		NodeIds:  req.URL.Query()["node"],
+		Owners:   req.URL.Query()["owner"],
+		Projects: req.URL.Query()["project"],
+	}
+	states, err := virtualMachineStatesFromQuery(req.URL.Query()["state"])
+	if err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	options.States = states
+	vms, err := env.vms.List(options)
+	if err != nil {
+		env.apiError(rw, req, err, "vm list failed")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	result := []*ApiVirtualMachine{}
+	for _, vm := range vms {
+		if user.Can(auth.RoleViewer, vm.NodeId, vm.Id) && user.Owns(vm.Owner, vm.Project) {
+			result = append(result, NewApiVirtualMachine(vm))
+		}
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiVirtualMachineDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiVirtualMachine(vm))
+}
+
+func (env *Environ) apiVirtualMachineFromUpdateParams(vm *compute.VirtualMachine, params ApiVirtualMachineUpdateParams) error {
+	if params.VCpus <= 0 {
+		return fmt.Errorf("invalid vcpus value %d", params.VCpus)
+	}
+	memory, err := params.Memory.Size()
+	if err != nil {
+		return fmt.Errorf("invalid memory: %s", err)
+	}
+	var graphicType compute.GraphicType = compute.GraphicTypeNone
+	if params.Graphic.Type != "" {
+		graphicType = compute.NewGraphicType(params.Graphic.Type)
+		if graphicType == compute.GraphicTypeUnknown {
+			return fmt.Errorf("unknown graphic type '%s'", params.Graphic.Type)
+		}
+	}
+	var videoModel compute.VideoModel = compute.VideoModelNone
+	if params.VideoModel != "" {
+		videoModel = compute.NewVideoModel(params.VideoModel)
+		if videoModel == compute.VideoModelUnknown {
+			return fmt.Errorf("unknown video model '%s'", params.VideoModel)
+		}
+	}
+	vm.VCpus = params.VCpus
+	vm.Memory = memory
+	vm.GuestAgent = params.GuestAgent
+	vm.Autostart = params.Autostart
+	vm.Hugepages = params.Hugepages
+	vm.Graphic = compute.VirtualMachineGraphic{Type: graphicType, Listen: params.Graphic.Listen}
+	vm.VideoModel = videoModel
+	return nil
+}
+
+func (env *Environ) ApiVirtualMachineCreate(rw http.ResponseWriter, req *http.Request) {
+	params := ApiVirtualMachineCreateParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	if params.Id == "" || params.NodeId == "" {
+		env.apiBadRequest(rw, req, "id and node are required")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	if !user.Can(auth.RoleAdmin, params.NodeId, params.Id) {
+		env.forbidden(rw, req)
+		return
+	}
+	if params.Project != "" && user.Scoped() && !user.MemberOf(params.Project) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm := &compute.VirtualMachine{Id: params.Id, NodeId: params.NodeId, Owner: user.Id, Project: params.Project}
+	if err := env.apiVirtualMachineFromUpdateParams(vm, params.ApiVirtualMachineUpdateParams); err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	vm.Autostart = params.Start || params.Autostart
+
+	for _, p := range params.AttachVolumes {
+		attachedVolume, err := p.AttachedVolume()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid attached volume: "+err.Error())
+			return
+		}
+		vm.Volumes = append(vm.Volumes, attachedVolume)
+	}
+	for _, p := range params.Interfaces {
+		attachedIface, err := p.AttachedInterface()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid interface: "+err.Error())
+			return
+		}
+		vm.Interfaces = append(vm.Interfaces, attachedIface)
+	}
+
+	cloneVols := []compute.VirtualMachineManagerClonedVolumeParams{}
+	for _, p := range params.CloneVolumes {
+		device := ApiVirtualMachineAttachedVolume{DeviceType: p.DeviceType, DeviceBus: p.DeviceBus}
+		attachment, err := device.AttachedVolume()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid cloned volume: "+err.Error())
+			return
+		}
+		size, err := p.NewSize.Size()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid cloned volume size: "+err.Error())
+			return
+		}
+		cloneVols = append(cloneVols, compute.VirtualMachineManagerClonedVolumeParams{
+			OriginalPath: p.OriginalPath,
+			NewName:      p.NewName,
+			NewPool:      p.NewPool,
+			NewSize:      size,
+			NewFormat:    compute.NewVolumeFormat(p.NewFormat),
+			Alias:        p.Alias,
+			DeviceType:   attachment.DeviceType,
+			DeviceBus:    attachment.DeviceBus,
+		})
+	}
+
+	volumePaths := []string{}
+	for _, attached := range vm.Volumes {
+		volumePaths = append(volumePaths, attached.Path)
+	}
+	for _, p := range cloneVols {
+		volumePaths = append(volumePaths, p.OriginalPath)
+	}
+	if permitted, err := env.volumesPermitted(user, vm.NodeId, volumePaths...); err != nil {
+		env.apiError(rw, req, err, "volume check failed")
+		return
+	} else if !permitted {
+		env.forbidden(rw, req)
+		return
+	}
+
+	newVols := []compute.VirtualMachineManagerCreatedVolumeParams{}
+	for _, p := range params.CreateVolumes {
+		device := ApiVirtualMachineAttachedVolume{DeviceType: p.DeviceType, DeviceBus: p.DeviceBus}
+		attachment, err := device.AttachedVolume()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid new volume: "+err.Error())
+			return
+		}
+		size, err := p.Size.Size()
+		if err != nil {
+			env.apiBadRequest(rw, req, "invalid new volume size: "+err.Error())
+			return
+		}
+		newVols = append(newVols, compute.VirtualMachineManagerCreatedVolumeParams{
+			Name:       p.Name,
+			Pool:       p.Pool,
+			Format:     compute.NewVolumeFormat(p.Format),
+			Size:       size,
+			Alias:      p.Alias,
+			DeviceType: attachment.DeviceType,
+			DeviceBus:  attachment.DeviceBus,
+		})
+	}
+
+	vm.Config = &compute.VirtualMachineConfig{
+		Hostname: params.Id,
+		Userdata: []byte(params.Userdata),
+	}
+	for _, fp := range params.Keys {
+		key, err := env.keys.Get(fp)
+		if err != nil {
+			env.apiError(rw, req, err, "cannot fetch key")
+			return
+		}
+		vm.Config.Keys = append(vm.Config.Keys, key)
+	}
+
+	if err := env.vmanager.CheckQuota(vm, cloneVols, newVols); err != nil {
+		env.apiError(rw, req, err, "cannot create vm")
+		return
+	}
+	task := &compute.Task{Name: "vm_create", NodeId: vm.NodeId, TargetId: vm.Id, Owner: vm.Owner, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Create(vm, cloneVols, newVols, params.Start, progress)
+	})
+}
+
+func (env *Environ) ApiVirtualMachineUpdate(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	params := NewApiVirtualMachineUpdateParams(vm)
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	if err := env.apiVirtualMachineFromUpdateParams(vm, params); err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	if err := env.vms.Update(vm); err != nil {
+		env.apiError(rw, req, err, "cannot update vm")
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
+
+func (env *Environ) ApiVirtualMachineDeletePlan(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	plan, err := env.vmanager.PlanDelete(vm, req.URL.Query().Get("delete_volumes") == "true")
+	if err != nil {
+		env.apiError(rw, req, err, "cannot plan vm deletion")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiVirtualMachineDeletePlan(plan))
+}
+
+func (env *Environ) ApiVirtualMachineDelete(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	query := req.URL.Query()
+	deleteVolumes := query.Get("delete_volumes") == "true"
+	var confirmed []string
+	if _, ok := query["volume"]; ok && deleteVolumes {
+		confirmed = []string{}
+		for _, path := range query["volume"] {
+			if path != "" {
+				confirmed = append(confirmed, path)
+			}
+		}
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "vm get failed")
+		return
+	}
+	plan, err := env.vmanager.PlanDelete(vm, deleteVolumes)
+	if err != nil {
+		env.apiError(rw, req, err, "cannot plan vm deletion")
+		return
+	}
+	if confirmed != nil && !plan.Matches(confirmed) {
+		env.apiError(rw, req, util.NewError(compute.ErrDeletePlanChanged, "volumes to delete are %s", strings.Join(plan.DeletedVolumes(), ", ")), "cannot delete vm")
+		return
+	}
+	task := &compute.Task{Name: "vm_delete", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Delete(vm.Id, vm.NodeId, deleteVolumes, confirmed, progress)
+	})
+}
+
+func (env *Environ) ApiVirtualMachineStateSet(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if urlvars["action"] == "shutdown" {
+		vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+		if err != nil {
+			env.apiError(rw, req, err, "vm get failed")
+			return
+		}
+		task := &compute.Task{Name: "vm_shutdown", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+		env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+			return env.vms.Shutdown(vm.Id, vm.NodeId, progress)
+		})
+		return
+	}
+	if err := env.vms.Action(urlvars["id"], urlvars["node"], urlvars["action"]); err != nil {
+		env.apiError(rw, req, err, fmt.Sprintf("failed to %s vm", urlvars["action"]))
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
+
+func (env *Environ) ApiVirtualMachineAttachVolume(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	params := ApiVirtualMachineAttachedVolume{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	attachedVolume, err := params.AttachedVolume()
+	if err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	if permitted, err := env.volumesPermitted(env.Session(req).AuthUser(), urlvars["node"], attachedVolume.Path); err != nil {
+		env.apiError(rw, req, err, "volume check failed")
+		return
+	} else if !permitted {
+		env.forbidden(rw, req)
+		return
+	}
+	if err := env.vms.AttachVolume(urlvars["id"], urlvars["node"], attachedVolume); err != nil {
+		env.apiError(rw, req, err, "cannot attach volume")
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
+
+func (env *Environ) ApiVirtualMachineDetachVolume(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	path := req.URL.Query().Get("path")
+	if path == "" {
+		env.apiBadRequest(rw, req, "no volume path specified")
+		return
+	}
+	if err := env.vms.DetachVolume(urlvars["id"], urlvars["node"], path); err != nil {
+		env.apiError(rw, req, err, "cannot detach volume")
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
+
+func (env *Environ) ApiVirtualMachineAttachInterface(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	params := ApiVirtualMachineAttachedInterface{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	attachedIface, err := params.AttachedInterface()
+	if err != nil {
+		env.apiBadRequest(rw, req, err.Error())
+		return
+	}
+	if err := env.vms.AttachInterface(urlvars["id"], urlvars["node"], attachedIface); err != nil {
+		env.apiError(rw, req, err, "cannot attach interface")
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
+
+func (env *Environ) ApiVirtualMachineDetachInterface(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.vms.DetachInterface(urlvars["id"], urlvars["node"], urlvars["mac"]); err != nil {
+		env.apiError(rw, req, err, "cannot detach interface")
+		return
+	}
+	env.ApiVirtualMachineDetail(rw, req)
+}
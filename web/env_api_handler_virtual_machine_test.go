@@ -0,0 +1,186 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subuk/vmango/compute"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/unrolled/render"
+)
+
+// fakeVirtualMachineRepository keeps machines in memory, methods not
+// overridden here panic.
+type fakeVirtualMachineRepository struct {
+	compute.VirtualMachineRepository
+	vms []*compute.VirtualMachine
+}
+
+func (repo *fakeVirtualMachineRepository) Get(id, node string) (*compute.VirtualMachine, error) {
+	for _, vm := range repo.vms {
+		if vm.Id == id && vm.NodeId == node {
+			copied := *vm
+			return &copied, nil
+		}
+	}
+	return nil, compute.ErrVirtualMachineNotFound
+}
+
+func (repo *fakeVirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
+	for idx, existing := range repo.vms {
+		if existing.Id == vm.Id && existing.NodeId == vm.NodeId {
+			repo.vms[idx] = vm
+		}
+	}
+	return nil
+}
+
+func (repo *fakeVirtualMachineRepository) AttachVolume(id, node string, attachedVolume *compute.VirtualMachineAttachedVolume) error {
+	vm, err := repo.Get(id, node)
+	if err != nil {
+		return err
+	}
+	vm.Volumes = append(vm.Volumes, attachedVolume)
+	return repo.Save(vm)
+}
+
+type fakeVolumeRepository struct {
+	compute.VolumeRepository
+	volumes []*compute.Volume
+}
+
+func (repo *fakeVolumeRepository) Get(path, node string) (*compute.Volume, error) {
+	for _, volume := range repo.volumes {
+		if volume.Path == path && volume.NodeId == node {
+			copied := *volume
+			return &copied, nil
+		}
+	}
+	return nil, compute.ErrVolumeNotFound
+}
+
+type fakeVolumeOwnershipRepository struct {
+	compute.VolumeOwnershipRepository
+}
+
+func (repo *fakeVolumeOwnershipRepository) List() ([]*compute.VolumeOwnership, error) {
+	return nil, nil
+}
+
+func TestEnvironApiVirtualMachineUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       func(vm *compute.VirtualMachine) bool
+	}{
+		{
+			name:       "omitted fields are kept",
+			body:       `{"memory": {"value": 4, "unit": "G"}}`,
+			wantStatus: http.StatusOK,
+			want: func(vm *compute.VirtualMachine) bool {
+				return vm.Memory.Bytes() == compute.NewSize(4, compute.SizeUnitG).Bytes() && vm.VCpus == 2 &&
+					vm.GuestAgent && vm.Autostart && vm.Graphic.Type == compute.GraphicTypeVnc &&
+					vm.VideoModel == compute.VideoModelQxl && vm.Cpupin != nil && vm.Owner == "alice" && vm.Project == "web"
+			},
+		},
+		{
+			name:       "submitted fields are changed",
+			body:       `{"vcpus": 4, "guest_agent": false, "graphic": {"type": "none"}}`,
+			wantStatus: http.StatusOK,
+			want: func(vm *compute.VirtualMachine) bool {
+				return vm.VCpus == 4 && !vm.GuestAgent && vm.Graphic.Type == compute.GraphicTypeNone && vm.Autostart && vm.Cpupin != nil
+			},
+		},
+		{
+			name:       "invalid value",
+			body:       `{"vcpus": 0}`,
+			wantStatus: http.StatusBadRequest,
+			want: func(vm *compute.VirtualMachine) bool {
+				return vm.VCpus == 2
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeVirtualMachineRepository{vms: []*compute.VirtualMachine{{
+				Id:         "web1",
+				NodeId:     "n1",
+				VCpus:      2,
+				Memory:     compute.NewSize(2, compute.SizeUnitG),
+				GuestAgent: true,
+				Autostart:  true,
+				Graphic:    compute.VirtualMachineGraphic{Type: compute.GraphicTypeVnc},
+				VideoModel: compute.VideoModelQxl,
+				Cpupin:     &compute.VirtualMachineCpuPin{Emulator: []uint{0}},
+				Owner:      "alice",
+				Project:    "web",
+			}}}
+			env := &Environ{
+				logger: zerolog.Nop(),
+				render: render.New(),
+				vms:    compute.NewVirtualMachineService(repo, compute.EventPublishers{}, time.Second),
+			}
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/machines/n1/web1/", strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"id": "web1", "node": "n1"})
+			rw := httptest.NewRecorder()
+			env.ApiVirtualMachineUpdate(rw, req)
+			if rw.Code != tt.wantStatus {
+				t.Fatalf("ApiVirtualMachineUpdate() status = %d, want %d: %s", rw.Code, tt.wantStatus, rw.Body.String())
+			}
+			if vm := repo.vms[0]; !tt.want(vm) {
+				t.Errorf("ApiVirtualMachineUpdate() saved %+v", vm)
+			}
+		})
+	}
+}
+
+func TestEnvironApiVirtualMachineAttachVolume(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *User
+		path       string
+		wantStatus int
+	}{
+		{"unscoped user", &User{Id: "admin"}, "/pool/db1_root", http.StatusOK},
+		{"own project volume", &User{Id: "alice", Projects: []string{"web"}}, "/pool/web2_root", http.StatusOK},
+		{"shared volume", &User{Id: "alice", Projects: []string{"web"}}, "/pool/ubuntu.iso", http.StatusOK},
+		{"other project volume", &User{Id: "alice", Projects: []string{"web"}}, "/pool/db1_root", http.StatusForbidden},
+		{"unknown volume", &User{Id: "alice", Projects: []string{"web"}}, "/pool/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeVirtualMachineRepository{vms: []*compute.VirtualMachine{
+				{Id: "web1", NodeId: "n1", Memory: compute.NewSize(1, compute.SizeUnitG), Owner: "alice", Project: "web"},
+			}}
+			volumes := &fakeVolumeRepository{volumes: []*compute.Volume{
+				{Path: "/pool/web2_root", NodeId: "n1", Project: "web"},
+				{Path: "/pool/db1_root", NodeId: "n1", AttachedTo: "db1", Owner: "bob", Project: "db"},
+				{Path: "/pool/ubuntu.iso", NodeId: "n1"},
+			}}
+			env := &Environ{
+				logger:  zerolog.Nop(),
+				render:  render.New(),
+				vms:     compute.NewVirtualMachineService(repo, compute.EventPublishers{}, time.Second),
+				volumes: compute.NewVolumeService(volumes, &fakeVolumeOwnershipRepository{}, compute.EventPublishers{}),
+			}
+			body := `{"path": "` + tt.path + `", "device_type": "disk", "device_bus": "virtio"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/machines/n1/web1/volumes/", strings.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), CONTEXT_TOKEN_USER_KEY, tt.user))
+			req = mux.SetURLVars(req, map[string]string{"id": "web1", "node": "n1"})
+			rw := httptest.NewRecorder()
+			env.ApiVirtualMachineAttachVolume(rw, req)
+			if rw.Code != tt.wantStatus {
+				t.Fatalf("ApiVirtualMachineAttachVolume() status = %d, want %d: %s", rw.Code, tt.wantStatus, rw.Body.String())
+			}
+			if attached := len(repo.vms[0].Volumes) > 0; attached != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("ApiVirtualMachineAttachVolume() attached = %v", attached)
+			}
+		})
+	}
+}
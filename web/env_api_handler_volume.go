@@ -0,0 +1,168 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ApiVolumeList(rw http.ResponseWriter, req *http.Request) {
+	options := compute.VolumeListOptions{
+		NodeIds:   req.URL.Query()["node"],
+		PoolNames: req.URL.Query()["pool"],
+	}
+	volumes, err := env.volumes.List(options)
+	if err != nil {
+		env.apiError(rw, req, err, "volume list failed")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	result := []*ApiVolume{}
+	for _, volume := range volumes {
+		if user.CanNode(auth.RoleViewer, volume.NodeId) && (!volume.Owned() || user.Owns(volume.Owner, volume.Project)) {
+			result = append(result, NewApiVolume(volume))
+		}
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
+
+func (env *Environ) ApiVolumeDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	path := strings.Replace(urlvars["path"], "%2F", "/", -1)
+	if !env.Session(req).AuthUser().CanNode(auth.RoleViewer, urlvars["node"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	volume, err := env.volumes.Get(path, urlvars["node"])
+	if err != nil {
+		env.apiError(rw, req, err, "volume get failed")
+		return
+	}
+	if volume.Owned() && !env.Session(req).AuthUser().Owns(volume.Owner, volume.Project) {
+		env.forbidden(rw, req)
+		return
+	}
+	env.apiResponse(rw, req, http.StatusOK, NewApiVolume(volume))
+}
+
+func (env *Environ) ApiVolumeCreate(rw http.ResponseWriter, req *http.Request) {
+	params := ApiVolumeCreateParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	size, err := params.Size.Size()
+	if err != nil {
+		env.apiBadRequest(rw, req, "invalid volume size: "+err.Error())
+		return
+	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, params.NodeId, "") {
+		env.forbidden(rw, req)
+		return
+	}
+	volume, err := env.vmanager.CreateVolume(compute.VolumeCreateParams{
+		NodeId: params.NodeId,
+		Name:   params.Name,
+		Pool:   params.Pool,
+		Format: compute.NewVolumeFormat(params.Format),
+		Size:   size,
+		Owner:  env.Session(req).AuthUser().VolumeOwner(),
+	})
+	if err != nil {
+		env.apiError(rw, req, err, "cannot create volume")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusCreated, NewApiVolume(volume))
+}
+
+func (env *Environ) ApiVolumeClone(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	path := strings.Replace(urlvars["path"], "%2F", "/", -1)
+	params := ApiVolumeCloneParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	size, err := params.Size.Size()
+	if err != nil {
+		env.apiBadRequest(rw, req, "invalid new volume size: "+err.Error())
+		return
+	}
+	if _, err := env.volumes.Get(path, urlvars["node"]); err != nil {
+		env.apiError(rw, req, err, "volume get failed")
+		return
+	}
+	cloneParams := compute.VolumeCloneParams{
+		NodeId:       urlvars["node"],
+		Format:       compute.NewVolumeFormat(params.Format),
+		OriginalPath: path,
+		NewName:      params.Name,
+		NewPool:      params.Pool,
+		NewSize:      size,
+		Owner:        env.Session(req).AuthUser().VolumeOwner(),
+	}
+	task := &compute.Task{Name: "volume_clone", NodeId: cloneParams.NodeId, TargetId: cloneParams.NewName}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "cloning volume "+cloneParams.OriginalPath)
+		volume, err := env.vmanager.CloneVolume(cloneParams)
+		if err != nil {
+			return err
+		}
+		progress.Target(volume.Path)
+		return nil
+	})
+}
+
+func (env *Environ) ApiVolumeResize(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	path := strings.Replace(urlvars["path"], "%2F", "/", -1)
+	params := ApiVolumeResizeParams{}
+	if !env.apiDecode(rw, req, &params) {
+		return
+	}
+	size, err := params.Size.Size()
+	if err != nil {
+		env.apiBadRequest(rw, req, "invalid new volume size: "+err.Error())
+		return
+	}
+	if _, err := env.volumes.Get(path, urlvars["node"]); err != nil {
+		env.apiError(rw, req, err, "volume get failed")
+		return
+	}
+	task := &compute.Task{Name: "volume_resize", NodeId: urlvars["node"], TargetId: path}
+	env.apiSubmitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "resizing volume "+path)
+		return env.volumes.Resize(path, urlvars["node"], size)
+	})
+}
+
+func (env *Environ) ApiVolumeDelete(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	path := strings.Replace(urlvars["path"], "%2F", "/", -1)
+	if _, err := env.volumes.Get(path, urlvars["node"]); err != nil {
+		env.apiError(rw, req, err, "volume get failed")
+		return
+	}
+	if err := env.volumes.Delete(path, urlvars["node"]); err != nil {
+		env.apiError(rw, req, err, "cannot delete volume")
+		return
+	}
+	env.apiResponse(rw, req, http.StatusNoContent, nil)
+}
+
+func (env *Environ) ApiVolumePoolList(rw http.ResponseWriter, req *http.Request) {
+	pools, err := env.volpools.List(compute.VolumePoolListOptions{NodeIds: req.URL.Query()["node"]})
+	if err != nil {
+		env.apiError(rw, req, err, "pool list failed")
+		return
+	}
+	user := env.Session(req).AuthUser()
+	result := []*ApiVolumePool{}
+	for _, pool := range pools {
+		if user.CanNode(auth.RoleViewer, pool.NodeId) {
+			result = append(result, NewApiVolumePool(pool))
+		}
+	}
+	env.apiResponse(rw, req, http.StatusOK, result)
+}
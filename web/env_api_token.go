@@ -0,0 +1,49 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const CONTEXT_TOKEN_USER_KEY contextKey = "token_user"
+const CONTEXT_AUDIT_ENTRY_KEY contextKey = "audit_entry"
+
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// Requests carrying a bearer token cannot be forged by a browser,
+// so they bypass csrf protection and go straight to the router.
+func (env *Environ) bearerAuthenticated(protected http.Handler, unprotected http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		plain, ok := bearerToken(req)
+		if !ok {
+			protected.ServeHTTP(rw, req)
+			return
+		}
+		unauthorized := ApiError{Status: http.StatusUnauthorized, Error: "invalid api token"}
+		token, err := env.tokens.Authenticate(plain)
+		if err != nil {
+			env.logger.Warn().Err(err).Str("remote", req.RemoteAddr).Msg("api token authentication failure")
+			env.render.JSON(rw, http.StatusUnauthorized, unauthorized)
+			return
+		}
+		principal, err := env.authn.Lookup(token.UserId)
+		if err != nil {
+			env.logger.Warn().Err(err).Str("user", token.UserId).Str("token", token.Name).Msg("api token belongs to unknown user")
+			env.render.JSON(rw, http.StatusUnauthorized, unauthorized)
+			return
+		}
+		user := NewUserFromPrincipal(principal)
+		user.ApiToken = token.Name
+		ctx := context.WithValue(req.Context(), CONTEXT_TOKEN_USER_KEY, user)
+		unprotected.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"subuk/vmango/audit"
+	"subuk/vmango/compute"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const auditMaxBodySize = 1024 * 1024
+
+var auditSensitiveParams = []string{"password", "secret", "token", "csrf", "userdata"}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *auditResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *auditResponseWriter) Write(data []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+func auditParamSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range auditSensitiveParams {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditRedact hides values of sensitive keys in params and in maps and
+// arrays nested into them, like config of created machine in json body.
+func auditRedact(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, nested := range value {
+			if auditParamSensitive(name) {
+				value[name] = "***"
+				continue
+			}
+			auditRedact(nested)
+		}
+	case []interface{}:
+		for _, nested := range value {
+			auditRedact(nested)
+		}
+	}
+}
+
+func auditAddValues(params map[string]interface{}, values url.Values) {
+	for name, value := range values {
+		if len(value) == 1 {
+			params[name] = value[0]
+		} else {
+			params[name] = value
+		}
+	}
+}
+
+// auditRequestParams collects query, form or json body parameters of request.
+// Json body is read and put back, so handler can read it again.
+func auditRequestParams(req *http.Request) map[string]interface{} {
+	params := map[string]interface{}{}
+	auditAddValues(params, req.URL.Query())
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		// Form may be already parsed by csrf protection, so body is empty
+		if err := req.ParseForm(); err == nil {
+			auditAddValues(params, req.PostForm)
+		}
+	case mediaType == "application/json" || (mediaType == "" && isApiRequest(req)):
+		if req.Body == nil {
+			break
+		}
+		original := req.Body
+		body, err := ioutil.ReadAll(io.LimitReader(original, auditMaxBodySize+1))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), original), original}
+		if err != nil || len(body) > auditMaxBodySize {
+			break
+		}
+		jsonParams := map[string]interface{}{}
+		if err := json.Unmarshal(body, &jsonParams); err == nil {
+			for name, value := range jsonParams {
+				params[name] = value
+			}
+		}
+	}
+	for name, value := range mux.Vars(req) {
+		params[name] = strings.Replace(value, "%2F", "/", -1)
+	}
+	auditRedact(params)
+	return params
+}
+
+func auditFirstParam(params map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if value, ok := params[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// audited records every request which may change something,
+// that is every request except GET, HEAD and OPTIONS.
+func (env *Environ) audited(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+		params := auditRequestParams(req)
+		entry := &audit.Entry{
+			Time:       time.Now(),
+			RemoteAddr: req.RemoteAddr,
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			NodeId:     auditFirstParam(params, "node", "NodeId"),
+			TargetId:   auditFirstParam(params, "id", "path", "fingerprint", "name", "Name", "Path"),
+			Params:     params,
+		}
+		if route := mux.CurrentRoute(req); route != nil {
+			entry.Action = route.GetName()
+		}
+		user := env.Session(req).AuthUser()
+		entry.User = user.Id
+		entry.ApiToken = user.ApiToken
+		if entry.User == "" {
+			entry.User = auditFirstParam(params, "Username")
+		}
+
+		auditRw := &auditResponseWriter{ResponseWriter: rw}
+		handler.ServeHTTP(auditRw, req.WithContext(context.WithValue(req.Context(), CONTEXT_AUDIT_ENTRY_KEY, entry)))
+
+		entry.Status = auditRw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Result = audit.ResultSuccess
+		if entry.Status >= http.StatusBadRequest {
+			entry.Result = audit.ResultFailure
+		} else if entry.TaskId != "" {
+			entry.Result = audit.ResultQueued
+		}
+		if err := env.audit.Append(entry); err != nil {
+			env.logger.Error().Err(err).Str("user", entry.User).Str("action", entry.Action).Msg("cannot write audit entry")
+		}
+	})
+}
+
+// auditTask makes task submitted by audited request append its outcome to
+// audit log when it finishes. Returned function must be called with task
+// id after submit, it is recorded in the request entry.
+func (env *Environ) auditTask(req *http.Request, fn compute.TaskFunc) (compute.TaskFunc, func(taskId string)) {
+	entry, ok := req.Context().Value(CONTEXT_AUDIT_ENTRY_KEY).(*audit.Entry)
+	if !ok {
+		return fn, func(string) {}
+	}
+	base := *entry
+	audited := func(progress *compute.TaskProgress) error {
+		err := fn(progress)
+		outcome := base
+		outcome.Time = time.Now()
+		outcome.TaskId = progress.TaskId()
+		outcome.Result = audit.ResultSuccess
+		if err != nil {
+			outcome.Result = audit.ResultFailure
+			outcome.Error = err.Error()
+		}
+		if appendErr := env.audit.Append(&outcome); appendErr != nil {
+			env.logger.Error().Err(appendErr).Str("user", outcome.User).Str("task", outcome.TaskId).Msg("cannot write audit entry")
+		}
+		return err
+	}
+	return audited, func(taskId string) { entry.TaskId = taskId }
+}
@@ -0,0 +1,183 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"subuk/vmango/audit"
+	"subuk/vmango/compute"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type fakeAuditRepository struct {
+	mu      sync.Mutex
+	entries []*audit.Entry
+}
+
+func (repo *fakeAuditRepository) Append(entry *audit.Entry) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	copied := *entry
+	repo.entries = append(repo.entries, &copied)
+	return nil
+}
+
+func (repo *fakeAuditRepository) List(options audit.EntryListOptions) ([]*audit.Entry, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.entries, nil
+}
+
+func TestAuditParamSensitive(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Password", true},
+		{"ClientSecret", true},
+		{"csrf_token", true},
+		{"Userdata", true},
+		{"Name", false},
+		{"node", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditParamSensitive(tt.name); got != tt.want {
+				t.Errorf("auditParamSensitive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditRequestParams(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]interface{}
+	}{
+		{
+			name: "top level",
+			body: `{"name": "web1", "password": "p"}`,
+			want: map[string]interface{}{"name": "web1", "password": "***"},
+		},
+		{
+			name: "nested object",
+			body: `{"config": {"hostname": "web1", "userdata": "#!/bin/sh", "auth": {"root_password": "p"}}}`,
+			want: map[string]interface{}{"config": map[string]interface{}{"hostname": "web1", "userdata": "***", "auth": map[string]interface{}{"root_password": "***"}}},
+		},
+		{
+			name: "objects in array",
+			body: `{"webhooks": [{"url": "http://hook", "secret": "s"}, "plain"]}`,
+			want: map[string]interface{}{"webhooks": []interface{}{map[string]interface{}{"url": "http://hook", "secret": "***"}, "plain"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/machines/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if got := auditRequestParams(req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditRequestParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironAuditedTask(t *testing.T) {
+	type result struct {
+		TaskId bool
+		Status int
+		Result string
+		Error  string
+	}
+	tests := []struct {
+		name    string
+		status  int
+		task    bool
+		taskErr error
+		want    []result
+	}{
+		{
+			name:   "request without task",
+			status: http.StatusFound,
+			want:   []result{{Status: http.StatusFound, Result: audit.ResultSuccess}},
+		},
+		{
+			name:   "failed request without task",
+			status: http.StatusInternalServerError,
+			want:   []result{{Status: http.StatusInternalServerError, Result: audit.ResultFailure}},
+		},
+		{
+			name:   "task done",
+			status: http.StatusAccepted,
+			task:   true,
+			want: []result{
+				{TaskId: true, Status: http.StatusAccepted, Result: audit.ResultQueued},
+				{TaskId: true, Result: audit.ResultSuccess},
+			},
+		},
+		{
+			name:    "task failed",
+			status:  http.StatusFound,
+			task:    true,
+			taskErr: errors.New("cannot clone volume"),
+			want: []result{
+				{TaskId: true, Status: http.StatusFound, Result: audit.ResultQueued},
+				{TaskId: true, Result: audit.ResultFailure, Error: "cannot clone volume"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeAuditRepository{}
+			env := &Environ{logger: zerolog.Nop(), audit: audit.NewLog(repo), tasks: compute.NewTaskService(1, 10)}
+			release := make(chan struct{})
+			var taskId string
+			handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tt.task {
+					fn, recordTask := env.auditTask(req, func(progress *compute.TaskProgress) error {
+						<-release
+						return tt.taskErr
+					})
+					submitted, err := env.tasks.Submit(&compute.Task{Name: "test"}, fn)
+					if err != nil {
+						t.Fatalf("Submit() error = %v", err)
+					}
+					recordTask(submitted.Id)
+					taskId = submitted.Id
+				}
+				rw.WriteHeader(tt.status)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/machines/add/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), CONTEXT_TOKEN_USER_KEY, &User{Id: "alice"}))
+			env.audited(handler).ServeHTTP(httptest.NewRecorder(), req)
+			close(release)
+			if tt.task {
+				for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+					if task, _ := env.tasks.Get(taskId); task.Completed() {
+						break
+					}
+				}
+			}
+			got := []result{}
+			for _, entry := range repo.entries {
+				if entry.User != "alice" {
+					t.Errorf("entry user = %s, want alice", entry.User)
+				}
+				if entry.TaskId != "" && entry.TaskId != taskId {
+					t.Errorf("entry task = %s, want %s", entry.TaskId, taskId)
+				}
+				got = append(got, result{TaskId: entry.TaskId != "", Status: entry.Status, Result: entry.Result, Error: entry.Error})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("audit entries = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// apiTokensAllowed reports whether tokens of user can authenticate. Token
+// owner is resolved with authenticators lookup, users known only to oidc
+// provider are not found there or found as another user with the same id.
+func (env *Environ) apiTokensAllowed(user *User) bool {
+	return !user.Sso
+}
+
+func (env *Environ) renderApiTokenList(rw http.ResponseWriter, req *http.Request, status int, newToken string) {
+	user := env.Session(req).AuthUser()
+	tokens, err := env.tokens.List(user.Id)
+	if err != nil {
+		env.error(rw, req, err, "token list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title    string
+		Tokens   []*auth.ApiToken
+		NewToken string
+		Allowed  bool
+		User     *User
+		Request  *http.Request
+	}{"API Tokens", tokens, newToken, env.apiTokensAllowed(user), user, req}
+	if err := env.render.HTML(rw, status, "api-token/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) ApiTokenList(rw http.ResponseWriter, req *http.Request) {
+	env.renderApiTokenList(rw, req, http.StatusOK, "")
+}
+
+func (env *Environ) ApiTokenAddFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := req.Form.Get("Name")
+	if name == "" {
+		http.Error(rw, "no token name specified", http.StatusBadRequest)
+		return
+	}
+	user := env.Session(req).AuthUser()
+	if !env.apiTokensAllowed(user) {
+		http.Error(rw, "api tokens are not available for single sign-on users", http.StatusForbidden)
+		return
+	}
+	token, plain, err := env.tokens.Issue(user.Id, name)
+	if err != nil {
+		env.error(rw, req, err, "cannot issue token", http.StatusInternalServerError)
+		return
+	}
+	env.logger.Info().Str("user", user.Id).Str("token", token.Name).Msg("api token issued")
+	env.renderApiTokenList(rw, req, http.StatusCreated, plain)
+}
+
+func (env *Environ) apiTokenGet(req *http.Request) (*auth.ApiToken, error) {
+	urlvars := mux.Vars(req)
+	tokens, err := env.tokens.List(env.Session(req).AuthUser().Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		if token.Id == urlvars["id"] {
+			return token, nil
+		}
+	}
+	return nil, auth.ErrApiTokenNotFound
+}
+
+func (env *Environ) ApiTokenDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
+	token, err := env.apiTokenGet(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == auth.ErrApiTokenNotFound {
+			status = http.StatusNotFound
+		}
+		env.error(rw, req, err, "token get failed", status)
+		return
+	}
+	data := struct {
+		Title   string
+		Token   *auth.ApiToken
+		User    *User
+		Request *http.Request
+	}{"Revoke API Token", token, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "api-token/delete", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) ApiTokenDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	user := env.Session(req).AuthUser()
+	if err := env.tokens.Revoke(urlvars["id"], user.Id); err != nil {
+		switch err {
+		default:
+			env.error(rw, req, err, "cannot revoke token", http.StatusInternalServerError)
+		case auth.ErrApiTokenNotFound:
+			env.error(rw, req, err, "token not found", http.StatusNotFound)
+		case auth.ErrApiTokenStatic:
+			env.error(rw, req, err, "cannot revoke token", http.StatusBadRequest)
+		}
+		return
+	}
+	env.logger.Info().Str("user", user.Id).Str("token", urlvars["id"]).Msg("api token revoked")
+	http.Redirect(rw, req, env.url("api-token-list").Path, http.StatusFound)
+}
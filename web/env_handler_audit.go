@@ -0,0 +1,54 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"subuk/vmango/audit"
+)
+
+const auditDefaultLimit = 200
+
+func auditListOptionsFromQuery(query url.Values) (audit.EntryListOptions, error) {
+	options := audit.EntryListOptions{
+		User:     query.Get("user"),
+		Action:   query.Get("action"),
+		NodeId:   query.Get("node"),
+		TargetId: query.Get("target"),
+		Result:   query.Get("result"),
+		TaskId:   query.Get("task"),
+		Limit:    auditDefaultLimit,
+	}
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.ParseUint(rawLimit, 10, 32)
+		if err != nil {
+			return options, fmt.Errorf("invalid limit '%s'", rawLimit)
+		}
+		options.Limit = int(limit)
+	}
+	return options, nil
+}
+
+func (env *Environ) AuditList(rw http.ResponseWriter, req *http.Request) {
+	options, err := auditListOptionsFromQuery(req.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := env.audit.List(options)
+	if err != nil {
+		env.error(rw, req, err, "audit list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title   string
+		Entries []*audit.Entry
+		Filter  audit.EntryListOptions
+		User    *User
+	}{"Audit", entries, options, env.Session(req).AuthUser()}
+	if err := env.render.HTML(rw, http.StatusOK, "audit/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
@@ -4,14 +4,34 @@ import (
 	"net/http"
 )
 
+func (env *Environ) renderLogin(rw http.ResponseWriter, req *http.Request, status int, errorMessage string) {
+	data := map[string]interface{}{
+		"Request":       req,
+		"Title":         "Login",
+		"PasswordLogin": !env.cfg.Oidc.DisablePasswordLogin,
+	}
+	if env.oidc != nil {
+		data["OidcTitle"] = env.cfg.Oidc.Title
+	}
+	if errorMessage != "" {
+		data["Error"] = errorMessage
+	}
+	env.render.HTML(rw, status, "login", data)
+}
+
 func (env *Environ) PasswordLoginFormShow(rw http.ResponseWriter, req *http.Request) {
-	env.render.HTML(rw, http.StatusOK, "login", map[string]interface{}{
-		"Request": req,
-		"Title":   "Login",
-	})
+	if env.oidc != nil && env.cfg.Oidc.DisablePasswordLogin {
+		http.Redirect(rw, req, env.url("login-oidc").Path, http.StatusFound)
+		return
+	}
+	env.renderLogin(rw, req, http.StatusOK, "")
 }
 
 func (env *Environ) PasswordLoginFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if env.cfg.Oidc.DisablePasswordLogin {
+		http.Error(rw, "password login disabled", http.StatusForbidden)
+		return
+	}
 	if err := req.ParseForm(); err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -20,11 +40,7 @@ func (env *Environ) PasswordLoginFormProcess(rw http.ResponseWriter, req *http.R
 	password := req.Form.Get("Password")
 	user := env.checkPassword(email, password)
 	if user == nil {
-		env.render.HTML(rw, http.StatusUnauthorized, "login", map[string]interface{}{
-			"Request": req,
-			"Title":   "Login",
-			"Error":   "Invalid username or password",
-		})
+		env.renderLogin(rw, req, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
 
@@ -44,7 +60,17 @@ func (env *Environ) PasswordLoginFormProcess(rw http.ResponseWriter, req *http.R
 func (env *Environ) Logout(rw http.ResponseWriter, req *http.Request) {
 	session := env.Session(req)
 	user := session.AuthUser()
+	redirectUrl := "/"
 	if user.Authenticated {
+		if idToken, ok := session.Values[SESSION_OIDC_ID_TOKEN_KEY].(string); ok && env.oidc != nil {
+			endSessionUrl, err := env.oidc.EndSessionURL(idToken, env.cfg.Oidc.PostLogoutRedirectUrl)
+			if err != nil {
+				env.logger.Warn().Err(err).Msg("cannot get oidc end session url")
+			}
+			if endSessionUrl != "" {
+				redirectUrl = endSessionUrl
+			}
+		}
 		session.Options.MaxAge = -1
 		if err := session.Save(req, rw); err != nil {
 			env.error(rw, req, err, "failed to save session", http.StatusInternalServerError)
@@ -52,5 +78,5 @@ func (env *Environ) Logout(rw http.ResponseWriter, req *http.Request) {
 		}
 		env.logger.Info().Str("user", user.Id).Msg("user logged out")
 	}
-	http.Redirect(rw, req, "/", http.StatusFound)
+	http.Redirect(rw, req, redirectUrl, http.StatusFound)
 }
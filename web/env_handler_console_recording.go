@@ -0,0 +1,87 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	consoleDefaultWidth  = 80
+	consoleDefaultHeight = 24
+)
+
+func consoleSizeFromQuery(query url.Values) (int, int) {
+	width, err := strconv.ParseUint(query.Get("cols"), 10, 16)
+	if err != nil || width == 0 {
+		width = consoleDefaultWidth
+	}
+	height, err := strconv.ParseUint(query.Get("rows"), 10, 16)
+	if err != nil || height == 0 {
+		height = consoleDefaultHeight
+	}
+	return int(width), int(height)
+}
+
+// machineRecording returns recording only if it belongs to the machine from url,
+// so machine permission check covers its recordings.
+func (env *Environ) machineRecording(req *http.Request) (*compute.ConsoleRecording, error) {
+	urlvars := mux.Vars(req)
+	recording, err := env.records.Get(urlvars["recording"])
+	if err != nil {
+		return nil, err
+	}
+	if recording.VmId != urlvars["id"] || recording.NodeId != urlvars["node"] {
+		return nil, compute.ErrConsoleRecordingNotFound
+	}
+	return recording, nil
+}
+
+func (env *Environ) VirtualMachineRecordingShow(rw http.ResponseWriter, req *http.Request) {
+	recording, err := env.machineRecording(req)
+	if err == compute.ErrConsoleRecordingNotFound {
+		env.error(rw, req, err, "console recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		env.error(rw, req, err, "cannot get console recording", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title     string
+		Recording *compute.ConsoleRecording
+		User      *User
+		Request   *http.Request
+	}{"Console Recording", recording, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/recording", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineRecordingCast(rw http.ResponseWriter, req *http.Request) {
+	recording, err := env.machineRecording(req)
+	if err == compute.ErrConsoleRecordingNotFound {
+		env.error(rw, req, err, "console recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		env.error(rw, req, err, "cannot get console recording", http.StatusInternalServerError)
+		return
+	}
+	content, err := env.records.Open(recording.Id)
+	if err != nil {
+		env.error(rw, req, err, "cannot open console recording", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	rw.Header().Set("Content-Type", "application/x-asciicast")
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+recording.VmId+"-"+recording.Started.Format("20060102-150405")+`.cast"`)
+	if _, err := io.Copy(rw, content); err != nil {
+		env.logger.Debug().Err(err).Str("recording", recording.Id).Msg("cannot send console recording")
+	}
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"time"
+)
+
+const eventStreamKeepAlive = 30 * time.Second
+
+// EventStream sends machine state changes visible to the user as
+// server-sent events until client disconnects.
+func (env *Environ) EventStream(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	user := env.Session(req).AuthUser()
+	events := env.events.Subscribe()
+	defer env.events.Unsubscribe(events)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepAlive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(rw, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			stateChanged, ok := event.(*compute.EventVirtualMachineStateChanged)
+			if !ok {
+				continue
+			}
+			if !user.Can(auth.RoleViewer, stateChanged.NodeId(), stateChanged.VmId()) {
+				continue
+			}
+			owner, project := "", ""
+			if vm := stateChanged.Vm(); vm != nil {
+				owner, project = vm.Owner, vm.Project
+			}
+			if !user.Owns(owner, project) {
+				continue
+			}
+			data, err := json.Marshal(NewApiVirtualMachineStateEvent(stateChanged))
+			if err != nil {
+				env.logger.Warn().Err(err).Msg("cannot marshal event")
+				continue
+			}
+			if _, err := fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event.Name(), data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func eventDeliveryListOptionsFromQuery(query url.Values, defaultState string) (compute.EventDeliveryListOptions, string, error) {
+	options := compute.EventDeliveryListOptions{}
+	rawState := defaultState
+	if _, ok := query["state"]; ok {
+		rawState = query.Get("state")
+	}
+	if rawState == "" {
+		return options, rawState, nil
+	}
+	state := compute.NewEventDeliveryState(rawState)
+	if state == compute.EventDeliveryStateUnknown {
+		return options, rawState, fmt.Errorf("invalid state '%s'", rawState)
+	}
+	options.States = []compute.EventDeliveryState{state}
+	return options, rawState, nil
+}
+
+func (env *Environ) EventDeliveryList(rw http.ResponseWriter, req *http.Request) {
+	options, state, err := eventDeliveryListOptionsFromQuery(req.URL.Query(), compute.EventDeliveryStateFailed.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deliveries, err := env.outbox.List(options)
+	if err != nil {
+		env.error(rw, req, err, "event delivery list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title      string
+		Deliveries []*compute.EventDelivery
+		State      string
+		User       *User
+		Request    *http.Request
+	}{"Event deliveries", deliveries, state, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "event-delivery/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) EventDeliveryReplayFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := env.outbox.Replay(mux.Vars(req)["id"]); err != nil {
+		env.error(rw, req, err, "cannot replay event delivery", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("event-delivery-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) EventDeliveryDiscardFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := env.outbox.Discard(mux.Vars(req)["id"]); err != nil {
+		env.error(rw, req, err, "cannot discard event delivery", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("event-delivery-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/gorilla/mux"
+)
+
+const importMaxMemory = 32 << 20
+
+func (env *Environ) VirtualMachineMoveFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	user := env.Session(req).AuthUser()
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	allNodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	nodes := []*compute.Node{}
+	for _, node := range allNodes {
+		if node.Id != vm.NodeId && user.Can(auth.RoleAdmin, node.Id, vm.Id) {
+			nodes = append(nodes, node)
+		}
+	}
+	data := struct {
+		Title   string
+		Vm      *compute.VirtualMachine
+		Nodes   []*compute.Node
+		User    *User
+		Request *http.Request
+	}{"Move Virtual Machine", vm, nodes, user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/move", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineMoveFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := compute.VirtualMachineMoveParams{
+		NodeId: req.Form.Get("NodeId"),
+		Pool:   req.Form.Get("Pool"),
+	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, params.NodeId, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	if err := env.vmanager.CheckMove(vm, params); err != nil {
+		status := http.StatusInternalServerError
+		if util.ErrorCause(err) == compute.ErrMigrationNotPossible {
+			status = http.StatusConflict
+		}
+		env.error(rw, req, err, "cannot move vm", status)
+		return
+	}
+	task := &compute.Task{Name: "vm_move", NodeId: params.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Move(vm.Id, vm.NodeId, params, progress)
+	})
+}
+
+func (env *Environ) VirtualMachineExport(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	if vm.State != compute.StateStopped {
+		env.error(rw, req, fmt.Errorf("machine must be stopped"), "cannot export vm", http.StatusConflict)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, vm.Id))
+	// Archive is streamed, error in the middle can only be logged.
+	if err := env.vmanager.Export(vm.Id, vm.NodeId, rw, nil); err != nil {
+		env.logger.Warn().Err(err).Str("id", vm.Id).Str("node", vm.NodeId).Msg("vm export failed")
+	}
+}
+
+func (env *Environ) VirtualMachineImportFormShow(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	allNodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	nodes := []*compute.Node{}
+	for _, node := range allNodes {
+		if user.Can(auth.RoleAdmin, node.Id, "") {
+			nodes = append(nodes, node)
+		}
+	}
+	data := struct {
+		Title   string
+		Nodes   []*compute.Node
+		User    *User
+		Request *http.Request
+	}{"Import Virtual Machine", nodes, user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/import", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineImportFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseMultipartForm(importMaxMemory); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := env.Session(req).AuthUser()
+	params := compute.VirtualMachineImportParams{
+		NodeId:  req.Form.Get("NodeId"),
+		Pool:    req.Form.Get("Pool"),
+		Owner:   user.Id,
+		Project: req.Form.Get("Project"),
+	}
+	if !user.Can(auth.RoleAdmin, params.NodeId, "") {
+		env.forbidden(rw, req)
+		return
+	}
+	if params.Project != "" && user.Scoped() && !user.MemberOf(params.Project) {
+		env.forbidden(rw, req)
+		return
+	}
+	archive, _, err := req.FormFile("Archive")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Uploaded archive is unlinked when request ends, but the open
+	// file stays readable until the task closes it.
+	task := &compute.Task{Name: "vm_import", NodeId: params.NodeId, Owner: params.Owner, Project: params.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		defer archive.Close()
+		vm, err := env.vmanager.Import(params, archive, progress)
+		if err != nil {
+			return err
+		}
+		progress.Target(vm.Id)
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) VirtualMachineMigrateFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	user := env.Session(req).AuthUser()
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	allNodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	nodes := []*compute.Node{}
+	for _, node := range allNodes {
+		if node.Id != vm.NodeId && user.Can(auth.RoleAdmin, node.Id, vm.Id) {
+			nodes = append(nodes, node)
+		}
+	}
+	data := struct {
+		Title   string
+		Vm      *compute.VirtualMachine
+		Nodes   []*compute.Node
+		User    *User
+		Request *http.Request
+	}{"Migrate Virtual Machine", vm, nodes, user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/migrate", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineMigrateFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := compute.VirtualMachineMigrateParams{
+		NodeId:      req.Form.Get("NodeId"),
+		CopyStorage: req.Form.Get("CopyStorage") == "true",
+	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, params.NodeId, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	if err := env.vmanager.CheckMigration(vm, params); err != nil {
+		status := http.StatusInternalServerError
+		if util.ErrorCause(err) == compute.ErrMigrationNotPossible {
+			status = http.StatusConflict
+		}
+		env.error(rw, req, err, "cannot migrate vm", status)
+		return
+	}
+	task := &compute.Task{Name: "vm_migrate", NodeId: params.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Migrate(vm.Id, vm.NodeId, params, progress)
+	})
+}
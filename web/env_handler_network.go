@@ -2,21 +2,29 @@ package web
 
 import (
 	"net/http"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
 )
 
 func (env *Environ) NetworkList(rw http.ResponseWriter, req *http.Request) {
-	networks, err := env.networks.List(compute.NetworkListOptions{})
+	user := env.Session(req).AuthUser()
+	allNetworks, err := env.networks.List(compute.NetworkListOptions{})
 	if err != nil {
 		env.error(rw, req, err, "network list failed", http.StatusInternalServerError)
 		return
 	}
+	networks := []*compute.Network{}
+	for _, network := range allNetworks {
+		if user.CanNode(auth.RoleViewer, network.NodeId) {
+			networks = append(networks, network)
+		}
+	}
 	data := struct {
 		Title    string
 		Networks []*compute.Network
 		User     *User
 		Request  *http.Request
-	}{"Networks", networks, env.Session(req).AuthUser(), req}
+	}{"Networks", networks, user, req}
 	if err := env.render.HTML(rw, http.StatusOK, "network/list", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -3,6 +3,7 @@ package web
 import (
 	"net/http"
 	"strconv"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
 
 	"github.com/gorilla/mux"
@@ -22,6 +23,10 @@ func (env *Environ) NodeDetail(rw http.ResponseWriter, req *http.Request) {
 		options.CpuNumaId = int(filterNumaId)
 	}
 
+	if !env.Session(req).AuthUser().CanNode(auth.RoleViewer, urlvars["id"]) {
+		env.forbidden(rw, req)
+		return
+	}
 	node, err := env.nodes.Get(urlvars["id"], options)
 	if err != nil {
 		env.error(rw, req, err, "node get failed", http.StatusInternalServerError)
@@ -40,22 +45,34 @@ func (env *Environ) NodeDetail(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (env *Environ) NodeList(rw http.ResponseWriter, req *http.Request) {
-	nodes, err := env.nodes.List(compute.NodeListOptions{})
+	user := env.Session(req).AuthUser()
+	allNodes, err := env.nodes.List(compute.NodeListOptions{})
 	if err != nil {
 		env.error(rw, req, err, "node list failed", http.StatusInternalServerError)
 		return
 	}
-	volumePools, err := env.volpools.List(compute.VolumePoolListOptions{})
-	if err != nil {
-		env.error(rw, req, err, "cannot fetch volume pools", http.StatusInternalServerError)
-		return
+	nodes := []*compute.Node{}
+	nodeIds := []string{}
+	for _, node := range allNodes {
+		if user.CanNode(auth.RoleViewer, node.Id) {
+			nodes = append(nodes, node)
+			nodeIds = append(nodeIds, node.Id)
+		}
+	}
+	volumePools := []*compute.VolumePool{}
+	if len(nodeIds) > 0 {
+		volumePools, err = env.volpools.List(compute.VolumePoolListOptions{NodeIds: nodeIds})
+		if err != nil {
+			env.error(rw, req, err, "cannot fetch volume pools", http.StatusInternalServerError)
+			return
+		}
 	}
 	data := struct {
 		Title       string
 		Nodes       []*compute.Node
 		VolumePools []*compute.VolumePool
 		User        *User
-	}{"Node Info", nodes, volumePools, env.Session(req).AuthUser()}
+	}{"Node Info", nodes, volumePools, user}
 	if err := env.render.HTML(rw, http.StatusOK, "node/list", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
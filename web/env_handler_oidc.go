@@ -0,0 +1,122 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"subuk/vmango/auth"
+)
+
+func oidcRandomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// oidcPrincipal gives permissions to principal authenticated by oidc provider.
+// Users defined in configuration file with matching oidc_id become these
+// users, others get grants of their groups or default role. Principal with
+// id of a configured user is rejected, it would get permissions of that
+// user with session refresh.
+func (env *Environ) oidcPrincipal(principal *auth.Principal) *auth.Principal {
+	if configUser := env.oidcConfigUser(principal.Id); configUser != nil {
+		configPrincipal := PrincipalFromConfig(configUser)
+		configPrincipal.FullName = principal.FullName
+		configPrincipal.Email = principal.Email
+		return configPrincipal
+	}
+	if env.configUser(principal.Id) != nil {
+		env.logger.Warn().Str("id", principal.Id).Msg("oidc user id is taken by configured user without matching oidc_id")
+		return nil
+	}
+	if len(principal.Grants) == 0 && env.cfg.Oidc.DefaultRole != "" {
+		principal.Grants = append(principal.Grants, auth.Grant{Role: auth.NewRole(env.cfg.Oidc.DefaultRole)})
+	}
+	if len(principal.Grants) == 0 {
+		return nil
+	}
+	return principal
+}
+
+func (env *Environ) OidcLoginStart(rw http.ResponseWriter, req *http.Request) {
+	if env.oidc == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	state, err := oidcRandomString()
+	if err != nil {
+		env.error(rw, req, err, "cannot generate oidc state", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidcRandomString()
+	if err != nil {
+		env.error(rw, req, err, "cannot generate oidc nonce", http.StatusInternalServerError)
+		return
+	}
+	authUrl, err := env.oidc.AuthCodeURL(state, nonce)
+	if err != nil {
+		env.error(rw, req, err, "oidc provider unavailable", http.StatusBadGateway)
+		return
+	}
+	session := env.Session(req)
+	session.Values[SESSION_OIDC_STATE_KEY] = state
+	session.Values[SESSION_OIDC_NONCE_KEY] = nonce
+	if err := session.Save(req, rw); err != nil {
+		env.error(rw, req, err, "failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, req, authUrl, http.StatusFound)
+}
+
+func (env *Environ) OidcLoginCallback(rw http.ResponseWriter, req *http.Request) {
+	if env.oidc == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	session := env.Session(req)
+	state, _ := session.Values[SESSION_OIDC_STATE_KEY].(string)
+	nonce, _ := session.Values[SESSION_OIDC_NONCE_KEY].(string)
+	delete(session.Values, SESSION_OIDC_STATE_KEY)
+	delete(session.Values, SESSION_OIDC_NONCE_KEY)
+
+	query := req.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		env.logger.Warn().Str("error", errorCode).Str("description", query.Get("error_description")).Msg("oidc provider returned error")
+		env.renderLogin(rw, req, http.StatusUnauthorized, "Single sign-on failed: "+errorCode)
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		env.renderLogin(rw, req, http.StatusBadRequest, "Single sign-on failed: invalid state, please try again")
+		return
+	}
+	principal, idToken, err := env.oidc.Exchange(req.Context(), query.Get("code"), nonce)
+	if err != nil {
+		env.logger.Warn().Err(err).Msg("oidc authentication failure")
+		env.renderLogin(rw, req, http.StatusUnauthorized, "Single sign-on failed")
+		return
+	}
+	principal = env.oidcPrincipal(principal)
+	if principal == nil {
+		env.renderLogin(rw, req, http.StatusForbidden, "You have no access to vmango")
+		return
+	}
+
+	user := NewUserFromPrincipal(principal)
+	user.Sso = env.configUser(principal.Id) == nil
+	session.SetAuthUser(user)
+	session.Values[SESSION_OIDC_ID_TOKEN_KEY] = idToken
+	redirectUrl, _ := session.Values["next"].(string)
+	delete(session.Values, "next")
+	if err := session.Save(req, rw); err != nil {
+		http.Error(rw, "Session save failed:"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if redirectUrl == "" {
+		redirectUrl = env.url("node-list").Path
+	}
+	env.logger.Info().Str("user", principal.Id).Msg("user logged in with oidc")
+	http.Redirect(rw, req, redirectUrl, http.StatusFound)
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"subuk/vmango/auth"
+	"subuk/vmango/config"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEnvironOidcPrincipal(t *testing.T) {
+	users := []config.UserWebConfig{
+		{Id: "admin"},
+		{Id: "alice", Role: "viewer", OidcId: "f3a1c2"},
+	}
+	tests := []struct {
+		name        string
+		principal   *auth.Principal
+		defaultRole string
+		wantId      string
+		wantRole    auth.Role
+		wantNil     bool
+	}{
+		{
+			name:      "mapped with oidc_id",
+			principal: &auth.Principal{Id: "f3a1c2", Email: "alice@example.com"},
+			wantId:    "alice",
+			wantRole:  auth.RoleViewer,
+		},
+		{
+			name:        "id of configured user without oidc_id",
+			principal:   &auth.Principal{Id: "admin"},
+			defaultRole: "viewer",
+			wantNil:     true,
+		},
+		{
+			name:        "configured user id is not oidc_id",
+			principal:   &auth.Principal{Id: "alice"},
+			defaultRole: "viewer",
+			wantNil:     true,
+		},
+		{
+			name:      "group grants",
+			principal: &auth.Principal{Id: "7d9e", Grants: auth.Grants{{Role: auth.RoleOperator}}},
+			wantId:    "7d9e",
+			wantRole:  auth.RoleOperator,
+		},
+		{
+			name:        "default role",
+			principal:   &auth.Principal{Id: "7d9e"},
+			defaultRole: "viewer",
+			wantId:      "7d9e",
+			wantRole:    auth.RoleViewer,
+		},
+		{
+			name:      "no grants",
+			principal: &auth.Principal{Id: "7d9e"},
+			wantNil:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Environ{
+				logger: zerolog.Nop(),
+				cfg:    &config.WebConfig{Users: users, Oidc: config.OidcWebConfig{DefaultRole: tt.defaultRole}},
+			}
+			got := env.oidcPrincipal(tt.principal)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("oidcPrincipal() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("oidcPrincipal() = nil, want %s", tt.wantId)
+			}
+			if got.Id != tt.wantId {
+				t.Errorf("oidcPrincipal() id = %s, want %s", got.Id, tt.wantId)
+			}
+			if len(got.Grants) != 1 || got.Grants[0].Role != tt.wantRole {
+				t.Errorf("oidcPrincipal() grants = %v, want role %s", got.Grants, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/compute"
+)
+
+// userQuotas returns quotas visible to user, users not limited by projects see all of them.
+func (env *Environ) userQuotas(user *User) ([]*compute.Quota, error) {
+	if !user.Scoped() {
+		return env.quotas.List(nil, nil)
+	}
+	return env.quotas.List([]string{user.Id}, user.Projects)
+}
+
+func (env *Environ) QuotaList(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	quotas, err := env.userQuotas(user)
+	if err != nil {
+		env.error(rw, req, err, "quota list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title  string
+		Quotas []*compute.Quota
+		User   *User
+	}{"Quotas", quotas, user}
+	if err := env.render.HTML(rw, http.StatusOK, "quota/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) VirtualMachineSnapshotCreateFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	params := compute.VirtualMachineSnapshotCreateParams{
+		Name:        req.Form.Get("Name"),
+		Description: req.Form.Get("Description"),
+		External:    req.Form.Get("External") == "true",
+	}
+	task := &compute.Task{Name: "vm_snapshot_create", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "creating snapshot "+params.Name)
+		_, err := env.vms.CreateSnapshot(vm.Id, vm.NodeId, params)
+		return err
+	})
+}
+
+func (env *Environ) VirtualMachineSnapshotRevertFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+		return
+	}
+	name := urlvars["snapshot"]
+	task := &compute.Task{Name: "vm_snapshot_revert", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "reverting to snapshot "+name)
+		return env.vms.RevertSnapshot(vm.Id, vm.NodeId, name)
+	})
+}
+
+func (env *Environ) VirtualMachineSnapshotDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.vms.DeleteSnapshot(urlvars["id"], urlvars["node"], urlvars["snapshot"]); err != nil {
+		env.error(rw, req, err, "cannot delete snapshot", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path+"?tab=snapshots", http.StatusFound)
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"net/http"
+	"subuk/vmango/auth"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+// taskVisible reports whether user may watch task,
+// user who submitted task always may.
+func taskVisible(user *User, task *compute.Task) bool {
+	if task.Owner != "" && task.Owner == user.Id {
+		return true
+	}
+	return user.CanNode(auth.RoleViewer, task.NodeId) && user.Owns(task.Owner, task.Project)
+}
+
+func (env *Environ) userTasks(user *User, options compute.TaskListOptions) ([]*compute.Task, error) {
+	allTasks, err := env.tasks.List(options)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []*compute.Task{}
+	for _, task := range allTasks {
+		if taskVisible(user, task) {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+func taskListOptionsFromRequest(req *http.Request) compute.TaskListOptions {
+	return compute.TaskListOptions{
+		Name:   req.URL.Query().Get("name"),
+		NodeId: req.URL.Query().Get("node"),
+		State:  compute.NewTaskState(req.URL.Query().Get("state")),
+	}
+}
+
+// submitTask starts task on behalf of request user and redirects to its page
+func (env *Environ) submitTask(rw http.ResponseWriter, req *http.Request, task *compute.Task, fn compute.TaskFunc) {
+	if task.Owner == "" {
+		task.Owner = env.Session(req).AuthUser().Id
+	}
+	fn, recordTask := env.auditTask(req, fn)
+	submitted, err := env.tasks.Submit(task, fn)
+	if err != nil {
+		env.error(rw, req, err, "cannot submit task", http.StatusServiceUnavailable)
+		return
+	}
+	recordTask(submitted.Id)
+	redirectUrl := env.url("task-detail", "id", submitted.Id)
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) TaskList(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	tasks, err := env.userTasks(user, taskListOptionsFromRequest(req))
+	if err != nil {
+		env.error(rw, req, err, "task list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title string
+		Tasks []*compute.Task
+		User  *User
+	}{"Tasks", tasks, user}
+	if err := env.render.HTML(rw, http.StatusOK, "task/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) TaskDetail(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	task, err := env.tasks.Get(mux.Vars(req)["id"])
+	if err != nil {
+		env.error(rw, req, err, "task not found", http.StatusNotFound)
+		return
+	}
+	if !taskVisible(user, task) {
+		env.forbidden(rw, req)
+		return
+	}
+	data := struct {
+		Title string
+		Task  *compute.Task
+		User  *User
+	}{"Task " + task.Name, task, user}
+	if err := env.render.HTML(rw, http.StatusOK, "task/detail", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
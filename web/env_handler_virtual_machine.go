@@ -1,33 +1,65 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
+	"subuk/vmango/util"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+func virtualMachineStatesFromQuery(values []string) ([]compute.VirtualMachineState, error) {
+	states := []compute.VirtualMachineState{}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		state := compute.NewVirtualMachineState(value)
+		if state == compute.StateUnknown {
+			return nil, fmt.Errorf("unknown state '%s'", value)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
 func (env *Environ) VirtualMachineList(rw http.ResponseWriter, req *http.Request) {
 	options := compute.VirtualMachineListOptions{}
 	selectedNodeIds := req.URL.Query()["node"]
 	if len(selectedNodeIds) > 0 {
 		options.NodeIds = selectedNodeIds
 	}
-	vms, err := env.vms.List(options)
+	states, err := virtualMachineStatesFromQuery(req.URL.Query()["state"])
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.States = states
+	user := env.Session(req).AuthUser()
+	allVms, err := env.vms.List(options)
 	if err != nil {
 		env.error(rw, req, err, "vm list failed", http.StatusInternalServerError)
 		return
 	}
+	vms := []*compute.VirtualMachine{}
+	for _, vm := range allVms {
+		if user.Can(auth.RoleViewer, vm.NodeId, vm.Id) && user.Owns(vm.Owner, vm.Project) {
+			vms = append(vms, vm)
+		}
+	}
 	data := struct {
-		Title string
-		Vms   []*compute.VirtualMachine
-		User  *User
-	}{"Virtual Machines", vms, env.Session(req).AuthUser()}
+		Title  string
+		Vms    []*compute.VirtualMachine
+		States []compute.VirtualMachineState
+		State  string
+		User   *User
+	}{"Virtual Machines", vms, compute.VirtualMachineStates, req.URL.Query().Get("state"), user}
 	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/list", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -52,6 +84,16 @@ func (env *Environ) VirtualMachineDetail(rw http.ResponseWriter, req *http.Reque
 		return
 	}
 
+	snapshots, err := env.vms.ListSnapshots(vm.Id, vm.NodeId)
+	if err != nil {
+		env.logger.Warn().Err(err).Str("vm", vm.Id).Msg("cannot list snapshots")
+	}
+
+	recordings, err := env.records.List(compute.ConsoleRecordingListOptions{VmId: vm.Id, NodeId: vm.NodeId})
+	if err != nil {
+		env.logger.Warn().Err(err).Str("vm", vm.Id).Msg("cannot list console recordings")
+	}
+
 	attachedVolumes := map[string]*compute.Volume{}
 	availableVolumes := []*compute.Volume{}
 	for _, volume := range volumes {
@@ -73,10 +115,12 @@ func (env *Environ) VirtualMachineDetail(rw http.ResponseWriter, req *http.Reque
 		DeviceBuses      []compute.DeviceBus
 		InterfaceModels  []string
 		Networks         []*compute.Network
+		Snapshots        []*compute.VirtualMachineSnapshot
+		Recordings       []*compute.ConsoleRecording
 		ActiveTab        string
 		User             *User
 		Request          *http.Request
-	}{"Virtual Machine", vm, attachedVolumes, availableVolumes, DeviceTypes, DeviceBuses, InterfaceModels, networks, req.URL.Query().Get("tab"), env.Session(req).AuthUser(), req}
+	}{"Virtual Machine", vm, attachedVolumes, availableVolumes, DeviceTypes, DeviceBuses, InterfaceModels, networks, snapshots, recordings, req.URL.Query().Get("tab"), env.Session(req).AuthUser(), req}
 	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/detail", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -109,6 +153,18 @@ func (env *Environ) VirtualMachineStateSetFormProcess(rw http.ResponseWriter, re
 		Str("method", req.Method).
 		Msg("Requesting state change")
 	urlvars := mux.Vars(req)
+	if urlvars["action"] == "shutdown" {
+		vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+		if err != nil {
+			env.error(rw, req, err, "vm get failed", http.StatusInternalServerError)
+			return
+		}
+		task := &compute.Task{Name: "vm_shutdown", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+		env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+			return env.vms.Shutdown(vm.Id, vm.NodeId, progress)
+		})
+		return
+	}
 	if err := env.vms.Action(urlvars["id"], urlvars["node"], urlvars["action"]); err != nil {
 		http.Error(rw, fmt.Sprintf("failed to %s machine: %s", urlvars["action"], err), http.StatusInternalServerError)
 		return
@@ -152,11 +208,21 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 		VideoModels:     VideoModels,
 	}
 
-	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	allNodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
 	if err != nil {
 		env.error(rw, req, err, "cannot list networks", http.StatusInternalServerError)
 		return
 	}
+	nodes := []*compute.Node{}
+	for _, node := range allNodes {
+		if data.User.CanNode(auth.RoleAdmin, node.Id) {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		env.forbidden(rw, req)
+		return
+	}
 	data.Nodes = nodes
 
 	var selectedNode *compute.Node
@@ -224,9 +290,21 @@ func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *ht
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	user := env.Session(req).AuthUser()
 	vm := &compute.VirtualMachine{
-		Id:     req.Form.Get("Name"),
-		NodeId: req.Form.Get("NodeId"),
+		Id:      req.Form.Get("Name"),
+		NodeId:  req.Form.Get("NodeId"),
+		Owner:   user.Id,
+		Project: req.Form.Get("Project"),
+	}
+
+	if !user.Can(auth.RoleAdmin, vm.NodeId, vm.Id) {
+		env.forbidden(rw, req)
+		return
+	}
+	if vm.Project != "" && user.Scoped() && !user.MemberOf(vm.Project) {
+		env.forbidden(rw, req)
+		return
 	}
 
 	vcpus, err := strconv.ParseInt(req.Form.Get("Vcpus"), 10, 16)
@@ -311,6 +389,16 @@ func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *ht
 		})
 	}
 
+	volumePaths := append([]string{}, req.Form["AttachVolumePath"]...)
+	volumePaths = append(volumePaths, req.Form["CloneVolumeOriginalPath"]...)
+	if permitted, err := env.volumesPermitted(user, vm.NodeId, volumePaths...); err != nil {
+		env.error(rw, req, err, "volume check failed", http.StatusInternalServerError)
+		return
+	} else if !permitted {
+		env.forbidden(rw, req)
+		return
+	}
+
 	for idx := 0; idx < len(req.Form["InterfaceNetwork"]); idx++ {
 		var accessVlan uint
 		accessVlanRaw := req.Form["InterfaceAccessVlan"][idx]
@@ -353,19 +441,23 @@ func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *ht
 	start := req.Form.Get("Start") == "true"
 	vm.Autostart = start
 
-	if err := env.vmanager.Create(vm, cloneVols, newVols, start); err != nil {
-		env.logger.Debug().Interface("vm", vm).Interface("cloneVols", cloneVols).Interface("newVols", newVols).Msg("vm create data")
-		env.error(rw, req, err, "cannot create vm", http.StatusInternalServerError)
+	if err := env.vmanager.CheckQuota(vm, cloneVols, newVols); err != nil {
+		status := http.StatusInternalServerError
+		if util.ErrorCause(err) == compute.ErrQuotaExceeded {
+			status = http.StatusForbidden
+		}
+		env.error(rw, req, err, "cannot create vm", status)
 		return
 	}
 
-	redirectPath := ""
-	if start {
-		redirectPath = env.url("virtual-machine-console-show", "id", vm.Id, "node", vm.NodeId).Path
-	} else {
-		redirectPath = env.url("virtual-machine-detail", "id", vm.Id, "node", vm.NodeId).Path
-	}
-	http.Redirect(rw, req, redirectPath, http.StatusFound)
+	task := &compute.Task{Name: "vm_create", NodeId: vm.NodeId, TargetId: vm.Id, Owner: vm.Owner, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		if err := env.vmanager.Create(vm, cloneVols, newVols, start, progress); err != nil {
+			env.logger.Debug().Interface("vm", vm).Interface("cloneVols", cloneVols).Interface("newVols", newVols).Msg("vm create data")
+			return err
+		}
+		return nil
+	})
 }
 
 func (env *Environ) VirtualMachineDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
@@ -375,12 +467,18 @@ func (env *Environ) VirtualMachineDeleteFormShow(rw http.ResponseWriter, req *ht
 		env.error(rw, req, err, "virtual-machine get failed", http.StatusInternalServerError)
 		return
 	}
+	plan, err := env.vmanager.PlanDelete(vm, true)
+	if err != nil {
+		env.error(rw, req, err, "cannot plan virtual machine deletion", http.StatusInternalServerError)
+		return
+	}
 	data := struct {
 		Title   string
 		Vm      *compute.VirtualMachine
+		Plan    *compute.VirtualMachineDeletePlan
 		User    *User
 		Request *http.Request
-	}{"Delete VirtualMachine", vm, env.Session(req).AuthUser(), req}
+	}{"Delete VirtualMachine", vm, plan, env.Session(req).AuthUser(), req}
 	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/delete", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -389,13 +487,33 @@ func (env *Environ) VirtualMachineDeleteFormShow(rw http.ResponseWriter, req *ht
 
 func (env *Environ) VirtualMachineDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
-	deleteVolumes := req.FormValue("DeleteVolumes") == "true"
-	if err := env.vmanager.Delete(urlvars["id"], urlvars["node"], deleteVolumes); err != nil {
-		env.error(rw, req, err, "cannot delete virtual machine", http.StatusInternalServerError)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	redirectUrl := env.url("virtual-machine-list")
-	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+	deleteVolumes := req.Form.Get("DeleteVolumes") == "true"
+	var confirmed []string
+	if deleteVolumes {
+		confirmed = append([]string{}, req.Form["Volume"]...)
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "virtual-machine get failed", http.StatusInternalServerError)
+		return
+	}
+	plan, err := env.vmanager.PlanDelete(vm, deleteVolumes)
+	if err != nil {
+		env.error(rw, req, err, "cannot plan virtual machine deletion", http.StatusInternalServerError)
+		return
+	}
+	if confirmed != nil && !plan.Matches(confirmed) {
+		env.error(rw, req, util.NewError(compute.ErrDeletePlanChanged, "machine volumes changed, please review deletion again"), "cannot delete virtual machine", http.StatusConflict)
+		return
+	}
+	task := &compute.Task{Name: "vm_delete", NodeId: vm.NodeId, TargetId: vm.Id, Project: vm.Project}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		return env.vmanager.Delete(vm.Id, vm.NodeId, deleteVolumes, confirmed, progress)
+	})
 }
 
 var GraphicTypes = []compute.GraphicType{
@@ -437,17 +555,18 @@ func (env *Environ) VirtualMachineUpdateFormProcess(rw http.ResponseWriter, req
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	vm := &compute.VirtualMachine{
-		Id:         urlvars["id"],
-		NodeId:     urlvars["node"],
-		Autostart:  req.Form.Get("Autostart") == "true",
-		GuestAgent: req.Form.Get("GuestAgent") == "true",
-		VideoModel: compute.NewVideoModel(req.Form.Get("VideoModel")),
-		Hugepages:  req.Form.Get("Hugepages") == "true",
-		Graphic: compute.VirtualMachineGraphic{
-			Type:   compute.NewGraphicType(req.Form.Get("GraphicType")),
-			Listen: req.Form.Get("GraphicListen"),
-		},
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	vm.Autostart = req.Form.Get("Autostart") == "true"
+	vm.GuestAgent = req.Form.Get("GuestAgent") == "true"
+	vm.VideoModel = compute.NewVideoModel(req.Form.Get("VideoModel"))
+	vm.Hugepages = req.Form.Get("Hugepages") == "true"
+	vm.Graphic = compute.VirtualMachineGraphic{
+		Type:   compute.NewGraphicType(req.Form.Get("GraphicType")),
+		Listen: req.Form.Get("GraphicListen"),
 	}
 
 	vcpus, err := strconv.ParseInt(req.Form.Get("Vcpus"), 10, 16)
@@ -469,7 +588,7 @@ func (env *Environ) VirtualMachineUpdateFormProcess(rw http.ResponseWriter, req
 	}
 	vm.Memory = compute.NewSize(memoryValue, memoryUnit)
 
-	if err := env.vms.Save(vm); err != nil {
+	if err := env.vms.Update(vm); err != nil {
 		env.error(rw, req, err, "cannot update virtual machine", http.StatusInternalServerError)
 		return
 	}
@@ -499,6 +618,13 @@ func (env *Environ) VirtualMachineAttachDiskFormProcess(rw http.ResponseWriter,
 		DeviceType: deviceType,
 		DeviceBus:  deviceBus,
 	}
+	if permitted, err := env.volumesPermitted(env.Session(req).AuthUser(), urlvars["node"], attachedVolume.Path); err != nil {
+		env.error(rw, req, err, "volume check failed", http.StatusInternalServerError)
+		return
+	} else if !permitted {
+		env.forbidden(rw, req)
+		return
+	}
 	if err := env.vms.AttachVolume(urlvars["id"], urlvars["node"], attachedVolume); err != nil {
 		env.error(rw, req, err, "cannot attach disk", http.StatusInternalServerError)
 		return
@@ -589,6 +715,21 @@ func (env *Environ) VirtualMachineConsoleShow(rw http.ResponseWriter, req *http.
 	}
 }
 
+type consoleStatusMessage struct {
+	Holder    string   `json:"holder"`
+	Control   bool     `json:"control"`
+	Viewers   []string `json:"viewers"`
+	Recording bool     `json:"recording"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type consoleCommandMessage struct {
+	Action string `json:"action"`
+}
+
+// VirtualMachineConsoleWS attaches websocket to shared machine console. Binary
+// messages carry console output and input, text messages carry json status
+// updates to the browser and take/release control commands from it.
 func (env *Environ) VirtualMachineConsoleWS(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 
@@ -597,37 +738,68 @@ func (env *Environ) VirtualMachineConsoleWS(rw http.ResponseWriter, req *http.Re
 		env.logger.Debug().Err(err).Msg("cannot upgrade websocket connection")
 		return
 	}
+	defer wsconn.Close()
 
-	console, err := env.vms.GetConsoleStream(urlvars["id"], urlvars["node"])
+	width, height := consoleSizeFromQuery(req.URL.Query())
+	viewer, err := env.consoles.Attach(urlvars["id"], urlvars["node"], env.Session(req).AuthUser().Id, width, height)
 	if err != nil {
-		env.error(rw, req, err, "cannot get vm console", http.StatusInternalServerError)
+		env.logger.Warn().Err(err).Str("vm", urlvars["id"]).Msg("cannot attach to vm console")
+		wsconn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "cannot get vm console"))
 		return
 	}
-	defer console.Close()
+	defer viewer.Close()
+	if err := viewer.RecordingError(); err != nil {
+		env.logger.Warn().Err(err).Str("vm", urlvars["id"]).Msg("cannot record console session")
+	}
 
 	go func() {
-		buf := make([]byte, 1024)
+		defer wsconn.Close()
+		output, status := viewer.Output(), viewer.Status()
 		for {
-			n, err := console.Read(buf)
-			if err != nil {
-				env.logger.Debug().Err(err).Msg("console read error")
-				return
-			}
-			if err := wsconn.WriteMessage(websocket.BinaryMessage, buf[0:n]); err != nil {
-				env.logger.Debug().Err(err).Msg("wsconn write error")
-				return
+			select {
+			case data, ok := <-output:
+				if !ok {
+					return
+				}
+				if err := wsconn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+					env.logger.Debug().Err(err).Msg("wsconn write error")
+					return
+				}
+			case update, ok := <-status:
+				if !ok {
+					return
+				}
+				if err := wsconn.WriteJSON(consoleStatusMessage(update)); err != nil {
+					env.logger.Debug().Err(err).Msg("wsconn write error")
+					return
+				}
 			}
 		}
 	}()
 	for {
-		_, reader, err := wsconn.NextReader()
+		messageType, data, err := wsconn.ReadMessage()
 		if err != nil {
 			env.logger.Debug().Err(err).Msg("ws message error")
 			return
 		}
-		if _, err := io.Copy(console, reader); err != nil {
-			env.logger.Debug().Err(err).Msg("console write error")
-			return
+		switch messageType {
+		case websocket.BinaryMessage:
+			if _, err := viewer.Write(data); err != nil && util.ErrorCause(err) != compute.ErrConsoleReadOnly {
+				env.logger.Debug().Err(err).Msg("console write error")
+				return
+			}
+		case websocket.TextMessage:
+			command := consoleCommandMessage{}
+			if err := json.Unmarshal(data, &command); err != nil {
+				env.logger.Debug().Err(err).Msg("invalid console command")
+				continue
+			}
+			switch command.Action {
+			case "take":
+				viewer.TakeControl()
+			case "release":
+				viewer.ReleaseControl()
+			}
 		}
 	}
 }
@@ -651,19 +823,51 @@ func (env *Environ) VirtualMachineVncShow(rw http.ResponseWriter, req *http.Requ
 	}
 }
 
+func (env *Environ) VirtualMachineSpiceShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get vm", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title   string
+		Vm      *compute.VirtualMachine
+		User    *User
+		Request *http.Request
+	}{"Virtual Machine Spice Console", vm, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/spice", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (env *Environ) VirtualMachineVncWs(rw http.ResponseWriter, req *http.Request) {
+	env.proxyGraphic(rw, req, compute.GraphicTypeVnc)
+}
+
+// VirtualMachineSpiceWs proxies SPICE client, it opens one websocket per channel.
+func (env *Environ) VirtualMachineSpiceWs(rw http.ResponseWriter, req *http.Request) {
+	env.proxyGraphic(rw, req, compute.GraphicTypeSpice)
+}
+
+// proxyGraphic proxies websocket to machine graphic port of given type,
+// it doesn't look into the protocol.
+func (env *Environ) proxyGraphic(rw http.ResponseWriter, req *http.Request, graphicType compute.GraphicType) {
 	urlvars := mux.Vars(req)
 	wsconn, err := env.ws.Upgrade(rw, req, nil)
 	if err != nil {
 		env.logger.Debug().Err(err).Msg("cannot upgrade websocket connection")
 		return
 	}
-	graphic, err := env.vms.GetGraphicStream(urlvars["id"], urlvars["node"])
+	defer wsconn.Close()
+	graphic, err := env.vms.GetGraphicStream(urlvars["id"], urlvars["node"], graphicType)
 	if err != nil {
-		http.Error(rw, "Cannot get vm graphic: "+err.Error(), http.StatusServiceUnavailable)
+		wsconn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "cannot get vm graphic"))
 		env.logger.Warn().Err(err).Msg("failed to establish tcp connection")
 		return
 	}
+	defer graphic.Close()
 
 	go func() {
 		buf := make([]byte, 4096)
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"subuk/vmango/auth"
 	"subuk/vmango/compute"
 
 	"github.com/gorilla/mux"
@@ -12,7 +13,8 @@ import (
 func (env *Environ) VolumeList(rw http.ResponseWriter, req *http.Request) {
 	selectedNodeId := req.URL.Query().Get("node")
 	selectedPool := req.URL.Query().Get("pool")
-	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	user := env.Session(req).AuthUser()
+	allNodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
 
 	var filterPoolNames []string
 	if selectedPool != "" {
@@ -27,18 +29,34 @@ func (env *Environ) VolumeList(rw http.ResponseWriter, req *http.Request) {
 		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
 		return
 	}
-	volumes, err := env.volumes.List(compute.VolumeListOptions{NodeIds: filterNodeIds, PoolNames: filterPoolNames})
+	nodes := []*compute.Node{}
+	for _, node := range allNodes {
+		if user.CanNode(auth.RoleViewer, node.Id) {
+			nodes = append(nodes, node)
+		}
+	}
+	allVolumes, err := env.volumes.List(compute.VolumeListOptions{NodeIds: filterNodeIds, PoolNames: filterPoolNames})
 	if err != nil {
 		env.error(rw, req, err, "volume list failed", http.StatusInternalServerError)
 		return
 	}
+	volumes := []*compute.Volume{}
+	for _, volume := range allVolumes {
+		if user.CanNode(auth.RoleViewer, volume.NodeId) && (!volume.Owned() || user.Owns(volume.Owner, volume.Project)) {
+			volumes = append(volumes, volume)
+		}
+	}
 	var pools []*compute.VolumePool
 	nodePools, err := env.volpools.List(compute.VolumePoolListOptions{NodeIds: filterNodeIds})
 	if err != nil {
 		env.error(rw, req, err, "pool list failed", http.StatusInternalServerError)
 		return
 	}
-	pools = nodePools
+	for _, pool := range nodePools {
+		if user.CanNode(auth.RoleViewer, pool.NodeId) {
+			pools = append(pools, pool)
+		}
+	}
 	data := struct {
 		Title         string
 		NodeId        string
@@ -49,7 +67,7 @@ func (env *Environ) VolumeList(rw http.ResponseWriter, req *http.Request) {
 		VolumeFormats []compute.VolumeFormat
 		User          *User
 		Request       *http.Request
-	}{"Volumes", selectedNodeId, selectedPool, volumes, nodes, pools, UIVolumeFormats, env.Session(req).AuthUser(), req}
+	}{"Volumes", selectedNodeId, selectedPool, volumes, nodes, pools, UIVolumeFormats, user, req}
 	if err := env.render.HTML(rw, http.StatusOK, "volume/list", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -127,13 +145,18 @@ func (env *Environ) VolumeCloneFormProcess(rw http.ResponseWriter, req *http.Req
 		NewName:      req.Form.Get("Name"),
 		NewPool:      req.Form.Get("Pool"),
 		NewSize:      compute.NewSize(sizeValue, sizeUnit),
-	}
-	if _, err := env.volumes.Clone(params); err != nil {
-		env.error(rw, req, err, "volume clone failed", http.StatusInternalServerError)
-		return
-	}
-	redirectUrl := env.url("volume-list")
-	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+		Owner:        env.Session(req).AuthUser().VolumeOwner(),
+	}
+	task := &compute.Task{Name: "volume_clone", NodeId: params.NodeId, TargetId: params.NewName}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "cloning volume "+params.OriginalPath)
+		volume, err := env.vmanager.CloneVolume(params)
+		if err != nil {
+			return err
+		}
+		progress.Target(volume.Path)
+		return nil
+	})
 }
 
 func (env *Environ) VolumeResizeFormShow(rw http.ResponseWriter, req *http.Request) {
@@ -174,12 +197,12 @@ func (env *Environ) VolumeResizeFormProcess(rw http.ResponseWriter, req *http.Re
 		http.Error(rw, "unknown size unit: "+req.Form.Get("SizeUnit"), http.StatusBadRequest)
 		return
 	}
-	if err := env.volumes.Resize(path, urlvars["node"], compute.NewSize(newSizeValue, newSizeUnit)); err != nil {
-		env.error(rw, req, err, "volume clone failed", http.StatusInternalServerError)
-		return
-	}
-	redirectUrl := env.url("volume-list")
-	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+	newSize := compute.NewSize(newSizeValue, newSizeUnit)
+	task := &compute.Task{Name: "volume_resize", NodeId: urlvars["node"], TargetId: path}
+	env.submitTask(rw, req, task, func(progress *compute.TaskProgress) error {
+		progress.Report(0, "resizing volume "+path)
+		return env.volumes.Resize(path, urlvars["node"], newSize)
+	})
 }
 
 func (env *Environ) VolumeDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
@@ -208,14 +231,19 @@ func (env *Environ) VolumeAddFormProcess(rw http.ResponseWriter, req *http.Reque
 		http.Error(rw, "unknown size unit: "+req.Form.Get("SizeUnit"), http.StatusBadRequest)
 		return
 	}
+	if !env.Session(req).AuthUser().Can(auth.RoleAdmin, req.Form.Get("NodeId"), "") {
+		env.forbidden(rw, req)
+		return
+	}
 	params := compute.VolumeCreateParams{
 		NodeId: req.Form.Get("NodeId"),
 		Name:   req.Form.Get("Name"),
 		Pool:   req.Form.Get("Pool"),
 		Format: compute.NewVolumeFormat(req.Form.Get("Format")),
 		Size:   compute.NewSize(sizeValue, sizeUnit),
+		Owner:  env.Session(req).AuthUser().VolumeOwner(),
 	}
-	if _, err := env.volumes.Create(params); err != nil {
+	if _, err := env.vmanager.CreateVolume(params); err != nil {
 		env.error(rw, req, err, "cannot add key", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,122 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"subuk/vmango/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// permissionTarget describes resource being accessed. Owned is set for
+// resources which have an owner, ownership is checked for them in addition
+// to grants.
+type permissionTarget struct {
+	NodeId  string
+	VmId    string
+	Owned   bool
+	Owner   string
+	Project string
+}
+
+type permissionScope func(env *Environ, req *http.Request) (*permissionTarget, error)
+
+func scopeGlobal(env *Environ, req *http.Request) (*permissionTarget, error) {
+	return &permissionTarget{}, nil
+}
+
+func scopeNode(env *Environ, req *http.Request) (*permissionTarget, error) {
+	return &permissionTarget{NodeId: mux.Vars(req)["node"]}, nil
+}
+
+func scopeVirtualMachine(env *Environ, req *http.Request) (*permissionTarget, error) {
+	urlvars := mux.Vars(req)
+	target := &permissionTarget{NodeId: urlvars["node"], VmId: urlvars["id"]}
+	if !env.Session(req).AuthUser().Scoped() {
+		return target, nil
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		return nil, err
+	}
+	target.Owned = true
+	target.Owner = vm.Owner
+	target.Project = vm.Project
+	return target, nil
+}
+
+func scopeVolume(env *Environ, req *http.Request) (*permissionTarget, error) {
+	urlvars := mux.Vars(req)
+	path := strings.Replace(urlvars["path"], "%2F", "/", -1)
+	volume, err := env.volumes.Get(path, urlvars["node"])
+	if err != nil {
+		return nil, err
+	}
+	return &permissionTarget{
+		NodeId:  volume.NodeId,
+		VmId:    volume.AttachedTo,
+		Owned:   volume.Owned(),
+		Owner:   volume.Owner,
+		Project: volume.Project,
+	}, nil
+}
+
+func isApiRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+func (env *Environ) forbidden(rw http.ResponseWriter, req *http.Request) {
+	user := env.Session(req).AuthUser()
+	env.logger.Warn().Str("user", user.Id).Str("method", req.Method).Str("path", req.URL.Path).Msg("permission denied")
+	if isApiRequest(req) {
+		env.apiError(rw, req, auth.ErrPermissionDenied, "permission denied")
+		return
+	}
+	data := struct {
+		Title string
+		Error string
+	}{"Forbidden", "Permission denied"}
+	if err := env.render.HTML(rw, http.StatusForbidden, "403", data); err != nil {
+		http.Error(rw, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) permitted(role auth.Role, scope permissionScope, handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		target, err := scope(env, req)
+		if err != nil {
+			if isApiRequest(req) {
+				env.apiError(rw, req, err, "permission check failed")
+			} else {
+				env.error(rw, req, err, "permission check failed", http.StatusInternalServerError)
+			}
+			return
+		}
+		user := env.Session(req).AuthUser()
+		if !user.Can(role, target.NodeId, target.VmId) {
+			env.forbidden(rw, req)
+			return
+		}
+		if target.Owned && !user.Owns(target.Owner, target.Project) {
+			env.forbidden(rw, req)
+			return
+		}
+		handler(rw, req)
+	}
+}
+
+// volumesPermitted reports whether user may attach or clone volumes with
+// given paths, volumes owned by others are refused.
+func (env *Environ) volumesPermitted(user *User, nodeId string, paths ...string) (bool, error) {
+	for _, path := range paths {
+		volume, err := env.volumes.Get(path, nodeId)
+		if err != nil {
+			return false, err
+		}
+		if volume.Owned() && !user.Owns(volume.Owner, volume.Project) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -8,6 +8,9 @@ import (
 
 const SESSION_NAME = "vmango"
 const SESSION_USER_KEY = "auth_user"
+const SESSION_OIDC_STATE_KEY = "oidc_state"
+const SESSION_OIDC_NONCE_KEY = "oidc_nonce"
+const SESSION_OIDC_ID_TOKEN_KEY = "oidc_id_token"
 
 type Session struct {
 	*sessions.Session
@@ -30,10 +33,23 @@ func (session *Session) IsAuthenticated() bool {
 }
 
 func (env *Environ) Session(request *http.Request) *Session {
+	if user, ok := request.Context().Value(CONTEXT_TOKEN_USER_KEY).(*User); ok {
+		session := sessions.NewSession(env.sessions, SESSION_NAME)
+		session.Values[SESSION_USER_KEY] = user
+		return &Session{session}
+	}
 	session, err := env.sessions.Get(request, SESSION_NAME)
 	if err != nil {
 		env.logger.Warn().Err(err).Msg("failed to fetch session, creating new one")
 		session.IsNew = true
 	}
+	// Permissions always follow current configuration, not the one at login time
+	if user, ok := session.Values[SESSION_USER_KEY].(*User); ok && user.Authenticated {
+		if configUser := env.configUser(user.Id); configUser != nil {
+			fresh := NewUserFromConfig(configUser)
+			user.Grants = fresh.Grants
+			user.Projects = fresh.Projects
+		}
+	}
 	return &Session{session}
 }
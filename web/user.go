@@ -1,8 +1,98 @@
 package web
 
+import (
+	"subuk/vmango/auth"
+	"subuk/vmango/config"
+)
+
 type User struct {
 	Id            string
 	FullName      string
 	Email         string
 	Authenticated bool
+	ApiToken      string
+	Grants        auth.Grants
+	Projects      []string
+	Sso           bool // known only to oidc provider
+}
+
+func PrincipalFromConfig(cfg *config.UserWebConfig) *auth.Principal {
+	principal := &auth.Principal{
+		Id:       cfg.Id,
+		FullName: cfg.FullName,
+		Email:    cfg.Email,
+		Projects: cfg.Projects,
+	}
+	if cfg.Role != "" {
+		principal.Grants = append(principal.Grants, auth.Grant{Role: auth.NewRole(cfg.Role)})
+	}
+	for _, grant := range cfg.Grants {
+		principal.Grants = append(principal.Grants, auth.Grant{
+			Role:       auth.NewRole(grant.Role),
+			Nodes:      grant.Nodes,
+			VmPrefixes: grant.VmPrefixes,
+		})
+	}
+	// Users without any role or grant are admins, as before roles were introduced
+	if len(principal.Grants) == 0 {
+		principal.Grants = append(principal.Grants, auth.Grant{Role: auth.RoleAdmin})
+	}
+	return principal
+}
+
+func NewUserFromPrincipal(principal *auth.Principal) *User {
+	return &User{
+		Id:            principal.Id,
+		FullName:      principal.FullName,
+		Email:         principal.Email,
+		Authenticated: true,
+		Grants:        principal.Grants,
+		Projects:      principal.Projects,
+	}
+}
+
+func NewUserFromConfig(cfg *config.UserWebConfig) *User {
+	return NewUserFromPrincipal(PrincipalFromConfig(cfg))
+}
+
+func (user *User) Can(role auth.Role, nodeId, vmId string) bool {
+	return user.Grants.Can(role, nodeId, vmId)
+}
+
+// Scoped reports whether user visibility is limited to own machines and
+// machines of user projects.
+func (user *User) Scoped() bool {
+	return len(user.Projects) > 0
+}
+
+func (user *User) MemberOf(project string) bool {
+	for _, p := range user.Projects {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *User) Owns(owner, project string) bool {
+	if !user.Scoped() {
+		return true
+	}
+	if owner != "" && owner == user.Id {
+		return true
+	}
+	return project != "" && user.MemberOf(project)
+}
+
+// VolumeOwner returns owner of volumes created by user. Volumes created by
+// users without projects are not owned and visible to everyone.
+func (user *User) VolumeOwner() string {
+	if !user.Scoped() {
+		return ""
+	}
+	return user.Id
+}
+
+func (user *User) CanNode(role auth.Role, nodeId string) bool {
+	return user.Grants.CanNode(role, nodeId)
 }
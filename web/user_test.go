@@ -0,0 +1,48 @@
+package web
+
+import (
+	"testing"
+)
+
+func TestUserOwns(t *testing.T) {
+	scoped := &User{Id: "alice", Projects: []string{"web", "db"}}
+	tests := []struct {
+		name    string
+		user    *User
+		owner   string
+		project string
+		want    bool
+	}{
+		{"unscoped user sees everything", &User{Id: "admin"}, "bob", "", true},
+		{"own machine", scoped, "alice", "", true},
+		{"project machine", scoped, "bob", "db", true},
+		{"other owner and project", scoped, "bob", "ops", false},
+		{"machine without owner", scoped, "", "", false},
+		{"empty owner doesn't match empty id", &User{Projects: []string{"web"}}, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.Owns(tt.owner, tt.project); got != tt.want {
+				t.Errorf("Owns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserVolumeOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		user *User
+		want string
+	}{
+		{"scoped user owns created volumes", &User{Id: "alice", Projects: []string{"web"}}, "alice"},
+		{"volumes of unscoped user are not owned", &User{Id: "admin"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.VolumeOwner(); got != tt.want {
+				t.Errorf("VolumeOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	HeaderEvent     = "X-Vmango-Event"
+	HeaderDelivery  = "X-Vmango-Delivery"
+	HeaderTimestamp = "X-Vmango-Timestamp"
+	HeaderSignature = "X-Vmango-Signature"
+
+	maxResponseBody = 4096
+)
+
+// Subscription posts events with matching name to Url, each attempt
+// is limited by Timeout.
+type Subscription struct {
+	Event     string
+	Url       string
+	Secret    string
+	Mandatory bool
+	Attempts  int
+	Timeout   time.Duration
+}
+
+// Subscriber posts event values as json object. Network errors, 5xx and
+// 429 responses may be retried, other failed responses reject the event.
+type Subscriber struct {
+	sub    Subscription
+	client *http.Client
+	logger zerolog.Logger
+}
+
+func NewSubscriber(sub Subscription, logger zerolog.Logger) *Subscriber {
+	return &Subscriber{
+		sub:    sub,
+		client: &http.Client{Timeout: sub.Timeout},
+		logger: logger,
+	}
+}
+
+func (subscriber *Subscriber) Name() string {
+	return "webhook " + subscriber.sub.Url
+}
+
+func (subscriber *Subscriber) Event() string {
+	return subscriber.sub.Event
+}
+
+func (subscriber *Subscriber) Mandatory() bool {
+	return subscriber.sub.Mandatory
+}
+
+func (subscriber *Subscriber) Attempts() int {
+	return subscriber.sub.Attempts
+}
+
+// Sign returns signature of the request body sent at timestamp, receivers
+// should compare it with X-Vmango-Signature header value.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp)
+	io.WriteString(mac, ".")
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (subscriber *Subscriber) Deliver(delivery *compute.EventDelivery) error {
+	body, err := json.Marshal(delivery.Data)
+	if err != nil {
+		return util.NewError(err, "cannot marshal event")
+	}
+	req, err := http.NewRequest(http.MethodPost, subscriber.sub.Url, bytes.NewReader(body))
+	if err != nil {
+		return util.NewError(err, "cannot create request")
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vmango")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if subscriber.sub.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(subscriber.sub.Secret, timestamp, body))
+	}
+	log := subscriber.logger.With().
+		Str("url", subscriber.sub.Url).
+		Str("event", delivery.Event).
+		Str("delivery", delivery.Id).
+		Int("attempt", delivery.Attempts).
+		Logger()
+
+	resp, err := subscriber.client.Do(req)
+	if err != nil {
+		log.Warn().Err(err).Msg("webhook request failed")
+		return util.NewError(err, "webhook request failed")
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		log.Info().Msg("webhook delivered")
+		return nil
+	}
+	log.Warn().Int("status", resp.StatusCode).Str("response", string(respBody)).Msg("webhook failed")
+	message := fmt.Sprintf("unexpected response status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return errors.New(message)
+	}
+	return util.NewError(compute.ErrEventRejected, "%s", message)
+}
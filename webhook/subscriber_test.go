@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"vm_created"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		want      string
+	}{
+		{"signed body", "s3cret", "1700000000", body, "sha256=c844ed300af8ecfdb78f242df32f69cf018a0924e521df960e271f80ff58a2d5"},
+		{"empty body", "", "1700000000", nil, "sha256=c1da1b6c6b8e9da7f4bbb90f7cab0820f271ad19ccbf80c88479c4e14f37d1c6"},
+		{"timestamp is signed", "other", "1700000001", body, "sha256=a2bdbdf5e56d1883171cec3eeddec84ccce8a119c6729e9279b4b501d7274552"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriberDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		secret       string
+		status       int
+		wantErr      bool
+		wantRejected bool
+	}{
+		{"delivered", "s3cret", http.StatusNoContent, false, false},
+		{"delivered without secret", "", http.StatusOK, false, false},
+		{"server error is retried", "", http.StatusBadGateway, true, false},
+		{"too many requests is retried", "", http.StatusTooManyRequests, true, false},
+		{"client error rejects event", "", http.StatusForbidden, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				body, _ := ioutil.ReadAll(req.Body)
+				if got := req.Header.Get(HeaderEvent); got != "vm_created" {
+					t.Errorf("%s = %q, want vm_created", HeaderEvent, got)
+				}
+				if got := req.Header.Get(HeaderDelivery); got != "d1" {
+					t.Errorf("%s = %q, want d1", HeaderDelivery, got)
+				}
+				signature := req.Header.Get(HeaderSignature)
+				if tt.secret == "" && signature != "" {
+					t.Errorf("%s = %q without secret", HeaderSignature, signature)
+				}
+				if tt.secret != "" && signature != Sign(tt.secret, req.Header.Get(HeaderTimestamp), body) {
+					t.Errorf("%s = %q doesn't match body", HeaderSignature, signature)
+				}
+				rw.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			subscriber := NewSubscriber(Subscription{Event: "vm_created", Url: server.URL, Secret: tt.secret, Timeout: time.Second}, zerolog.Nop())
+			err := subscriber.Deliver(&compute.EventDelivery{Id: "d1", Event: "vm_created", Data: map[string]string{"event": "vm_created", "vm_id": "web1"}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rejected := util.ErrorCause(err) == compute.ErrEventRejected; rejected != tt.wantRejected {
+				t.Errorf("Deliver() error = %v, want rejected %v", err, tt.wantRejected)
+			}
+		})
+	}
+}